@@ -0,0 +1,144 @@
+package multisocket
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/message"
+)
+
+// pendingAck is a sent message waiting for the peer's ack, see Options.RequireAck.
+type pendingAck struct {
+	msg    *message.Message
+	sentAt time.Time
+}
+
+// nextAckID generate a non-zero ack id.
+func (s *socket) nextAckID() uint32 {
+	for {
+		if id := atomic.AddUint32(&s.ackIDs, 1); id != 0 {
+			return id
+		}
+	}
+}
+
+// tagAck add an ack id to msg if RequireAck is set,
+// only send to one messages are acked, as they can be resent on any pipe.
+func (s *socket) tagAck(msg *message.Message) {
+	if s.requireAck && msg.SendType() == message.SendTypeToOne {
+		msg.AddAckID(s.nextAckID())
+	}
+}
+
+// dupUnacked dup msg to retain until it's acked, nil if msg is not tagged.
+func dupUnacked(msg *message.Message) *message.Message {
+	if msg.AckID() == 0 {
+		return nil
+	}
+	return msg.Dup()
+}
+
+// trackAck retain sent msg until it's acked, it's resent if not acked within AckTimeout.
+func (s *socket) trackAck(msg *message.Message) {
+	if msg == nil {
+		return
+	}
+	s.ackLock.Lock()
+	if s.acks == nil {
+		// closed
+		s.ackLock.Unlock()
+		msg.FreeAll()
+		return
+	}
+	s.acks[msg.AckID()] = &pendingAck{msg: msg, sentAt: time.Now()}
+	s.ackLock.Unlock()
+
+	s.ackOnce.Do(func() {
+		go s.resendUnacked()
+	})
+}
+
+// handleAck release the sent message acked by the peer.
+func (s *socket) handleAck(p connector.Pipe, msg *message.Message) {
+	if len(msg.Content) != 1+message.AckIDSize {
+		return
+	}
+	id := binary.BigEndian.Uint32(msg.Content[1:])
+	s.ackLock.Lock()
+	pa := s.acks[id]
+	delete(s.acks, id)
+	s.ackLock.Unlock()
+	if pa != nil {
+		pa.msg.FreeAll()
+	}
+}
+
+// ackRecvMsg strip ack id of msg received from p and ack it if RequireAck is set,
+// returns false if msg is malformed.
+func (s *socket) ackRecvMsg(p *pipe, msg *message.Message) bool {
+	if !s.requireAck || msg.SendType() != message.SendTypeToOne || msg.HasFlags(message.MsgFlagRaw) {
+		return true
+	}
+	if err := msg.StripAckID(); err != nil {
+		return false
+	}
+	var id [message.AckIDSize]byte
+	binary.BigEndian.PutUint32(id[:], msg.AckID())
+	select {
+	case <-p.stopq:
+	case p.sendq <- message.NewInternalMessageWithData(p.ID(), message.InternalMsgAck, id[:]):
+	}
+	return true
+}
+
+// resendUnacked resend messages not acked within AckTimeout, until socket is closed.
+func (s *socket) resendUnacked() {
+	tm := time.NewTimer(s.ackCheckInterval())
+	defer tm.Stop()
+	for {
+		select {
+		case <-s.closedq:
+			return
+		case <-tm.C:
+		}
+
+		var (
+			expired []*message.Message
+			sentBy  = time.Now().Add(-s.ackTimeout)
+		)
+		s.ackLock.Lock()
+		for id, pa := range s.acks {
+			if pa.sentAt.Before(sentBy) {
+				expired = append(expired, pa.msg)
+				delete(s.acks, id)
+			}
+		}
+		s.ackLock.Unlock()
+		for _, msg := range expired {
+			if s.pushSendMsg(msg) != nil {
+				msg.FreeAll()
+			}
+		}
+		tm.Reset(s.ackCheckInterval())
+	}
+}
+
+func (s *socket) ackCheckInterval() time.Duration {
+	if d := s.ackTimeout / 2; d > time.Millisecond {
+		return d
+	}
+	return time.Millisecond
+}
+
+// dropUnacked free messages waiting for acks, no more are retained after.
+func (s *socket) dropUnacked() {
+	s.ackLock.Lock()
+	acks := s.acks
+	s.acks = nil
+	s.ackLock.Unlock()
+	for _, pa := range acks {
+		pa.msg.FreeAll()
+	}
+}
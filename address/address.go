@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"strings"
 
 	"github.com/multisocket/multisocket/options"
 )
@@ -51,7 +52,7 @@ func ParseMultiSocketAddress(s string) (sa MultiSocketAddress, err error) {
 	var (
 		u *url.URL
 	)
-	if u, err = url.Parse(s); err != nil {
+	if u, err = url.Parse(escapeZone(s)); err != nil {
 		return
 	}
 
@@ -97,6 +98,25 @@ func ParseMultiSocketAddress(s string) (sa MultiSocketAddress, err error) {
 	return address, nil
 }
 
+// escapeZone escape the '%' of IPv6 literal's zone id as url requires, e.g. [fe80::1%eth0] => [fe80::1%25eth0]
+func escapeZone(s string) string {
+	i := strings.Index(s, "://[")
+	if i < 0 {
+		return s
+	}
+	i += len("://[")
+	j := strings.IndexByte(s[i:], ']')
+	if j < 0 {
+		return s
+	}
+	k := strings.IndexByte(s[i:i+j], '%')
+	if k < 0 || strings.HasPrefix(s[i+k:], "%25") {
+		// no zone or already escaped
+		return s
+	}
+	return s[:i+k] + "%25" + s[i+k+1:]
+}
+
 func (sa *multiSocketAddress) String() string {
 	return sa.raw
 }
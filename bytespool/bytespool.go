@@ -1,7 +1,9 @@
 package bytespool
 
 import (
+	"fmt"
 	"sync"
+	"sync/atomic"
 )
 
 type (
@@ -37,16 +39,59 @@ var (
 		newPoolInfo(16 * 1024),
 	}
 	extraPools = []*poolInfo{}
+	// max size of bytes kept in pools, 0 for no limit
+	maxPooledSize int64
 )
 
 func init() {
-	// 16KB as the increment unit
-	for i := 2; i <= 64; i++ {
+	// 8KB as the increment unit after 16KB
+	for i := 3; i <= 64; i++ {
 		pools = append(pools, newPoolInfo(i*8*1024))
 	}
 }
 
-// Alloc alloc bytes
+// Configure set the size classes of pools, which must be positive and increasing,
+// Alloc rounds up to the nearest class, larger sizes are not pooled.
+// It should be called before any Alloc, e.g. in init, it panics on bad classes.
+func Configure(classes []int) {
+	xpools := make([]*poolInfo, len(classes))
+	for i, sz := range classes {
+		if sz <= 0 || (i > 0 && sz <= classes[i-1]) {
+			panic(fmt.Sprintf("bytespool: bad size classes %v", classes))
+		}
+		xpools[i] = newPoolInfo(sz)
+	}
+	pools = xpools
+}
+
+// Classes get the size classes of pools.
+func Classes() []int {
+	classes := make([]int, len(pools))
+	for i, pi := range pools {
+		classes[i] = pi.sz
+	}
+	return classes
+}
+
+// SetMaxPooledSize set the max size of bytes kept in pools, larger ones are left to GC, 0 for no limit.
+// It bounds memory held by pools after a spike of large messages.
+func SetMaxPooledSize(sz int) {
+	atomic.StoreInt64(&maxPooledSize, int64(sz))
+}
+
+// MaxPooledSize get the max size of bytes kept in pools, 0 for no limit.
+func MaxPooledSize() int {
+	return int(atomic.LoadInt64(&maxPooledSize))
+}
+
+// pooled check if bytes of size sz can be kept in pools
+func pooled(sz int) bool {
+	max := atomic.LoadInt64(&maxPooledSize)
+	return max <= 0 || int64(sz) <= max
+}
+
+// Alloc alloc bytes of length sz, the capacity is rounded up to its size class,
+// so use len rather than cap as the size.
 func Alloc(sz int) []byte {
 	if sz <= 0 {
 		return nil
@@ -54,6 +99,9 @@ func Alloc(sz int) []byte {
 
 	for _, pi := range pools {
 		if sz <= pi.sz {
+			if !pooled(pi.sz) {
+				break
+			}
 			// to requested size.
 			return pi.p.Get().([]byte)[:sz]
 		}
@@ -64,12 +112,13 @@ func Alloc(sz int) []byte {
 // Free bytes
 func Free(p []byte) {
 	sz := cap(p)
-	if sz <= 0 {
+	if sz <= 0 || !pooled(sz) {
 		return
 	}
 	for _, pi := range pools {
 		if sz == pi.sz {
 			pi.p.Put(p)
+			return
 		}
 	}
 }
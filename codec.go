@@ -0,0 +1,55 @@
+package multisocket
+
+import (
+	"encoding/json"
+)
+
+type (
+	// Codec marshals objects sent by SendObject and unmarshals ones received by RecvObject.
+	Codec interface {
+		Marshal(v interface{}) ([]byte, error)
+		Unmarshal(b []byte, v interface{}) error
+	}
+
+	jsonCodec struct{}
+)
+
+var (
+	// JSONCodec encodes objects as JSON, it's the default codec.
+	JSONCodec Codec = jsonCodec{}
+)
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+func sockCodec(s Socket) Codec {
+	if c, _ := s.GetOptionDefault(Options.Codec).(Codec); c != nil {
+		return c
+	}
+	return JSONCodec
+}
+
+// sendObject marshal v by s's codec and send it
+func sendObject(s Socket, v interface{}) error {
+	b, err := sockCodec(s).Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Send(b)
+}
+
+// recvObject recv a message and unmarshal it into v by s's codec
+func recvObject(s Socket, v interface{}) error {
+	msg, err := s.RecvMsg()
+	if err != nil {
+		return err
+	}
+	err = sockCodec(s).Unmarshal(msg.Content, v)
+	msg.FreeAll()
+	return err
+}
@@ -3,11 +3,11 @@ package connector
 import (
 	"sync"
 
-	log "github.com/sirupsen/logrus"
-
 	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/log"
 	"github.com/multisocket/multisocket/options"
 	"github.com/multisocket/multisocket/transport"
+	"github.com/multisocket/multisocket/utils"
 )
 
 type (
@@ -17,11 +17,16 @@ type (
 		sync.RWMutex
 		negotiator       Negotiator
 		limit            int
+		schemeLimits     map[string]int
 		dialers          map[*dialer]struct{} // can dial to any address any times
 		listeners        map[*listener]struct{}
 		pipes            map[uint32]*pipe
 		pipeEventHandler PipeEventHandlerFunc
+		connEventHandler ConnEventHandlerFunc
+		pipeChannels     map[chan<- Pipe]*pipeChannel
+		idGen            *utils.RecyclableIDGenerator
 		closed           bool
+		wg               sync.WaitGroup // listeners' serving and accepting goroutines
 	}
 )
 
@@ -48,6 +53,9 @@ func NewWithLimitAndOptions(limit int, opts options.Options) Connector {
 		dialers:   make(map[*dialer]struct{}),
 		listeners: make(map[*listener]struct{}),
 		pipes:     make(map[uint32]*pipe),
+
+		pipeChannels: make(map[chan<- Pipe]*pipeChannel),
+		idGen:        pipeID,
 	}
 	c.Options.AddOptionChangeHook(c.onOptionChange)
 	for o, v := range c.Options.OptionValues() {
@@ -55,13 +63,19 @@ func NewWithLimitAndOptions(limit int, opts options.Options) Connector {
 	}
 
 	if log.IsLevelEnabled(log.DebugLevel) {
-		log.WithField("domain", "connector").
-			WithField("limit", c.limit).
-			Debug("create")
+		log.Debug("create", log.Fields{"domain": "connector", "limit": c.limit})
 	}
 	return c
 }
 
+// NewWithIDGenerator create a Connector generating pipe ids by gen instead of the shared one,
+// a fresh gen gives predictable ids 1, 2, 3..., e.g. for tests.
+func NewWithIDGenerator(gen *utils.RecyclableIDGenerator) Connector {
+	c := NewWithOptions(options.NewOptions()).(*connector)
+	c.idGen = gen
+	return c
+}
+
 func (c *connector) onOptionChange(opt options.Option, oldVal, newVal interface{}) error {
 	switch opt {
 	case Options.PipeLimit:
@@ -69,13 +83,15 @@ func (c *connector) onOptionChange(opt options.Option, oldVal, newVal interface{
 		oldLimit := c.limit
 		c.limit = Options.PipeLimit.Value(newVal)
 		if log.IsLevelEnabled(log.DebugLevel) {
-			log.WithField("domain", "connector").
-				WithField("oldLimit", oldLimit).
-				WithField("newLimit", c.limit).
-				Debug("change limit")
+			log.Debug("change limit", log.Fields{"domain": "connector", "oldLimit": oldLimit, "newLimit": c.limit})
 		}
 		c.checkLimit(true)
 		c.Unlock()
+	case Options.PipeLimitByScheme:
+		c.Lock()
+		c.schemeLimits, _ = newVal.(map[string]int)
+		c.checkLimit(true)
+		c.Unlock()
 	}
 	return nil
 }
@@ -87,7 +103,7 @@ func (c *connector) checkLimit(checkNoLimit bool) {
 	}
 
 	if c.limit == -1 {
-		if checkNoLimit {
+		if checkNoLimit || len(c.schemeLimits) > 0 {
 			c.startConnecting()
 		}
 	} else if len(c.pipes) < c.limit {
@@ -99,19 +115,39 @@ func (c *connector) checkLimit(checkNoLimit bool) {
 	}
 }
 
+// schemeExceeded check if pipes of scheme reach its limit
+func (c *connector) schemeExceeded(scheme string) bool {
+	limit, ok := c.schemeLimits[scheme]
+	if !ok {
+		return false
+	}
+	n := 0
+	for _, p := range c.pipes {
+		if p.scheme() == scheme {
+			n++
+		}
+	}
+	return n >= limit
+}
+
 func (c *connector) startConnecting() {
 	for l := range c.listeners {
-		l.start()
+		if c.schemeExceeded(transport.ParseScheme(l.addr)) {
+			l.stop()
+		} else {
+			l.start()
+		}
 	}
 
 	for d := range c.dialers {
-		d.start()
+		if c.schemeExceeded(transport.ParseScheme(d.addr)) {
+			d.stop()
+		} else {
+			d.start()
+		}
 	}
 	if log.IsLevelEnabled(log.DebugLevel) {
-		log.WithField("domain", "connector").
-			WithFields(log.Fields{"limit": c.limit, "pipes": len(c.pipes)}).
-			WithField("action", "start").
-			Debug("check limit")
+		log.Debug("check limit", log.Fields{"domain": "connector", "limit": c.limit, "pipes": len(c.pipes), "action": "start"})
 	}
 }
 
@@ -124,10 +160,7 @@ func (c *connector) stopConnecting() {
 		d.stop()
 	}
 	if log.IsLevelEnabled(log.DebugLevel) {
-		log.WithField("domain", "connector").
-			WithFields(log.Fields{"limit": c.limit, "pipes": len(c.pipes)}).
-			WithField("action", "stop").
-			Debug("check limit")
+		log.Debug("check limit", log.Fields{"domain": "connector", "limit": c.limit, "pipes": len(c.pipes), "action": "stop"})
 	}
 }
 
@@ -139,37 +172,36 @@ func (c *connector) addPipe(p *pipe) {
 		// negotiating
 		if err := c.negotiator.Negotiate(p); err != nil {
 			if log.IsLevelEnabled(log.DebugLevel) {
-				log.WithField("domain", "connector").
-					WithFields(log.Fields{"id": p.ID(), "raw": p.IsRaw(), "localAddress": p.LocalAddress(), "remoteAddress": p.RemoteAddress()}).
-					WithFields(log.Fields{"limit": c.limit, "pipes": len(c.pipes)}).
-					WithField("action", "netotiating").
-					WithError(err).
-					Error("add pipe")
+				log.Error("add pipe", log.Fields{"domain": "connector",
+					"id": p.ID(), "raw": p.IsRaw(), "localAddress": p.LocalAddress(), "remoteAddress": p.RemoteAddress(),
+					"limit": c.limit, "pipes": len(c.pipes), "action": "netotiating", log.ErrorKey: err})
 			}
 			return
 		}
 	}
 
-	if c.limit == -1 || c.limit > len(c.pipes) {
+	if (c.limit == -1 || c.limit > len(c.pipes)) && !c.schemeExceeded(p.scheme()) {
 		c.pipes[p.ID()] = p
 		if c.pipeEventHandler != nil {
 			c.pipeEventHandler(PipeEventAdd, p)
 		}
+		for _, pc := range c.pipeChannels {
+			pc.push(p)
+		}
+		p.startIdleCheck()
 
 		if log.IsLevelEnabled(log.DebugLevel) {
-			log.WithField("domain", "connector").
-				WithFields(log.Fields{"id": p.ID(), "raw": p.IsRaw(), "localAddress": p.LocalAddress(), "remoteAddress": p.RemoteAddress()}).
-				WithFields(log.Fields{"limit": c.limit, "pipes": len(c.pipes)}).
-				Debug("add pipe")
+			log.Debug("add pipe", log.Fields{"domain": "connector",
+				"id": p.ID(), "raw": p.IsRaw(), "localAddress": p.LocalAddress(), "remoteAddress": p.RemoteAddress(),
+				"limit": c.limit, "pipes": len(c.pipes)})
 		}
 
 		c.checkLimit(false)
 	} else {
 		if log.IsLevelEnabled(log.DebugLevel) {
-			log.WithField("domain", "connector").
-				WithFields(log.Fields{"id": p.ID(), "raw": p.IsRaw(), "localAddress": p.LocalAddress(), "remoteAddress": p.RemoteAddress()}).
-				WithFields(log.Fields{"limit": c.limit, "pipes": len(c.pipes)}).
-				Debug("drop pipe")
+			log.Debug("drop pipe", log.Fields{"domain": "connector",
+				"id": p.ID(), "raw": p.IsRaw(), "localAddress": p.LocalAddress(), "remoteAddress": p.RemoteAddress(),
+				"limit": c.limit, "pipes": len(c.pipes)})
 		}
 
 		go p.Close()
@@ -186,22 +218,49 @@ func (c *connector) remPipe(p *pipe) {
 
 	if log.IsLevelEnabled(log.DebugLevel) {
 		isRaw := p.GetOptionDefault(Options.Pipe.Raw)
-		log.WithField("domain", "connector").
-			WithFields(log.Fields{"id": p.ID(), "raw": isRaw, "localAddress": p.LocalAddress(), "remoteAddress": p.RemoteAddress()}).
-			WithFields(log.Fields{"limit": c.limit, "pipes": len(c.pipes)}).
-			Debug("remove pipe")
+		log.Debug("remove pipe", log.Fields{"domain": "connector",
+			"id": p.ID(), "raw": isRaw, "localAddress": p.LocalAddress(), "remoteAddress": p.RemoteAddress(),
+			"limit": c.limit, "pipes": len(c.pipes)})
 	}
 
+	c.Lock()
 	// If the pipe was from a dialer, inform it so that it can redial.
 	if d := p.d; d != nil {
 		go d.pipeClosed()
+		// wake up dialers waiting for pipes of the same address
+		for xd := range c.dialers {
+			if xd != d && xd.addr == d.addr && xd.isWaiting() {
+				go xd.redial()
+			}
+		}
 	}
-
-	c.Lock()
 	c.checkLimit(false)
 	c.Unlock()
 }
 
+// dialPermitted check if dialer d can dial without exceeding max pipes of its address,
+// d is set waiting if not permitted.
+func (c *connector) dialPermitted(d *dialer, maxPipes int) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	n := 0
+	for xd := range c.dialers {
+		if xd != d && xd.addr == d.addr && xd.isBusy() {
+			n++
+		}
+	}
+	if n < maxPipes {
+		return true
+	}
+
+	d.Lock()
+	d.dialing = false
+	d.waiting = true
+	d.Unlock()
+	return false
+}
+
 func (c *connector) SetNegotiator(negotiator Negotiator) {
 	c.Lock()
 	c.negotiator = negotiator
@@ -221,6 +280,19 @@ func (c *connector) DialOptions(addr string, ovs options.OptionValues) error {
 }
 
 func (c *connector) NewDialer(addr string, ovs options.OptionValues) (d Dialer, err error) {
+	return c.newDialer([]string{addr}, ovs)
+}
+
+func (c *connector) DialMulti(addrs []string, ovs options.OptionValues) error {
+	d, err := c.newDialer(addrs, ovs)
+	if err != nil {
+		return err
+	}
+	return d.Dial()
+}
+
+// newDialer create a dialer which fails over between addrs
+func (c *connector) newDialer(addrs []string, ovs options.OptionValues) (d Dialer, err error) {
 	c.Lock()
 	defer c.Unlock()
 
@@ -228,22 +300,25 @@ func (c *connector) NewDialer(addr string, ovs options.OptionValues) (d Dialer,
 		err = errs.ErrClosed
 		return
 	}
-
-	var (
-		t  transport.Transport
-		td transport.Dialer
-	)
-
-	if t = transport.GetTransportFromAddr(addr); t == nil {
-		err = errs.ErrBadTransport
+	if len(addrs) == 0 {
+		err = errs.ErrBadAddr
 		return
 	}
 
-	if td, err = t.NewDialer(addr); err != nil {
-		return
+	tds := make([]transport.Dialer, len(addrs))
+	for i, addr := range addrs {
+		var t transport.Transport
+		if t = transport.GetTransportFromAddr(addr); t == nil {
+			err = errs.ErrBadTransport
+			return
+		}
+
+		if tds[i], err = t.NewDialer(addr); err != nil {
+			return
+		}
 	}
 
-	xd := newDialer(c, addr, td, options.NewOptionsWithValuesAndSubs(ovs, c.Options))
+	xd := newDialer(c, addrs, tds, options.NewOptionsWithValuesAndSubs(ovs, c.Options))
 	if c.limit != -1 && c.limit <= len(c.pipes) {
 		// exceed limit
 		xd.stop()
@@ -332,6 +407,24 @@ func (c *connector) StopListen(addr string) {
 	c.Unlock()
 }
 
+func (c *connector) remListener(l *listener) {
+	c.Lock()
+	delete(c.listeners, l)
+	c.Unlock()
+}
+
+// listenerPipes get pipes accepted by listener l
+func (c *connector) listenerPipes(l *listener) (pipes []*pipe) {
+	c.RLock()
+	for _, p := range c.pipes {
+		if p.l == l {
+			pipes = append(pipes, p)
+		}
+	}
+	c.RUnlock()
+	return
+}
+
 func (c *connector) GetPipe(id uint32) Pipe {
 	c.RLock()
 	p := c.pipes[id]
@@ -342,6 +435,23 @@ func (c *connector) GetPipe(id uint32) Pipe {
 	return p
 }
 
+func (c *connector) Pipes() []Pipe {
+	c.RLock()
+	pipes := make([]Pipe, 0, len(c.pipes))
+	for _, p := range c.pipes {
+		pipes = append(pipes, p)
+	}
+	c.RUnlock()
+	return pipes
+}
+
+func (c *connector) PipeCount() int {
+	c.RLock()
+	n := len(c.pipes)
+	c.RUnlock()
+	return n
+}
+
 func (c *connector) ClosePipe(id uint32) {
 	c.RLock()
 	p := c.pipes[id]
@@ -351,6 +461,26 @@ func (c *connector) ClosePipe(id uint32) {
 	}
 }
 
+func (c *connector) ClosePipesWhere(match func(Pipe) bool) int {
+	var matched []*pipe
+	c.RLock()
+	for _, p := range c.pipes {
+		if match(p) {
+			matched = append(matched, p)
+		}
+	}
+	c.RUnlock()
+	if len(matched) > 0 {
+		// closing removes pipes from connector, so not in lock
+		go func() {
+			for _, p := range matched {
+				p.Close()
+			}
+		}()
+	}
+	return len(matched)
+}
+
 func (c *connector) Close() {
 	c.Lock()
 	if c.closed {
@@ -361,12 +491,18 @@ func (c *connector) Close() {
 	listeners := c.listeners
 	dialers := c.dialers
 	pipes := c.pipes
+	pipeChannels := c.pipeChannels
 
 	c.listeners = nil
 	c.dialers = nil
 	c.pipes = nil
+	c.pipeChannels = nil
 	c.Unlock()
 
+	for _, pc := range pipeChannels {
+		pc.close()
+	}
+
 	for l := range listeners {
 		l.Close()
 	}
@@ -377,6 +513,7 @@ func (c *connector) Close() {
 	for _, p := range pipes {
 		p.Close()
 	}
+	c.wg.Wait()
 }
 
 func (c *connector) SetPipeEventHandler(handler PipeEventHandlerFunc) {
@@ -390,3 +527,36 @@ func (c *connector) ClearPipeEventHandler(handler PipeEventHandlerFunc) {
 	c.pipeEventHandler = nil
 	c.Unlock()
 }
+
+func (c *connector) SetConnEventHandler(handler ConnEventHandlerFunc) {
+	c.Lock()
+	c.connEventHandler = handler
+	c.Unlock()
+}
+
+// connEvent notify connecting event, handler is called without holding lock.
+func (c *connector) connEvent(e PipeEvent, addr string, err error) {
+	c.RLock()
+	handler := c.connEventHandler
+	c.RUnlock()
+	if handler != nil {
+		handler(e, addr, err)
+	}
+}
+
+func (c *connector) AddPipeChannel(channel chan<- Pipe) {
+	c.Lock()
+	if !c.closed && c.pipeChannels[channel] == nil {
+		c.pipeChannels[channel] = newPipeChannel(channel)
+	}
+	c.Unlock()
+}
+
+func (c *connector) RemovePipeChannel(channel chan<- Pipe) {
+	c.Lock()
+	if pc := c.pipeChannels[channel]; pc != nil {
+		delete(c.pipeChannels, channel)
+		pc.close()
+	}
+	c.Unlock()
+}
@@ -6,33 +6,43 @@ import (
 	"time"
 
 	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/log"
 	"github.com/multisocket/multisocket/options"
 	"github.com/multisocket/multisocket/transport"
-	log "github.com/sirupsen/logrus"
 )
 
 type dialer struct {
 	options.Options
 	parent *connector
-	addr   string
+	addr   string // first address, identifies the dialer
 	transport.Dialer
 
+	// failover addresses, tried in order, rotated on each failed dial
+	addrs   []string
+	dialers []transport.Dialer
+	cur     int
+
 	sync.Mutex
 	closedq    chan struct{}
 	stopped    bool
 	active     bool
 	dialing    bool
 	connected  bool
+	waiting    bool // waiting for pipes of the same address to close
 	redialer   *time.Timer
+	nextDial   time.Time // when redialer fires
 	reconnTime time.Duration
+	attempts   int // failed redial attempts since last connected
 }
 
-func newDialer(parent *connector, addr string, td transport.Dialer, opts options.Options) *dialer {
+func newDialer(parent *connector, addrs []string, tds []transport.Dialer, opts options.Options) *dialer {
 	return &dialer{
 		Options: opts,
 		parent:  parent,
-		addr:    addr,
-		Dialer:  td,
+		addr:    addrs[0],
+		Dialer:  tds[0],
+		addrs:   addrs,
+		dialers: tds,
 		closedq: make(chan struct{}),
 	}
 }
@@ -46,6 +56,10 @@ func (d *dialer) maxReconnectTime() time.Duration {
 	return d.GetOptionDefault(Options.Dialer.MaxReconnectTime).(time.Duration)
 }
 
+func (d *dialer) backoffStrategy() uint8 {
+	return d.GetOptionDefault(Options.Dialer.BackoffStrategy).(uint8)
+}
+
 func (d *dialer) dialAsync() bool {
 	return d.GetOptionDefault(Options.Dialer.DialAsync).(bool)
 }
@@ -54,27 +68,83 @@ func (d *dialer) reconnect() bool {
 	return d.GetOptionDefault(Options.Dialer.Reconnect).(bool)
 }
 
-func (d *dialer) Dial() error {
+func (d *dialer) maxPipes() int {
+	return d.GetOptionDefault(Options.Dialer.MaxPipes).(int)
+}
+
+func (d *dialer) maxReconnectAttempts() int {
+	return d.GetOptionDefault(Options.Dialer.MaxReconnectAttempts).(int)
+}
+
+func (d *dialer) onGiveUp() DialGiveUpFunc {
+	switch f := Options.Dialer.OnGiveUp.ValueFrom(d.Options).(type) {
+	case DialGiveUpFunc:
+		return f
+	case func(string, error):
+		return f
+	}
+	return nil
+}
+
+func (d *dialer) isBusy() bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.dialing || d.connected
+}
+
+func (d *dialer) isWaiting() bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.waiting
+}
+
+// activate mark dialer as active, a dialer can only be activated once.
+func (d *dialer) activate() error {
 	select {
 	case <-d.closedq:
 		return errs.ErrClosed
 	default:
 	}
 	d.Lock()
+	defer d.Unlock()
 	if d.active {
-		d.Unlock()
 		return errs.ErrAddrInUse
 	}
 
 	d.active = true
 	d.reconnTime = d.minReconnectTime()
-	d.Unlock()
+	return nil
+}
+
+func (d *dialer) Dial() error {
+	if err := d.activate(); err != nil {
+		return err
+	}
 	async := d.dialAsync()
 	if async {
 		go d.redial()
 		return nil
 	}
-	return d.dial(false)
+	// try each address once
+	var err error
+	for range d.dialers {
+		if err = d.dial(false); err == nil {
+			break
+		}
+	}
+	return err
+}
+
+func (d *dialer) DialWithResult() (<-chan error, error) {
+	if err := d.activate(); err != nil {
+		return nil, err
+	}
+	result := make(chan error, 1)
+	go func() {
+		// same as async dial, failed dial will be retried
+		result <- d.dial(true)
+	}()
+	return result, nil
 }
 
 func (d *dialer) Close() error {
@@ -86,6 +156,10 @@ func (d *dialer) Close() error {
 	default:
 		close(d.closedq)
 	}
+	if d.redialer != nil {
+		d.redialer.Stop()
+		d.redialer = nil
+	}
 	d.Unlock()
 	return nil
 }
@@ -132,6 +206,7 @@ func (d *dialer) reconn() bool {
 		d.redialer.Stop()
 	}
 	d.redialer = time.AfterFunc(d.reconnTime, d.redial)
+	d.nextDial = time.Now().Add(d.reconnTime)
 	d.Unlock()
 	return true
 }
@@ -168,17 +243,27 @@ func (d *dialer) dial(redial bool) error {
 		d.redialer = nil
 	}
 	d.dialing = true
+	d.waiting = false
+	addr, td := d.addrs[d.cur], d.dialers[d.cur]
 	d.Unlock()
 
+	if maxPipes := d.maxPipes(); maxPipes > 0 && !d.parent.dialPermitted(d, maxPipes) {
+		if log.IsLevelEnabled(log.DebugLevel) {
+			log.Debug("dial", log.Fields{"addr": d.addr, "action": "wait", "maxPipes": maxPipes})
+		}
+		return ErrMaxPipes
+	}
+
 	if log.IsLevelEnabled(log.DebugLevel) {
 		raw := Options.Pipe.Raw.ValueFrom(d.Options)
-		log.WithFields(log.Fields{"addr": d.addr, "action": "start", "raw": raw}).Debug("dial")
+		log.Debug("dial", log.Fields{"addr": addr, "action": "start", "raw": raw})
 	}
-	tc, err := d.Dialer.Dial(d.Options)
+	d.parent.connEvent(PipeEventDialing, addr, nil)
+	tc, err := td.Dial(d.Options)
 	if err == nil {
 		if log.IsLevelEnabled(log.DebugLevel) {
 			raw := Options.Pipe.Raw.ValueFrom(d.Options)
-			log.WithFields(log.Fields{"addr": d.addr, "action": "success", "raw": raw}).Debug("dial")
+			log.Debug("dial", log.Fields{"addr": addr, "action": "success", "raw": raw})
 		}
 		d.parent.addPipe(newPipe(d.parent, tc, d, nil, d.Options))
 
@@ -186,16 +271,17 @@ func (d *dialer) dial(redial bool) error {
 		d.dialing = false
 		d.connected = true
 		d.reconnTime = d.minReconnectTime()
+		d.attempts = 0
 		d.Unlock()
 		return nil
 	}
 	if log.IsLevelEnabled(log.DebugLevel) {
 		raw := Options.Pipe.Raw.ValueFrom(d.Options)
-		log.WithError(err).WithFields(log.Fields{"addr": d.addr, "action": "failed", "raw": raw}).Error("dial")
+		log.Error("dial", log.Fields{"addr": addr, "action": "failed", "raw": raw, log.ErrorKey: err})
 	}
+	d.parent.connEvent(PipeEventDialFailed, addr, err)
 
 	d.Lock()
-	defer d.Unlock()
 	// We're no longer dialing, so let another reschedule happen, if
 	// appropriate.   This is quite possibly paranoia.  We should only
 	// be in this routine in the following circumstances:
@@ -208,33 +294,96 @@ func (d *dialer) dial(redial bool) error {
 	// Consider removing the d.dialing logic later if we can prove
 	// that this never occurs.
 	d.dialing = false
+	// fail over to the next address, backoff goes on across the addresses.
+	d.cur = (d.cur + 1) % len(d.dialers)
+	d.Dialer = d.dialers[d.cur]
 
 	if !redial {
+		d.Unlock()
 		return err
 	}
 
-	// Exponential backoff, and jitter.  Our backoff grows at
-	// about 1.3x on average, so we don't penalize a failed
-	// connection too badly.
-	minfact := float64(1.1)
-	maxfact := float64(1.5)
-	actfact := rand.Float64()*(maxfact-minfact) + minfact
-	rtime := d.reconnTime
-	d.reconnTime = time.Duration(actfact * float64(d.reconnTime))
-	reconnMaxTime := d.maxReconnectTime()
-	if reconnMaxTime != 0 {
-		if d.reconnTime > reconnMaxTime {
-			d.reconnTime = reconnMaxTime
-		}
+	d.attempts++
+	if maxAttempts := d.maxReconnectAttempts(); maxAttempts > 0 && d.attempts >= maxAttempts {
+		d.Unlock()
+		d.giveUp(err)
+		return err
 	}
+
+	rtime := d.reconnTime
+	d.reconnTime = d.nextReconnectTime(rtime)
 	d.redialer = time.AfterFunc(rtime, d.redial)
+	d.nextDial = time.Now().Add(rtime)
+	d.Unlock()
 	return err
 }
 
+// nextReconnectTime get reconnect time after a failed dial waited for rtime, by BackoffStrategy.
+func (d *dialer) nextReconnectTime(rtime time.Duration) time.Duration {
+	switch d.backoffStrategy() {
+	case BackoffConstant:
+		return d.minReconnectTime()
+	case BackoffLinear:
+		rtime += d.minReconnectTime()
+	default:
+		// Exponential backoff, and jitter.  Our backoff grows at
+		// about 1.3x on average, so we don't penalize a failed
+		// connection too badly.
+		minfact := float64(1.1)
+		maxfact := float64(1.5)
+		actfact := rand.Float64()*(maxfact-minfact) + minfact
+		rtime = time.Duration(actfact * float64(rtime))
+	}
+	if reconnMaxTime := d.maxReconnectTime(); reconnMaxTime != 0 && rtime > reconnMaxTime {
+		rtime = reconnMaxTime
+	}
+	return rtime
+}
+
+// giveUp stop dialing, and notify OnGiveUp hook.
+func (d *dialer) giveUp(lastErr error) {
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debug("dial", log.Fields{"addr": d.addr, "action": "giveup", "attempts": d.maxReconnectAttempts(), log.ErrorKey: lastErr})
+	}
+	d.parent.remDialer(d)
+	if onGiveUp := d.onGiveUp(); onGiveUp != nil {
+		onGiveUp(d.addr, lastErr)
+	}
+}
+
 func (d *dialer) redial() {
 	d.dial(true)
 }
 
+// curAddr get the address being dialed or connected
+func (d *dialer) curAddr() string {
+	d.Lock()
+	defer d.Unlock()
+	return d.addrs[d.cur]
+}
+
+func (d *dialer) State() (state DialerState) {
+	d.Lock()
+	defer d.Unlock()
+	state.Addr = d.addrs[d.cur]
+	select {
+	case <-d.closedq:
+		state.Closed = true
+		return
+	default:
+	}
+	state.Connected = d.connected
+	state.Dialing = d.dialing
+	state.Attempts = d.attempts
+	if d.redialer != nil && !d.stopped && !d.dialing && !d.connected {
+		state.BackingOff = true
+		state.NextAttempt = d.nextDial
+	}
+	return
+}
+
 func (d *dialer) TransportDialer() transport.Dialer {
+	d.Lock()
+	defer d.Unlock()
 	return d.Dialer
 }
@@ -7,4 +7,7 @@ import (
 // errors
 const (
 	ErrStopped = errs.Err("object is stopped")
+	// ErrMaxPipes is returned when dialer's address already has max pipes,
+	// the dialer will dial again when a pipe to the address is closed.
+	ErrMaxPipes = errs.Err("exceed max pipes")
 )
@@ -0,0 +1,172 @@
+package connector
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/multisocket/multisocket/bytespool"
+	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/message"
+)
+
+type (
+	// Framer reads and writes frames on stream connections,
+	// so that pipes can speak length-prefixed, line-based or other foreign wire protocols.
+	Framer interface {
+		// ReadFrame read a frame's payload, the returned bytes may be put back to bytespool after used.
+		ReadFrame(r io.Reader) ([]byte, error)
+		// WriteFrame write b as a frame.
+		WriteFrame(w io.Writer, b []byte) error
+	}
+
+	nativeFramer struct {
+		maxLength uint32
+	}
+
+	lengthPrefixedFramer struct {
+		maxLength uint32
+	}
+
+	lineFramer struct {
+		maxLength int
+	}
+
+	vectorWriter interface {
+		Writev(v ...[]byte) (int64, error)
+	}
+)
+
+var (
+	// NativeFramer frames multisocket encoded messages, it's the default wire format of stream pipes.
+	// Pipes using it limit received content length by MaxRecvContentLength.
+	NativeFramer Framer = nativeFramer{}
+	// LineFramer frames newline delimited data, the trailing "\n" or "\r\n" is stripped when reading.
+	LineFramer Framer = lineFramer{}
+
+	lineDelimiter = []byte{'\n'}
+)
+
+// NewNativeFramer create a native framer which limits received content length, 0 for no limit.
+func NewNativeFramer(maxLength uint32) Framer {
+	return nativeFramer{maxLength: maxLength}
+}
+
+// NewLengthPrefixedFramer create a framer of frames prefixed by 4 bytes big endian length,
+// maxLength limits received frame length, 0 for no limit.
+func NewLengthPrefixedFramer(maxLength uint32) Framer {
+	return lengthPrefixedFramer{maxLength: maxLength}
+}
+
+// NewLineFramer create a line framer which limits received line length, 0 for no limit.
+func NewLineFramer(maxLength int) Framer {
+	return lineFramer{maxLength: maxLength}
+}
+
+func writeFrame(w io.Writer, v ...[]byte) (err error) {
+	if vw, ok := w.(vectorWriter); ok {
+		_, err = vw.Writev(v...)
+		return
+	}
+	for _, b := range v {
+		if _, err = w.Write(b); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (f nativeFramer) ReadFrame(r io.Reader) (b []byte, err error) {
+	var metaBuf [message.MetaSize]byte
+	if _, err = io.ReadFull(r, metaBuf[:]); err != nil {
+		return
+	}
+	meta := message.DecodeMeta(metaBuf[:])
+	// check before alloc, path sizes are bounded by Hops and Distance
+	if f.maxLength != 0 && meta.Length > f.maxLength {
+		err = errs.ErrContentTooLong
+		return
+	}
+	b = bytespool.Alloc(meta.FrameSize())
+	copy(b, metaBuf[:])
+	if _, err = io.ReadFull(r, b[message.MetaSize:]); err != nil {
+		bytespool.Free(b)
+		b = nil
+	}
+	return
+}
+
+func (nativeFramer) WriteFrame(w io.Writer, b []byte) (err error) {
+	_, err = w.Write(b)
+	return
+}
+
+func (f lengthPrefixedFramer) ReadFrame(r io.Reader) (b []byte, err error) {
+	var lenBuf [4]byte
+	if _, err = io.ReadFull(r, lenBuf[:]); err != nil {
+		return
+	}
+	length := binary.BigEndian.Uint32(lenBuf[:])
+	if f.maxLength != 0 && length > f.maxLength {
+		err = errs.ErrContentTooLong
+		return
+	}
+	b = bytespool.Alloc(int(length))
+	if _, err = io.ReadFull(r, b); err != nil {
+		bytespool.Free(b)
+		b = nil
+	}
+	return
+}
+
+func (f lengthPrefixedFramer) WriteFrame(w io.Writer, b []byte) error {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(b)))
+	return writeFrame(w, lenBuf[:], b)
+}
+
+// ReadFrame read line byte by byte, reader should be buffered to avoid excessive reads.
+func (f lineFramer) ReadFrame(r io.Reader) (b []byte, err error) {
+	var (
+		n int
+		c [1]byte
+	)
+	b = bytespool.Alloc(64)[:0]
+	for {
+		if n, err = r.Read(c[:]); err != nil {
+			if err == io.EOF && len(b) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			bytespool.Free(b)
+			b = nil
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		if c[0] == '\n' {
+			break
+		}
+		if f.maxLength != 0 && len(b) >= f.maxLength {
+			bytespool.Free(b)
+			b = nil
+			err = errs.ErrContentTooLong
+			return
+		}
+		if len(b) == cap(b) {
+			// grow in pool
+			nb := bytespool.Alloc(2 * cap(b))[:len(b)]
+			copy(nb, b)
+			bytespool.Free(b)
+			b = nb
+		}
+		b = append(b, c[0])
+	}
+	if n = len(b); n > 0 && b[n-1] == '\r' {
+		b = b[:n-1]
+	}
+	return
+}
+
+func (f lineFramer) WriteFrame(w io.Writer, b []byte) error {
+	return writeFrame(w, b, lineDelimiter)
+}
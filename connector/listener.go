@@ -5,9 +5,9 @@ import (
 	"time"
 
 	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/log"
 	"github.com/multisocket/multisocket/options"
 	"github.com/multisocket/multisocket/transport"
-	log "github.com/sirupsen/logrus"
 )
 
 type listener struct {
@@ -17,7 +17,8 @@ type listener struct {
 	addr   string
 	transport.Listener
 	sync.Mutex
-	closed bool
+	closed  bool
+	closedq chan struct{}
 
 	stopped bool
 }
@@ -29,6 +30,7 @@ func newListener(parent *connector, addr string, tl transport.Listener, opts opt
 		addr:     addr,
 		Listener: tl,
 		closed:   false,
+		closedq:  make(chan struct{}),
 	}
 }
 
@@ -60,9 +62,10 @@ func (l *listener) isStopped() bool {
 
 // serve spins in a loop, calling the accepter's Accept routine.
 func (l *listener) serve() {
+	defer l.parent.wg.Done()
 	if log.IsLevelEnabled(log.DebugLevel) {
 		raw := Options.Pipe.Raw.ValueFrom(l.Options)
-		log.WithFields(log.Fields{"addr": l.addr, "action": "start", "raw": raw}).Debug("accept")
+		log.Debug("accept", log.Fields{"addr": l.addr, "action": "start", "raw": raw})
 	}
 	for {
 		// If the underlying PipeListener is closed, or not
@@ -73,8 +76,15 @@ func (l *listener) serve() {
 			if l.isStopped() {
 				tc.Close()
 			} else {
-				go l.parent.addPipe(newPipe(l.parent, tc, nil, l, l.Options))
+				l.parent.connEvent(PipeEventAccepted, tc.RemoteAddress(), nil)
+				l.parent.wg.Add(1)
+				go func() {
+					defer l.parent.wg.Done()
+					l.parent.addPipe(newPipe(l.parent, tc, nil, l, l.Options))
+				}()
 			}
+		} else if l.isClosed() {
+			break
 		} else {
 			// Debounce a little bit, to avoid thrashing the CPU.
 			time.Sleep(time.Second / 100)
@@ -82,15 +92,52 @@ func (l *listener) serve() {
 	}
 	if log.IsLevelEnabled(log.DebugLevel) {
 		raw := Options.Pipe.Raw.ValueFrom(l.Options)
-		log.WithFields(log.Fields{"addr": l.addr, "action": "end", "raw": raw}).Debug("accept")
+		log.Debug("accept", log.Fields{"addr": l.addr, "action": "end", "raw": raw})
 	}
 }
 
-func (l *listener) Listen() error {
-	if err := l.Listener.Listen(l.Options); err != nil {
-		return err
+func (l *listener) isClosed() bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.closed
+}
+
+// Listen start listening, retry with backoff on failure if ListenRetry is set,
+// returns ErrClosed once the listener is closed while retrying.
+func (l *listener) Listen() (err error) {
+	var (
+		retry        = Options.Listener.ListenRetry.ValueFrom(l.Options)
+		retryTime    = Options.Listener.MinRetryTime.ValueFrom(l.Options)
+		maxRetryTime = Options.Listener.MaxRetryTime.ValueFrom(l.Options)
+	)
+	for {
+		if err = l.Listener.Listen(l.Options); err == nil {
+			break
+		}
+		if err == errs.ErrClosed || retry == 0 || l.isClosed() {
+			return
+		}
+		if retry > 0 {
+			retry--
+		}
+		if log.IsLevelEnabled(log.DebugLevel) {
+			log.Debug("listen retry", log.Fields{"addr": l.addr, "retryTime": retryTime, log.ErrorKey: err})
+		}
+		tm := time.NewTimer(retryTime)
+		select {
+		case <-l.closedq:
+			tm.Stop()
+			return errs.ErrClosed
+		case <-tm.C:
+		}
+
+		retryTime *= 2
+		if maxRetryTime != 0 && retryTime > maxRetryTime {
+			retryTime = maxRetryTime
+		}
 	}
 
+	l.parent.wg.Add(1)
 	go l.serve()
 	return nil
 }
@@ -102,9 +149,46 @@ func (l *listener) Close() error {
 		return errs.ErrClosed
 	}
 	l.closed = true
+	close(l.closedq)
 	return l.Listener.Close()
 }
 
+func (l *listener) CloseGracefully(timeout time.Duration) (err error) {
+	// stop accepting, keep accepted pipes
+	l.parent.remListener(l)
+	if err = l.Close(); err != nil {
+		return
+	}
+
+	var tmC <-chan time.Time
+	if timeout > 0 {
+		tm := time.NewTimer(timeout)
+		defer tm.Stop()
+		tmC = tm.C
+	}
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for len(l.parent.listenerPipes(l)) > 0 {
+		select {
+		case <-tmC:
+			// force close survivors
+			for _, p := range l.parent.listenerPipes(l) {
+				p.Close()
+			}
+			return errs.ErrTimeout
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (l *listener) BoundAddress() string {
+	if tl, ok := l.Listener.(interface{ Address() string }); ok {
+		return tl.Address()
+	}
+	return l.addr
+}
+
 func (l *listener) TransportListener() transport.Listener {
 	return l.Listener
 }
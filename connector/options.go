@@ -12,7 +12,22 @@ type (
 		Reconnect        options.BoolOption
 		MinReconnectTime options.TimeDurationOption
 		MaxReconnectTime options.TimeDurationOption
-		DialAsync        options.BoolOption
+		// how reconnect time grows after failed dials, see Backoff* strategies
+		BackoffStrategy options.Uint8Option
+		DialAsync       options.BoolOption
+		// max concurrent pipes dialed to the same address, 0 for no limit
+		MaxPipes options.IntOption
+		// max failed redial attempts before giving up, 0 for no limit
+		MaxReconnectAttempts options.IntOption
+		// DialGiveUpFunc called when dialer gives up, nil for none
+		OnGiveUp options.AnyOption
+	}
+
+	listenerOptions struct {
+		// retry times when listen failed, 0 for no retry, -1 for retry forever
+		ListenRetry  options.IntOption
+		MinRetryTime options.TimeDurationOption
+		MaxRetryTime options.TimeDurationOption
 	}
 
 	pipeOptions struct {
@@ -20,17 +35,40 @@ type (
 		Raw            options.BoolOption
 		RawRecvBufSize options.IntOption
 		// close pipe when peer shutdown write(half-close, cause EOF)
-		CloseOnEOF           options.BoolOption
+		CloseOnEOF options.BoolOption
+		// max content length of received messages, longer ones are rejected.
+		// like other pipe options, it can be set per dialer or listener by their option values.
 		MaxRecvContentLength options.Uint32Option
+		// Framer of stream pipes, nil for native multisocket framing
+		Framer options.AnyOption
+		// ping peer every interval, 0 for no keepalive
+		KeepAliveInterval options.TimeDurationOption
+		// close pipe if no pong received within timeout after ping
+		KeepAliveTimeout options.TimeDurationOption
+		// close pipe if no message is sent or received for the duration, 0 for never
+		IdleTimeout options.TimeDurationOption
 	}
 
 	connectorOptions struct {
 		PipeLimit options.IntOption
 		Dialer    dialerOptions
+		Listener  listenerOptions
 		Pipe      pipeOptions
+		// map[string]int, max pipes of each transport scheme, schemes not in it are only limited by PipeLimit
+		PipeLimitByScheme options.AnyOption
 	}
 )
 
+// dial backoff strategies
+const (
+	// grow about 1.3x with jitter, up to MaxReconnectTime
+	BackoffExponential uint8 = iota
+	// grow by MinReconnectTime, up to MaxReconnectTime
+	BackoffLinear
+	// always MinReconnectTime
+	BackoffConstant
+)
+
 var (
 	// OptionDomains is option's domain
 	OptionDomains = []string{"Connector"}
@@ -41,15 +79,30 @@ var (
 			Reconnect:        options.NewBoolOption(true),
 			MinReconnectTime: options.NewTimeDurationOption(100 * time.Millisecond),
 			MaxReconnectTime: options.NewTimeDurationOption(8 * time.Second),
+			BackoffStrategy:  options.NewUint8Option(BackoffExponential),
 			DialAsync:        options.NewBoolOption(false),
+			MaxPipes:         options.NewIntOption(0),
+
+			MaxReconnectAttempts: options.NewIntOption(0),
+			OnGiveUp:             options.NewAnyOption(DialGiveUpFunc(nil)),
+		},
+		Listener: listenerOptions{
+			ListenRetry:  options.NewIntOption(0),
+			MinRetryTime: options.NewTimeDurationOption(100 * time.Millisecond),
+			MaxRetryTime: options.NewTimeDurationOption(8 * time.Second),
 		},
 		Pipe: pipeOptions{
-			ReadBuffer:           options.NewIntOption(0),
+			ReadBuffer:           options.NewIntOption(8 * 1024), // 0 for no buffer
 			Raw:                  options.NewBoolOption(false),
 			RawRecvBufSize:       options.NewIntOption(4 * 1024),
 			CloseOnEOF:           options.NewBoolOption(true),
 			MaxRecvContentLength: options.NewUint32Option(128 * 1024), // 0 for no limit
+			Framer:               options.NewAnyOption(Framer(nil)),
+			KeepAliveInterval:    options.NewTimeDurationOption(0),
+			KeepAliveTimeout:     options.NewTimeDurationOption(5 * time.Second),
+			IdleTimeout:          options.NewTimeDurationOption(0),
 		},
+		PipeLimitByScheme: options.NewAnyOption(map[string]int(nil)),
 	}
 )
 
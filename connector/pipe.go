@@ -4,13 +4,17 @@ import (
 	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/multisocket/multisocket/message"
 	"github.com/multisocket/multisocket/options"
 
 	"bufio"
 
+	"github.com/multisocket/multisocket/bytespool"
 	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/log"
 	"github.com/multisocket/multisocket/transport"
 	"github.com/multisocket/multisocket/utils"
 )
@@ -18,18 +22,32 @@ import (
 // pipe wraps the transport.Connection data structure with the stuff we need to keep.
 // It implements the Pipe interface.
 type pipe struct {
+	// stats, keep 64-bit aligned for atomic operations
+	bytesSent uint64
+	bytesRecv uint64
+	msgsSent  uint64
+	msgsRecv  uint64
+	// unix nano time of last sent or received message, only tracked if idleTimeout > 0
+	lastActive int64
+
 	options.Options
 	transport.Connection
 	closeOnEOF           bool
 	raw                  bool
 	maxRecvContentLength uint32
+	readDeadline         time.Duration
+	writeDeadline        time.Duration
+	idleTimeout          time.Duration
 	id                   uint32
 	parent               *connector
 	d                    *dialer
 	l                    *listener
 
 	// Reader
-	r io.Reader
+	r  io.Reader
+	br *bufio.Reader
+
+	framer Framer
 
 	sr  SendReceiver
 	msr MsgSendReceiver
@@ -41,15 +59,20 @@ type pipe struct {
 
 	// for read message meta data
 	metaBuf []byte
+	// for vectored send of a batch of messages, nil if pipe can not do vectored send
+	sendBufs [][]byte
 	// for recv raw message
 	rawRecvBuf []byte
 
 	sync.Mutex
-	closed bool
+	closed    bool
+	idleTimer *time.Timer
+	userData  interface{}
 }
 
 var (
-	pipeID = utils.NewRecyclableIDGenerator()
+	pipeID     = utils.NewRecyclableIDGenerator()
+	emptyFrame = []byte{}
 )
 
 func newPipe(parent *connector, tc transport.Connection, d *dialer, l *listener, opts options.Options) *pipe {
@@ -59,7 +82,11 @@ func newPipe(parent *connector, tc transport.Connection, d *dialer, l *listener,
 		closeOnEOF: Options.Pipe.CloseOnEOF.ValueFrom(opts),
 		raw:        Options.Pipe.Raw.ValueFrom(opts),
 
-		id:     pipeID.NextID(),
+		readDeadline:  transport.Options.ReadDeadline.ValueFrom(opts),
+		writeDeadline: transport.Options.WriteDeadline.ValueFrom(opts),
+		idleTimeout:   Options.Pipe.IdleTimeout.ValueFrom(opts),
+
+		id:     parent.idGen.NextID(),
 		parent: parent,
 		d:      d,
 		l:      l,
@@ -69,9 +96,12 @@ func newPipe(parent *connector, tc transport.Connection, d *dialer, l *listener,
 	}
 	readBuffer := opts.GetOptionDefault(Options.Pipe.ReadBuffer).(int)
 	if readBuffer > 0 {
-		p.r = bufio.NewReaderSize(tc, readBuffer)
+		p.br = bufio.NewReaderSize(tc, readBuffer)
+		p.r = p.br
 	}
 
+	framer, _ := Options.Pipe.Framer.ValueFrom(opts).(Framer)
+
 	p.msgFreeLevel = message.FreeAll
 	if p.raw {
 		if sr, ok := tc.RawConn().(SendReceiver); ok {
@@ -79,6 +109,11 @@ func newPipe(parent *connector, tc transport.Connection, d *dialer, l *listener,
 			// funcs
 			p.sendMsgFunc = p.sendBlockRawMsg
 			p.recvMsgFunc = p.recvBlockRawMsg
+		} else if framer != nil {
+			p.framer = framer
+			// funcs
+			p.sendMsgFunc = p.sendFramedRawMsg
+			p.recvMsgFunc = p.recvFramedRawMsg
 		} else {
 			// funcs
 			p.sendMsgFunc = p.sendRawMsg
@@ -95,7 +130,7 @@ func newPipe(parent *connector, tc transport.Connection, d *dialer, l *listener,
 		if msr, ok := tc.RawConn().(MsgSendReceiver); ok {
 			p.msr = msr
 			// funcs
-			p.sendMsgFunc = msr.SendMsg
+			p.sendMsgFunc = p.sendDirectMsg
 			p.recvMsgFunc = p.recvDirectMsg
 
 			if strings.HasPrefix(tc.Transport().Scheme(), "inproc.channel") {
@@ -107,12 +142,24 @@ func newPipe(parent *connector, tc transport.Connection, d *dialer, l *listener,
 				// funcs
 				p.sendMsgFunc = p.sendBlockMsg
 				p.recvMsgFunc = p.recvBlockMsg
+			} else if framer != nil {
+				p.framer = framer
+				if framer == NativeFramer {
+					p.framer = NewNativeFramer(p.maxRecvContentLength)
+				}
+				// funcs
+				p.sendMsgFunc = p.sendFramedMsg
+				p.recvMsgFunc = p.recvFramedMsg
 			} else {
 				// funcs
 				p.sendMsgFunc = p.sendMsg
 				p.recvMsgFunc = p.recvMsg
+				if p.br != nil {
+					p.recvMsgFunc = p.recvBufferedMsg
+				}
 				// alloc
 				p.metaBuf = make([]byte, message.MetaSize)
+				p.sendBufs = make([][]byte, 0, 16)
 			}
 			if strings.HasPrefix(tc.Transport().Scheme(), "inproc.channel") {
 				p.msgFreeLevel = message.FreeMsg
@@ -131,10 +178,30 @@ func (p *pipe) IsRaw() bool {
 	return p.raw
 }
 
+func (p *pipe) IsInbound() bool {
+	return p.l != nil
+}
+
 func (p *pipe) MsgFreeLevel() message.FreeLevel {
 	return p.msgFreeLevel
 }
 
+func (p *pipe) BytesSent() uint64 {
+	return atomic.LoadUint64(&p.bytesSent)
+}
+
+func (p *pipe) BytesRecv() uint64 {
+	return atomic.LoadUint64(&p.bytesRecv)
+}
+
+func (p *pipe) MsgsSent() uint64 {
+	return atomic.LoadUint64(&p.msgsSent)
+}
+
+func (p *pipe) MsgsRecv() uint64 {
+	return atomic.LoadUint64(&p.msgsRecv)
+}
+
 func (p *pipe) Close() error {
 	p.Lock()
 	if p.closed {
@@ -142,19 +209,90 @@ func (p *pipe) Close() error {
 		return errs.ErrClosed
 	}
 	p.closed = true
+	if p.idleTimer != nil {
+		p.idleTimer.Stop()
+	}
 	p.Unlock()
 
 	p.Connection.Close()
 	p.parent.remPipe(p)
 
-	pipeID.Recycle(p.id)
+	p.parent.idGen.Recycle(p.id)
 
 	return nil
 }
 
+// touch mark pipe active now
+func (p *pipe) touch() {
+	if p.idleTimeout > 0 {
+		atomic.StoreInt64(&p.lastActive, time.Now().UnixNano())
+	}
+}
+
+// startIdleCheck close pipe once no message is sent or received for idleTimeout
+func (p *pipe) startIdleCheck() {
+	if p.idleTimeout <= 0 {
+		return
+	}
+	p.touch()
+	p.Lock()
+	if !p.closed {
+		p.idleTimer = time.AfterFunc(p.idleTimeout, p.checkIdle)
+	}
+	p.Unlock()
+}
+
+func (p *pipe) checkIdle() {
+	idle := time.Since(time.Unix(0, atomic.LoadInt64(&p.lastActive)))
+	if idle >= p.idleTimeout {
+		if log.IsLevelEnabled(log.DebugLevel) {
+			log.Debug("close idle pipe", log.Fields{"domain": "connector", "id": p.id, "idle": idle})
+		}
+		p.Close()
+		return
+	}
+	p.Lock()
+	if !p.closed {
+		p.idleTimer.Reset(p.idleTimeout - idle)
+	}
+	p.Unlock()
+}
+
+// scheme get the scheme of address which the pipe is dialed to or accepted from
+func (p *pipe) scheme() string {
+	if p.d != nil {
+		return transport.ParseScheme(p.d.curAddr())
+	}
+	if p.l != nil {
+		return transport.ParseScheme(p.l.addr)
+	}
+	return p.Transport().Scheme()
+}
+
+func (p *pipe) CloseWrite() error {
+	if cw, ok := p.RawConn().(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errs.ErrOperationNotSupported
+}
+
+func (p *pipe) SetUserData(data interface{}) {
+	p.Lock()
+	p.userData = data
+	p.Unlock()
+}
+
+func (p *pipe) UserData() interface{} {
+	p.Lock()
+	defer p.Unlock()
+	return p.userData
+}
+
 func (p *pipe) Read(b []byte) (n int, err error) {
 	// if n, err = p.Connection.Read(b); err != nil {
-	if n, err = p.r.Read(b); err != nil {
+	n, err = p.r.Read(b)
+	atomic.AddUint64(&p.bytesRecv, uint64(n))
+	if err != nil {
 		if err == io.EOF {
 			if n > 0 {
 				err = nil
@@ -171,8 +309,33 @@ func (p *pipe) Read(b []byte) (n int, err error) {
 	return
 }
 
+// peek peek n bytes from buffered reader without advancing it.
+func (p *pipe) peek(n int) (b []byte, err error) {
+	if b, err = p.br.Peek(n); err != nil {
+		if err == io.EOF {
+			if len(b) > 0 {
+				// incomplete message
+				err = io.ErrUnexpectedEOF
+				if errx := p.Close(); errx != nil {
+					err = errx
+				}
+			} else if p.closeOnEOF {
+				p.Close()
+				err = errs.ErrClosed
+			}
+		} else if err != bufio.ErrBufferFull {
+			if errx := p.Close(); errx != nil {
+				err = errx
+			}
+		}
+	}
+	return
+}
+
 func (p *pipe) recv() (b []byte, err error) {
-	if b, err = p.sr.Recv(); err != nil {
+	b, err = p.sr.Recv()
+	atomic.AddUint64(&p.bytesRecv, uint64(len(b)))
+	if err != nil {
 		if err == io.EOF {
 			if len(b) > 0 {
 				err = nil
@@ -190,7 +353,9 @@ func (p *pipe) recv() (b []byte, err error) {
 }
 
 func (p *pipe) Write(b []byte) (n int, err error) {
-	if n, err = p.Connection.Write(b); err != nil {
+	n, err = p.Connection.Write(b)
+	atomic.AddUint64(&p.bytesSent, uint64(n))
+	if err != nil {
 		if errx := p.Close(); errx != nil {
 			err = errx
 		}
@@ -199,16 +364,26 @@ func (p *pipe) Write(b []byte) (n int, err error) {
 }
 
 func (p *pipe) send(b []byte) (err error) {
-	if err = p.sr.Send(b); err != nil {
+	if err = p.sr.Send(b); err == transport.ErrMsgTooLarge {
+		// rejected by transport, drop it and keep the pipe
+		if log.IsLevelEnabled(log.WarnLevel) {
+			log.Warn("send", log.Fields{"id": p.id, "size": len(b), log.ErrorKey: err})
+		}
+		return
+	} else if err != nil {
 		if errx := p.Close(); errx != nil {
 			err = errx
 		}
+		return
 	}
+	atomic.AddUint64(&p.bytesSent, uint64(len(b)))
 	return
 }
 
 func (p *pipe) Writev(v ...[]byte) (n int64, err error) {
-	if n, err = p.Connection.Writev(v...); err != nil {
+	n, err = p.Connection.Writev(v...)
+	atomic.AddUint64(&p.bytesSent, uint64(n))
+	if err != nil {
 		if errx := p.Close(); errx != nil {
 			err = errx
 		}
@@ -216,8 +391,72 @@ func (p *pipe) Writev(v ...[]byte) (n int64, err error) {
 	return
 }
 
+// setReadDeadline set connection's read deadline if configured,
+// deadline exceeded errors close the pipe as other read errors.
+func (p *pipe) setReadDeadline() {
+	if p.readDeadline > 0 {
+		// not all connections support deadlines, e.g. inproc
+		p.Connection.SetReadDeadline(time.Now().Add(p.readDeadline))
+	}
+}
+
+// setWriteDeadline set connection's write deadline if configured.
+func (p *pipe) setWriteDeadline() {
+	if p.writeDeadline > 0 {
+		p.Connection.SetWriteDeadline(time.Now().Add(p.writeDeadline))
+	}
+}
+
 func (p *pipe) SendMsg(msg *message.Message) (err error) {
-	return p.sendMsgFunc(msg)
+	p.setWriteDeadline()
+	if p.msgFreeLevel == message.FreeMsg {
+		// transport takes over msg's buf, so it can not be shared.
+		msg.Unshare()
+	}
+	if err = p.sendMsgFunc(msg); err == nil {
+		atomic.AddUint64(&p.msgsSent, 1)
+		p.touch()
+	}
+	return
+}
+
+// SendMsgs send a batch of messages, stream pipes coalesce them into one vectored write.
+// Messages may be partially sent on error. ErrMsgTooLarge is returned after the rest are sent,
+// only the too large messages are dropped, they're freed and set to nil in msgs.
+func (p *pipe) SendMsgs(msgs []*message.Message) (err error) {
+	if p.sendBufs == nil {
+		for i, msg := range msgs {
+			if errx := p.SendMsg(msg); errx == transport.ErrMsgTooLarge {
+				msgs[i] = nil
+				msg.FreeAll()
+				err = errx
+			} else if errx != nil {
+				return errx
+			}
+		}
+		return
+	}
+
+	p.setWriteDeadline()
+	bufs := p.sendBufs[:0]
+	for _, msg := range msgs {
+		if msg.HasFlags(message.MsgFlagRaw) {
+			// ignore raw messages, see sendMsg
+			continue
+		}
+		bufs = append(bufs, msg.Encode())
+	}
+	_, err = p.Writev(bufs...)
+	if err == nil {
+		atomic.AddUint64(&p.msgsSent, uint64(len(bufs)))
+		p.touch()
+	}
+	// release references
+	for i := range bufs {
+		bufs[i] = nil
+	}
+	p.sendBufs = bufs[:0]
+	return
 }
 
 func (p *pipe) sendMsg(msg *message.Message) (err error) {
@@ -236,6 +475,15 @@ func (p *pipe) sendMsg(msg *message.Message) (err error) {
 	return
 }
 
+func (p *pipe) sendFramedMsg(msg *message.Message) (err error) {
+	if msg.HasFlags(message.MsgFlagRaw) {
+		// ignore raw messages, see sendMsg
+		return nil
+	}
+
+	return p.framer.WriteFrame(p, msg.Encode())
+}
+
 func (p *pipe) sendBlockMsg(msg *message.Message) (err error) {
 	if msg.HasFlags(message.MsgFlagRaw) {
 		// TODO: remove check, guaranteed by user
@@ -247,6 +495,18 @@ func (p *pipe) sendBlockMsg(msg *message.Message) (err error) {
 	return p.send(msg.Encode())
 }
 
+func (p *pipe) sendDirectMsg(msg *message.Message) (err error) {
+	sz := msg.FrameSize()
+	if err = p.msr.SendMsg(msg); err != nil {
+		if errx := p.Close(); errx != nil {
+			err = errx
+		}
+		return
+	}
+	atomic.AddUint64(&p.bytesSent, uint64(sz))
+	return
+}
+
 func (p *pipe) sendRawMsg(msg *message.Message) (err error) {
 	if msg.HasAnyFlags() {
 		// ignore none normal messages.
@@ -266,14 +526,97 @@ func (p *pipe) sendBlockRawMsg(msg *message.Message) (err error) {
 	return p.send(msg.Content)
 }
 
+func (p *pipe) sendFramedRawMsg(msg *message.Message) (err error) {
+	if msg.HasAnyFlags() {
+		// ignore none normal messages.
+		return
+	}
+
+	return p.framer.WriteFrame(p, msg.Content)
+}
+
 func (p *pipe) RecvMsg() (msg *message.Message, err error) {
-	return p.recvMsgFunc()
+	p.setReadDeadline()
+	if msg, err = p.recvMsgFunc(); err == nil {
+		atomic.AddUint64(&p.msgsRecv, 1)
+		p.touch()
+	} else if err == errs.ErrBadMsgFraming {
+		// peer is broken, messages after it can not be trusted
+		p.Close()
+	}
+	return
 }
 
 func (p *pipe) recvMsg() (msg *message.Message, err error) {
 	return message.NewMessageFromReader(p.id, p, p.metaBuf, p.maxRecvContentLength)
 }
 
+// recvBufferedMsg parse messages from the read buffer directly,
+// so that multiple small messages are recved by one read.
+func (p *pipe) recvBufferedMsg() (msg *message.Message, err error) {
+	var b []byte
+	if b, err = p.peek(message.MetaSize); err != nil {
+		return
+	}
+	meta := message.DecodeMeta(b)
+	if p.maxRecvContentLength != 0 && meta.Length > p.maxRecvContentLength {
+		p.Close()
+		err = errs.ErrContentTooLong
+		return
+	}
+
+	sz := meta.FrameSize()
+	if sz > p.br.Size() {
+		// message is larger than read buffer
+		return p.recvMsg()
+	}
+	if b, err = p.peek(sz); err != nil {
+		return
+	}
+	msg, err = message.NewMessageFromBytes(p.id, b, p.maxRecvContentLength)
+	p.br.Discard(sz)
+	atomic.AddUint64(&p.bytesRecv, uint64(sz))
+	return
+}
+
+func (p *pipe) readFrame() (b []byte, err error) {
+	if b, err = p.framer.ReadFrame(p); err != nil && err != io.EOF && err != errs.ErrClosed {
+		// bad or incomplete frame
+		if errx := p.Close(); errx != nil {
+			err = errx
+		}
+	}
+	return
+}
+
+func (p *pipe) recvFramedMsg() (msg *message.Message, err error) {
+	var b []byte
+	if b, err = p.readFrame(); err != nil {
+		return
+	}
+	msg, err = message.NewMessageFromBytes(p.id, b, p.maxRecvContentLength)
+	bytespool.Free(b)
+	return
+}
+
+func (p *pipe) recvFramedRawMsg() (msg *message.Message, err error) {
+	var b []byte
+	if b, err = p.readFrame(); err != nil {
+		if err == io.EOF {
+			// use nil represents EOF
+			msg = message.NewRawRecvMessage(p.id, nil)
+		}
+		return
+	}
+	if b == nil {
+		// empty frame, not EOF
+		b = emptyFrame
+	}
+	msg = message.NewRawRecvMessage(p.id, b)
+	bytespool.Free(b)
+	return
+}
+
 func (p *pipe) recvBlockMsg() (msg *message.Message, err error) {
 	var buf []byte
 	if buf, err = p.recv(); err != nil {
@@ -311,7 +654,11 @@ func (p *pipe) recvBlockRawMsg() (msg *message.Message, err error) {
 func (p *pipe) recvDirectMsg() (msg *message.Message, err error) {
 	var srcMsg *message.Message
 	if srcMsg, err = p.msr.RecvMsg(); err != nil {
+		if errx := p.Close(); errx != nil {
+			err = errx
+		}
 		return
 	}
+	atomic.AddUint64(&p.bytesRecv, uint64(srcMsg.FrameSize()))
 	return message.NewMessageFromMsg(p.id, srcMsg, p.maxRecvContentLength)
 }
@@ -0,0 +1,66 @@
+package connector
+
+import "sync"
+
+// pipeChannel deliver added pipes to a user channel,
+// pipes are queued so a slow consumer never blocks the connector.
+type pipeChannel struct {
+	ch chan<- Pipe
+
+	sync.Mutex
+	pending []Pipe
+	notifyq chan struct{}
+	closedq chan struct{}
+}
+
+func newPipeChannel(ch chan<- Pipe) *pipeChannel {
+	pc := &pipeChannel{
+		ch:      ch,
+		notifyq: make(chan struct{}, 1),
+		closedq: make(chan struct{}),
+	}
+	go pc.run()
+	return pc
+}
+
+func (pc *pipeChannel) push(p Pipe) {
+	pc.Lock()
+	pc.pending = append(pc.pending, p)
+	pc.Unlock()
+	select {
+	case pc.notifyq <- struct{}{}:
+	default:
+	}
+}
+
+func (pc *pipeChannel) pop() (p Pipe) {
+	pc.Lock()
+	if len(pc.pending) > 0 {
+		p = pc.pending[0]
+		pc.pending[0] = nil
+		pc.pending = pc.pending[1:]
+	}
+	pc.Unlock()
+	return
+}
+
+func (pc *pipeChannel) run() {
+	for {
+		select {
+		case <-pc.closedq:
+			return
+		case <-pc.notifyq:
+		}
+		for p := pc.pop(); p != nil; p = pc.pop() {
+			select {
+			case <-pc.closedq:
+				return
+			case pc.ch <- p:
+			}
+		}
+	}
+}
+
+func (pc *pipeChannel) close() {
+	close(pc.closedq)
+}
@@ -1,6 +1,8 @@
 package connector
 
 import (
+	"time"
+
 	"github.com/multisocket/multisocket/message"
 	"github.com/multisocket/multisocket/options"
 	"github.com/multisocket/multisocket/transport"
@@ -45,11 +47,29 @@ type (
 
 		ID() uint32
 		IsRaw() bool
+		// IsInbound check if the pipe is accepted by a listener, false if dialed by a dialer.
+		IsInbound() bool
 		MsgFreeLevel() message.FreeLevel
 
+		// stats
+		BytesSent() uint64
+		BytesRecv() uint64
+		MsgsSent() uint64
+		MsgsRecv() uint64
+
 		transport.Connection
 
 		MsgSendReceiver
+		// SendMsgs send a batch of messages at once,
+		// messages rejected with ErrMsgTooLarge are freed and set to nil in msgs.
+		SendMsgs(msgs []*message.Message) error
+		// CloseWrite shut down the writing side (half-close), pipe keeps receiving until peer closes,
+		// returns ErrOperationNotSupported if the transport can not half-close.
+		CloseWrite() error
+		// SetUserData attach data to the pipe, e.g. per connection state.
+		SetUserData(data interface{})
+		// UserData get data attached by SetUserData, nil if none.
+		UserData() interface{}
 	}
 )
 
@@ -59,12 +79,40 @@ type (
 
 	// PipeEventHandlerFunc can handle pipe event
 	PipeEventHandlerFunc func(PipeEvent, Pipe)
+
+	// ConnEventHandlerFunc can handle connecting events, which happen before pipes are added:
+	// addr is the address dialing, or remote address of the accepted connection, err is the dial error.
+	ConnEventHandlerFunc func(e PipeEvent, addr string, err error)
+
+	// DialGiveUpFunc is called when dialer to addr gives up reconnecting, lastErr is the last dial error.
+	DialGiveUpFunc func(addr string, lastErr error)
+
+	// DialerState is a snapshot of dialer's state
+	DialerState struct {
+		// address dialing or connected
+		Addr      string
+		Connected bool
+		Dialing   bool
+		// waiting to redial at NextAttempt
+		BackingOff  bool
+		NextAttempt time.Time
+		// failed redial attempts since last connected
+		Attempts int
+		Closed   bool
+	}
 )
 
 // pipe events
 const (
 	PipeEventAdd PipeEvent = iota
 	PipeEventRemove
+	// connecting events
+	PipeEventDialing
+	PipeEventDialFailed
+	PipeEventAccepted
+	// PipeEventHandshakeFailed is raised by socket before closing a pipe
+	// whose peer speaks another protocol version, see ErrVersionMismatch.
+	PipeEventHandshakeFailed
 )
 
 type (
@@ -78,7 +126,11 @@ type (
 		options.Options
 
 		Dial() error
+		// DialWithResult start dialing asynchronously, the returned channel delivers the result of the first dial attempt.
+		DialWithResult() (<-chan error, error)
 		Close() error
+		// State get a snapshot of dialer's state
+		State() DialerState
 		TransportDialer() transport.Dialer
 	}
 
@@ -88,6 +140,11 @@ type (
 
 		Listen() error
 		Close() error
+		// CloseGracefully stop accepting new connections, wait up to timeout for accepted pipes to close,
+		// then close the survivors and return ErrTimeout, 0 timeout waits forever.
+		CloseGracefully(timeout time.Duration) error
+		// BoundAddress returns the concrete address after Listen, e.g. the assigned port of "tcp://127.0.0.1:0".
+		BoundAddress() string
 		TransportListener() transport.Listener
 	}
 
@@ -96,6 +153,8 @@ type (
 		Dial(addr string) error
 		DialOptions(addr string, ovs options.OptionValues) error
 		NewDialer(addr string, ovs options.OptionValues) (Dialer, error)
+		// DialMulti dial to the first of addrs, fail over to the next one on each failed dial.
+		DialMulti(addrs []string, ovs options.OptionValues) error
 		// StopDial stop dial to address, but keep connected pipes.
 		StopDial(addr string)
 
@@ -113,7 +172,14 @@ type (
 		CoreAction
 
 		GetPipe(id uint32) Pipe
+		// Pipes get all live pipes
+		Pipes() []Pipe
+		// PipeCount get count of live pipes
+		PipeCount() int
 		ClosePipe(id uint32)
+		// ClosePipesWhere close pipes matched by match asynchronously, returns count of them.
+		// match is called while the connector is locked, it must not call into the connector.
+		ClosePipesWhere(match func(Pipe) bool) int
 	}
 
 	// Connector controls socket's connections
@@ -123,5 +189,12 @@ type (
 		Close()
 		SetPipeEventHandler(PipeEventHandlerFunc)
 		ClearPipeEventHandler(PipeEventHandlerFunc)
+		// SetConnEventHandler set handler of dialing, dial failed and accepted events, nil handler to remove.
+		SetConnEventHandler(ConnEventHandlerFunc)
+		// AddPipeChannel deliver added pipes to channel, pipes are queued if channel is not ready,
+		// so a slow consumer never blocks connecting.
+		AddPipeChannel(channel chan<- Pipe)
+		// RemovePipeChannel stop delivering added pipes to channel, queued pipes are discarded.
+		RemovePipeChannel(channel chan<- Pipe)
 	}
 )
@@ -1,6 +1,8 @@
 package multisocket
 
 import (
+	"fmt"
+
 	"github.com/multisocket/multisocket/errs"
 )
 
@@ -8,5 +10,34 @@ import (
 const (
 	ErrMsgDropped      = errs.Err("message dropped")
 	ErrBrokenPath      = errs.Err("bad destination: broken path")
-	ErrInvalidSendType = errs.Err("invalid send type")
+	ErrBadDestination  = errs.Err("bad destination: forged or stale path")
+	ErrPipeNotFound    = errs.Err("pipe not found")
+	ErrInvalidSendType = errs.ErrInvalidSendType
+	ErrTimeout         = errs.ErrTimeout
+	ErrContentTooLong  = errs.ErrContentTooLong
+	ErrBadCompression  = errs.ErrBadCompression
+	ErrBadChecksum     = errs.ErrBadChecksum
+	ErrVersionMismatch = errs.Err("protocol version mismatch")
 )
+
+// DestsError is returned by SendToMany if sending to some of the destinations failed,
+// Errs[i] is the error of dests[i], nil if succeeded.
+type DestsError struct {
+	Errs []error
+}
+
+func (e *DestsError) Error() string {
+	var (
+		failed int
+		first  error
+	)
+	for _, err := range e.Errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	return fmt.Sprintf("send to %d/%d destinations failed: %s", failed, len(e.Errs), first)
+}
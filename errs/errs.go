@@ -17,6 +17,10 @@ const (
 	ErrOperationNotSupported = Err("operation not supported")
 	ErrBadTransport          = Err("invalid or unsupported transport")
 	ErrBadMsg                = Err("bad message")
+	ErrBadMsgFraming         = Err("bad message framing")
 	ErrBadProtocol           = Err("bad protocol")
 	ErrContentTooLong        = Err("content is too long")
+	ErrInvalidSendType       = Err("invalid send type")
+	ErrBadCompression        = Err("unknown or unsupported compression")
+	ErrBadChecksum           = Err("bad checksum")
 )
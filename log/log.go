@@ -0,0 +1,85 @@
+// Package log is the logging hook of multisocket, logs go to logrus by default, see SetLogger.
+package log
+
+import (
+	"sync/atomic"
+)
+
+type (
+	// Level is log level
+	Level uint8
+
+	// Fields is log fields
+	Fields map[string]interface{}
+
+	// Logger log messages with fields
+	Logger interface {
+		// IsLevelEnabled check if level is enabled, to skip preparing fields of disabled logs.
+		IsLevelEnabled(level Level) bool
+		Debug(msg string, fields Fields)
+		Info(msg string, fields Fields)
+		Warn(msg string, fields Fields)
+		Error(msg string, fields Fields)
+	}
+
+	// wrap loggers for atomic.Value, which requires consistent concrete type
+	loggerHolder struct {
+		Logger
+	}
+)
+
+// levels, from the least to the most verbose
+const (
+	ErrorLevel Level = iota
+	WarnLevel
+	InfoLevel
+	DebugLevel
+)
+
+// ErrorKey is the field key of errors
+const ErrorKey = "error"
+
+var (
+	logger atomic.Value
+)
+
+func init() {
+	SetLogger(nil)
+}
+
+// SetLogger set the logger, nil to reset to the default logrus logger.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = NewLogrusLogger(nil)
+	}
+	logger.Store(loggerHolder{l})
+}
+
+func getLogger() Logger {
+	return logger.Load().(loggerHolder)
+}
+
+// IsLevelEnabled check if level is enabled
+func IsLevelEnabled(level Level) bool {
+	return getLogger().IsLevelEnabled(level)
+}
+
+// Debug log at debug level
+func Debug(msg string, fields Fields) {
+	getLogger().Debug(msg, fields)
+}
+
+// Info log at info level
+func Info(msg string, fields Fields) {
+	getLogger().Info(msg, fields)
+}
+
+// Warn log at warn level
+func Warn(msg string, fields Fields) {
+	getLogger().Warn(msg, fields)
+}
+
+// Error log at error level
+func Error(msg string, fields Fields) {
+	getLogger().Error(msg, fields)
+}
@@ -0,0 +1,44 @@
+package log
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+type logrusLogger struct {
+	*logrus.Logger
+}
+
+var logrusLevels = [...]logrus.Level{
+	ErrorLevel: logrus.ErrorLevel,
+	WarnLevel:  logrus.WarnLevel,
+	InfoLevel:  logrus.InfoLevel,
+	DebugLevel: logrus.DebugLevel,
+}
+
+// NewLogrusLogger create a Logger logging to l, nil for logrus' standard logger.
+func NewLogrusLogger(l *logrus.Logger) Logger {
+	if l == nil {
+		l = logrus.StandardLogger()
+	}
+	return logrusLogger{l}
+}
+
+func (l logrusLogger) IsLevelEnabled(level Level) bool {
+	return l.Logger.IsLevelEnabled(logrusLevels[level])
+}
+
+func (l logrusLogger) Debug(msg string, fields Fields) {
+	l.WithFields(logrus.Fields(fields)).Debug(msg)
+}
+
+func (l logrusLogger) Info(msg string, fields Fields) {
+	l.WithFields(logrus.Fields(fields)).Info(msg)
+}
+
+func (l logrusLogger) Warn(msg string, fields Fields) {
+	l.WithFields(logrus.Fields(fields)).Warn(msg)
+}
+
+func (l logrusLogger) Error(msg string, fields Fields) {
+	l.WithFields(logrus.Fields(fields)).Error(msg)
+}
@@ -0,0 +1,40 @@
+package message
+
+import (
+	"encoding/binary"
+
+	"github.com/multisocket/multisocket/errs"
+)
+
+// AckIDSize is the size of ack id trailer.
+const AckIDSize = 4
+
+// AckID get msg's ack id, 0 for none.
+func (msg *Message) AckID() uint32 {
+	return msg.ackID
+}
+
+// AddAckID set msg's ack id to id and append it to its content,
+// the peer replies an InternalMsgAck of id once received.
+func (msg *Message) AddAckID(id uint32) {
+	content := make([]byte, len(msg.Content)+AckIDSize)
+	copy(content, msg.Content)
+	binary.BigEndian.PutUint32(content[len(msg.Content):], id)
+	msg.SetContent(content)
+	msg.ackID = id
+}
+
+// StripAckID strip msg's ack id trailer and set its ack id,
+// returns ErrBadMsg if content is too short.
+func (msg *Message) StripAckID() error {
+	n := len(msg.Content) - AckIDSize
+	if n < 0 {
+		return errs.ErrBadMsg
+	}
+	msg.ackID = binary.BigEndian.Uint32(msg.Content[n:])
+	// content is at the end of buf
+	msg.Content = msg.Content[:n:n]
+	msg.buf = msg.buf[:len(msg.buf)-AckIDSize]
+	msg.Length = uint32(n)
+	return nil
+}
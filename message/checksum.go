@@ -0,0 +1,44 @@
+package message
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/multisocket/multisocket/errs"
+)
+
+// ChecksumSize is the size of checksum trailer.
+const ChecksumSize = 4
+
+// AddChecksum append CRC32 checksum of msg's content to its content and set MsgFlagChecksum.
+func (msg *Message) AddChecksum() {
+	if msg.HasFlags(MsgFlagChecksum) {
+		return
+	}
+	content := make([]byte, len(msg.Content)+ChecksumSize)
+	copy(content, msg.Content)
+	binary.BigEndian.PutUint32(content[len(msg.Content):], crc32.ChecksumIEEE(msg.Content))
+	msg.SetContent(content)
+	msg.Flags |= MsgFlagChecksum
+}
+
+// VerifyChecksum verify and strip msg's checksum trailer, then clear MsgFlagChecksum,
+// returns ErrBadChecksum if content is corrupted.
+func (msg *Message) VerifyChecksum() error {
+	if !msg.HasFlags(MsgFlagChecksum) {
+		return nil
+	}
+	n := len(msg.Content) - ChecksumSize
+	if n < 0 {
+		return errs.ErrBadChecksum
+	}
+	if crc32.ChecksumIEEE(msg.Content[:n]) != binary.BigEndian.Uint32(msg.Content[n:]) {
+		return errs.ErrBadChecksum
+	}
+	// content is at the end of buf
+	msg.Content = msg.Content[:n:n]
+	msg.buf = msg.buf[:len(msg.buf)-ChecksumSize]
+	msg.Length = uint32(n)
+	msg.Flags = msg.ClearFlags(MsgFlagChecksum)
+	return nil
+}
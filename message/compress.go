@@ -0,0 +1,133 @@
+package message
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/multisocket/multisocket/errs"
+)
+
+// Compressor compress and decompress message content.
+type Compressor interface {
+	Compress(content []byte) ([]byte, error)
+	// Decompress must stop and return ErrContentTooLong once
+	// the decompressed content exceeds maxLength, 0 for no limit.
+	Decompress(b []byte, maxLength uint32) ([]byte, error)
+}
+
+// compression types, others are free to be registered.
+const (
+	CompressionNone uint8 = iota
+	CompressionGzip
+)
+
+var (
+	compressorsLk sync.RWMutex
+	compressors   = map[uint8]Compressor{
+		CompressionGzip: gzipCompressor{},
+	}
+)
+
+// RegisterCompressor register compressor for compression type typ, overrides the old one.
+func RegisterCompressor(typ uint8, c Compressor) {
+	compressorsLk.Lock()
+	compressors[typ] = c
+	compressorsLk.Unlock()
+}
+
+// GetCompressor get compressor of compression type typ, nil if not registered.
+func GetCompressor(typ uint8) Compressor {
+	compressorsLk.RLock()
+	c := compressors[typ]
+	compressorsLk.RUnlock()
+	return c
+}
+
+// Compress compress msg's content by compressor of typ and set MsgFlagCompressed,
+// compressed content is prefixed with typ.
+func (msg *Message) Compress(typ uint8) error {
+	if typ == CompressionNone || msg.HasFlags(MsgFlagCompressed) {
+		return nil
+	}
+	c := GetCompressor(typ)
+	if c == nil {
+		return errs.ErrBadCompression
+	}
+	b, err := c.Compress(msg.Content)
+	if err != nil {
+		return err
+	}
+	msg.SetContent(append([]byte{typ}, b...))
+	msg.Flags |= MsgFlagCompressed
+	return nil
+}
+
+// Decompress decompress msg's content and clear MsgFlagCompressed,
+// returns ErrBadCompression if there is no compressor for msg's compression type,
+// or ErrContentTooLong if the content exceeds the receiving pipe's max content length.
+func (msg *Message) Decompress() error {
+	if !msg.HasFlags(MsgFlagCompressed) {
+		return nil
+	}
+	if len(msg.Content) < 1 {
+		return errs.ErrBadMsg
+	}
+	c := GetCompressor(msg.Content[0])
+	if c == nil {
+		return errs.ErrBadCompression
+	}
+	b, err := c.Decompress(msg.Content[1:], msg.maxLength)
+	if err != nil {
+		return err
+	}
+	if msg.maxLength != 0 && len(b) > int(msg.maxLength) {
+		// not honored by the compressor
+		return errs.ErrContentTooLong
+	}
+	msg.SetContent(b)
+	msg.Flags = msg.ClearFlags(MsgFlagCompressed)
+	return nil
+}
+
+type gzipCompressor struct{}
+
+var gzipWriterPool = &sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+func (gzipCompressor) Compress(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzipWriterPool.Get().(*gzip.Writer)
+	defer gzipWriterPool.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(b []byte, maxLength uint32) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if maxLength == 0 {
+		return ioutil.ReadAll(r)
+	}
+	// read one more byte to tell if it's too long
+	content, err := ioutil.ReadAll(io.LimitReader(r, int64(maxLength)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > int(maxLength) {
+		return nil, errs.ErrContentTooLong
+	}
+	return content, nil
+}
@@ -0,0 +1,58 @@
+package message
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/multisocket/multisocket/errs"
+)
+
+// DeadlineSize is the size of deadline trailer.
+const DeadlineSize = 8
+
+// SetDeadline set msg's absolute deadline, zero time for none.
+// it's carried to peers in a trailer only by sockets with MsgDeadline option, see AddDeadline.
+func (msg *Message) SetDeadline(t time.Time) {
+	if t.IsZero() {
+		msg.deadline = 0
+		return
+	}
+	msg.deadline = t.UnixNano()
+}
+
+// Deadline get msg's absolute deadline, zero time for none.
+func (msg *Message) Deadline() time.Time {
+	if msg.deadline == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, msg.deadline)
+}
+
+// Expired check if msg's deadline passed more than leeway before now,
+// leeway tolerates clock skew between the sender and now's clock.
+func (msg *Message) Expired(now time.Time, leeway time.Duration) bool {
+	return msg.deadline != 0 && now.UnixNano() > msg.deadline+int64(leeway)
+}
+
+// AddDeadline append msg's deadline to its content as unix nanoseconds, 0 for none.
+func (msg *Message) AddDeadline() {
+	content := make([]byte, len(msg.Content)+DeadlineSize)
+	copy(content, msg.Content)
+	binary.BigEndian.PutUint64(content[len(msg.Content):], uint64(msg.deadline))
+	msg.SetContent(content)
+}
+
+// StripDeadline strip msg's deadline trailer and set its deadline,
+// returns ErrBadMsg if content is too short.
+func (msg *Message) StripDeadline() error {
+	n := len(msg.Content) - DeadlineSize
+	if n < 0 {
+		return errs.ErrBadMsg
+	}
+	msg.deadline = int64(binary.BigEndian.Uint64(msg.Content[n:]))
+	// content is at the end of buf
+	msg.Content = msg.Content[:n:n]
+	msg.buf = msg.buf[:len(msg.buf)-DeadlineSize]
+	msg.Length = uint32(n)
+	return nil
+}
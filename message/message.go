@@ -4,7 +4,10 @@ import (
 	"encoding/binary"
 	"io"
 	"io/ioutil"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/multisocket/multisocket/bytespool"
 	"github.com/multisocket/multisocket/errs"
@@ -26,12 +29,25 @@ type (
 
 	// Message is a message
 	Message struct {
-		buf []byte // decode/encode buffer
+		buf   []byte // decode/encode buffer
+		refs  *int32 // buf's reference count, nil if buf is not shared
+		freed bool
 		Meta
 		Source      MsgPath
 		Destination MsgPath
 		// TODO: support zero copy content
 		Content []byte
+
+		// addresses of the pipe which the message is received from
+		localAddr  string
+		remoteAddr string
+		// absolute deadline in unix nanoseconds, 0 for none, see SetDeadline
+		deadline int64
+		// id acked by the peer, 0 for none, see AddAckID
+		ackID uint32
+		// max content length of the pipe which the message is received from,
+		// bounds Decompress, 0 for no limit
+		maxLength uint32
 	}
 
 	// TODO: use internal message
@@ -50,6 +66,9 @@ const DefaultMsgTTL = uint8(16)
 // TODO: update when Meta modifed
 const MetaSize = 8
 
+// MaxHeaderSize is the max size of an encoded message's meta data and paths.
+const MaxHeaderSize = MetaSize + 4*(0xff+0xff)
+
 var (
 	emptyMeta = Meta{
 		TTL: DefaultMsgTTL,
@@ -72,33 +91,73 @@ const (
 	SendTypeToAll
 	// send to a destination
 	SendTypeToDest
+
+	// undefined, marks messages created with invalid send types
+	sendTypeInvalid = sendTypeMask
 )
 
 // Msg Flags
 const (
 	// socket internal message, used by socket internal
 	MsgFlagInternal uint8 = 1 << (iota + 2)
-	// TODO:
-	// MsgFlagNoSource, do not record message source
-	MsgFlagNoSource
+	// MsgFlagChecksum is used to indicate the message's content has a CRC32 trailer, see AddChecksum.
+	// It takes the bit of MsgFlagNoSource, peers before it ignore the bit and recv the trailer as content.
+	MsgFlagChecksum
 	// MsgFlagRaw is used to indicate the message is from a raw transport
 	MsgFlagRaw
 	// protocol control message, predefined flag, use by protocols implementations or others.
 	MsgFlagControl
+	// MsgFlagPriority is used to indicate the message should be sent before normal messages
+	MsgFlagPriority
+	// MsgFlagCompressed is used to indicate the message's content is compressed, see Compress
+	MsgFlagCompressed
 )
 
-// TODO:
+// MsgFlagNoSource was never implemented, its bit is taken by MsgFlagChecksum.
+//
+// Deprecated: use MsgFlagChecksum, messages with this flag have a checksum trailer.
+const MsgFlagNoSource = MsgFlagChecksum
+
 // Internal Messages
 const (
 	// close peer
 	InternalMsgClosePeer uint8 = iota
+	// ping peer, peer should reply a pong
+	InternalMsgPing
+	// reply to a ping
+	InternalMsgPong
+	// announce client id, followed by the id
+	InternalMsgClientID
+	// stream data, followed by stream id and data
+	InternalMsgStreamData
+	// stream closed, followed by stream id
+	InternalMsgStreamFin
+	// protocol version handshake, followed by the version
+	InternalMsgHandshake
+	// ack a received message, followed by its ack id
+	InternalMsgAck
 )
 
+func newMessage() *Message {
+	msg := msgPool.Get().(*Message)
+	msg.freed = false
+	return msg
+}
+
 // SendType get message's send type
 func (m *Meta) SendType() uint8 {
 	return m.Flags & sendTypeMask
 }
 
+// SetSendType set message's send type, the change will be reflected when encoding.
+func (m *Meta) SetSendType(sendType uint8) error {
+	if sendType > SendTypeToDest {
+		return errs.ErrInvalidSendType
+	}
+	m.Flags = m.Flags&flagsMask | sendType
+	return nil
+}
+
 // HasFlags check if meta data has flags setted.
 func (m *Meta) HasFlags(flags uint8) bool {
 	return m.Flags&flags == flags
@@ -125,6 +184,17 @@ func (m *Meta) encodeTo(b []byte) []byte {
 	return b
 }
 
+// FrameSize get the encoded message's total size.
+func (m *Meta) FrameSize() int {
+	return MetaSize + 4*(int(m.Hops)+int(m.Distance)) + int(m.Length)
+}
+
+// DecodeMeta decode meta data from bytes, b must be at least MetaSize long.
+func DecodeMeta(b []byte) (m Meta) {
+	decodeMetaFrom(b, &m)
+	return
+}
+
 // decodeMetaFrom reader
 func decodeMetaFrom(a []byte, m *Meta) {
 	m.Flags = a[0]
@@ -134,6 +204,15 @@ func decodeMetaFrom(a []byte, m *Meta) {
 	m.Length = binary.BigEndian.Uint32(a[4:])
 }
 
+// checkRecvMeta check meta data of a received message before decoding it,
+// returns ErrBadMsgFraming if Hops would overflow, or a to dest message lacks the sender's pipe id.
+func checkRecvMeta(m *Meta) error {
+	if m.Hops == 0xff || (m.SendType() == SendTypeToDest && m.Distance == 0) {
+		return errs.ErrBadMsgFraming
+	}
+	return nil
+}
+
 // Length get Path length
 func (path MsgPath) Length() uint8 {
 	return uint8(len(path) / 4)
@@ -151,7 +230,39 @@ func (path MsgPath) NextID() (id uint32, source MsgPath) {
 	return
 }
 
-// NewMessageFromMsg create a message from message
+// IDs get path's pipe ids in order.
+func (path MsgPath) IDs() []uint32 {
+	ids := make([]uint32, 0, len(path)/4)
+	for i := 0; i+4 <= len(path); i += 4 {
+		ids = append(ids, binary.BigEndian.Uint32(path[i:]))
+	}
+	return ids
+}
+
+// Contains check if id is one of path's pipe ids.
+func (path MsgPath) Contains(id uint32) bool {
+	for i := 0; i+4 <= len(path); i += 4 {
+		if binary.BigEndian.Uint32(path[i:]) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// String render path's pipe ids as 1->2->3.
+func (path MsgPath) String() string {
+	var sb strings.Builder
+	for i, id := range path.IDs() {
+		if i > 0 {
+			sb.WriteString("->")
+		}
+		sb.WriteString(strconv.FormatUint(uint64(id), 10))
+	}
+	return sb.String()
+}
+
+// NewMessageFromMsg create a message from message,
+// returns ErrBadMsgFraming if meta data is malformed.
 func NewMessageFromMsg(pid uint32, srcMsg *Message, maxLength uint32) (msg *Message, err error) {
 	var (
 		meta       *Meta
@@ -161,7 +272,8 @@ func NewMessageFromMsg(pid uint32, srcMsg *Message, maxLength uint32) (msg *Mess
 		destSize   int
 		length     int
 	)
-	msg = msgPool.Get().(*Message)
+	msg = newMessage()
+	msg.maxLength = maxLength
 	msg.Meta = srcMsg.Meta
 	meta = &msg.Meta
 
@@ -172,8 +284,14 @@ func NewMessageFromMsg(pid uint32, srcMsg *Message, maxLength uint32) (msg *Mess
 		return
 	}
 
+	if err = checkRecvMeta(meta); err != nil {
+		msg.Free()
+		msg = nil
+		return
+	}
+
 	sentType = meta.SendType()
-	sourceSize = 4 * int(meta.Hops+1)
+	sourceSize = 4 * (int(meta.Hops) + 1)
 	if sentType == SendTypeToDest {
 		destSize = 4 * int(meta.Distance-1)
 	} else {
@@ -200,7 +318,11 @@ func NewMessageFromMsg(pid uint32, srcMsg *Message, maxLength uint32) (msg *Mess
 		from = to
 		to = from + destSize
 		msg.Destination = msg.buf[from:to:to]
-		copy(msg.Destination, msg.Destination[4:])
+		if sentType == SendTypeToDest {
+			copy(msg.Destination, srcMsg.Destination[4:])
+		} else {
+			copy(msg.Destination, srcMsg.Destination)
+		}
 	}
 
 	// Content
@@ -212,7 +334,8 @@ func NewMessageFromMsg(pid uint32, srcMsg *Message, maxLength uint32) (msg *Mess
 	return
 }
 
-// NewMessageFromBytes create a message from bytes
+// NewMessageFromBytes create a message from bytes,
+// returns ErrBadMsgFraming if meta data is malformed, or ErrBadMsg if buf's size mismatches it.
 func NewMessageFromBytes(pid uint32, buf []byte, maxLength uint32) (msg *Message, err error) {
 	var (
 		meta       *Meta
@@ -222,7 +345,8 @@ func NewMessageFromBytes(pid uint32, buf []byte, maxLength uint32) (msg *Message
 		destSize   int
 		length     int
 	)
-	msg = msgPool.Get().(*Message)
+	msg = newMessage()
+	msg.maxLength = maxLength
 	meta = &msg.Meta
 
 	if len(buf) < MetaSize {
@@ -241,7 +365,13 @@ func NewMessageFromBytes(pid uint32, buf []byte, maxLength uint32) (msg *Message
 		return
 	}
 
-	if len(buf) != 4*int(meta.Hops+meta.Distance)+int(meta.Length) {
+	if err = checkRecvMeta(meta); err != nil {
+		msg.Free()
+		msg = nil
+		return
+	}
+
+	if len(buf) != 4*(int(meta.Hops)+int(meta.Distance))+int(meta.Length) {
 		msg.Free()
 		msg = nil
 		err = errs.ErrBadMsg
@@ -249,7 +379,7 @@ func NewMessageFromBytes(pid uint32, buf []byte, maxLength uint32) (msg *Message
 	}
 
 	sentType = meta.SendType()
-	sourceSize = 4 * int(meta.Hops+1)
+	sourceSize = 4 * (int(meta.Hops) + 1)
 	if sentType == SendTypeToDest {
 		destSize = 4 * int(meta.Distance-1)
 	} else {
@@ -291,7 +421,8 @@ func NewMessageFromBytes(pid uint32, buf []byte, maxLength uint32) (msg *Message
 	return
 }
 
-// NewMessageFromReader create a message from reader
+// NewMessageFromReader create a message from reader,
+// returns ErrBadMsgFraming and close r if the stream is truncated or malformed.
 func NewMessageFromReader(pid uint32, r io.ReadCloser, metaBuf []byte, maxLength uint32) (msg *Message, err error) {
 	var (
 		meta       *Meta
@@ -301,13 +432,18 @@ func NewMessageFromReader(pid uint32, r io.ReadCloser, metaBuf []byte, maxLength
 		destSize   int
 		length     int
 	)
-	msg = msgPool.Get().(*Message)
+	msg = newMessage()
+	msg.maxLength = maxLength
 	meta = &msg.Meta
 
 	if _, err = io.ReadFull(r, metaBuf); err != nil {
 		msg.Free()
 		msg = nil
-		// err = errs.ErrBadMsg
+		if err == io.ErrUnexpectedEOF {
+			// partial header
+			r.Close()
+			err = errs.ErrBadMsgFraming
+		}
 		return
 	}
 	decodeMetaFrom(metaBuf, meta)
@@ -320,8 +456,16 @@ func NewMessageFromReader(pid uint32, r io.ReadCloser, metaBuf []byte, maxLength
 		return
 	}
 
+	if err = checkRecvMeta(meta); err != nil {
+		// garbage, the stream can not be resynchronized
+		msg.Free()
+		msg = nil
+		r.Close()
+		return
+	}
+
 	sentType = meta.SendType()
-	sourceSize = 4 * int(meta.Hops+1)
+	sourceSize = 4 * (int(meta.Hops) + 1)
 	if sentType == SendTypeToDest {
 		destSize = 4 * int(meta.Distance-1)
 	} else {
@@ -336,6 +480,7 @@ func NewMessageFromReader(pid uint32, r io.ReadCloser, metaBuf []byte, maxLength
 	if _, err = io.ReadFull(r, msg.Source[4:sourceSize]); err != nil {
 		msg.FreeAll()
 		msg = nil
+		err = framingError(r, err)
 		return
 	}
 	// update source, add current pipe id
@@ -349,6 +494,7 @@ func NewMessageFromReader(pid uint32, r io.ReadCloser, metaBuf []byte, maxLength
 		if _, err = io.CopyN(ioutil.Discard, r, 4); err != nil {
 			msg.FreeAll()
 			msg = nil
+			err = framingError(r, err)
 			return
 		}
 		meta.Distance--
@@ -360,6 +506,7 @@ func NewMessageFromReader(pid uint32, r io.ReadCloser, metaBuf []byte, maxLength
 		if _, err = io.ReadFull(r, msg.Destination); err != nil {
 			msg.FreeAll()
 			msg = nil
+			err = framingError(r, err)
 			return
 		}
 	}
@@ -371,12 +518,22 @@ func NewMessageFromReader(pid uint32, r io.ReadCloser, metaBuf []byte, maxLength
 	if _, err = io.ReadFull(r, msg.Content); err != nil {
 		msg.FreeAll()
 		msg = nil
+		err = framingError(r, err)
 		return
 	}
 
 	return
 }
 
+// framingError close r and returns ErrBadMsgFraming if the stream ended in the middle of a message.
+func framingError(r io.Closer, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		r.Close()
+		return errs.ErrBadMsgFraming
+	}
+	return err
+}
+
 // NewRawRecvMessage create a new raw recv message
 func NewRawRecvMessage(pid uint32, content []byte) (msg *Message) {
 	var (
@@ -386,7 +543,7 @@ func NewRawRecvMessage(pid uint32, content []byte) (msg *Message) {
 		length     int
 	)
 	// raw message is always send to one.
-	msg = msgPool.Get().(*Message)
+	msg = newMessage()
 	msg.Meta = Meta{
 		Flags:  MsgFlagRaw | SendTypeToOne,
 		Length: uint32(len(content)),
@@ -418,7 +575,8 @@ func NewRawRecvMessage(pid uint32, content []byte) (msg *Message) {
 	return
 }
 
-// NewSendMessage create a message to send
+// NewSendMessage create a message to send,
+// sendType must be one of SendType* types, otherwise the message is rejected by Validate.
 func NewSendMessage(flags, sendType uint8, ttl uint8, src, dest MsgPath, content []byte) *Message {
 	var (
 		from, to   int
@@ -430,7 +588,11 @@ func NewSendMessage(flags, sendType uint8, ttl uint8, src, dest MsgPath, content
 	if ttl == 0 {
 		ttl = DefaultMsgTTL
 	}
-	msg := msgPool.Get().(*Message)
+	if sendType > SendTypeToDest {
+		// don't overflow into flags
+		sendType = sendTypeInvalid
+	}
+	msg := newMessage()
 	msg.Meta = Meta{
 		Flags:    flags | sendType,
 		TTL:      ttl,
@@ -471,34 +633,205 @@ func NewSendMessage(flags, sendType uint8, ttl uint8, src, dest MsgPath, content
 	return msg
 }
 
+// NewInternalMessage create an internal message of type typ, which is sent to the peer of pipe pid.
+func NewInternalMessage(pid uint32, typ uint8) *Message {
+	return NewInternalMessageWithData(pid, typ, nil)
+}
+
+// NewInternalMessageWithData is like NewInternalMessage, but data follows the type in content.
+func NewInternalMessageWithData(pid uint32, typ uint8, data []byte) *Message {
+	var dest [4]byte
+	binary.BigEndian.PutUint32(dest[:], pid)
+	content := make([]byte, 1+len(data))
+	content[0] = typ
+	copy(content[1:], data)
+	return NewSendMessage(MsgFlagInternal, SendTypeToDest, 0, nil, dest[:], content)
+}
+
+// InternalType get internal message's type.
+func (msg *Message) InternalType() (typ uint8, err error) {
+	if !msg.HasFlags(MsgFlagInternal) || len(msg.Content) < 1 {
+		err = errs.ErrBadMsg
+		return
+	}
+	typ = msg.Content[0]
+	return
+}
+
 // Encode encode msg'b body parts.
 func (msg *Message) Encode() []byte {
+	if msg.refs != nil && atomic.LoadInt32(msg.refs) > 1 {
+		if DecodeMeta(msg.buf) == msg.Meta {
+			// shared buf is read only
+			return msg.buf
+		}
+		// meta modified, copy on write
+		msg.Unshare()
+	}
 	msg.Meta.encodeTo(msg.buf)
 	return msg.buf
 }
 
-// Dup create a duplicated message
-// TODO: try effective way, like reference counting.
+// Dup create a duplicated message which shares buf with msg by reference counting,
+// so Content should be treated as read only.
+// NOTE: the first Dup of a message must not be called concurrently.
 func (msg *Message) Dup() (dup *Message) {
-	dup = msgPool.Get().(*Message)
+	if msg.refs == nil {
+		msg.refs = new(int32)
+		*msg.refs = 1
+		// encode before sharing
+		msg.Meta.encodeTo(msg.buf)
+	}
+	atomic.AddInt32(msg.refs, 1)
+
+	dup = newMessage()
+	dup.buf = msg.buf
+	dup.refs = msg.refs
 	dup.Meta = msg.Meta
+	dup.Source = msg.Source
+	dup.Destination = msg.Destination
+	dup.Content = msg.Content
+	dup.localAddr = msg.localAddr
+	dup.remoteAddr = msg.remoteAddr
+	dup.deadline = msg.deadline
+	dup.ackID = msg.ackID
+	dup.maxLength = msg.maxLength
+
+	return dup
+}
+
+// Copy create a copy of msg which has its own buf, so they can be modified and freed independently.
+func (msg *Message) Copy() (cp *Message) {
+	cp = newMessage()
+	cp.Meta = msg.Meta
+	cp.buf = bytespool.Alloc(MetaSize + len(msg.Source) + len(msg.Destination) + len(msg.Content))
+
+	from, to := MetaSize, MetaSize+len(msg.Source)
+	if msg.Source != nil {
+		cp.Source = cp.buf[from:to:to]
+		copy(cp.Source, msg.Source)
+	}
+	from, to = to, to+len(msg.Destination)
+	if msg.Destination != nil {
+		cp.Destination = cp.buf[from:to:to]
+		copy(cp.Destination, msg.Destination)
+	}
+	from, to = to, to+len(msg.Content)
+	cp.Content = cp.buf[from:to:to]
+	copy(cp.Content, msg.Content)
+
+	cp.localAddr = msg.localAddr
+	cp.remoteAddr = msg.remoteAddr
+	cp.deadline = msg.deadline
+	cp.ackID = msg.ackID
+	cp.maxLength = msg.maxLength
+	return
+}
+
+// Unshare make sure msg has its own buf, copy buf if it's shared with others.
+func (msg *Message) Unshare() {
+	if msg.refs == nil {
+		return
+	}
+	if atomic.LoadInt32(msg.refs) == 1 {
+		// all others freed
+		msg.refs = nil
+		return
+	}
+
+	buf := bytespool.Alloc(len(msg.buf))
+	copy(buf, msg.buf)
+	msg.release()
+	msg.buf = buf
+	msg.refs = nil
+
+	from, to := MetaSize, MetaSize+len(msg.Source)
+	if msg.Source != nil {
+		msg.Source = buf[from:to:to]
+	}
+
+	from, to = to, to+len(msg.Destination)
+	if msg.Destination != nil {
+		msg.Destination = buf[from:to:to]
+	}
+
+	from, to = to, to+len(msg.Content)
+	if msg.Content != nil {
+		msg.Content = buf[from:to:to]
+	}
+}
 
-	dup.buf = bytespool.Alloc(len(msg.buf))
-	copy(dup.buf, msg.buf)
-	sourceSize := 4 * int(dup.Meta.Hops)
-	destSize := 4 * int(dup.Meta.Distance)
-	length := int(dup.Meta.Length)
+// SetContent replace msg's content, msg gets a new buf.
+func (msg *Message) SetContent(content []byte) {
+	sourceSize, destSize := len(msg.Source), len(msg.Destination)
+	buf := bytespool.Alloc(MetaSize + sourceSize + destSize + len(content))
 
 	from, to := MetaSize, MetaSize+sourceSize
-	dup.Source = dup.buf[from:to:to]
+	if msg.Source != nil {
+		copy(buf[from:to], msg.Source)
+		msg.Source = buf[from:to:to]
+	}
 
 	from, to = to, to+destSize
-	dup.Destination = dup.buf[from:to:to]
+	if msg.Destination != nil {
+		copy(buf[from:to], msg.Destination)
+		msg.Destination = buf[from:to:to]
+	}
 
-	from, to = to, to+length
-	dup.Content = dup.buf[from:to:to]
+	from, to = to, to+len(content)
+	copy(buf[from:to], content)
+	msg.Content = buf[from:to:to]
+	msg.Length = uint32(len(content))
 
-	return dup
+	msg.release()
+	msg.buf = buf
+	msg.refs = nil
+}
+
+// Validate check msg's meta data against its paths and content before encoding,
+// Hops, Distance and Length are repaired to match them, and buf is rebuilt if it doesn't hold them.
+// returns ErrInvalidSendType if send type is undefined, or ErrBadMsg if paths are malformed.
+func (msg *Message) Validate() error {
+	if msg.SendType() > SendTypeToDest {
+		return errs.ErrInvalidSendType
+	}
+	if len(msg.Source)%4 != 0 || len(msg.Source) > 4*0xff ||
+		len(msg.Destination)%4 != 0 || len(msg.Destination) > 4*0xff ||
+		uint64(len(msg.Content)) > 0xffffffff {
+		return errs.ErrBadMsg
+	}
+	msg.Hops = msg.Source.Length()
+	msg.Distance = msg.Destination.Length()
+	msg.Length = uint32(len(msg.Content))
+	if !msg.holdsParts() {
+		msg.SetContent(msg.Content)
+	}
+	return nil
+}
+
+// holdsParts check if buf is laid out as meta, source, destination and content.
+func (msg *Message) holdsParts() bool {
+	from, to := MetaSize, MetaSize+len(msg.Source)
+	if len(msg.buf) != to+len(msg.Destination)+len(msg.Content) || !sameBytes(msg.buf[from:to], msg.Source) {
+		return false
+	}
+	from, to = to, to+len(msg.Destination)
+	if !sameBytes(msg.buf[from:to], msg.Destination) {
+		return false
+	}
+	return sameBytes(msg.buf[to:], msg.Content)
+}
+
+// sameBytes check if a and b are the same memory
+func sameBytes(a, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// release release msg's reference to buf, put buf to pool if no one references it.
+func (msg *Message) release() {
+	if msg.refs == nil || atomic.AddInt32(msg.refs, -1) == 0 {
+		bytespool.Free(msg.buf)
+	}
 }
 
 // FreeLevel defines how to free messages
@@ -524,23 +857,59 @@ func (msg *Message) FreeByLevel(level FreeLevel) {
 	}
 }
 
-// FreeAll put buf and msg to pools
+// FreeAll put buf and msg to pools, buf is put back only when it's not referenced by any other messages.
+// A freed message may be reused by the pool at once, it must not be used or freed again,
+// the check of freed messages only catches a double free before that.
 func (msg *Message) FreeAll() {
-	bytespool.Free(msg.buf)
+	if msg.freed {
+		return
+	}
+	msg.release()
 
 	msg.Free()
 }
 
+// FreeAllMsgs put a batch of messages' buf and msg to pools, nil messages are skipped.
+func FreeAllMsgs(msgs ...*Message) {
+	for _, msg := range msgs {
+		if msg != nil {
+			msg.FreeAll()
+		}
+	}
+}
+
 // Free put msg to pool
 func (msg *Message) Free() {
+	if msg.freed {
+		return
+	}
+	msg.freed = true
 	msg.buf = nil
+	msg.refs = nil
 	msg.Meta = emptyMeta
 	msg.Source = nil
 	msg.Destination = nil
 	msg.Content = nil
+	msg.localAddr = ""
+	msg.remoteAddr = ""
+	msg.deadline = 0
+	msg.ackID = 0
+	msg.maxLength = 0
 	msgPool.Put(msg)
 }
 
+// SetFromAddresses set local and remote addresses of the pipe which msg is received from.
+func (msg *Message) SetFromAddresses(local, remote string) {
+	msg.localAddr = local
+	msg.remoteAddr = remote
+}
+
+// FromAddresses get local and remote addresses of the pipe which msg is received from,
+// empty if msg is not received by a socket.
+func (msg *Message) FromAddresses() (local, remote string) {
+	return msg.localAddr, msg.remoteAddr
+}
+
 // PipeID get this message's source pipe id.
 func (msg *Message) PipeID() uint32 {
 	return msg.Source.CurID()
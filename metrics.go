@@ -0,0 +1,59 @@
+package multisocket
+
+import "sync/atomic"
+
+type (
+	nopMetricsCollector struct{}
+
+	// wrap collectors for atomic.Value, which requires consistent concrete type
+	metricsCollectorHolder struct {
+		MetricsCollector
+	}
+)
+
+func (nopMetricsCollector) MsgSent(bytes int)    {}
+func (nopMetricsCollector) MsgRecv(bytes int)    {}
+func (nopMetricsCollector) MsgDropped()          {}
+func (nopMetricsCollector) SendQueueDepth(n int) {}
+func (nopMetricsCollector) RecvQueueDepth(n int) {}
+
+var (
+	nopMetrics = metricsCollectorHolder{nopMetricsCollector{}}
+)
+
+func (s *socket) SetMetricsCollector(collector MetricsCollector) {
+	if collector == nil {
+		s.metricsCollector.Store(nopMetrics)
+		return
+	}
+	s.metricsCollector.Store(metricsCollectorHolder{collector})
+}
+
+func (s *socket) metrics() MetricsCollector {
+	return s.metricsCollector.Load().(metricsCollectorHolder)
+}
+
+// msgSent count a message of content bytes sent by a pipe
+func (s *socket) msgSent(bytes int) {
+	atomic.AddUint64(&s.msgsSent, 1)
+	atomic.AddUint64(&s.bytesSent, uint64(bytes))
+	s.metrics().MsgSent(bytes)
+}
+
+// msgRecv count a message of content bytes received
+func (s *socket) msgRecv(bytes int) {
+	atomic.AddUint64(&s.msgsRecv, 1)
+	atomic.AddUint64(&s.bytesRecv, uint64(bytes))
+	s.metrics().MsgRecv(bytes)
+}
+
+// reportSendQueueDepth report depth of socket's send queues
+func (s *socket) reportSendQueueDepth() {
+	q := s.queues()
+	s.metrics().SendQueueDepth(len(q.sendq) + len(q.prioq))
+}
+
+// reportRecvQueueDepth report depth of socket's recv queue
+func (s *socket) reportRecvQueueDepth() {
+	s.metrics().RecvQueueDepth(len(s.queues().recvq))
+}
@@ -3,6 +3,7 @@ package multisocket
 import (
 	"time"
 
+	"github.com/multisocket/multisocket/connector"
 	"github.com/multisocket/multisocket/message"
 	"github.com/multisocket/multisocket/options"
 )
@@ -14,9 +15,69 @@ type (
 		NoSend          options.BoolOption // silently drop sended messages
 		SendQueueSize   options.Uint16Option
 		SendTTL         options.Uint8Option
-		SendBestEffort  options.BoolOption
+		SendBestEffort  options.BoolOption // same as SendQueueFullDrop policy
 		SendStopTimeout options.TimeDurationOption
+		// max messages sent at once by a pipe's sender, 0 or 1 for no batching
+		SendBatchSize options.Uint16Option
+		// reject reply destinations which are malformed or not from a connected pipe
+		SendStrictDest options.BoolOption
+		// max priority messages sent in a row while normal messages are waiting, 0 disables overtaking
+		SendPriorityBurst options.Uint16Option
+		// what to do when send queue is full, see SendQueueFull* policies.
+		// SendAll never blocks, a pipe's broadcasts wait in its backlog of SendQueueSize instead, or are dropped.
+		SendQueueFullPolicy options.Uint8Option
+		// max time to block for SendQueueFullBlockTimeout policy
+		SendBlockTimeout options.TimeDurationOption
+		// reject sending messages with longer content, 0 for no limit
+		MaxSendContentLength options.Uint32Option
+		// compress sending messages' content, see message.Compression* types, raw pipes ignore compressed messages.
+		// received compressed messages are always decompressed.
+		Compression options.Uint8Option
+		// append CRC32 checksum to sending messages' content,
+		// received messages with bad checksum are always dropped.
+		// Enable it only when all peers support checksums, older peers recv the checksum as content.
+		SendChecksum options.BoolOption
+		// client id []byte announced to the peers of all pipes, see Socket.OnClientID
+		ClientID options.AnyOption
+		// max bytes of content sent per second, 0 for no limit.
+		// sends block when throttled, or are dropped with ErrMsgDropped if SendBestEffort or SendQueueFullDrop.
+		SendRateLimit options.Int64Option
+		// max bytes sent in a burst, 0 for one second of SendRateLimit
+		SendRateBurst options.Int64Option
+		// Codec of objects sent by SendObject and received by RecvObject, JSONCodec if nil
+		Codec options.AnyOption
+		// carry messages' deadline in a trailer of content, peers must enable it too.
+		// received messages past deadline are dropped, see Message.SetDeadline.
+		MsgDeadline options.BoolOption
+		// deadline stamped on sent messages relative to the sending time, 0 for none, requires MsgDeadline.
+		SendDeadline options.TimeDurationOption
+		// tolerance of clock skew between peers when checking received messages' deadline
+		DeadlineLeeway options.TimeDurationOption
+		// version exchanged with peers before any other messages, pipes to peers of other versions are closed
+		// after a PipeEventHandshakeFailed event, 0 for no handshake, peers must set it too.
+		ProtocolVersion options.Uint8Option
+		// tag sent to one messages with an ack id, and retain them until acked by the peer,
+		// unacked ones are resent on any pipe after AckTimeout. delivery is at-least-once,
+		// so peers may receive duplicates, and peers must enable it too.
+		RequireAck options.BoolOption
+		// time to wait for the ack of a sent message before resending it
+		AckTimeout options.TimeDurationOption
+		// max streams opened by the peer of a pipe, more are refused, 0 for no limit
+		MaxStreams options.Uint16Option
 	}
+
+	// SocketOption set an option value of the socket created by NewWith
+	SocketOption func(ovs options.OptionValues)
+)
+
+// send queue full policies
+const (
+	// block until queue has room
+	SendQueueFullBlock uint8 = iota
+	// drop message, returns ErrMsgDropped
+	SendQueueFullDrop
+	// block up to SendBlockTimeout, then drop message, returns ErrMsgDropped
+	SendQueueFullBlockTimeout
 )
 
 var (
@@ -31,9 +92,60 @@ var (
 		SendTTL:         options.NewUint8Option(message.DefaultMsgTTL),
 		SendBestEffort:  options.NewBoolOption(false),
 		SendStopTimeout: options.NewTimeDurationOption(5 * time.Second),
+		SendStrictDest:  options.NewBoolOption(false),
+		SendBatchSize:   options.NewUint16Option(1),
+
+		SendPriorityBurst: options.NewUint16Option(8),
+
+		SendQueueFullPolicy: options.NewUint8Option(SendQueueFullBlock),
+		SendBlockTimeout:    options.NewTimeDurationOption(time.Second),
+
+		MaxSendContentLength: options.NewUint32Option(0),
+		Compression:          options.NewUint8Option(message.CompressionNone),
+		SendChecksum:         options.NewBoolOption(false),
+
+		ClientID: options.NewAnyOption([]byte(nil)),
+
+		SendRateLimit: options.NewInt64Option(0),
+		SendRateBurst: options.NewInt64Option(0),
+
+		Codec: options.NewAnyOption(JSONCodec),
+
+		MsgDeadline:    options.NewBoolOption(false),
+		SendDeadline:   options.NewTimeDurationOption(0),
+		DeadlineLeeway: options.NewTimeDurationOption(time.Second),
+
+		ProtocolVersion: options.NewUint8Option(0),
+
+		RequireAck: options.NewBoolOption(false),
+		AckTimeout: options.NewTimeDurationOption(5 * time.Second),
+
+		MaxStreams: options.NewUint16Option(256),
 	}
 )
 
 func init() {
 	options.RegisterStructuredOptions(Options, OptionDomains)
 }
+
+// WithOption set any socket, connector or transport option opt to val
+func WithOption(opt options.Option, val interface{}) SocketOption {
+	return func(ovs options.OptionValues) {
+		ovs[opt] = val
+	}
+}
+
+// WithSendQueueSize set Options.SendQueueSize
+func WithSendQueueSize(n uint16) SocketOption {
+	return WithOption(Options.SendQueueSize, n)
+}
+
+// WithRecvQueueSize set Options.RecvQueueSize
+func WithRecvQueueSize(n uint16) SocketOption {
+	return WithOption(Options.RecvQueueSize, n)
+}
+
+// WithDialAsync set connector.Options.Dialer.DialAsync
+func WithDialAsync(async bool) SocketOption {
+	return WithOption(connector.Options.Dialer.DialAsync, async)
+}
@@ -24,6 +24,18 @@ type (
 	Options interface {
 		SetOption(opt Option, val interface{}) (err error)
 		SetOptionIfNotExists(opt Option, val interface{}) (err error)
+		// typed setters, value types are checked by the compiler instead of failing at runtime.
+		SetBool(opt BoolOption, val bool) error
+		SetString(opt StringOption, val string) error
+		SetStringSlice(opt StringSliceOption, val []string) error
+		SetDuration(opt TimeDurationOption, val time.Duration) error
+		SetInt(opt IntOption, val int) error
+		SetUint8(opt Uint8Option, val uint8) error
+		SetUint16(opt Uint16Option, val uint16) error
+		SetUint32(opt Uint32Option, val uint32) error
+		SetInt32(opt Int32Option, val int32) error
+		SetInt64(opt Int64Option, val int64) error
+		SetFloat64(opt Float64Option, val float64) error
 		ReadOnlyOptions
 		AddOptionChangeHook(hook OptionChangeHook) Options
 	}
@@ -51,6 +63,11 @@ type (
 	// BaseOption is the base of specific options
 	BaseOption struct {
 		defaultValue interface{}
+		name         string
+	}
+
+	namedOption interface {
+		setName(name string)
 	}
 
 	// AnyOption is option with any value.
@@ -85,6 +102,17 @@ type (
 		BaseOption
 	}
 
+	// StringSliceOption is option with string slice value.
+	StringSliceOption interface {
+		Option
+		Value(val interface{}) []string
+		ValueFrom(optss ...Options) []string
+	}
+
+	stringSliceOption struct {
+		BaseOption
+	}
+
 	// TimeDurationOption is option with time duration value.
 	TimeDurationOption interface {
 		Option
@@ -150,6 +178,28 @@ type (
 	int32Option struct {
 		BaseOption
 	}
+
+	// Int64Option is option with int64 value.
+	Int64Option interface {
+		Option
+		Value(val interface{}) int64
+		ValueFrom(optss ...Options) int64
+	}
+
+	int64Option struct {
+		BaseOption
+	}
+
+	// Float64Option is option with float64 value.
+	Float64Option interface {
+		Option
+		Value(val interface{}) float64
+		ValueFrom(optss ...Options) float64
+	}
+
+	float64Option struct {
+		BaseOption
+	}
 )
 
 // errors
@@ -179,7 +229,11 @@ func RegisterOption(opt Option, name string, domains []string) {
 		cur = m.(map[string]interface{})
 	}
 	cur[strings.ToLower(name)] = opt
-	optionFullNames[opt] = strings.Join(append(domains, name), ".")
+	fullName := strings.Join(append(domains, name), ".")
+	optionFullNames[opt] = fullName
+	if o, ok := opt.(namedOption); ok {
+		o.setName(fullName)
+	}
 	lock.Unlock()
 }
 
@@ -206,7 +260,9 @@ func ParseOption(s string) (opt Option, err error) {
 	name := strings.ToLower(domains[l-1])
 	domains = domains[:l-1]
 
-	lock.Lock()
+	lock.RLock()
+	defer lock.RUnlock()
+
 	var ok bool
 	cur := registeredOptions
 	for _, d := range domains {
@@ -223,10 +279,13 @@ func ParseOption(s string) (opt Option, err error) {
 	if opt, ok = cur[name].(Option); !ok {
 		return nil, fmt.Errorf("%s: %s", ErrOptionNotFound, s)
 	}
-	lock.Unlock()
 	return
 }
 
+func invalidValueError(opt Option, s string) error {
+	return fmt.Errorf("%s: %s=>%s", ErrInvalidOptionValue, OptionName(opt), s)
+}
+
 // NewOptions create an option set.
 func NewOptions() Options {
 	return NewOptionsWithValues(nil)
@@ -325,6 +384,50 @@ func (opts *options) doSetOption(opt Option, val interface{}) (err error) {
 	return
 }
 
+func (opts *options) SetBool(opt BoolOption, val bool) error {
+	return opts.SetOption(opt, val)
+}
+
+func (opts *options) SetString(opt StringOption, val string) error {
+	return opts.SetOption(opt, val)
+}
+
+func (opts *options) SetStringSlice(opt StringSliceOption, val []string) error {
+	return opts.SetOption(opt, val)
+}
+
+func (opts *options) SetDuration(opt TimeDurationOption, val time.Duration) error {
+	return opts.SetOption(opt, val)
+}
+
+func (opts *options) SetInt(opt IntOption, val int) error {
+	return opts.SetOption(opt, val)
+}
+
+func (opts *options) SetUint8(opt Uint8Option, val uint8) error {
+	return opts.SetOption(opt, val)
+}
+
+func (opts *options) SetUint16(opt Uint16Option, val uint16) error {
+	return opts.SetOption(opt, val)
+}
+
+func (opts *options) SetUint32(opt Uint32Option, val uint32) error {
+	return opts.SetOption(opt, val)
+}
+
+func (opts *options) SetInt32(opt Int32Option, val int32) error {
+	return opts.SetOption(opt, val)
+}
+
+func (opts *options) SetInt64(opt Int64Option, val int64) error {
+	return opts.SetOption(opt, val)
+}
+
+func (opts *options) SetFloat64(opt Float64Option, val float64) error {
+	return opts.SetOption(opt, val)
+}
+
 func (opts *options) SetOptionIfNotExists(opt Option, val interface{}) (err error) {
 	if val, err = opt.Validate(val); err != nil {
 		return
@@ -392,10 +495,25 @@ func (opts *options) OptionValues() (res OptionValues) {
 	return
 }
 
+// String returns option's registered full name, like Connector.Dialer.MinReconnectTime.
 func (o *BaseOption) String() string {
+	if o.name != "" {
+		return o.name
+	}
 	return fmt.Sprintf("<%T:%v>", o.defaultValue, o.defaultValue)
 }
 
+func (o *BaseOption) setName(name string) {
+	o.name = name
+}
+
+// OptionName get option's registered full name, empty if not registered.
+func OptionName(opt Option) string {
+	lock.RLock()
+	defer lock.RUnlock()
+	return optionFullNames[opt]
+}
+
 // DefaultValue returns the default option value.
 func (o *BaseOption) DefaultValue() interface{} {
 	return o.defaultValue
@@ -413,7 +531,7 @@ func valueFrom(opt Option, optss ...Options) interface{} {
 
 // NewAnyOption create an any option
 func NewAnyOption(val interface{}) AnyOption {
-	return &anyOption{BaseOption{val}}
+	return &anyOption{BaseOption{defaultValue: val}}
 }
 
 // Validate validate the option value
@@ -431,7 +549,7 @@ func (o *anyOption) ValueFrom(optss ...Options) interface{} {
 
 // NewBoolOption create a bool option
 func NewBoolOption(val bool) BoolOption {
-	return &boolOption{BaseOption{val}}
+	return &boolOption{BaseOption{defaultValue: val}}
 }
 
 // Validate validate the option value
@@ -452,7 +570,7 @@ func (o *boolOption) Parse(s string) (val interface{}, err error) {
 	case "false", "f", "0":
 		return false, nil
 	default:
-		return nil, fmt.Errorf("%s: %s=>%s", ErrInvalidOptionValue, optionFullNames[o], s)
+		return nil, invalidValueError(o, s)
 	}
 }
 
@@ -467,7 +585,7 @@ func (o *boolOption) ValueFrom(optss ...Options) bool {
 
 // NewStringOption create a string option
 func NewStringOption(val string) StringOption {
-	return &stringOption{BaseOption{val}}
+	return &stringOption{BaseOption{defaultValue: val}}
 }
 
 // Validate validate the option value
@@ -493,9 +611,41 @@ func (o *stringOption) ValueFrom(optss ...Options) string {
 	return valueFrom(o, optss...).(string)
 }
 
+// NewStringSliceOption create a string slice option
+func NewStringSliceOption(val []string) StringSliceOption {
+	return &stringSliceOption{BaseOption{defaultValue: val}}
+}
+
+// Validate validate the option value
+func (o *stringSliceOption) Validate(val interface{}) (newVal interface{}, err error) {
+	if _, ok := val.([]string); !ok {
+		err = ErrInvalidOptionValue
+		return
+	}
+	newVal = val
+	return
+}
+
+// Parse parse comma separated values
+func (o *stringSliceOption) Parse(s string) (val interface{}, err error) {
+	if s == "" {
+		return []string{}, nil
+	}
+	return strings.Split(s, ","), nil
+}
+
+// Value get option's value, must ensure option value is not empty
+func (o *stringSliceOption) Value(val interface{}) []string {
+	return val.([]string)
+}
+
+func (o *stringSliceOption) ValueFrom(optss ...Options) []string {
+	return valueFrom(o, optss...).([]string)
+}
+
 // NewTimeDurationOption create a time duration option
 func NewTimeDurationOption(name time.Duration) TimeDurationOption {
-	return &timeDurationOption{BaseOption{name}}
+	return &timeDurationOption{BaseOption{defaultValue: name}}
 }
 
 // Validate validate the option value
@@ -510,7 +660,7 @@ func (o *timeDurationOption) Validate(val interface{}) (newVal interface{}, err
 
 func (o *timeDurationOption) Parse(s string) (val interface{}, err error) {
 	if val, err = time.ParseDuration(s); err != nil {
-		err = fmt.Errorf("%s: %s=>%s", ErrInvalidOptionValue, optionFullNames[o], s)
+		err = invalidValueError(o, s)
 	}
 	return
 }
@@ -526,7 +676,7 @@ func (o *timeDurationOption) ValueFrom(optss ...Options) time.Duration {
 
 // NewIntOption create an int option
 func NewIntOption(val int) IntOption {
-	return &intOption{BaseOption{val}}
+	return &intOption{BaseOption{defaultValue: val}}
 }
 
 // Validate validate the option value
@@ -543,10 +693,11 @@ func (o *intOption) Validate(val interface{}) (newVal interface{}, err error) {
 }
 
 func (o *intOption) Parse(s string) (val interface{}, err error) {
-	if val, err = strconv.ParseInt(s, 10, 0); err != nil {
-		err = fmt.Errorf("%s: %s=>%s", ErrInvalidOptionValue, optionFullNames[o], s)
+	x, err := strconv.ParseInt(s, 10, 0)
+	if err != nil {
+		return nil, invalidValueError(o, s)
 	}
-	return
+	return int(x), nil
 }
 
 // Value get option's value, must ensure option value is not empty
@@ -560,7 +711,7 @@ func (o *intOption) ValueFrom(optss ...Options) int {
 
 // NewUint8Option create an uint8 option
 func NewUint8Option(val uint8) Uint8Option {
-	return &uint8Option{BaseOption{val}}
+	return &uint8Option{BaseOption{defaultValue: val}}
 }
 
 // Validate validate the option value
@@ -587,10 +738,11 @@ func (o *uint8Option) Validate(val interface{}) (newVal interface{}, err error)
 }
 
 func (o *uint8Option) Parse(s string) (val interface{}, err error) {
-	if val, err = strconv.ParseUint(s, 10, 8); err != nil {
-		err = fmt.Errorf("%s: %s=>%s", ErrInvalidOptionValue, optionFullNames[o], s)
+	x, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return nil, invalidValueError(o, s)
 	}
-	return
+	return uint8(x), nil
 }
 
 // Value get option's value, must ensure option value is not empty
@@ -604,7 +756,7 @@ func (o *uint8Option) ValueFrom(optss ...Options) uint8 {
 
 // NewUint16Option create an uint16 option
 func NewUint16Option(val uint16) Uint16Option {
-	return &uint16Option{BaseOption{val}}
+	return &uint16Option{BaseOption{defaultValue: val}}
 }
 
 // Validate validate the option value
@@ -631,10 +783,11 @@ func (o *uint16Option) Validate(val interface{}) (newVal interface{}, err error)
 }
 
 func (o *uint16Option) Parse(s string) (val interface{}, err error) {
-	if val, err = strconv.ParseUint(s, 10, 16); err != nil {
-		err = fmt.Errorf("%s: %s=>%s", ErrInvalidOptionValue, optionFullNames[o], s)
+	x, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return nil, invalidValueError(o, s)
 	}
-	return
+	return uint16(x), nil
 }
 
 // Value get option's value, must ensure option value is not empty
@@ -648,7 +801,7 @@ func (o *uint16Option) ValueFrom(optss ...Options) uint16 {
 
 // NewUint32Option create an uint32 option
 func NewUint32Option(val uint32) Uint32Option {
-	return &uint32Option{BaseOption{val}}
+	return &uint32Option{BaseOption{defaultValue: val}}
 }
 
 // Validate validate the option value
@@ -663,7 +816,7 @@ func (o *uint32Option) Validate(val interface{}) (newVal interface{}, err error)
 		}
 		err = ErrInvalidOptionValue
 	case uint64:
-		if x >= 0 && x <= math.MaxUint64 {
+		if x <= math.MaxUint32 {
 			newVal = uint32(x)
 			break
 		}
@@ -675,10 +828,11 @@ func (o *uint32Option) Validate(val interface{}) (newVal interface{}, err error)
 }
 
 func (o *uint32Option) Parse(s string) (val interface{}, err error) {
-	if val, err = strconv.ParseUint(s, 10, 32); err != nil {
-		err = fmt.Errorf("%s: %s=>%s", ErrInvalidOptionValue, optionFullNames[o], s)
+	x, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return nil, invalidValueError(o, s)
 	}
-	return
+	return uint32(x), nil
 }
 
 // Value get option's value, must ensure option value is not empty
@@ -692,7 +846,7 @@ func (o *uint32Option) ValueFrom(optss ...Options) uint32 {
 
 // NewInt32Option create an int32 option
 func NewInt32Option(val int32) Int32Option {
-	return &int32Option{BaseOption{val}}
+	return &int32Option{BaseOption{defaultValue: val}}
 }
 
 // Validate validate the option value
@@ -719,10 +873,11 @@ func (o *int32Option) Validate(val interface{}) (newVal interface{}, err error)
 }
 
 func (o *int32Option) Parse(s string) (val interface{}, err error) {
-	if val, err = strconv.ParseInt(s, 10, 32); err != nil {
-		err = fmt.Errorf("%s: %s=>%s", ErrInvalidOptionValue, optionFullNames[o], s)
+	x, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return nil, invalidValueError(o, s)
 	}
-	return
+	return int32(x), nil
 }
 
 // Value get option's value, must ensure option value is not empty
@@ -733,3 +888,84 @@ func (o *int32Option) Value(val interface{}) int32 {
 func (o *int32Option) ValueFrom(optss ...Options) int32 {
 	return valueFrom(o, optss...).(int32)
 }
+
+// NewInt64Option create an int64 option
+func NewInt64Option(val int64) Int64Option {
+	return &int64Option{BaseOption{defaultValue: val}}
+}
+
+// Validate validate the option value
+func (o *int64Option) Validate(val interface{}) (newVal interface{}, err error) {
+	switch x := val.(type) {
+	case int64:
+		newVal = x
+	case int:
+		newVal = int64(x)
+	case int32:
+		newVal = int64(x)
+	case uint32:
+		newVal = int64(x)
+	default:
+		err = ErrInvalidOptionValue
+	}
+	return
+}
+
+func (o *int64Option) Parse(s string) (val interface{}, err error) {
+	x, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil, invalidValueError(o, s)
+	}
+	return x, nil
+}
+
+// Value get option's value, must ensure option value is not empty
+func (o *int64Option) Value(val interface{}) int64 {
+	return val.(int64)
+}
+
+func (o *int64Option) ValueFrom(optss ...Options) int64 {
+	return valueFrom(o, optss...).(int64)
+}
+
+// NewFloat64Option create a float64 option
+func NewFloat64Option(val float64) Float64Option {
+	return &float64Option{BaseOption{defaultValue: val}}
+}
+
+// Validate validate the option value
+func (o *float64Option) Validate(val interface{}) (newVal interface{}, err error) {
+	switch x := val.(type) {
+	case float64:
+		newVal = x
+	case float32:
+		newVal = float64(x)
+	case int:
+		newVal = float64(x)
+	default:
+		err = ErrInvalidOptionValue
+		return
+	}
+	if f := newVal.(float64); math.IsNaN(f) || math.IsInf(f, 0) {
+		newVal = nil
+		err = ErrInvalidOptionValue
+	}
+	return
+}
+
+func (o *float64Option) Parse(s string) (val interface{}, err error) {
+	x, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(x) || math.IsInf(x, 0) {
+		return nil, invalidValueError(o, s)
+	}
+	return x, nil
+}
+
+// Value get option's value, must ensure option value is not empty
+func (o *float64Option) Value(val interface{}) float64 {
+	return val.(float64)
+}
+
+func (o *float64Option) ValueFrom(optss ...Options) float64 {
+	return valueFrom(o, optss...).(float64)
+}
@@ -1,12 +1,16 @@
 package multisocket
 
 import (
+	"context"
 	"sync"
+	"time"
 
+	"github.com/multisocket/multisocket/bytespool"
 	"github.com/multisocket/multisocket/connector"
 	"github.com/multisocket/multisocket/errs"
 	"github.com/multisocket/multisocket/message"
 	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport"
 )
 
 type (
@@ -16,10 +20,11 @@ type (
 
 		recvq chan *message.Message
 
-		noSend     bool
-		sendq      chan *message.Message
-		ttl        uint8
-		bestEffort bool
+		noSend        bool
+		sendq         chan *message.Message
+		ttl           uint8
+		bestEffort    bool
+		maxSendLength uint32
 
 		lk      *sync.Mutex
 		closedq chan struct{}
@@ -57,6 +62,7 @@ func newPairSocket(sendq, recvq chan *message.Message, lk *sync.Mutex, closedq c
 	s.onOptionChange(Options.NoSend, nil, nil)
 	s.onOptionChange(Options.SendTTL, nil, nil)
 	s.onOptionChange(Options.SendBestEffort, nil, nil)
+	s.onOptionChange(Options.MaxSendContentLength, nil, nil)
 
 	s.Options.AddOptionChangeHook(s.onOptionChange)
 	return s
@@ -74,6 +80,8 @@ func (s *pairSocket) onOptionChange(opt options.Option, oldVal, newVal interface
 		s.ttl = s.GetOptionDefault(Options.SendTTL).(uint8)
 	case Options.SendBestEffort:
 		s.bestEffort = s.GetOptionDefault(Options.SendBestEffort).(bool)
+	case Options.MaxSendContentLength:
+		s.maxSendLength = s.GetOptionDefault(Options.MaxSendContentLength).(uint32)
 	}
 	return nil
 }
@@ -88,12 +96,114 @@ func (s *pairSocket) RecvMsg() (msg *message.Message, err error) {
 	}
 }
 
+func (s *pairSocket) RecvMsgTimeout(d time.Duration) (msg *message.Message, err error) {
+	tm := time.NewTimer(d)
+	defer tm.Stop()
+	select {
+	case msg = <-s.recvq:
+	case <-s.closedq:
+		err = errs.ErrClosed
+	case <-tm.C:
+		err = errs.ErrTimeout
+	}
+	return
+}
+
+func (s *pairSocket) RecvBatch(max int, within time.Duration) (msgs []*message.Message, err error) {
+	tm := time.NewTimer(within)
+	defer tm.Stop()
+	for len(msgs) < max {
+		select {
+		case msg := <-s.recvq:
+			msgs = append(msgs, msg)
+		case <-s.closedq:
+			if len(msgs) == 0 {
+				err = errs.ErrClosed
+			}
+			return
+		case <-tm.C:
+			if len(msgs) == 0 {
+				err = errs.ErrTimeout
+			}
+			return
+		}
+	}
+	return
+}
+
+func (s *pairSocket) RecvTimeout(d time.Duration) (content []byte, err error) {
+	var msg *message.Message
+	if msg, err = s.RecvMsgTimeout(d); err != nil {
+		return
+	}
+	content = bytespool.Alloc(len(msg.Content))
+	copy(content, msg.Content)
+	msg.FreeAll()
+	return
+}
+
+func (s *pairSocket) RecvFrom() (content []byte, from string, err error) {
+	var msg *message.Message
+	if msg, err = s.RecvMsg(); err != nil {
+		return
+	}
+	_, from = msg.FromAddresses()
+	content = bytespool.Alloc(len(msg.Content))
+	copy(content, msg.Content)
+	msg.FreeAll()
+	return
+}
+
+func (s *pairSocket) RecvZeroCopy() (msg *message.Message, release func(), err error) {
+	if msg, err = s.RecvMsg(); err != nil {
+		return
+	}
+	release = msg.FreeAll
+	return
+}
+
+func (s *pairSocket) SendObject(v interface{}) error {
+	return sendObject(s, v)
+}
+
+func (s *pairSocket) RecvObject(v interface{}) error {
+	return recvObject(s, v)
+}
+
+// CorruptMsgs pair sockets never corrupt messages
+func (s *pairSocket) CorruptMsgs() uint64 {
+	return 0
+}
+
+// TTLExpiredMsgs pair sockets never forward messages
+func (s *pairSocket) TTLExpiredMsgs() uint64 {
+	return 0
+}
+
+// Stats pair sockets have no pipes, messages are handed over directly
+func (s *pairSocket) Stats() Stats {
+	return Stats{}
+}
+
+// MsgTransport pair sockets have no transport
+func (s *pairSocket) MsgTransport(msg *message.Message) transport.Transport {
+	return nil
+}
+
 func (s *pairSocket) SendMsg(msg *message.Message) error {
 	if s.noSend {
 		// drop msg
 		msg.FreeAll()
 		return nil
 	}
+	if s.maxSendLength != 0 && uint64(len(msg.Content)) > uint64(s.maxSendLength) {
+		msg.FreeAll()
+		return ErrContentTooLong
+	}
+	if err := msg.Validate(); err != nil {
+		msg.FreeAll()
+		return err
+	}
 	select {
 	case s.sendq <- msg:
 		return nil
@@ -102,6 +212,10 @@ func (s *pairSocket) SendMsg(msg *message.Message) error {
 	}
 }
 
+func (s *pairSocket) SendMsgCopy(msg *message.Message) error {
+	return s.SendMsg(msg.Copy())
+}
+
 func (s *pairSocket) Send(content []byte) error {
 	if s.noSend {
 		return nil
@@ -109,6 +223,19 @@ func (s *pairSocket) Send(content []byte) error {
 	return s.SendMsg(message.NewSendMessage(0, message.SendTypeToOne, s.ttl, nil, nil, content))
 }
 
+// SendToPipe pair sockets have no pipes.
+func (s *pairSocket) SendToPipe(id uint32, content []byte) error {
+	return ErrPipeNotFound
+}
+
+// SendPriority pair sockets have no send queue, same as Send.
+func (s *pairSocket) SendPriority(content []byte) error {
+	if s.noSend {
+		return nil
+	}
+	return s.SendMsg(message.NewSendMessage(message.MsgFlagPriority, message.SendTypeToOne, s.ttl, nil, nil, content))
+}
+
 func (s *pairSocket) SendAll(content []byte) error {
 	if s.noSend {
 		return nil
@@ -116,6 +243,26 @@ func (s *pairSocket) SendAll(content []byte) error {
 	return s.SendMsg(message.NewSendMessage(0, message.SendTypeToAll, s.ttl, nil, nil, content))
 }
 
+// SendToMany the only peer is the destination of all.
+func (s *pairSocket) SendToMany(dests []message.MsgPath, content []byte) error {
+	var (
+		destErrs = make([]error, len(dests))
+		failed   bool
+	)
+	for i, dest := range dests {
+		destErrs[i] = s.SendTo(dest, content)
+		failed = failed || destErrs[i] != nil
+	}
+	if failed {
+		return &DestsError{Errs: destErrs}
+	}
+	return nil
+}
+
+func (s *pairSocket) Broadcast(content []byte) error {
+	return s.SendAll(content)
+}
+
 func (s *pairSocket) SendTo(dest message.MsgPath, content []byte) error {
 	if s.noSend {
 		return nil
@@ -125,10 +272,75 @@ func (s *pairSocket) SendTo(dest message.MsgPath, content []byte) error {
 
 // connector
 
+func (s *pairSocket) NewRawListener(addr string) (connector.Listener, error) {
+	return nil, errs.ErrOperationNotSupported
+}
+
+func (s *pairSocket) DialTimeout(addr string, d time.Duration) error {
+	return errs.ErrOperationNotSupported
+}
+
+func (s *pairSocket) RawDial(addr string) error {
+	return errs.ErrOperationNotSupported
+}
+
+func (s *pairSocket) OnPipeEvent(handler connector.PipeEventHandlerFunc) {
+	// no pipes, no pipe events
+}
+
+func (s *pairSocket) OnClientID(handler ClientIDHandler) {
+	// no pipes, no client ids
+}
+
+func (s *pairSocket) OpenStream() (Stream, error) {
+	return nil, errs.ErrOperationNotSupported
+}
+
+func (s *pairSocket) AcceptStream() (Stream, error) {
+	return nil, errs.ErrOperationNotSupported
+}
+
+func (s *pairSocket) SetMetricsCollector(collector MetricsCollector) {
+	// no queues, no metrics
+}
+
+func (s *pairSocket) SetRecvFilter(filter RecvFilter) {
+	// no receivers, messages are handed over directly
+}
+
+func (s *pairSocket) SetInternalMsgHandler(typ uint8, handler InternalMsgHandler) {
+	// no pipes, no internal messages
+}
+
 func (s *pairSocket) Connector() connector.Connector {
 	return nil
 }
 
+// WaitConnected pair sockets are connected until closed
+func (s *pairSocket) WaitConnected(ctx context.Context) error {
+	select {
+	case <-s.closedq:
+		return errs.ErrClosed
+	default:
+		return nil
+	}
+}
+
+// Flush messages are handed over to peer synchronously, nothing to wait for.
+func (s *pairSocket) Flush(ctx context.Context) error {
+	select {
+	case <-s.closedq:
+		return errs.ErrClosed
+	default:
+		return nil
+	}
+}
+
+// CloseGracefully messages are handed over to peer synchronously, just close.
+func (s *pairSocket) CloseGracefully(timeout time.Duration) error {
+	return s.Close()
+}
+
 func (s *pairSocket) Close() error {
 	s.lk.Lock()
 	defer s.lk.Unlock()
@@ -141,3 +353,7 @@ func (s *pairSocket) Close() error {
 
 	return nil
 }
+
+func (s *pairSocket) Closed() <-chan struct{} {
+	return s.closedq
+}
@@ -19,6 +19,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
@@ -32,7 +33,8 @@ import (
 // LatencyServer is the server side -- very much equivalent to local_lat in
 // nanomsg/perf.  It does no measurement at all, just sends packets on the wire.
 func LatencyServer(addr string, msgSize int, roundTrips int) {
-	s := multisocket.New(nil)
+	// TCP no delay, please!
+	s := multisocket.NewWith(multisocket.WithOption(tcp.Options.NoDelay, true))
 	defer func() { time.Sleep(10 * time.Microsecond); s.Close() }()
 
 	l, err := s.NewListener(addr, nil)
@@ -40,9 +42,6 @@ func LatencyServer(addr string, msgSize int, roundTrips int) {
 		log.Fatalf("Failed to make new listener: %v", err)
 	}
 
-	// TCP no delay, please!
-	l.SetOption(tcp.Options.NoDelay, true)
-
 	err = l.Listen()
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
@@ -65,7 +64,8 @@ func LatencyServer(addr string, msgSize int, roundTrips int) {
 // LatencyClient is the client side of the latency test.  It measures round
 // trip times, and is the equivalent to nanomsg/perf/remote_lat.
 func LatencyClient(addr string, msgSize int, roundTrips int) {
-	s := multisocket.New(nil)
+	// TCP no delay, please!
+	s := multisocket.NewWith(multisocket.WithOption(tcp.Options.NoDelay, true))
 	defer s.Close()
 
 	d, err := s.NewDialer(addr, nil)
@@ -73,16 +73,15 @@ func LatencyClient(addr string, msgSize int, roundTrips int) {
 		log.Fatalf("Failed to make new dialer: %v", err)
 	}
 
-	// TCP no delay, please!
-	d.SetOption(tcp.Options.NoDelay, true)
-
 	err = d.Dial()
 	if err != nil {
 		log.Fatalf("Failed to dial: %v", err)
 	}
 
-	// 100 milliseconds to give TCP a chance to establish
-	time.Sleep(time.Millisecond * 100)
+	// wait for the pipe to be established
+	if err = s.WaitConnected(context.Background()); err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
 	var (
 		msg     *message.Message
 		content = make([]byte, msgSize)
@@ -19,6 +19,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
@@ -80,8 +81,10 @@ func ReqRepLatencyClient(addr string, msgSize int, roundTrips int) {
 		log.Fatalf("Failed to dial: %v", err)
 	}
 
-	// 100 milliseconds to give TCP a chance to establish
-	time.Sleep(time.Millisecond * 100)
+	// wait for the pipe to be established
+	if err = s.WaitConnected(context.Background()); err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
 
 	var (
 		msg     *message.Message
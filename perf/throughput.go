@@ -19,6 +19,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
@@ -99,8 +100,10 @@ func ThroughputClient(addr string, msgSize int, count int) {
 		log.Fatalf("Failed to dial: %v", err)
 	}
 
-	// 100 milliseconds to give TCP a chance to establish
-	time.Sleep(time.Millisecond * 100)
+	// wait for the pipe to be established
+	if err = s.WaitConnected(context.Background()); err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
 
 	content := make([]byte, msgSize)
 	for i := 0; i < msgSize; i++ {
@@ -0,0 +1,57 @@
+// Package pair implements a one-to-one bidirectional protocol, like nanomsg PAIR.
+// A Pair only ever keeps a single active pipe, any other connected pipes are closed.
+package pair
+
+import (
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/bytespool"
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/options"
+)
+
+type (
+	// Pair is a one-to-one bidirectional socket.
+	Pair interface {
+		options.Options
+		multisocket.ConnectorAction
+
+		Send(content []byte) error
+		Recv() ([]byte, error)
+
+		Close() error
+	}
+
+	pair struct {
+		multisocket.Socket
+	}
+)
+
+// New create a Pair
+func New() Pair {
+	return NewWithOptionValues(nil)
+}
+
+// NewWithOptionValues create a Pair with option values
+func NewWithOptionValues(ovs options.OptionValues) Pair {
+	xovs := options.OptionValues{}
+	for o, v := range ovs {
+		xovs[o] = v
+	}
+	// only keep a single active pipe
+	xovs[connector.Options.PipeLimit] = 1
+
+	return &pair{
+		Socket: multisocket.New(xovs),
+	}
+}
+
+func (p *pair) Recv() (content []byte, err error) {
+	msg, err := p.RecvMsg()
+	if err != nil {
+		return
+	}
+	content = bytespool.Alloc(len(msg.Content))
+	copy(content, msg.Content)
+	msg.FreeAll()
+	return
+}
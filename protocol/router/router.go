@@ -0,0 +1,189 @@
+// Package router implements multi-hop routing between logical nodes.
+// Each Router has a node id and a routing table mapping node ids to the pipes toward them,
+// messages not destined to the local node are forwarded to the next hop until their TTL runs out.
+//
+// Nodes are addressed by the node ids in content header rather than by MsgPath,
+// pipe ids are only known to their sockets, so each hop sends a SendTypeToDest message of Distance 1
+// to the pipe of its route. Source keeps growing along the hops, so the path is traceable,
+// messages whose Source is too long to be forwarded are dropped.
+package router
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/message"
+	"github.com/multisocket/multisocket/options"
+)
+
+type (
+	// Router is a node of a routed network.
+	Router interface {
+		options.Options
+		multisocket.ConnectorAction
+
+		// NodeID get local node id
+		NodeID() uint32
+		// AddRoute route messages to node nodeID through pipe pipeID.
+		AddRoute(nodeID, pipeID uint32)
+		// RemoveRoute remove route of node nodeID.
+		RemoveRoute(nodeID uint32)
+		// OnPipeEvent set handler of pipes' add/remove events, used to learn pipe ids of routes.
+		OnPipeEvent(handler connector.PipeEventHandlerFunc)
+
+		// SendToNode send content to node nodeID, returns ErrNoRoute if no route to it.
+		SendToNode(nodeID uint32, content []byte) error
+		// Recv recv content destined to local node, and the node id it's from.
+		Recv() (from uint32, content []byte, err error)
+
+		Close() error
+	}
+
+	router struct {
+		multisocket.Socket
+		nodeID uint32
+
+		sync.RWMutex
+		routes map[uint32]uint32
+
+		recvq     chan *message.Message
+		closedq   chan struct{}
+		closeOnce sync.Once
+	}
+)
+
+// errors
+const (
+	ErrNoRoute = errs.Err("no route to node")
+)
+
+// content: destination node id(4) + source node id(4) + data
+const headerSize = 8
+
+const defaultRecvQueueSize = 64
+
+// maxHops is the longest Source a forwarded message can carry, peers reject longer ones.
+const maxHops = 0xfe
+
+// New create a Router of node nodeID
+func New(nodeID uint32) Router {
+	return NewWithOptionValues(nodeID, nil)
+}
+
+// NewWithOptionValues create a Router of node nodeID with option values
+func NewWithOptionValues(nodeID uint32, ovs options.OptionValues) Router {
+	r := &router{
+		Socket:  multisocket.New(ovs),
+		nodeID:  nodeID,
+		routes:  make(map[uint32]uint32),
+		recvq:   make(chan *message.Message, defaultRecvQueueSize),
+		closedq: make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+func (r *router) NodeID() uint32 {
+	return r.nodeID
+}
+
+func (r *router) AddRoute(nodeID, pipeID uint32) {
+	r.Lock()
+	r.routes[nodeID] = pipeID
+	r.Unlock()
+}
+
+func (r *router) RemoveRoute(nodeID uint32) {
+	r.Lock()
+	delete(r.routes, nodeID)
+	r.Unlock()
+}
+
+func (r *router) route(nodeID uint32) (pipeID uint32, ok bool) {
+	r.RLock()
+	pipeID, ok = r.routes[nodeID]
+	r.RUnlock()
+	return
+}
+
+func (r *router) SendToNode(nodeID uint32, content []byte) error {
+	pipeID, ok := r.route(nodeID)
+	if !ok {
+		return ErrNoRoute
+	}
+	data := make([]byte, headerSize+len(content))
+	binary.BigEndian.PutUint32(data, nodeID)
+	binary.BigEndian.PutUint32(data[4:], r.nodeID)
+	copy(data[headerSize:], content)
+	return r.SendToPipe(pipeID, data)
+}
+
+func (r *router) Recv() (from uint32, content []byte, err error) {
+	msg, ok := <-r.recvq
+	if !ok {
+		err = errs.ErrClosed
+		return
+	}
+	from = binary.BigEndian.Uint32(msg.Content[4:])
+	content = make([]byte, len(msg.Content)-headerSize)
+	copy(content, msg.Content[headerSize:])
+	msg.FreeAll()
+	return
+}
+
+func (r *router) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.closedq)
+	})
+	return r.Socket.Close()
+}
+
+func (r *router) run() {
+	defer close(r.recvq)
+	for {
+		msg, err := r.RecvMsg()
+		if err != nil {
+			return
+		}
+		if len(msg.Content) < headerSize {
+			// not a routed message
+			msg.FreeAll()
+			continue
+		}
+		dest := binary.BigEndian.Uint32(msg.Content)
+		if dest == r.nodeID {
+			select {
+			case r.recvq <- msg:
+			case <-r.closedq:
+				msg.FreeAll()
+				return
+			}
+			continue
+		}
+		r.forward(dest, msg)
+	}
+}
+
+// forward send msg to the next hop toward node dest,
+// msg's TTL is decremented at each hop, expired messages are dropped by SendMsg.
+func (r *router) forward(dest uint32, msg *message.Message) {
+	if msg.TTL == 0 {
+		// SendMsg drops and counts the expired message
+		r.SendMsg(msg)
+		return
+	}
+	pipeID, ok := r.route(dest)
+	if !ok || msg.Hops > maxHops {
+		msg.FreeAll()
+		return
+	}
+	var next [4]byte
+	binary.BigEndian.PutUint32(next[:], pipeID)
+	// keep Source, so the path the message went through is traceable
+	fwd := message.NewSendMessage(0, message.SendTypeToDest, msg.TTL, msg.Source, next[:], msg.Content)
+	msg.FreeAll()
+	r.SendMsg(fwd)
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"github.com/multisocket/multisocket/message"
+	"github.com/multisocket/multisocket/options"
+)
+
+type (
+	// TransformFunc transform messages before they are forwarded,
+	// it returns the message to forward, msg modified or a new one, then msg must be freed by it,
+	// or false to drop msg, which is freed by the proxy.
+	TransformFunc func(msg *message.Message) (*message.Message, bool)
+
+	proxyOptions struct {
+		// forwarding directions, see Direction* consts
+		Direction options.Uint8Option
+		// TransformFunc applied to forwarded messages of both directions, nil for none
+		Transform options.AnyOption
+	}
+)
+
+// forwarding directions
+const (
+	DirectionBoth uint8 = iota
+	DirectionFrontToBack
+	DirectionBackToFront
+)
+
+var (
+	// OptionDomains is option's domain
+	OptionDomains = []string{"Proxy"}
+	// Options for proxy
+	Options = proxyOptions{
+		Direction: options.NewUint8Option(DirectionBoth),
+		Transform: options.NewAnyOption(TransformFunc(nil)),
+	}
+)
+
+func init() {
+	options.RegisterStructuredOptions(Options, OptionDomains)
+}
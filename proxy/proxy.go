@@ -0,0 +1,118 @@
+// Package proxy forwards messages between a front and a back socket,
+// e.g. clients connect to front, and servers connect to back.
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/log"
+	"github.com/multisocket/multisocket/message"
+	"github.com/multisocket/multisocket/options"
+)
+
+// how often forwarders check whether the other direction is stopped
+const pollInterval = 100 * time.Millisecond
+
+// Run forward messages between front and back in both directions,
+// until either of them is closed, then returns ErrClosed. Errors of other kinds are logged and skipped.
+func Run(front, back multisocket.Socket) error {
+	return RunWithOptionValues(front, back, nil)
+}
+
+// RunWithOptionValues is like Run, with option values.
+func RunWithOptionValues(front, back multisocket.Socket, ovs options.OptionValues) (err error) {
+	var (
+		opts      = options.NewOptionsWithValues(ovs)
+		direction = Options.Direction.ValueFrom(opts)
+		transform = transformFunc(opts)
+		stopq     = make(chan struct{})
+		stopOnce  sync.Once
+		wg        sync.WaitGroup
+	)
+	stop := func(e error) {
+		stopOnce.Do(func() {
+			err = e
+			close(stopq)
+		})
+	}
+	run := func(name string, from, to multisocket.Socket) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stop(forward(name, from, to, transform, stopq))
+		}()
+	}
+	if direction == DirectionBoth || direction == DirectionFrontToBack {
+		run("front->back", front, back)
+	}
+	if direction == DirectionBoth || direction == DirectionBackToFront {
+		run("back->front", back, front)
+	}
+	// a forwarder only notices its destination is closed when sending
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-front.Closed():
+		case <-back.Closed():
+		case <-stopq:
+			return
+		}
+		stop(errs.ErrClosed)
+	}()
+	wg.Wait()
+	return
+}
+
+func transformFunc(opts options.Options) TransformFunc {
+	switch f := Options.Transform.ValueFrom(opts).(type) {
+	case TransformFunc:
+		return f
+	case func(*message.Message) (*message.Message, bool):
+		return f
+	}
+	return nil
+}
+
+// forward forward messages from one socket to the other, until either is closed or stopq is closed,
+// returns ErrClosed if stopped by a closed socket.
+func forward(name string, from, to multisocket.Socket, transform TransformFunc, stopq <-chan struct{}) error {
+	for {
+		select {
+		case <-stopq:
+			return nil
+		default:
+		}
+		msg, err := from.RecvMsgTimeout(pollInterval)
+		if err != nil {
+			if err == errs.ErrClosed {
+				return err
+			}
+			if err != errs.ErrTimeout && log.IsLevelEnabled(log.ErrorLevel) {
+				log.Error("recv", log.Fields{"domain": "proxy", "direction": name, log.ErrorKey: err})
+			}
+			continue
+		}
+
+		if transform != nil {
+			tmsg, ok := transform(msg)
+			if !ok {
+				msg.FreeAll()
+				continue
+			}
+			msg = tmsg
+		}
+
+		if err = to.SendMsg(msg); err != nil {
+			if err == errs.ErrClosed {
+				return err
+			}
+			if log.IsLevelEnabled(log.ErrorLevel) {
+				log.Error("send", log.Fields{"domain": "proxy", "direction": name, log.ErrorKey: err})
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package multisocket
+
+import (
+	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/message"
+)
+
+// socketQueues are socket's shared message queues,
+// they are replaced as a whole when resized, and resizedq of the old ones is closed.
+type socketQueues struct {
+	recvq    chan *message.Message
+	sendq    chan *message.Message
+	prioq    chan *message.Message
+	resizedq chan struct{}
+}
+
+func (q *socketQueues) isResized() bool {
+	select {
+	case <-q.resizedq:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendQueue select send to one queue by msg's priority
+func (q *socketQueues) sendQueue(msg *message.Message) chan<- *message.Message {
+	if msg.HasFlags(message.MsgFlagPriority) {
+		return q.prioq
+	}
+	return q.sendq
+}
+
+func (s *socket) queues() *socketQueues {
+	return s.qs.Load().(*socketQueues)
+}
+
+// resizeQueues replace queues by ones of current sizes,
+// queued messages are migrated to the new queues, but may be reordered.
+func (s *socket) resizeQueues() {
+	s.qlock.Lock()
+	old, _ := s.qs.Load().(*socketQueues)
+	s.qs.Store(&socketQueues{
+		recvq:    make(chan *message.Message, s.recvQueueSize()),
+		sendq:    make(chan *message.Message, s.sendQueueSize()),
+		prioq:    make(chan *message.Message, s.sendQueueSize()),
+		resizedq: make(chan struct{}),
+	})
+	s.qlock.Unlock()
+	if old == nil {
+		return
+	}
+	// wake up waiters of the old queues
+	close(old.resizedq)
+	go s.migrateQueues(old)
+}
+
+// migrateQueues move messages from replaced queues old to current ones.
+// pushers which might push to old after it's replaced must call it again.
+func (s *socket) migrateQueues(old *socketQueues) {
+	for {
+		select {
+		case msg := <-old.recvq:
+			if !s.pushRecvMsg(msg) {
+				msg.FreeAll()
+			}
+		case msg := <-old.prioq:
+			s.migrateSendMsg(msg)
+		case msg := <-old.sendq:
+			s.migrateSendMsg(msg)
+		default:
+			return
+		}
+	}
+}
+
+// migrateSendMsg push msg taken from replaced queues again, it's counted again when pushed.
+func (s *socket) migrateSendMsg(msg *message.Message) {
+	n := unsentCount(msg)
+	if s.pushSendMsg(msg) != nil {
+		msg.FreeAll()
+	}
+	s.msgsDone(n)
+}
+
+// pushRecvMsg push msg to recv queue, returns false if socket is closed.
+func (s *socket) pushRecvMsg(msg *message.Message) bool {
+	for {
+		q := s.queues()
+		select {
+		case <-s.closedq:
+			return false
+		case q.recvq <- msg:
+			if q.isResized() {
+				s.migrateQueues(q)
+			}
+			return true
+		case <-q.resizedq:
+		}
+	}
+}
+
+// pushSendMsg push msg to send to one queues, which pipes' senders compete for.
+func (s *socket) pushSendMsg(msg *message.Message) (err error) {
+	for {
+		q := s.queues()
+		err = s.doPushMsgUntil(msg, q.sendQueue(msg), q.resizedq)
+		if err == errs.ErrClosed && q.isResized() && !s.isClosed() {
+			// retry with the new queues
+			continue
+		}
+		if err == nil && q.isResized() {
+			s.migrateQueues(q)
+		}
+		return
+	}
+}
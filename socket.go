@@ -1,37 +1,84 @@
 package multisocket
 
 import (
+	"context"
+	"encoding/binary"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/multisocket/multisocket/bytespool"
 	"github.com/multisocket/multisocket/connector"
 	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/log"
 	"github.com/multisocket/multisocket/message"
 	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport"
 	"github.com/multisocket/multisocket/utils"
-	log "github.com/sirupsen/logrus"
 )
 
 type (
 	socket struct {
+		// stats, keep 64-bit aligned for atomic operations
+		corruptMsgs    uint64
+		ttlExpiredMsgs uint64
+		bytesSent      uint64
+		bytesRecv      uint64
+		msgsSent       uint64
+		msgsRecv       uint64
+		unsentMsgs     int64 // queued or being sent, for Flush
+		sentLock       sync.Mutex
+		sentq          chan struct{} // closed when unsentMsgs drops to 0, nil if no one waits
+
 		options.Options
 		connector connector.Connector
 		ConnectorAction
 
 		sync.RWMutex
-		closedq chan struct{}
+		closedq     chan struct{}
+		sendClosedq chan struct{} // closed when stop accepting new sends
+
+		pipes               map[uint32]*pipe
+		metricsCollector    atomic.Value
+		recvFilter          atomic.Value // RecvFilter
+		internalMsgHandlers map[uint8]InternalMsgHandler
+		pipeEventHandler    connector.PipeEventHandlerFunc
+		clientIDHandler     ClientIDHandler
+		streamIDs           *utils.RecyclableIDGenerator
+		acceptq             chan *stream
 
-		pipes map[uint32]*pipe
+		// sent messages waiting for acks by id, nil after closed
+		acks    map[uint32]*pendingAck
+		ackIDs  uint32
+		ackLock sync.Mutex
+		ackOnce sync.Once
+
+		// shared queues, *socketQueues
+		qs    atomic.Value
+		qlock sync.Mutex
 
 		// recv
 		noRecv bool
-		recvq  chan *message.Message
 		// send
 		noSend         bool
 		ttl            uint8
 		bestEffort     bool
-		sendq          chan *message.Message
+		fullPolicy     uint8
+		blockTimeout   time.Duration
+		maxSendLength  uint32
+		compression    uint8
+		checksum       bool
+		msgDeadline    bool
+		sendDeadline   time.Duration
+		deadlineLeeway time.Duration
+		strictDest     bool
+		requireAck     bool
+		ackTimeout     time.Duration
+		sendBatchSize  int
+		priorityBurst  int
+		rateLimiter    atomic.Value // *utils.TokenBucket, nil for no limit
 		senderWg       *sync.WaitGroup
+		pipesWg        sync.WaitGroup // pipes' receivers and keepalives
 		senderStopTm   *utils.Timer
 		senderStoppedq chan struct{}
 	}
@@ -41,7 +88,23 @@ type (
 		// send
 		stopq     chan struct{}
 		sendq     chan *message.Message
+		prioq     chan *message.Message
 		freeLevel message.FreeLevel
+		// protocol version to handshake, 0 for no handshake
+		version uint8
+		// closed once peer's handshake is verified, sender waits for it
+		handshakedq chan struct{}
+		// keepalive
+		pongq chan struct{}
+		// streams by id, nil after pipe removed
+		streams map[uint32]*stream
+		// count of streams in streams opened by the peer
+		peerStreams int
+		// sender's scratch of a batch
+		sendSizes []int
+		// broadcasts waiting for room in the send queues, pushed in order by pushBacklog
+		backlogLock sync.Mutex
+		backlog     []*message.Message
 	}
 )
 
@@ -66,26 +129,64 @@ func NewDefault() Socket {
 	return New(nil)
 }
 
+// NewWith creates a Socket with option values set by opts
+func NewWith(opts ...SocketOption) Socket {
+	ovs := options.OptionValues{}
+	for _, opt := range opts {
+		opt(ovs)
+	}
+	return New(ovs)
+}
+
 // New creates a Socket
 func New(ovs options.OptionValues) Socket {
 	s := &socket{
-		Options: options.NewOptionsWithValues(ovs),
-		closedq: make(chan struct{}),
-		pipes:   make(map[uint32]*pipe),
+		Options:     options.NewOptionsWithValues(ovs),
+		closedq:     make(chan struct{}),
+		sendClosedq: make(chan struct{}),
+		pipes:       make(map[uint32]*pipe),
+		streamIDs:   utils.NewRecyclableIDGenerator(),
+		acks:        make(map[uint32]*pendingAck),
+		internalMsgHandlers: map[uint8]InternalMsgHandler{
+			message.InternalMsgClosePeer: handleClosePeer,
+			message.InternalMsgPing:      handlePing,
+			message.InternalMsgPong:      handlePong,
+		},
 		// send
 		senderWg:       &sync.WaitGroup{},
 		senderStopTm:   utils.NewTimer(),
 		senderStoppedq: make(chan struct{}),
 	}
+	s.metricsCollector.Store(nopMetrics)
+	s.recvFilter.Store(RecvFilter(nil))
+	s.internalMsgHandlers[message.InternalMsgClientID] = s.handleClientID
+	s.internalMsgHandlers[message.InternalMsgStreamData] = s.handleStreamMsg
+	s.internalMsgHandlers[message.InternalMsgStreamFin] = s.handleStreamMsg
+	s.internalMsgHandlers[message.InternalMsgAck] = s.handleAck
 	s.connector = connector.NewWithOptions(s.Options)
 	s.ConnectorAction = s.connector
 	// init option values
 	s.onOptionChange(Options.NoRecv, nil, nil)
 	s.onOptionChange(Options.RecvQueueSize, nil, nil)
+	s.acceptq = make(chan *stream, s.recvQueueSize())
 	s.onOptionChange(Options.NoSend, nil, nil)
 	s.onOptionChange(Options.SendQueueSize, nil, nil)
 	s.onOptionChange(Options.SendTTL, nil, nil)
 	s.onOptionChange(Options.SendBestEffort, nil, nil)
+	s.onOptionChange(Options.SendQueueFullPolicy, nil, nil)
+	s.onOptionChange(Options.SendBlockTimeout, nil, nil)
+	s.onOptionChange(Options.MaxSendContentLength, nil, nil)
+	s.onOptionChange(Options.Compression, nil, nil)
+	s.onOptionChange(Options.SendChecksum, nil, nil)
+	s.onOptionChange(Options.MsgDeadline, nil, nil)
+	s.onOptionChange(Options.SendDeadline, nil, nil)
+	s.onOptionChange(Options.DeadlineLeeway, nil, nil)
+	s.onOptionChange(Options.SendStrictDest, nil, nil)
+	s.onOptionChange(Options.RequireAck, nil, nil)
+	s.onOptionChange(Options.AckTimeout, nil, nil)
+	s.onOptionChange(Options.SendBatchSize, nil, nil)
+	s.onOptionChange(Options.SendPriorityBurst, nil, nil)
+	s.onOptionChange(Options.SendRateLimit, nil, nil)
 
 	s.Options.AddOptionChangeHook(s.onOptionChange)
 
@@ -102,19 +203,90 @@ func (s *socket) onOptionChange(opt options.Option, oldVal, newVal interface{})
 	case Options.NoRecv:
 		s.noRecv = s.GetOptionDefault(Options.NoRecv).(bool)
 	case Options.RecvQueueSize:
-		s.recvq = make(chan *message.Message, s.recvQueueSize())
+		s.resizeQueues()
 	case Options.NoRecv:
 		s.noSend = s.GetOptionDefault(Options.NoSend).(bool)
 	case Options.SendQueueSize:
-		s.sendq = make(chan *message.Message, s.sendQueueSize())
+		s.resizeQueues()
 	case Options.SendTTL:
 		s.ttl = s.GetOptionDefault(Options.SendTTL).(uint8)
 	case Options.SendBestEffort:
 		s.bestEffort = s.GetOptionDefault(Options.SendBestEffort).(bool)
+	case Options.SendQueueFullPolicy:
+		s.fullPolicy = s.GetOptionDefault(Options.SendQueueFullPolicy).(uint8)
+	case Options.SendBlockTimeout:
+		s.blockTimeout = s.GetOptionDefault(Options.SendBlockTimeout).(time.Duration)
+	case Options.MaxSendContentLength:
+		s.maxSendLength = s.GetOptionDefault(Options.MaxSendContentLength).(uint32)
+	case Options.Compression:
+		s.compression = s.GetOptionDefault(Options.Compression).(uint8)
+	case Options.SendChecksum:
+		s.checksum = s.GetOptionDefault(Options.SendChecksum).(bool)
+	case Options.MsgDeadline:
+		s.msgDeadline = s.GetOptionDefault(Options.MsgDeadline).(bool)
+	case Options.SendDeadline:
+		s.sendDeadline = s.GetOptionDefault(Options.SendDeadline).(time.Duration)
+	case Options.DeadlineLeeway:
+		s.deadlineLeeway = s.GetOptionDefault(Options.DeadlineLeeway).(time.Duration)
+	case Options.SendStrictDest:
+		s.strictDest = s.GetOptionDefault(Options.SendStrictDest).(bool)
+	case Options.RequireAck:
+		s.requireAck = s.GetOptionDefault(Options.RequireAck).(bool)
+	case Options.AckTimeout:
+		s.ackTimeout = s.GetOptionDefault(Options.AckTimeout).(time.Duration)
+	case Options.SendBatchSize:
+		s.sendBatchSize = int(s.GetOptionDefault(Options.SendBatchSize).(uint16))
+	case Options.SendPriorityBurst:
+		s.priorityBurst = int(s.GetOptionDefault(Options.SendPriorityBurst).(uint16))
+	case Options.SendRateLimit, Options.SendRateBurst:
+		s.resetRateLimiter()
 	}
 	return nil
 }
 
+func (s *socket) resetRateLimiter() {
+	var (
+		rate  = s.GetOptionDefault(Options.SendRateLimit).(int64)
+		burst = s.GetOptionDefault(Options.SendRateBurst).(int64)
+		tb    *utils.TokenBucket
+	)
+	if rate > 0 {
+		if burst <= 0 {
+			burst = rate
+		}
+		tb = utils.NewTokenBucket(rate, burst)
+	}
+	s.rateLimiter.Store(tb)
+}
+
+// throttle wait until n bytes can be sent within rate limit,
+// or returns ErrMsgDropped if not to block.
+func (s *socket) throttle(n int) error {
+	tb, _ := s.rateLimiter.Load().(*utils.TokenBucket)
+	if tb == nil {
+		return nil
+	}
+	if s.bestEffort || s.fullPolicy == SendQueueFullDrop {
+		if !tb.TryTake(n) {
+			s.metrics().MsgDropped()
+			return ErrMsgDropped
+		}
+		return nil
+	}
+	d := tb.Take(n)
+	if d <= 0 {
+		return nil
+	}
+	tm := time.NewTimer(d)
+	defer tm.Stop()
+	select {
+	case <-s.closedq:
+		return errs.ErrClosed
+	case <-tm.C:
+		return nil
+	}
+}
+
 func (s *socket) recvQueueSize() uint16 {
 	return s.GetOptionDefault(Options.RecvQueueSize).(uint16)
 }
@@ -134,25 +306,73 @@ func (s *socket) HandlePipeEvent(e connector.PipeEvent, pipe connector.Pipe) {
 	case connector.PipeEventRemove:
 		s.remPipe(pipe.ID())
 	}
+	s.pipeEvent(e, pipe)
+}
+
+// pipeEvent notify socket's pipe event handler.
+func (s *socket) pipeEvent(e connector.PipeEvent, pipe connector.Pipe) {
+	s.RLock()
+	handler := s.pipeEventHandler
+	s.RUnlock()
+	if handler != nil {
+		handler(e, pipe)
+	}
+}
+
+func (s *socket) OnPipeEvent(handler connector.PipeEventHandlerFunc) {
+	s.Lock()
+	s.pipeEventHandler = handler
+	s.Unlock()
 }
 
 func (s *socket) addPipe(cp connector.Pipe) {
 	s.Lock()
 	p := s.newPipe(cp)
 	s.pipes[p.ID()] = p
+	s.pipesWg.Add(1)
 	go s.receiver(p)
+	s.senderWg.Add(1)
 	go s.sender(p)
+	if interval := p.GetOptionDefault(connector.Options.Pipe.KeepAliveInterval).(time.Duration); interval > 0 && !p.IsRaw() {
+		s.pipesWg.Add(1)
+		go s.keepAlive(p, interval, p.GetOptionDefault(connector.Options.Pipe.KeepAliveTimeout).(time.Duration))
+	}
 	s.Unlock()
+
+	if id, _ := s.GetOptionDefault(Options.ClientID).([]byte); len(id) > 0 && !p.IsRaw() {
+		select {
+		case <-p.stopq:
+		case p.sendq <- message.NewInternalMessageWithData(p.ID(), message.InternalMsgClientID, id):
+		}
+	}
 }
 
 func (s *socket) newPipe(cp connector.Pipe) *pipe {
-	return &pipe{
+	p := &pipe{
 		Pipe: cp,
 		// send
-		stopq:     make(chan struct{}),
-		sendq:     make(chan *message.Message, s.sendQueueSize()),
-		freeLevel: cp.MsgFreeLevel(),
+		stopq:       make(chan struct{}),
+		sendq:       make(chan *message.Message, s.sendQueueSize()),
+		prioq:       make(chan *message.Message, s.sendQueueSize()),
+		freeLevel:   cp.MsgFreeLevel(),
+		version:     s.handshakeVersion(cp),
+		handshakedq: make(chan struct{}),
+		pongq:       make(chan struct{}, 1),
+		// streams
+		streams: make(map[uint32]*stream),
+	}
+	if p.version == 0 {
+		close(p.handshakedq)
+	}
+	return p
+}
+
+// handshakeVersion get protocol version to handshake with the peer of pipe cp, raw pipes never handshake.
+func (s *socket) handshakeVersion(cp connector.Pipe) uint8 {
+	if cp.IsRaw() {
+		return 0
 	}
+	return s.GetOptionDefault(Options.ProtocolVersion).(uint8)
 }
 
 func (s *socket) remPipe(id uint32) {
@@ -165,6 +385,7 @@ func (s *socket) remPipe(id uint32) {
 	delete(s.pipes, id)
 	s.Unlock()
 
+	s.closeStreams(p)
 	s.stopPipe(p)
 }
 
@@ -179,11 +400,15 @@ DRAIN_MSG_LOOP:
 			break DRAIN_MSG_LOOP
 		case <-tm.C:
 			break DRAIN_MSG_LOOP
-		case msg := <-p.sendq:
+		case msg := <-p.prioq:
 			// send to dest/all msgs
 			if err := s.doSendMsg(p, msg); err != nil {
 				break DRAIN_MSG_LOOP
 			}
+		case msg := <-p.sendq:
+			if err := s.doSendMsg(p, msg); err != nil {
+				break DRAIN_MSG_LOOP
+			}
 		default:
 			return
 		}
@@ -191,7 +416,11 @@ DRAIN_MSG_LOOP:
 	// drop last
 	for {
 		select {
+		case msg := <-p.prioq:
+			s.msgsDone(unsentCount(msg))
+			msg.FreeAll()
 		case msg := <-p.sendq:
+			s.msgsDone(unsentCount(msg))
 			msg.FreeAll()
 		default:
 			return
@@ -202,24 +431,173 @@ DRAIN_MSG_LOOP:
 // recv
 
 func (s *socket) RecvMsg() (msg *message.Message, err error) {
+	for msg == nil && err == nil {
+		q := s.queues()
+		select {
+		case <-s.closedq:
+			// exhaust received messages
+			select {
+			case msg = <-q.recvq:
+			default:
+				err = errs.ErrClosed
+			}
+		case msg = <-q.recvq:
+		case <-q.resizedq:
+		}
+	}
+	if msg != nil {
+		msg, err = s.takeMsg(msg)
+	}
+	return
+}
+
+// takeMsg decompress message taken from recv queue, msg is freed on error.
+func (s *socket) takeMsg(msg *message.Message) (*message.Message, error) {
+	s.reportRecvQueueDepth()
+	if err := msg.Decompress(); err != nil {
+		msg.FreeAll()
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (s *socket) RecvMsgTimeout(d time.Duration) (msg *message.Message, err error) {
 	select {
-	case <-s.closedq:
-		// exhaust received messages
+	case msg = <-s.queues().recvq:
+		// avoid creating timer if already received
+		return s.takeMsg(msg)
+	default:
+	}
+
+	tm := time.NewTimer(d)
+	defer tm.Stop()
+	for msg == nil && err == nil {
+		q := s.queues()
 		select {
-		case msg = <-s.recvq:
-		default:
-			err = errs.ErrClosed
+		case <-s.closedq:
+			// exhaust received messages
+			select {
+			case msg = <-q.recvq:
+			default:
+				err = errs.ErrClosed
+			}
+		case msg = <-q.recvq:
+		case <-q.resizedq:
+		case <-tm.C:
+			err = errs.ErrTimeout
+		}
+	}
+	if msg != nil {
+		msg, err = s.takeMsg(msg)
+	}
+	return
+}
+
+func (s *socket) RecvBatch(max int, within time.Duration) (msgs []*message.Message, err error) {
+	tm := time.NewTimer(within)
+	defer tm.Stop()
+	var msg *message.Message
+	for len(msgs) < max {
+		q := s.queues()
+		select {
+		case <-s.closedq:
+			// exhaust received messages
+			select {
+			case msg = <-q.recvq:
+			default:
+				if len(msgs) == 0 {
+					err = errs.ErrClosed
+				}
+				return
+			}
+		case msg = <-q.recvq:
+		case <-q.resizedq:
+			continue
+		case <-tm.C:
+			if len(msgs) == 0 {
+				err = errs.ErrTimeout
+			}
+			return
 		}
-	case msg = <-s.recvq:
+		if msg, err = s.takeMsg(msg); err != nil {
+			return
+		}
+		msgs = append(msgs, msg)
+	}
+	return
+}
+
+func (s *socket) RecvTimeout(d time.Duration) (content []byte, err error) {
+	var msg *message.Message
+	if msg, err = s.RecvMsgTimeout(d); err != nil {
+		return
+	}
+	content = bytespool.Alloc(len(msg.Content))
+	copy(content, msg.Content)
+	msg.FreeAll()
+	return
+}
+
+func (s *socket) RecvFrom() (content []byte, from string, err error) {
+	var msg *message.Message
+	if msg, err = s.RecvMsg(); err != nil {
+		return
+	}
+	_, from = msg.FromAddresses()
+	content = bytespool.Alloc(len(msg.Content))
+	copy(content, msg.Content)
+	msg.FreeAll()
+	return
+}
+
+func (s *socket) RecvZeroCopy() (msg *message.Message, release func(), err error) {
+	if msg, err = s.RecvMsg(); err != nil {
+		return
 	}
+	release = msg.FreeAll
 	return
 }
 
+func (s *socket) CorruptMsgs() uint64 {
+	return atomic.LoadUint64(&s.corruptMsgs)
+}
+
+func (s *socket) TTLExpiredMsgs() uint64 {
+	return atomic.LoadUint64(&s.ttlExpiredMsgs)
+}
+
+func (s *socket) Stats() Stats {
+	s.RLock()
+	pipes := len(s.pipes)
+	s.RUnlock()
+	return Stats{
+		BytesSent: atomic.LoadUint64(&s.bytesSent),
+		BytesRecv: atomic.LoadUint64(&s.bytesRecv),
+		MsgsSent:  atomic.LoadUint64(&s.msgsSent),
+		MsgsRecv:  atomic.LoadUint64(&s.msgsRecv),
+		Pipes:     pipes,
+	}
+}
+
+func (s *socket) MsgTransport(msg *message.Message) transport.Transport {
+	if len(msg.Source) < 4 {
+		// not a received message
+		return nil
+	}
+
+	s.RLock()
+	p := s.pipes[msg.PipeID()]
+	s.RUnlock()
+	if p == nil {
+		return nil
+	}
+	return p.Transport()
+}
+
 func (s *socket) receiver(p *pipe) {
+	defer s.pipesWg.Done()
 	if log.IsLevelEnabled(log.DebugLevel) {
-		log.WithField("domain", "receiver").
-			WithFields(log.Fields{"id": p.ID(), "raw": p.IsRaw()}).
-			Debug("receiver start run")
+		log.Debug("receiver start run", log.Fields{"domain": "receiver", "id": p.ID(), "raw": p.IsRaw()})
 	}
 
 	var (
@@ -230,25 +608,58 @@ func (s *socket) receiver(p *pipe) {
 	if p.IsRaw() {
 		// NOTE:
 		// send a empty message to make a connection
-		s.recvq <- message.NewRawRecvMessage(p.ID(), emptyByteSlice)
+		msg = message.NewRawRecvMessage(p.ID(), emptyByteSlice)
+		msg.SetFromAddresses(p.LocalAddress(), p.RemoteAddress())
+		if !s.pushRecvMsg(msg) {
+			msg.FreeAll()
+		}
 	}
+	handshaked := p.version == 0
 RECVING:
 	for {
 		if msg, err = p.RecvMsg(); msg != nil {
-			if s.noRecv {
-				// just drop
+			if !handshaked {
+				// the first message must be peer's handshake
+				handshaked = s.verifyHandshake(p, msg)
+				msg.FreeAll()
+				if !handshaked {
+					s.pipeEvent(connector.PipeEventHandshakeFailed, p.Pipe)
+					p.Close()
+					break RECVING
+				}
+				close(p.handshakedq)
+			} else if errx := msg.VerifyChecksum(); errx != nil {
+				atomic.AddUint64(&s.corruptMsgs, 1)
+				s.metrics().MsgDropped()
+				if log.IsLevelEnabled(log.DebugLevel) {
+					log.Debug("drop corrupt message", log.Fields{"domain": "receiver", "id": p.ID(), log.ErrorKey: errx})
+				}
+				msg.FreeAll()
+			} else if !s.ackRecvMsg(p, msg) {
+				// malformed
 				msg.FreeAll()
+				s.metrics().MsgDropped()
 			} else if msg.HasFlags(message.MsgFlagInternal) {
-				// FIXME: handle internal messages.
+				s.handleInternalMsg(p, msg)
+			} else if s.noRecv {
+				// just drop
+				msg.FreeAll()
+				s.metrics().MsgDropped()
+			} else if s.msgDeadline && !s.takeDeadline(msg) {
+				// malformed or expired
 				msg.FreeAll()
+				s.metrics().MsgDropped()
+			} else if msg = s.filterRecvMsg(p, msg); msg == nil {
+				// dropped by filter
 			} else {
-				select {
-				case <-s.closedq:
+				n := len(msg.Content)
+				if !s.pushRecvMsg(msg) {
 					msg.FreeAll()
 					s.remPipe(p.ID())
 					break RECVING
-				case s.recvq <- msg:
 				}
+				s.msgRecv(n)
+				s.reportRecvQueueDepth()
 			}
 		}
 		if err != nil {
@@ -256,188 +667,906 @@ RECVING:
 		}
 	}
 
-	if log.IsLevelEnabled(log.DebugLevel) {
-		log.WithField("domain", "receiver").
-			WithError(err).
-			WithFields(log.Fields{"id": p.ID(), "raw": p.IsRaw()}).
-			Debug("receiver stopped run")
+	if err == errs.ErrBadMsgFraming && log.IsLevelEnabled(log.ErrorLevel) {
+		// peer is broken or not speaking multisocket protocol
+		log.Error("bad message framing", log.Fields{"domain": "receiver", "id": p.ID(), "remoteAddress": p.RemoteAddress(), log.ErrorKey: err})
 	}
-}
-
-// sender
-
-func (s *socket) sender(p *pipe) {
-	// start
-	s.senderWg.Add(1)
-
 	if log.IsLevelEnabled(log.DebugLevel) {
-		log.WithField("domain", "sender").
-			WithFields(log.Fields{"id": p.ID(), "raw": p.IsRaw()}).
-			Debug("sender start run")
+		log.Debug("receiver stopped run", log.Fields{"domain": "receiver", "id": p.ID(), "raw": p.IsRaw(), log.ErrorKey: err})
 	}
-	var (
-		err error
-		msg *message.Message
-	)
+}
 
-	sendq := s.sendq
-	if p.IsRaw() {
-		// raw pipe should not recv send to one messages.
-		sendq = nil
+// verifyHandshake check if msg is a handshake of the same protocol version.
+func (s *socket) verifyHandshake(p *pipe, msg *message.Message) bool {
+	var peerVersion uint8 // unknown
+	if typ, err := msg.InternalType(); err == nil && typ == message.InternalMsgHandshake && len(msg.Content) == 2 {
+		peerVersion = msg.Content[1]
 	}
-SENDING:
-	for {
-		select {
-		case <-s.closedq:
-			// send remaining messages
-		SEND_REMAINING:
-			for {
-				select {
-				case msg = <-sendq:
-					if err = s.doSendMsg(p, msg); err != nil {
-						break SEND_REMAINING
-					}
-				case <-s.senderStoppedq:
-					// timeout
-					break SEND_REMAINING
-				default:
-					break SEND_REMAINING
-				}
-			}
-			s.remPipe(p.ID())
-			break SENDING
-		case <-p.stopq:
-			break SENDING
-		case msg = <-sendq:
-		case msg = <-p.sendq:
-		}
-
-		if err = s.doSendMsg(p, msg); err != nil {
-			break SENDING
-		}
+	if peerVersion == p.version {
+		return true
 	}
-	// done
-	s.senderWg.Done()
-	if log.IsLevelEnabled(log.DebugLevel) {
-		log.WithField("domain", "sender").
-			WithFields(log.Fields{"id": p.ID(), "raw": p.IsRaw()}).
-			Debug("sender stopped run")
+	if log.IsLevelEnabled(log.ErrorLevel) {
+		log.Error("handshake", log.Fields{"domain": "receiver", "id": p.ID(), "remoteAddress": p.RemoteAddress(),
+			"version": p.version, "peerVersion": peerVersion, log.ErrorKey: ErrVersionMismatch})
 	}
+	return false
 }
 
-func (s *socket) doSendMsg(p *pipe, msg *message.Message) (err error) {
-	if err = p.SendMsg(msg); err != nil {
-		if s.resendMsg(msg) == nil {
-			return
-		}
-		msg.FreeAll()
-		return
-	}
-	msg.FreeByLevel(p.freeLevel)
-	return
+func (s *socket) SetRecvFilter(filter RecvFilter) {
+	s.recvFilter.Store(filter)
 }
 
-func (s *socket) doPushMsg(msg *message.Message, sendq chan<- *message.Message) (err error) {
-	if s.bestEffort {
-		select {
-		case <-s.closedq:
-			return errs.ErrClosed
-		case sendq <- msg:
-			return nil
-		default:
-			// drop msg
-			return ErrMsgDropped
-		}
+// filterRecvMsg set msg's from addresses and apply recv filter, returns nil if msg is dropped.
+func (s *socket) filterRecvMsg(p *pipe, msg *message.Message) *message.Message {
+	msg.SetFromAddresses(p.LocalAddress(), p.RemoteAddress())
+	filter := s.recvFilter.Load().(RecvFilter)
+	if filter == nil {
+		return msg
 	}
-
-	select {
-	case <-s.closedq:
-		err = errs.ErrClosed
-	case sendq <- msg:
+	if err := msg.Decompress(); err != nil {
+		msg.FreeAll()
+		return nil
 	}
-	return
+	fmsg, ok := filter(msg)
+	if !ok {
+		msg.FreeAll()
+		return nil
+	}
+	fmsg.SetFromAddresses(p.LocalAddress(), p.RemoteAddress())
+	return fmsg
 }
 
-func (s *socket) resendMsg(msg *message.Message) error {
-	if msg.SendType() == message.SendTypeToOne {
-		// only resend when send to one, so we can choose another pipe to send.
-		return s.doPushMsg(msg, s.sendq)
+// takeDeadline strip deadline trailer of msg, returns false if msg is malformed or expired.
+func (s *socket) takeDeadline(msg *message.Message) bool {
+	if msg.HasFlags(message.MsgFlagRaw) {
+		return true
 	}
-	return errs.ErrBadMsg
+	if err := msg.StripDeadline(); err != nil {
+		return false
+	}
+	return !msg.Expired(time.Now(), s.deadlineLeeway)
 }
 
-func (s *socket) sendTo(msg *message.Message) (err error) {
-	if msg.Distance == 0 {
-		// already arrived, just drop
-		return
+func (s *socket) SetInternalMsgHandler(typ uint8, handler InternalMsgHandler) {
+	s.Lock()
+	if handler == nil {
+		delete(s.internalMsgHandlers, typ)
+	} else {
+		s.internalMsgHandlers[typ] = handler
 	}
+	s.Unlock()
+}
 
-	s.RLock()
-	p := s.pipes[msg.Destination.CurID()]
-	s.RUnlock()
-	if p == nil {
-		err = ErrBrokenPath
-		return
+func (s *socket) handleInternalMsg(p *pipe, msg *message.Message) {
+	if typ, err := msg.InternalType(); err == nil {
+		s.RLock()
+		handler := s.internalMsgHandlers[typ]
+		s.RUnlock()
+		if handler != nil {
+			handler(p, msg)
+		}
 	}
+	msg.FreeAll()
+}
 
-	return s.doPushMsg(msg, p.sendq)
+func (s *socket) OnClientID(handler ClientIDHandler) {
+	s.Lock()
+	s.clientIDHandler = handler
+	s.Unlock()
 }
 
-func (s *socket) sendToAll(msg *message.Message) (err error) {
+func (s *socket) handleClientID(p connector.Pipe, msg *message.Message) {
 	s.RLock()
-	for _, p := range s.pipes {
-		s.doPushMsg(msg.Dup(), p.sendq)
-	}
+	handler := s.clientIDHandler
 	s.RUnlock()
-	msg.FreeAll()
-	return nil
+	if handler != nil {
+		id := make([]byte, len(msg.Content)-1)
+		copy(id, msg.Content[1:])
+		handler(p, id)
+	}
 }
 
-func (s *socket) Send(content []byte) (err error) {
-	if s.noSend {
-		return nil
-	}
-	return s.doPushMsg(message.NewSendMessage(0, message.SendTypeToOne, s.ttl, nil, nil, content), s.sendq)
+func handleClosePeer(p connector.Pipe, msg *message.Message) {
+	p.Close()
 }
 
-func (s *socket) SendTo(dest message.MsgPath, content []byte) (err error) {
-	if s.noSend {
-		return nil
+func handlePing(cp connector.Pipe, msg *message.Message) {
+	p := cp.(*pipe)
+	select {
+	case <-p.stopq:
+	case p.sendq <- message.NewInternalMessage(p.ID(), message.InternalMsgPong):
 	}
-	return s.sendTo(message.NewSendMessage(0, message.SendTypeToDest, s.ttl, nil, dest, content))
 }
 
-func (s *socket) SendAll(content []byte) (err error) {
-	if s.noSend {
-		return nil
+func handlePong(cp connector.Pipe, msg *message.Message) {
+	select {
+	case cp.(*pipe).pongq <- struct{}{}:
+	default:
 	}
-
-	return s.sendToAll(message.NewSendMessage(0, message.SendTypeToAll, s.ttl, nil, nil, content))
 }
 
-func (s *socket) SendMsg(msg *message.Message) error {
-	if s.noSend {
-		// drop msg
-		msg.FreeAll()
-		return nil
-	}
+// keepAlive ping peer every interval, close pipe if no pong received within timeout.
+func (s *socket) keepAlive(p *pipe, interval, timeout time.Duration) {
+	defer s.pipesWg.Done()
+	tm := utils.NewTimerWithDuration(interval)
+	defer tm.Stop()
+	for {
+		select {
+		case <-s.closedq:
+			return
+		case <-p.stopq:
+			return
+		case <-tm.C:
+		}
 
-	if msg.TTL == 0 {
+		select {
+		case <-p.pongq:
+			// drop stale pong
+		default:
+		}
+		select {
+		case <-s.closedq:
+			return
+		case <-p.stopq:
+			return
+		case p.sendq <- message.NewInternalMessage(p.ID(), message.InternalMsgPing):
+		}
+
+		tm.Reset(timeout)
+		select {
+		case <-s.closedq:
+			return
+		case <-p.stopq:
+			return
+		case <-tm.C:
+			if log.IsLevelEnabled(log.DebugLevel) {
+				log.Debug("pong timeout", log.Fields{"domain": "keepalive", "id": p.ID(), "timeout": timeout})
+			}
+			p.Close()
+			return
+		case <-p.pongq:
+		}
+		tm.Reset(interval)
+	}
+}
+
+// sender
+
+func (s *socket) sender(p *pipe) {
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debug("sender start run", log.Fields{"domain": "sender", "id": p.ID(), "raw": p.IsRaw()})
+	}
+	var (
+		err      error
+		msg      *message.Message
+		msgs     []*message.Message
+		prioSent int // priority messages sent in a row
+	)
+
+	var (
+		q            *socketQueues
+		sendq, prioq chan *message.Message
+	)
+	if p.version > 0 {
+		// handshake before any other messages
+		err = s.doSendMsg(p, message.NewInternalMessageWithData(p.ID(), message.InternalMsgHandshake, []byte{p.version}))
+		if err == nil {
+			// messages sent to a mismatched peer would be lost, keep them queued until verified
+			select {
+			case <-p.handshakedq:
+			case <-p.stopq:
+				err = errs.ErrClosed
+			case <-s.closedq:
+				s.remPipe(p.ID())
+				err = errs.ErrClosed
+			}
+		}
+	}
+SENDING:
+	for err == nil {
+		if q = s.queues(); !p.IsRaw() {
+			// raw pipe should not recv send to one messages.
+			sendq, prioq = q.sendq, q.prioq
+		}
+		msg = nil
+		if prioSent < s.priorityBurst {
+			select {
+			case msg = <-prioq:
+			case msg = <-p.prioq:
+			default:
+			}
+		} else {
+			// bound starvation, service a waiting normal message first
+			select {
+			case msg = <-sendq:
+			case msg = <-p.sendq:
+			default:
+			}
+		}
+		if msg == nil {
+			select {
+			case <-s.closedq:
+				// send remaining messages
+			SEND_REMAINING:
+				for {
+					select {
+					case msg = <-prioq:
+					case msg = <-sendq:
+					case <-s.senderStoppedq:
+						// timeout
+						break SEND_REMAINING
+					default:
+						break SEND_REMAINING
+					}
+					if err = s.doSendMsg(p, msg); err != nil {
+						break SEND_REMAINING
+					}
+				}
+				s.remPipe(p.ID())
+				break SENDING
+			case <-p.stopq:
+				break SENDING
+			case <-q.resizedq:
+				continue SENDING
+			case msg = <-prioq:
+			case msg = <-p.prioq:
+			case msg = <-sendq:
+			case msg = <-p.sendq:
+			}
+		}
+
+		s.reportSendQueueDepth()
+
+		if msg.HasFlags(message.MsgFlagPriority) {
+			prioSent++
+			// priority messages are not batched, send them asap
+			if err = s.doSendMsg(p, msg); err != nil {
+				break SENDING
+			}
+			continue
+		}
+		prioSent = 0
+
+		if s.sendBatchSize > 1 {
+			msgs = s.collectMsgs(append(msgs[:0], msg), sendq, p.sendq)
+			if len(msgs) > 1 {
+				err = s.doSendMsgs(p, msgs)
+				for i := range msgs {
+					msgs[i] = nil
+				}
+				if err != nil {
+					break SENDING
+				}
+				continue
+			}
+		}
+		if err = s.doSendMsg(p, msg); err != nil {
+			break SENDING
+		}
+	}
+	// done
+	s.senderWg.Done()
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debug("sender stopped run", log.Fields{"domain": "sender", "id": p.ID(), "raw": p.IsRaw()})
+	}
+}
+
+func (s *socket) doSendMsg(p *pipe, msg *message.Message) (err error) {
+	defer s.msgsDone(unsentCount(msg))
+	// dup before sending, msg may be taken over by the pipe
+	unacked := dupUnacked(msg)
+	// the peer may own msg once it's sent, read it before
+	internal := msg.HasFlags(message.MsgFlagInternal)
+	n := len(msg.Content)
+	if err = p.SendMsg(msg); err == transport.ErrMsgTooLarge {
+		// dropped, the pipe is still fine
+		message.FreeAllMsgs(unacked)
+		msg.FreeAll()
+		s.metrics().MsgDropped()
+		return nil
+	} else if err != nil {
+		message.FreeAllMsgs(unacked)
+		if s.resendMsg(msg) == nil {
+			return
+		}
+		msg.FreeAll()
+		return
+	}
+	if !internal {
+		s.msgSent(n)
+	}
+	msg.FreeByLevel(p.freeLevel)
+	s.trackAck(unacked)
+	return
+}
+
+// collectMsgs collect queued messages without blocking until batch is full.
+func (s *socket) collectMsgs(msgs []*message.Message, sendq, psendq <-chan *message.Message) []*message.Message {
+	var msg *message.Message
+	for len(msgs) < s.sendBatchSize {
+		select {
+		case msg = <-sendq:
+		case msg = <-psendq:
+		default:
+			return msgs
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func (s *socket) doSendMsgs(p *pipe, msgs []*message.Message) (err error) {
+	defer s.msgsDone(unsentCount(msgs...))
+	// unacked[i] is msgs[i]'s dup if it requires ack
+	var unacked []*message.Message
+	// the peer may own msgs once they're sent, read them before, -1 for internal messages
+	sizes := p.sendSizes[:0]
+	for i, msg := range msgs {
+		if dup := dupUnacked(msg); dup != nil {
+			if unacked == nil {
+				unacked = make([]*message.Message, len(msgs))
+			}
+			unacked[i] = dup
+		}
+		if msg.HasFlags(message.MsgFlagInternal) {
+			sizes = append(sizes, -1)
+		} else {
+			sizes = append(sizes, len(msg.Content))
+		}
+	}
+	p.sendSizes = sizes
+	if err = p.SendMsgs(msgs); err == transport.ErrMsgTooLarge {
+		// only the too large ones are dropped, the pipe is still fine
+		err = nil
+	} else if err != nil {
+		message.FreeAllMsgs(unacked...)
+		for _, msg := range msgs {
+			if msg != nil && s.resendMsg(msg) != nil {
+				msg.FreeAll()
+			}
+		}
+		return
+	}
+	for i, msg := range msgs {
+		if msg == nil {
+			// rejected
+			s.metrics().MsgDropped()
+			if unacked != nil {
+				message.FreeAllMsgs(unacked[i])
+				unacked[i] = nil
+			}
+			continue
+		}
+		if sizes[i] >= 0 {
+			s.msgSent(sizes[i])
+		}
+		msg.FreeByLevel(p.freeLevel)
+	}
+	for _, msg := range unacked {
+		s.trackAck(msg)
+	}
+	return
+}
+
+func (s *socket) doPushMsg(msg *message.Message, sendq chan<- *message.Message) (err error) {
+	return s.doPushMsgUntil(msg, sendq, nil)
+}
+
+// doPushMsgUntil is like doPushMsg, but gives up with ErrClosed once stopq is closed.
+func (s *socket) doPushMsgUntil(msg *message.Message, sendq chan<- *message.Message, stopq <-chan struct{}) (err error) {
+	// count before pushing, the sender may be done with msg before pushMsg returns
+	n := unsentCount(msg)
+	atomic.AddInt64(&s.unsentMsgs, n)
+	switch err = s.pushMsg(msg, sendq, stopq); err {
+	case nil:
+		s.reportSendQueueDepth()
+	case ErrMsgDropped:
+		s.metrics().MsgDropped()
+	}
+	if err != nil {
+		s.msgsDone(n)
+	}
+	return
+}
+
+// unsentCount count msgs tracked by unsentMsgs, internal messages are not tracked.
+func unsentCount(msgs ...*message.Message) (n int64) {
+	for _, msg := range msgs {
+		if !msg.HasFlags(message.MsgFlagInternal) {
+			n++
+		}
+	}
+	return
+}
+
+// msgsDone mark n queued messages as sent or dropped.
+func (s *socket) msgsDone(n int64) {
+	if n > 0 && atomic.AddInt64(&s.unsentMsgs, -n) == 0 {
+		s.sentLock.Lock()
+		if s.sentq != nil {
+			close(s.sentq)
+			s.sentq = nil
+		}
+		s.sentLock.Unlock()
+	}
+}
+
+// waitSent wait until all queued messages are sent or dropped,
+// returns ErrClosed if socket is closed, or ErrTimeout if cancelq is closed.
+func (s *socket) waitSent(cancelq <-chan struct{}) error {
+	for {
+		s.sentLock.Lock()
+		if atomic.LoadInt64(&s.unsentMsgs) <= 0 {
+			s.sentLock.Unlock()
+			return nil
+		}
+		if s.sentq == nil {
+			s.sentq = make(chan struct{})
+		}
+		sentq := s.sentq
+		s.sentLock.Unlock()
+
+		select {
+		case <-sentq:
+		case <-s.closedq:
+			return errs.ErrClosed
+		case <-cancelq:
+			return errs.ErrTimeout
+		}
+	}
+}
+
+func (s *socket) pushMsg(msg *message.Message, sendq chan<- *message.Message, stopq <-chan struct{}) (err error) {
+	if s.bestEffort || s.fullPolicy == SendQueueFullDrop {
+		select {
+		case <-s.closedq:
+			return errs.ErrClosed
+		case <-stopq:
+			return errs.ErrClosed
+		case sendq <- msg:
+			return nil
+		default:
+			// drop msg
+			return ErrMsgDropped
+		}
+	}
+
+	if s.fullPolicy == SendQueueFullBlockTimeout {
+		select {
+		case <-s.closedq:
+			return errs.ErrClosed
+		case <-stopq:
+			return errs.ErrClosed
+		case sendq <- msg:
+			return nil
+		default:
+		}
+		// queue is full
+		tm := time.NewTimer(s.blockTimeout)
+		defer tm.Stop()
+		select {
+		case <-s.closedq:
+			err = errs.ErrClosed
+		case <-stopq:
+			err = errs.ErrClosed
+		case sendq <- msg:
+		case <-tm.C:
+			// drop msg
+			err = ErrMsgDropped
+		}
+		return
+	}
+
+	select {
+	case <-s.closedq:
+		err = errs.ErrClosed
+	case <-stopq:
+		err = errs.ErrClosed
+	case sendq <- msg:
+	}
+	return
+}
+
+func (s *socket) resendMsg(msg *message.Message) error {
+	if msg.SendType() == message.SendTypeToOne {
+		// only resend when send to one, so we can choose another pipe to send.
+		return s.pushSendMsg(msg)
+	}
+	return errs.ErrBadMsg
+}
+
+// sendQueue select pipe's send queue by msg's priority
+func (p *pipe) sendQueue(msg *message.Message) chan<- *message.Message {
+	if msg.HasFlags(message.MsgFlagPriority) {
+		return p.prioq
+	}
+	return p.sendq
+}
+
+func (s *socket) sendTo(msg *message.Message) (err error) {
+	if msg.Distance == 0 {
+		// already arrived, just drop
+		return
+	}
+
+	if s.strictDest && len(msg.Destination) != 4*int(msg.Distance) {
+		// malformed path
+		msg.FreeAll()
+		return ErrBadDestination
+	}
+
+	s.RLock()
+	p := s.pipes[msg.Destination.CurID()]
+	s.RUnlock()
+	if s.strictDest && (p == nil || (p.IsRaw() && msg.Distance > 1)) {
+		// unknown pipe, or raw peer which can not forward messages
+		msg.FreeAll()
+		return ErrBadDestination
+	}
+	if p == nil {
+		err = ErrBrokenPath
+		return
+	}
+
+	return s.doPushMsg(msg, p.sendQueue(msg))
+}
+
+// sendToAll push msg to all pipes, slow pipes' copies are left in their backlogs or dropped.
+func (s *socket) sendToAll(msg *message.Message) (err error) {
+	s.RLock()
+	pipes := make([]*pipe, 0, len(s.pipes))
+	for _, p := range s.pipes {
+		pipes = append(pipes, p)
+	}
+	s.RUnlock()
+
+	// never wait for a pipe, so a slow pipe only delays itself.
+	var dup *message.Message
+	for _, p := range pipes {
+		if dup == nil {
+			dup = msg.Dup()
+		}
+		if s.pushPipeMsg(p, dup) {
+			dup = nil
+		}
+	}
+	if dup != nil {
+		dup.FreeAll()
+	}
+	msg.FreeAll()
+	return nil
+}
+
+// pushPipeMsg push msg to pipe p's send queues without blocking,
+// msg is put in p's backlog if they are full, returns false if msg is dropped.
+func (s *socket) pushPipeMsg(p *pipe, msg *message.Message) bool {
+	// count before pushing, like doPushMsgUntil
+	n := unsentCount(msg)
+	atomic.AddInt64(&s.unsentMsgs, n)
+	p.backlogLock.Lock()
+	if len(p.backlog) == 0 {
+		select {
+		case p.sendQueue(msg) <- msg:
+			p.backlogLock.Unlock()
+			s.reportSendQueueDepth()
+			return true
+		default:
+		}
+	}
+	if s.bestEffort || s.fullPolicy == SendQueueFullDrop || len(p.backlog) >= int(s.sendQueueSize()) {
+		p.backlogLock.Unlock()
+		s.msgsDone(n)
+		s.metrics().MsgDropped()
+		return false
+	}
+	p.backlog = append(p.backlog, msg)
+	if len(p.backlog) == 1 {
+		go s.pushBacklog(p)
+	}
+	p.backlogLock.Unlock()
+	return true
+}
+
+// pushBacklog push p's backlog to its send queues until it's empty,
+// waiting for room as SendQueueFullPolicy, messages are dropped if p is removed.
+func (s *socket) pushBacklog(p *pipe) {
+	p.backlogLock.Lock()
+	for len(p.backlog) > 0 {
+		// keep it in backlog while pushing, so newer messages queue up after it
+		msg := p.backlog[0]
+		p.backlogLock.Unlock()
+		if err := s.pushMsg(msg, p.sendQueue(msg), p.stopq); err == nil {
+			s.reportSendQueueDepth()
+		} else {
+			if err == ErrMsgDropped {
+				s.metrics().MsgDropped()
+			}
+			s.msgsDone(unsentCount(msg))
+			msg.FreeAll()
+		}
+		p.backlogLock.Lock()
+		p.backlog[0] = nil
+		p.backlog = p.backlog[1:]
+	}
+	p.backlog = nil
+	p.backlogLock.Unlock()
+}
+
+// isClosed check if socket is closed
+func (s *socket) isClosed() bool {
+	select {
+	case <-s.closedq:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *socket) Closed() <-chan struct{} {
+	return s.closedq
+}
+
+// isSendClosed check if socket stopped accepting new sends
+func (s *socket) isSendClosed() bool {
+	select {
+	case <-s.sendClosedq:
+		return true
+	default:
+		return false
+	}
+}
+
+// isContentTooLong check content's length against MaxSendContentLength
+func (s *socket) isContentTooLong(content []byte) bool {
+	return s.maxSendLength != 0 && uint64(len(content)) > uint64(s.maxSendLength)
+}
+
+// newSendMessage create a message to send, content is compressed if Compression is set,
+// then deadline is added if MsgDeadline is set, ack id if RequireAck is set,
+// and then checksummed if SendChecksum is set.
+func (s *socket) newSendMessage(flags, sendType uint8, dest message.MsgPath, content []byte) (msg *message.Message, err error) {
+	msg = message.NewSendMessage(flags, sendType, s.ttl, nil, dest, content)
+	if err = msg.Compress(s.compression); err != nil {
+		msg.FreeAll()
+		msg = nil
+		return
+	}
+	if s.msgDeadline {
+		if s.sendDeadline > 0 {
+			msg.SetDeadline(time.Now().Add(s.sendDeadline))
+		}
+		msg.AddDeadline()
+	}
+	s.tagAck(msg)
+	if s.checksum {
+		msg.AddChecksum()
+	}
+	return
+}
+
+func (s *socket) Send(content []byte) (err error) {
+	if s.noSend {
+		return nil
+	}
+	if s.isSendClosed() {
+		return errs.ErrClosed
+	}
+	if s.isContentTooLong(content) {
+		return ErrContentTooLong
+	}
+	if err = s.throttle(len(content)); err != nil {
+		return
+	}
+	var msg *message.Message
+	if msg, err = s.newSendMessage(0, message.SendTypeToOne, nil, content); err != nil {
+		return
+	}
+	return s.pushSendMsg(msg)
+}
+
+func (s *socket) SendToPipe(id uint32, content []byte) (err error) {
+	if s.noSend {
+		return nil
+	}
+	if s.isSendClosed() {
+		return errs.ErrClosed
+	}
+	if s.isContentTooLong(content) {
+		return ErrContentTooLong
+	}
+	if err = s.throttle(len(content)); err != nil {
+		return
+	}
+
+	s.RLock()
+	p := s.pipes[id]
+	s.RUnlock()
+	if p == nil {
+		return ErrPipeNotFound
+	}
+	var (
+		dest [4]byte
+		msg  *message.Message
+	)
+	binary.BigEndian.PutUint32(dest[:], id)
+	if msg, err = s.newSendMessage(0, message.SendTypeToDest, dest[:], content); err != nil {
+		return
+	}
+	return s.doPushMsg(msg, p.sendq)
+}
+
+func (s *socket) SendPriority(content []byte) (err error) {
+	if s.noSend {
+		return nil
+	}
+	if s.isSendClosed() {
+		return errs.ErrClosed
+	}
+	if s.isContentTooLong(content) {
+		return ErrContentTooLong
+	}
+	if err = s.throttle(len(content)); err != nil {
+		return
+	}
+	var msg *message.Message
+	if msg, err = s.newSendMessage(message.MsgFlagPriority, message.SendTypeToOne, nil, content); err != nil {
+		return
+	}
+	return s.pushSendMsg(msg)
+}
+
+func (s *socket) SendTo(dest message.MsgPath, content []byte) (err error) {
+	if s.noSend {
+		return nil
+	}
+	if s.isSendClosed() {
+		return errs.ErrClosed
+	}
+	if s.isContentTooLong(content) {
+		return ErrContentTooLong
+	}
+	if err = s.throttle(len(content)); err != nil {
+		return
+	}
+	var msg *message.Message
+	if msg, err = s.newSendMessage(0, message.SendTypeToDest, dest, content); err != nil {
+		return
+	}
+	return s.sendTo(msg)
+}
+
+func (s *socket) SendToMany(dests []message.MsgPath, content []byte) (err error) {
+	if s.noSend || len(dests) == 0 {
+		return nil
+	}
+	if s.isSendClosed() {
+		return errs.ErrClosed
+	}
+	if s.isContentTooLong(content) {
+		return ErrContentTooLong
+	}
+	if err = s.throttle(len(content)); err != nil {
+		return
+	}
+	var msg *message.Message
+	// content is prepared once, each destination gets its own buf as it's encoded into it.
+	if msg, err = s.newSendMessage(0, message.SendTypeToDest, nil, content); err != nil {
+		return
+	}
+	var (
+		destErrs = make([]error, len(dests))
+		failed   bool
+	)
+	for i, dest := range dests {
+		dup := msg.Dup()
+		dup.Destination = dest
+		if destErrs[i] = dup.Validate(); destErrs[i] != nil {
+			dup.FreeAll()
+		} else {
+			destErrs[i] = s.sendTo(dup)
+		}
+		failed = failed || destErrs[i] != nil
+	}
+	msg.FreeAll()
+	if failed {
+		return &DestsError{Errs: destErrs}
+	}
+	return nil
+}
+
+func (s *socket) SendAll(content []byte) (err error) {
+	if s.noSend {
+		return nil
+	}
+	if s.isSendClosed() {
+		return errs.ErrClosed
+	}
+	if s.isContentTooLong(content) {
+		return ErrContentTooLong
+	}
+	if err = s.throttle(len(content)); err != nil {
+		return
+	}
+
+	var msg *message.Message
+	if msg, err = s.newSendMessage(0, message.SendTypeToAll, nil, content); err != nil {
+		return
+	}
+	return s.sendToAll(msg)
+}
+
+func (s *socket) Broadcast(content []byte) error {
+	return s.SendAll(content)
+}
+
+func (s *socket) SendObject(v interface{}) error {
+	return sendObject(s, v)
+}
+
+func (s *socket) RecvObject(v interface{}) error {
+	return recvObject(s, v)
+}
+
+func (s *socket) SendMsg(msg *message.Message) error {
+	if s.noSend {
 		// drop msg
 		msg.FreeAll()
 		return nil
 	}
+	if s.isSendClosed() {
+		msg.FreeAll()
+		return errs.ErrClosed
+	}
+	if s.isContentTooLong(msg.Content) {
+		msg.FreeAll()
+		return ErrContentTooLong
+	}
+	if err := msg.Validate(); err != nil {
+		msg.FreeAll()
+		return err
+	}
+
+	if msg.TTL == 0 {
+		// ttl expired, drop msg to avoid forwarding loops
+		atomic.AddUint64(&s.ttlExpiredMsgs, 1)
+		s.metrics().MsgDropped()
+		msg.FreeAll()
+		return nil
+	}
+	if !msg.HasFlags(message.MsgFlagInternal) {
+		if err := s.throttle(len(msg.Content)); err != nil {
+			msg.FreeAll()
+			return err
+		}
+	}
+	if !msg.HasFlags(message.MsgFlagInternal) && !msg.HasFlags(message.MsgFlagRaw) {
+		if err := msg.Compress(s.compression); err != nil {
+			msg.FreeAll()
+			return err
+		}
+		if s.msgDeadline {
+			msg.AddDeadline()
+		}
+		s.tagAck(msg)
+		if s.checksum {
+			msg.AddChecksum()
+		}
+	}
 	switch msg.SendType() {
 	case message.SendTypeToDest:
 		return s.sendTo(msg)
 	case message.SendTypeToOne:
-		return s.doPushMsg(msg, s.sendq)
+		return s.pushSendMsg(msg)
 	case message.SendTypeToAll:
 		return s.sendToAll(msg)
 	}
 	return ErrInvalidSendType
 }
 
+func (s *socket) SendMsgCopy(msg *message.Message) error {
+	return s.SendMsg(msg.Copy())
+}
+
 func (s *socket) stopSender() {
 	s.senderStopTm.Reset(s.sendStopTimeout())
 	defer s.senderStopTm.Stop()
@@ -453,10 +1582,15 @@ func (s *socket) stopSender() {
 	case <-s.senderStopTm.C:
 		close(s.senderStoppedq)
 	}
+	q := s.queues()
 	for {
 		// drop remaining messages
 		select {
-		case msg := <-s.sendq:
+		case msg := <-q.prioq:
+			s.msgsDone(unsentCount(msg))
+			msg.FreeAll()
+		case msg := <-q.sendq:
+			s.msgsDone(unsentCount(msg))
 			msg.FreeAll()
 		default:
 			return
@@ -470,6 +1604,86 @@ func (s *socket) Connector() connector.Connector {
 	return s.connector
 }
 
+func (s *socket) WaitConnected(ctx context.Context) error {
+	pipes := make(chan connector.Pipe, 1)
+	s.connector.AddPipeChannel(pipes)
+	defer s.connector.RemovePipeChannel(pipes)
+	// subscribed before checking, so no pipe is missed
+	if s.connector.PipeCount() > 0 {
+		return nil
+	}
+	select {
+	case <-s.closedq:
+		return errs.ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-pipes:
+		return nil
+	}
+}
+
+// rawPipeOptions options of pipes to plain byte stream peers
+func rawPipeOptions() options.OptionValues {
+	return options.OptionValues{
+		connector.Options.Pipe.Raw:        true,
+		connector.Options.Pipe.CloseOnEOF: true,
+	}
+}
+
+func (s *socket) NewRawListener(addr string) (connector.Listener, error) {
+	return s.connector.NewListener(addr, rawPipeOptions())
+}
+
+func (s *socket) DialTimeout(addr string, d time.Duration) error {
+	return s.connector.DialOptions(addr, options.OptionValues{
+		connector.Options.Dialer.DialAsync: false,
+		transport.Options.DialTimeout:      d,
+	})
+}
+
+func (s *socket) RawDial(addr string) error {
+	return s.connector.DialOptions(addr, rawPipeOptions())
+}
+
+func (s *socket) Flush(ctx context.Context) error {
+	switch err := s.waitSent(ctx.Done()); err {
+	case nil:
+	case errs.ErrTimeout:
+		return ctx.Err()
+	default:
+		return err
+	}
+	if s.isClosed() {
+		return errs.ErrClosed
+	}
+	return nil
+}
+
+func (s *socket) CloseGracefully(timeout time.Duration) (err error) {
+	s.Lock()
+	select {
+	case <-s.closedq:
+		s.Unlock()
+		return errs.ErrClosed
+	case <-s.sendClosedq:
+	default:
+		close(s.sendClosedq)
+	}
+	s.Unlock()
+
+	// wait for queued and being sent messages, like Flush
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	if errx := s.waitSent(ctx.Done()); errx == errs.ErrTimeout {
+		err = errx
+	}
+	cancel()
+
+	if errx := s.Close(); errx != nil {
+		return errx
+	}
+	return
+}
+
 func (s *socket) Close() error {
 	s.Lock()
 	select {
@@ -479,6 +1693,11 @@ func (s *socket) Close() error {
 	default:
 		close(s.closedq)
 	}
+	select {
+	case <-s.sendClosedq:
+	default:
+		close(s.sendClosedq)
+	}
 	s.Unlock()
 
 	// clear pipe even handler
@@ -486,6 +1705,9 @@ func (s *socket) Close() error {
 
 	s.stopSender()
 	s.connector.Close()
+	// pipes are all closed, wait for their goroutines to exit
+	s.pipesWg.Wait()
+	s.dropUnacked()
 
 	return nil
 }
@@ -0,0 +1,224 @@
+package multisocket
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/message"
+)
+
+// stream messages are internal messages of the pipe,
+// content: type(1) + stream id(4) + data.
+// stream ids are allocated by the opener, the peer flips streamAccepted bit of received ids,
+// so both sides can open streams on a pipe without conflicts.
+// Either side closing a stream sends a fin, the other side replies its fin,
+// the stream is kept until peer's fin arrives, so that its late data is dropped.
+// Refused streams are never kept, their late data may be refused again.
+const streamAccepted uint32 = 1 << 31
+
+type stream struct {
+	s         *socket
+	p         *pipe
+	id        uint32
+	recvq     chan []byte
+	closedq   chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *socket) newStream(p *pipe, id uint32) *stream {
+	return &stream{
+		s:       s,
+		p:       p,
+		id:      id,
+		recvq:   make(chan []byte, s.recvQueueSize()),
+		closedq: make(chan struct{}),
+	}
+}
+
+// OpenStream open a stream on one of the connected pipes.
+func (s *socket) OpenStream() (Stream, error) {
+	s.Lock()
+	defer s.Unlock()
+	for _, p := range s.pipes {
+		if p.IsRaw() || p.streams == nil {
+			continue
+		}
+		st := s.newStream(p, s.streamIDs.NextID())
+		p.streams[st.id] = st
+		return st, nil
+	}
+	return nil, ErrPipeNotFound
+}
+
+// AcceptStream wait for a stream opened by peers.
+func (s *socket) AcceptStream() (Stream, error) {
+	select {
+	case st := <-s.acceptq:
+		return st, nil
+	case <-s.closedq:
+		return nil, errs.ErrClosed
+	}
+}
+
+func (s *socket) handleStreamMsg(cp connector.Pipe, msg *message.Message) {
+	if len(msg.Content) < 5 {
+		return
+	}
+	p := cp.(*pipe)
+	typ := msg.Content[0]
+	id := binary.BigEndian.Uint32(msg.Content[1:5]) ^ streamAccepted
+
+	accepted := false
+	s.Lock()
+	st := p.streams[id]
+	if st == nil && typ == message.InternalMsgStreamData && id&streamAccepted != 0 && p.streams != nil {
+		st = s.newStream(p, id)
+		if max := int(p.GetOptionDefault(Options.MaxStreams).(uint16)); max > 0 && p.peerStreams >= max {
+			s.Unlock()
+			// too many streams on the pipe, refuse it
+			st.close(true)
+			return
+		}
+		p.streams[id] = st
+		p.peerStreams++
+		accepted = true
+	}
+	s.Unlock()
+	if st == nil {
+		// closed stream
+		return
+	}
+
+	switch typ {
+	case message.InternalMsgStreamData:
+		data := make([]byte, len(msg.Content)-5)
+		copy(data, msg.Content[5:])
+		// never block the pipe, other streams and messages share it
+		select {
+		case <-st.closedq:
+			return
+		case <-s.closedq:
+			return
+		case st.recvq <- data:
+		default:
+			// recver falls behind, fail the stream
+			st.close(true)
+			return
+		}
+		if accepted {
+			select {
+			case s.acceptq <- st:
+			default:
+				// too many streams waiting to be accepted, refuse it
+				st.close(true)
+				st.remove()
+			}
+		}
+	case message.InternalMsgStreamFin:
+		st.close(true)
+		st.remove()
+	}
+}
+
+// closeStreams close streams of pipe p, their peers are gone.
+func (s *socket) closeStreams(p *pipe) {
+	s.Lock()
+	streams := p.streams
+	p.streams = nil
+	s.Unlock()
+	for _, st := range streams {
+		st.close(false)
+		st.remove()
+	}
+}
+
+func (st *stream) ID() uint32 {
+	return st.id
+}
+
+func (st *stream) newMsg(typ uint8, content []byte) *message.Message {
+	data := make([]byte, 4+len(content))
+	binary.BigEndian.PutUint32(data, st.id)
+	copy(data[4:], content)
+	return message.NewInternalMessageWithData(st.p.ID(), typ, data)
+}
+
+func (st *stream) Send(content []byte) error {
+	msg := st.newMsg(message.InternalMsgStreamData, content)
+	select {
+	case <-st.closedq:
+	case <-st.p.stopq:
+	case st.p.sendq <- msg:
+		return nil
+	}
+	msg.FreeAll()
+	return errs.ErrClosed
+}
+
+func (st *stream) Recv() (content []byte, err error) {
+	select {
+	case content = <-st.recvq:
+		return
+	case <-st.closedq:
+	}
+	// drain received
+	select {
+	case content = <-st.recvq:
+	default:
+		err = errs.ErrClosed
+	}
+	return
+}
+
+func (st *stream) Close() error {
+	if !st.close(true) {
+		return errs.ErrClosed
+	}
+	return nil
+}
+
+// close close stream, notify peer with a fin if fin is true.
+// It never blocks, as it's called by the pipe's receiver too.
+func (st *stream) close(fin bool) (ok bool) {
+	st.closeOnce.Do(func() {
+		ok = true
+		close(st.closedq)
+
+		if fin {
+			msg := st.newMsg(message.InternalMsgStreamFin, nil)
+			select {
+			case st.p.sendq <- msg:
+			default:
+				go st.sendFin(msg)
+			}
+		}
+	})
+	return
+}
+
+// sendFin send fin msg once the pipe's send queue has room.
+func (st *stream) sendFin(msg *message.Message) {
+	select {
+	case <-st.p.stopq:
+		msg.FreeAll()
+	case st.p.sendq <- msg:
+	}
+}
+
+// remove closed stream from its pipe, its id can be reused.
+func (st *stream) remove() {
+	s := st.s
+	s.Lock()
+	if st.p.streams != nil && st.p.streams[st.id] == st {
+		delete(st.p.streams, st.id)
+		if st.id&streamAccepted != 0 {
+			st.p.peerStreams--
+		}
+	}
+	s.Unlock()
+	if st.id&streamAccepted == 0 {
+		s.streamIDs.Recycle(st.id)
+	}
+}
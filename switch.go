@@ -9,7 +9,8 @@ type (
 	SwitchMiddlewareFunc func(msg *message.Message) *message.Message
 )
 
-// StartSwitch start switch messages between back and front sockets
+// StartSwitch start switch messages between back and front sockets,
+// see package proxy for one with message transform and one directional forwarding.
 func StartSwitch(backSock, frontSock Socket, mid SwitchMiddlewareFunc) {
 	go forward(backSock, frontSock, mid)
 	go forward(frontSock, backSock, mid)
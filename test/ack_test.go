@@ -0,0 +1,89 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/message"
+	"github.com/multisocket/multisocket/options"
+)
+
+func TestMessageAckID(t *testing.T) {
+	content := []byte("hello")
+	msg := message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, content)
+	defer msg.FreeAll()
+
+	msg.AddAckID(42)
+	if len(msg.Content) != len(content)+message.AckIDSize {
+		t.Fatalf("ack id not added")
+	}
+	dup := msg.Dup()
+	if dup.AckID() != 42 {
+		t.Errorf("dup ack id: %d", dup.AckID())
+	}
+	dup.FreeAll()
+
+	recvd := message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, append(content, 0, 0, 0, 7))
+	defer recvd.FreeAll()
+	if err := recvd.StripAckID(); err != nil {
+		t.Fatalf("strip ack id error: %s", err)
+	}
+	if !bytes.Equal(recvd.Content, content) || len(recvd.Encode()) != recvd.FrameSize() {
+		t.Errorf("ack id not stripped")
+	}
+	if recvd.AckID() != 7 {
+		t.Errorf("ack id: %d, expected: 7", recvd.AckID())
+	}
+}
+
+func TestSocketRequireAck(t *testing.T) {
+	addr := "tcp://127.0.0.1:23994"
+	ovs := options.OptionValues{
+		multisocket.Options.RequireAck: true,
+		multisocket.Options.AckTimeout: 100 * time.Millisecond,
+	}
+	// never acks
+	srvsock := multisocket.New(nil)
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	cliovs := options.OptionValues{connector.Options.Dialer.MinReconnectTime: 10 * time.Millisecond}
+	for opt, val := range ovs {
+		cliovs[opt] = val
+	}
+	clisock := multisocket.New(cliovs)
+	defer clisock.Close()
+	if err := clisock.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	if err := clisock.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if _, err := srvsock.RecvTimeout(time.Second); err != nil {
+		t.Fatalf("recv error: %s", err)
+	}
+	// pipe killed before acked
+	srvsock.Close()
+
+	srvsock = multisocket.New(ovs)
+	defer srvsock.Close()
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	content, err := srvsock.RecvTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("recv redelivered error: %s", err)
+	}
+	if string(content) != "hello" {
+		t.Errorf("recv redelivered: %q, expected: hello", content)
+	}
+
+	// acked, no more redeliveries
+	if content, err = srvsock.RecvTimeout(300 * time.Millisecond); err == nil {
+		t.Errorf("recv acked message again: %q", content)
+	}
+}
@@ -0,0 +1,45 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/multisocket/multisocket/address"
+	"github.com/multisocket/multisocket/transport"
+	"github.com/multisocket/multisocket/transport/tcp"
+)
+
+func TestAddressParse(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		s        string
+		addr     string
+		host     string
+		connType string
+	}{
+		{"IPv4", "tcp://127.0.0.1:9000#dial", "tcp://127.0.0.1:9000", "127.0.0.1:9000", address.ConnDial},
+		{"IPv4Wildcard", "tcp://*:9000#listen", "tcp://*:9000", "*:9000", address.ConnListen},
+		{"IPv6", "tcp://[::1]:9000#listen", "tcp://[::1]:9000", "[::1]:9000", address.ConnListen},
+		{"IPv6Zone", "tcp://[fe80::1%eth0]:9000#dial", "tcp://[fe80::1%eth0]:9000", "[fe80::1%eth0]:9000", address.ConnDial},
+		{"IPv6EscapedZone", "tcp://[fe80::1%25eth0]:9000#dial", "tcp://[fe80::1%eth0]:9000", "[fe80::1%eth0]:9000", address.ConnDial},
+		{"IPv6ZoneOptions", "tcp://[fe80::1%eth0]:9000?Connector.Pipe.Raw=true#dial", "tcp://[fe80::1%eth0]:9000", "[fe80::1%eth0]:9000", address.ConnDial},
+		{"IPv6Path", "ws://[::1]:9000/ws#dial", "ws://[::1]:9000/ws", "[::1]:9000/ws", address.ConnDial},
+		{"Hostname", "tcp://localhost:9000", "tcp://localhost:9000", "localhost:9000", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sa, err := address.ParseMultiSocketAddress(tc.s)
+			if err != nil {
+				t.Fatalf("parse error: %s", err)
+			}
+			if sa.Address() != tc.addr {
+				t.Errorf("address: %s, expected: %s", sa.Address(), tc.addr)
+			}
+			if sa.ConnectType() != tc.connType {
+				t.Errorf("connect type: %s, expected: %s", sa.ConnectType(), tc.connType)
+			}
+			host, err := transport.StripScheme(tcp.Transport, sa.Address())
+			if err != nil || host != tc.host {
+				t.Errorf("strip scheme: %s, %v, expected: %s", host, err, tc.host)
+			}
+		})
+	}
+}
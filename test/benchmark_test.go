@@ -6,7 +6,10 @@ import (
 	"time"
 
 	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/bytespool"
+	"github.com/multisocket/multisocket/connector"
 	"github.com/multisocket/multisocket/message"
+	"github.com/multisocket/multisocket/options"
 	_ "github.com/multisocket/multisocket/transport/all"
 )
 
@@ -90,7 +93,75 @@ func BenchmarkRecvThroughput(b *testing.B) {
 	}
 }
 
+func BenchmarkSmallMsgRecvThroughput(b *testing.B) {
+	readBuffers := []struct {
+		name string
+		sz   int
+	}{
+		{"NoReadBuffer", 0},
+		{"ReadBuffer", connector.Options.Pipe.ReadBuffer.DefaultValue().(int)},
+	}
+	for idx := range readBuffers {
+		rb := readBuffers[idx]
+		b.Run(rb.name, func(b *testing.B) {
+			for idx := range simpleTransports {
+				tp := simpleTransports[idx]
+				if tp.name != "ipc" && tp.name != "tcp" {
+					// stream transports only
+					continue
+				}
+				b.Run(tp.name, func(b *testing.B) {
+					addr := tp.addr
+					benchmarkRecvThroughput(b, addr, 64, options.OptionValues{connector.Options.Pipe.ReadBuffer: rb.sz})
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkBatchSendThroughput(b *testing.B) {
+	batchSizes := []struct {
+		name string
+		sz   uint16
+	}{
+		{"Single", 1},
+		{"Batch16", 16},
+	}
+	for idx := range batchSizes {
+		bs := batchSizes[idx]
+		b.Run(bs.name, func(b *testing.B) {
+			benchmarkSendThroughput(b, "tcp://127.0.0.1:33833", 64, options.OptionValues{multisocket.Options.SendBatchSize: bs.sz})
+		})
+	}
+}
+
+func BenchmarkRecvZeroCopy(b *testing.B) {
+	b.Run("Copy", func(b *testing.B) {
+		benchmarkRecvCopy(b, "tcp://127.0.0.1:23951", 1024*1024, false)
+	})
+	b.Run("ZeroCopy", func(b *testing.B) {
+		benchmarkRecvCopy(b, "tcp://127.0.0.1:23951", 1024*1024, true)
+	})
+}
+
 // benchmark single message's average latency
+func BenchmarkBytespoolConfigure(b *testing.B) {
+	// larger than default classes
+	sizes := []int{1000 * 1024, 1024 * 1024}
+	alloc := func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			bytespool.Free(bytespool.Alloc(sizes[i%len(sizes)]))
+		}
+	}
+	b.Run("Default", alloc)
+	b.Run("Configured", func(b *testing.B) {
+		defer bytespool.Configure(bytespool.Classes())
+		bytespool.Configure(append(bytespool.Classes(), 1024*1024))
+		alloc(b)
+	})
+}
+
 func benchmarkSingleLatency(b *testing.B, addr string, sz int) {
 	var (
 		err     error
@@ -239,7 +310,7 @@ func benchmarkGroupLatency(b *testing.B, addr string, sz int) {
 }
 
 // benchmark sender side's throughput, use -benchmem to see xx MB/s => xx M(msg)/s
-func benchmarkSendThroughput(b *testing.B, addr string, sz int) {
+func benchmarkSendThroughput(b *testing.B, addr string, sz int, sockOvses ...options.OptionValues) {
 	var (
 		err     error
 		srvsock multisocket.Socket
@@ -250,6 +321,11 @@ func benchmarkSendThroughput(b *testing.B, addr string, sz int) {
 	}
 	defer srvsock.Close()
 	defer clisock.Close()
+	for _, ovs := range sockOvses {
+		for opt, val := range ovs {
+			clisock.SetOption(opt, val)
+		}
+	}
 
 	go func() {
 		// just recv content
@@ -281,13 +357,13 @@ func benchmarkSendThroughput(b *testing.B, addr string, sz int) {
 }
 
 // benchmark receiver side's throughput, use -benchmem to see xx MB/s => xx M(msg)/s
-func benchmarkRecvThroughput(b *testing.B, addr string, sz int) {
+func benchmarkRecvThroughput(b *testing.B, addr string, sz int, ovses ...options.OptionValues) {
 	var (
 		err     error
 		srvsock multisocket.Socket
 		clisock multisocket.Socket
 	)
-	if srvsock, clisock, err = prepareSocks(addr); err != nil {
+	if srvsock, clisock, err = prepareSocks(addr, ovses...); err != nil {
 		b.Errorf("connect error: %s", err)
 	}
 	defer srvsock.Close()
@@ -324,3 +400,61 @@ func benchmarkRecvThroughput(b *testing.B, addr string, sz int) {
 
 	b.StopTimer()
 }
+
+// benchmark receiver side's throughput with or without copying received content
+func benchmarkRecvCopy(b *testing.B, addr string, sz int, zeroCopy bool) {
+	var (
+		err     error
+		srvsock multisocket.Socket
+		clisock multisocket.Socket
+	)
+	if srvsock, clisock, err = prepareSocks(addr, options.OptionValues{connector.Options.Pipe.MaxRecvContentLength: uint32(0)}); err != nil {
+		b.Errorf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	go func() {
+		var (
+			err     error
+			content = make([]byte, sz)
+		)
+		// just send content
+		for {
+			if err = srvsock.Send(content); err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+	b.SetBytes(int64(sz))
+
+	b.ResetTimer()
+	var (
+		msg     *message.Message
+		release func()
+		content []byte
+	)
+	for i := 0; i < b.N; i++ {
+		if zeroCopy {
+			if msg, release, err = clisock.RecvZeroCopy(); err != nil {
+				b.Errorf("client recv error: %s", err)
+				return
+			}
+			_ = msg.Content
+			release()
+		} else {
+			if msg, err = clisock.RecvMsg(); err != nil {
+				b.Errorf("client recv error: %s", err)
+				return
+			}
+			content = bytespool.Alloc(len(msg.Content))
+			copy(content, msg.Content)
+			msg.FreeAll()
+			bytespool.Free(content)
+		}
+	}
+
+	b.StopTimer()
+}
@@ -0,0 +1,47 @@
+package test
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/multisocket/multisocket/bytespool"
+)
+
+func TestBytespoolMaxPooledSize(t *testing.T) {
+	const (
+		large = 4 * 1024 * 1024
+		spike = 32
+	)
+	defer bytespool.Configure(bytespool.Classes())
+	bytespool.Configure(append(bytespool.Classes(), large))
+	defer bytespool.SetMaxPooledSize(bytespool.MaxPooledSize())
+	bytespool.SetMaxPooledSize(1024 * 1024)
+
+	heapInuse := func() uint64 {
+		var ms runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&ms)
+		return ms.HeapInuse
+	}
+	before := heapInuse()
+	// a spike of large messages
+	bufs := make([][]byte, spike)
+	for i := range bufs {
+		bufs[i] = bytespool.Alloc(large)
+	}
+	for i, b := range bufs {
+		bytespool.Free(b)
+		bufs[i] = nil
+	}
+	// unbounded pools would keep spike*large bytes until next GC
+	if after := heapInuse(); after > before+large {
+		t.Errorf("heap in use %d after spike, %d before", after, before)
+	}
+
+	// small bytes are still pooled
+	b := bytespool.Alloc(1024)
+	if cap(b) != 1024 {
+		t.Errorf("cap %d, expected: 1024", cap(b))
+	}
+	bytespool.Free(b)
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/message"
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport"
+	"github.com/multisocket/multisocket/transport/tcp"
+)
+
+type (
+	// flakyTran is a tcp transport which corrupts sent data on demand.
+	flakyTran struct {
+		transport.Transport
+		corrupt *int32
+	}
+
+	flakyDialer struct {
+		transport.Dialer
+		corrupt *int32
+	}
+
+	flakyConn struct {
+		transport.Connection
+		corrupt *int32
+	}
+)
+
+func (t flakyTran) Scheme() string {
+	return "flakytcp"
+}
+
+func (t flakyTran) NewDialer(address string) (transport.Dialer, error) {
+	d, err := t.Transport.NewDialer(address)
+	if err != nil {
+		return nil, err
+	}
+	return flakyDialer{d, t.corrupt}, nil
+}
+
+func (d flakyDialer) Dial(opts options.Options) (transport.Connection, error) {
+	conn, err := d.Dialer.Dial(opts)
+	if err != nil {
+		return nil, err
+	}
+	return flakyConn{conn, d.corrupt}, nil
+}
+
+func (c flakyConn) Transport() transport.Transport {
+	return flakyTran{c.Connection.Transport(), c.corrupt}
+}
+
+// Write flip the last byte of one write when asked to corrupt.
+func (c flakyConn) Write(b []byte) (int, error) {
+	if len(b) > 0 && atomic.CompareAndSwapInt32(c.corrupt, 1, 0) {
+		b = append([]byte(nil), b...)
+		b[len(b)-1] ^= 0xff
+	}
+	return c.Connection.Write(b)
+}
+
+func TestMessageChecksum(t *testing.T) {
+	content := []byte("hello")
+	msg := message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, content)
+	defer msg.FreeAll()
+
+	msg.AddChecksum()
+	if !msg.HasFlags(message.MsgFlagChecksum) || len(msg.Content) != len(content)+message.ChecksumSize {
+		t.Fatalf("checksum not added")
+	}
+	if err := msg.VerifyChecksum(); err != nil {
+		t.Fatalf("verify checksum error: %s", err)
+	}
+	if msg.HasFlags(message.MsgFlagChecksum) || !bytes.Equal(msg.Content, content) || len(msg.Encode()) != msg.FrameSize() {
+		t.Errorf("checksum not stripped")
+	}
+
+	msg.AddChecksum()
+	msg.Content[0] ^= 0xff
+	if err := msg.VerifyChecksum(); err != multisocket.ErrBadChecksum {
+		t.Errorf("verify corrupt checksum error: %v", err)
+	}
+}
+
+func TestSocketChecksum(t *testing.T) {
+	corrupt := new(int32)
+	transport.RegisterTransport(flakyTran{tcp.Transport, corrupt})
+
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	if err := srvsock.Listen("tcp://127.0.0.1:23954"); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	clisock := multisocket.New(options.OptionValues{multisocket.Options.SendChecksum: true})
+	defer clisock.Close()
+	if err := clisock.Dial("flakytcp://127.0.0.1:23954"); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+
+	// corrupted in transit
+	atomic.StoreInt32(corrupt, 1)
+	if err := clisock.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if _, err := srvsock.RecvTimeout(100 * time.Millisecond); err != multisocket.ErrTimeout {
+		t.Errorf("corrupt message received: %v", err)
+	}
+	if n := srvsock.CorruptMsgs(); n != 1 {
+		t.Errorf("%d corrupt messages", n)
+	}
+
+	if err := clisock.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if content, err := srvsock.RecvTimeout(time.Second); err != nil || string(content) != "hello" {
+		t.Errorf("recv error: %v", err)
+	}
+}
@@ -93,3 +93,15 @@ func genRandomContent(sz int) (b []byte) {
 	rand.Read(b)
 	return
 }
+
+// waitUntil wait until cond is true or timeout
+func waitUntil(d time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(d)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
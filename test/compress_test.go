@@ -0,0 +1,188 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/message"
+	"github.com/multisocket/multisocket/options"
+)
+
+// reverseCompressor is a fake compressor which just reverses content.
+type reverseCompressor struct{}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+func (reverseCompressor) Compress(content []byte) ([]byte, error) {
+	return reverse(content), nil
+}
+
+func (reverseCompressor) Decompress(b []byte, maxLength uint32) ([]byte, error) {
+	if maxLength != 0 && len(b) > int(maxLength) {
+		return nil, multisocket.ErrContentTooLong
+	}
+	return reverse(b), nil
+}
+
+const (
+	compressionReverse      = uint8(200)
+	compressionUnregistered = uint8(201)
+)
+
+func TestMessageCompress(t *testing.T) {
+	message.RegisterCompressor(compressionReverse, reverseCompressor{})
+	defer message.RegisterCompressor(compressionReverse, nil)
+
+	for _, tc := range []struct {
+		name        string
+		compression uint8
+	}{
+		{"Gzip", message.CompressionGzip},
+		{"Registered", compressionReverse},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			content := bytes.Repeat([]byte("hello"), 100)
+			src := message.MsgPath{0, 0, 0, 1, 0, 0, 0, 2}
+			msg := message.NewSendMessage(0, message.SendTypeToOne, 0, src, nil, content)
+			defer msg.FreeAll()
+
+			if err := msg.Compress(tc.compression); err != nil {
+				t.Fatalf("compress error: %s", err)
+			}
+			if !msg.HasFlags(message.MsgFlagCompressed) || bytes.Equal(msg.Content, content) || int(msg.Length) != len(msg.Content) {
+				t.Errorf("content not compressed: %d", len(msg.Content))
+			}
+			if err := msg.Decompress(); err != nil {
+				t.Fatalf("decompress error: %s", err)
+			}
+			if msg.HasFlags(message.MsgFlagCompressed) || !bytes.Equal(msg.Content, content) || int(msg.Length) != len(content) {
+				t.Errorf("decompressed content mismatch")
+			}
+			if !bytes.Equal(msg.Source, src) {
+				t.Errorf("source lost: %v", msg.Source)
+			}
+		})
+	}
+
+	msg := message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, []byte("hello"))
+	defer msg.FreeAll()
+	if err := msg.Compress(compressionUnregistered); err != multisocket.ErrBadCompression {
+		t.Errorf("compress by unregistered compressor error: %v", err)
+	}
+}
+
+func TestSocketCompression(t *testing.T) {
+	message.RegisterCompressor(compressionReverse, reverseCompressor{})
+	defer message.RegisterCompressor(compressionReverse, nil)
+
+	for _, tc := range []struct {
+		name        string
+		compression uint8
+	}{
+		{"Gzip", message.CompressionGzip},
+		{"Registered", compressionReverse},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srvsock, clisock, err := prepareSocks("tcp://127.0.0.1:23953")
+			if err != nil {
+				t.Fatalf("connect error: %s", err)
+			}
+			defer srvsock.Close()
+			defer clisock.Close()
+			clisock.SetOption(multisocket.Options.Compression, tc.compression)
+
+			content := bytes.Repeat([]byte("hello"), 1000)
+			if err = clisock.Send(content); err != nil {
+				t.Fatalf("send error: %s", err)
+			}
+			msg, err := srvsock.RecvMsgTimeout(time.Second)
+			if err != nil {
+				t.Fatalf("recv error: %s", err)
+			}
+			if msg.HasFlags(message.MsgFlagCompressed) || !bytes.Equal(msg.Content, content) {
+				t.Errorf("content mismatch")
+			}
+
+			// reply compressed by server
+			srvsock.SetOption(multisocket.Options.Compression, tc.compression)
+			if err = srvsock.SendTo(msg.Source, content); err != nil {
+				t.Fatalf("reply error: %s", err)
+			}
+			msg.FreeAll()
+			if reply, err := clisock.RecvTimeout(time.Second); err != nil || !bytes.Equal(reply, content) {
+				t.Errorf("recv reply error: %v", err)
+			}
+		})
+	}
+
+	t.Run("Mismatch", func(t *testing.T) {
+		srvsock, clisock, err := prepareSocks("tcp://127.0.0.1:23953")
+		if err != nil {
+			t.Fatalf("connect error: %s", err)
+		}
+		defer srvsock.Close()
+		defer clisock.Close()
+		clisock.SetOption(multisocket.Options.Compression, compressionReverse)
+
+		if err = clisock.Send([]byte("hello")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		if !waitUntil(time.Second, func() bool {
+			pipes := srvsock.Connector().Pipes()
+			return len(pipes) == 1 && pipes[0].MsgsRecv() == 1
+		}) {
+			t.Fatalf("message not received")
+		}
+		// receiver has no such compressor
+		message.RegisterCompressor(compressionReverse, nil)
+		if _, err = srvsock.RecvMsgTimeout(time.Second); err != multisocket.ErrBadCompression {
+			t.Errorf("recv error: %v", err)
+		}
+
+		// still works for following messages
+		clisock.SetOption(multisocket.Options.Compression, message.CompressionNone)
+		if err = clisock.Send([]byte("hello")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		if content, err := srvsock.RecvTimeout(time.Second); err != nil || string(content) != "hello" {
+			t.Errorf("recv error: %v", err)
+		}
+	})
+}
+
+func TestSocketDecompressTooLong(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("tcp://127.0.0.1:24003", options.OptionValues{
+		connector.Options.Pipe.MaxRecvContentLength: uint32(4096),
+	})
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+	clisock.SetOption(multisocket.Options.Compression, message.CompressionGzip)
+
+	// compressed is far below the limit
+	if err = clisock.Send(make([]byte, 1024*1024)); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if _, err = srvsock.RecvMsgTimeout(time.Second); err != multisocket.ErrContentTooLong {
+		t.Errorf("recv error: %v, expected: %v", err, multisocket.ErrContentTooLong)
+	}
+
+	content := bytes.Repeat([]byte("hello"), 200)
+	if err = clisock.Send(content); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if recvd, err := srvsock.RecvTimeout(time.Second); err != nil || !bytes.Equal(recvd, content) {
+		t.Errorf("recv error: %v", err)
+	}
+}
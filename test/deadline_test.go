@@ -0,0 +1,112 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/message"
+	"github.com/multisocket/multisocket/options"
+)
+
+func TestMessageDeadline(t *testing.T) {
+	content := []byte("hello")
+	msg := message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, content)
+	defer msg.FreeAll()
+
+	deadline := time.Now().Add(time.Minute)
+	msg.SetDeadline(deadline)
+	msg.AddDeadline()
+	if len(msg.Content) != len(content)+message.DeadlineSize {
+		t.Fatalf("deadline not added")
+	}
+	msg.SetDeadline(time.Time{})
+	if err := msg.StripDeadline(); err != nil {
+		t.Fatalf("strip deadline error: %s", err)
+	}
+	if !bytes.Equal(msg.Content, content) || len(msg.Encode()) != msg.FrameSize() {
+		t.Errorf("deadline not stripped")
+	}
+	if !msg.Deadline().Equal(deadline) {
+		t.Errorf("deadline %s, expected: %s", msg.Deadline(), deadline)
+	}
+
+	now := time.Now()
+	msg.SetDeadline(now.Add(-time.Second))
+	if !msg.Expired(now, 0) {
+		t.Errorf("not expired")
+	}
+	if msg.Expired(now, 2*time.Second) {
+		t.Errorf("expired within leeway")
+	}
+	msg.SetDeadline(time.Time{})
+	if msg.Expired(now, 0) {
+		t.Errorf("expired without deadline")
+	}
+}
+
+func TestSocketMsgDeadline(t *testing.T) {
+	addr := "tcp://127.0.0.1:23983"
+	ovs := options.OptionValues{multisocket.Options.MsgDeadline: true}
+	srvsock := multisocket.New(ovs)
+	defer srvsock.Close()
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	clisock := multisocket.New(ovs)
+	defer clisock.Close()
+	if err := clisock.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+
+	send := func(content string, deadline time.Time) {
+		msg := message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, []byte(content))
+		msg.SetDeadline(deadline)
+		if err := clisock.SendMsg(msg); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+
+	t.Run("Expired", func(t *testing.T) {
+		send("expired", time.Now().Add(-time.Minute))
+		// within leeway
+		send("late", time.Now().Add(-10*time.Millisecond))
+		send("none", time.Time{})
+
+		for _, expected := range []string{"late", "none"} {
+			msg, err := srvsock.RecvMsgTimeout(time.Second)
+			if err != nil {
+				t.Fatalf("recv error: %s", err)
+			}
+			if string(msg.Content) != expected {
+				t.Errorf("recv %s, expected: %s", msg.Content, expected)
+			}
+			msg.FreeAll()
+		}
+		if _, err := srvsock.RecvTimeout(100 * time.Millisecond); err != multisocket.ErrTimeout {
+			t.Errorf("expired message received: %v", err)
+		}
+	})
+
+	t.Run("SendDeadline", func(t *testing.T) {
+		clisock.SetOption(multisocket.Options.SendDeadline, time.Minute)
+		defer clisock.SetOption(multisocket.Options.SendDeadline, time.Duration(0))
+
+		before := time.Now()
+		if err := clisock.Send([]byte("hello")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		msg, err := srvsock.RecvMsgTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+		defer msg.FreeAll()
+		if string(msg.Content) != "hello" {
+			t.Errorf("recv %s, expected: hello", msg.Content)
+		}
+		if d := msg.Deadline().Sub(before); d < time.Minute || d > time.Minute+time.Second {
+			t.Errorf("deadline %s after send", d)
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package test
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+)
+
+// blackholeListen listen with a full backlog, so that new connections' SYNs are dropped.
+func blackholeListen(t *testing.T, port int) (closeFn func()) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatalf("socket error: %s", err)
+	}
+	closeFn = func() { syscall.Close(fd) }
+	if err = syscall.Bind(fd, &syscall.SockaddrInet4{Port: port, Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		closeFn()
+		t.Fatalf("bind error: %s", err)
+	}
+	if err = syscall.Listen(fd, 0); err != nil {
+		closeFn()
+		t.Fatalf("listen error: %s", err)
+	}
+	// fill the backlog
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		closeFn()
+		t.Fatalf("dial error: %s", err)
+	}
+	closeFn = func() {
+		conn.Close()
+		syscall.Close(fd)
+	}
+	return
+}
+
+func TestSocketDialTimeout(t *testing.T) {
+	closeFn := blackholeListen(t, 23974)
+	defer closeFn()
+
+	sock := multisocket.New(nil)
+	defer sock.Close()
+	start := time.Now()
+	if err := sock.DialTimeout("tcp://127.0.0.1:23974", 100*time.Millisecond); err != multisocket.ErrTimeout {
+		t.Errorf("dial error: %v", err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond || d > time.Second {
+		t.Errorf("dial returned after %s", d)
+	}
+	if n := len(sock.Connector().Pipes()); n != 0 {
+		t.Errorf("%d pipes connected", n)
+	}
+}
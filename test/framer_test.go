@@ -0,0 +1,181 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/options"
+)
+
+func TestFramerReadWrite(t *testing.T) {
+	framers := []struct {
+		name   string
+		framer connector.Framer
+	}{
+		{"Line", connector.LineFramer},
+		{"LengthPrefixed", connector.NewLengthPrefixedFramer(0)},
+	}
+	for idx := range framers {
+		f := framers[idx]
+		t.Run(f.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			// longer than the initial line buffer
+			frames := []string{"hello", "", strings.Repeat("world", 100)}
+			for _, frame := range frames {
+				if err := f.framer.WriteFrame(buf, []byte(frame)); err != nil {
+					t.Fatalf("write frame error: %s", err)
+				}
+			}
+			for _, frame := range frames {
+				b, err := f.framer.ReadFrame(buf)
+				if err != nil {
+					t.Fatalf("read frame error: %s", err)
+				}
+				if string(b) != frame {
+					t.Errorf("read frame %q != %q", b, frame)
+				}
+			}
+			if _, err := f.framer.ReadFrame(buf); err != io.EOF {
+				t.Errorf("read frame at end error: %v", err)
+			}
+		})
+	}
+
+	if _, err := connector.NewLineFramer(4).ReadFrame(bytes.NewBufferString("hello\n")); err != errs.ErrContentTooLong {
+		t.Errorf("read too long line error: %v", err)
+	}
+	if _, err := connector.NewLengthPrefixedFramer(4).ReadFrame(bytes.NewBuffer([]byte{0, 0, 0, 5})); err != errs.ErrContentTooLong {
+		t.Errorf("read too long frame error: %v", err)
+	}
+	// content length 5
+	if _, err := connector.NewNativeFramer(4).ReadFrame(bytes.NewBuffer([]byte{0, 16, 0, 0, 0, 0, 0, 5})); err != errs.ErrContentTooLong {
+		t.Errorf("read too long native frame error: %v", err)
+	}
+}
+
+// recvRawConnected recv the empty message of a new raw connection.
+func recvRawConnected(t *testing.T, sock multisocket.Socket) {
+	msg, err := sock.RecvMsg()
+	if err != nil {
+		t.Fatalf("recv error: %s", err)
+	}
+	if len(msg.Content) != 0 {
+		t.Errorf("recv %q on connected", msg.Content)
+	}
+	msg.FreeAll()
+}
+
+func TestFramerLineService(t *testing.T) {
+	sock := multisocket.New(nil)
+	defer sock.Close()
+	ovs := options.OptionValues{
+		connector.Options.Pipe.Raw:    true,
+		connector.Options.Pipe.Framer: connector.LineFramer,
+	}
+	if err := sock.ListenOptions("tcp://127.0.0.1:23941", ovs); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	conn, err := net.Dial("tcp", "127.0.0.1:23941")
+	if err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	defer conn.Close()
+	recvRawConnected(t, sock)
+	if _, err = conn.Write([]byte("hello\r\nworld\n")); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	for _, line := range []string{"hello", "world"} {
+		msg, err := sock.RecvMsg()
+		if err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+		if string(msg.Content) != line {
+			t.Errorf("recv %q != %q", msg.Content, line)
+		}
+		if err = sock.SendTo(msg.Source, append([]byte("echo "), msg.Content...)); err != nil {
+			t.Errorf("send error: %s", err)
+		}
+		msg.FreeAll()
+	}
+
+	r := bufio.NewReader(conn)
+	for _, line := range []string{"echo hello\n", "echo world\n"} {
+		if s, err := r.ReadString('\n'); err != nil || s != line {
+			t.Errorf("read %q, %v", s, err)
+		}
+	}
+}
+
+func TestFramerLengthPrefixedService(t *testing.T) {
+	sock := multisocket.New(nil)
+	defer sock.Close()
+	ovs := options.OptionValues{
+		connector.Options.Pipe.Raw:    true,
+		connector.Options.Pipe.Framer: connector.NewLengthPrefixedFramer(1024),
+	}
+	if err := sock.ListenOptions("tcp://127.0.0.1:23942", ovs); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	conn, err := net.Dial("tcp", "127.0.0.1:23942")
+	if err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	defer conn.Close()
+	recvRawConnected(t, sock)
+	if _, err = conn.Write([]byte{0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'}); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	msg, err := sock.RecvMsg()
+	if err != nil {
+		t.Fatalf("recv error: %s", err)
+	}
+	if string(msg.Content) != "hello" {
+		t.Errorf("recv %q", msg.Content)
+	}
+	if err = sock.SendTo(msg.Source, []byte("world")); err != nil {
+		t.Errorf("send error: %s", err)
+	}
+	msg.FreeAll()
+
+	b := make([]byte, 9)
+	if _, err = io.ReadFull(conn, b); err != nil {
+		t.Fatalf("read error: %s", err)
+	}
+	if binary.BigEndian.Uint32(b) != 5 || string(b[4:]) != "world" {
+		t.Errorf("read frame %v", b)
+	}
+}
+
+func TestFramerNative(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("tcp://127.0.0.1:23943", options.OptionValues{connector.Options.Pipe.Framer: connector.NativeFramer})
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	if err = clisock.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	msg, err := srvsock.RecvMsg()
+	if err != nil {
+		t.Fatalf("recv error: %s", err)
+	}
+	if err = srvsock.SendTo(msg.Source, []byte("world")); err != nil {
+		t.Errorf("send error: %s", err)
+	}
+	msg.FreeAll()
+	if msg, err = clisock.RecvMsg(); err != nil || string(msg.Content) != "world" {
+		t.Errorf("recv reply error: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport"
+	"github.com/multisocket/multisocket/transport/inproc"
+	"github.com/multisocket/multisocket/transport/inproc/netpipe"
+)
+
+func TestInprocAcceptQueueSize(t *testing.T) {
+	const dialers = 16
+	addr := "inproc.netpipe://accept_queue_test"
+	opts := options.NewOptionsWithValues(options.OptionValues{inproc.Options.AcceptQueueSize: dialers})
+
+	l, err := netpipe.Transport.NewListener(addr)
+	if err != nil {
+		t.Fatalf("new listener error: %s", err)
+	}
+	defer l.Close()
+	if err := l.Listen(opts); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		conns = make(chan transport.Connection, dialers)
+	)
+	for i := 0; i < dialers; i++ {
+		d, err := netpipe.Transport.NewDialer(addr)
+		if err != nil {
+			t.Fatalf("new dialer error: %s", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := d.Dial(opts)
+			if err != nil {
+				t.Errorf("dial error: %s", err)
+				return
+			}
+			conns <- conn
+		}()
+	}
+
+	// let all dialers queue up before accepting
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < dialers; i++ {
+		conn, err := l.Accept(opts)
+		if err != nil {
+			t.Fatalf("accept error: %s", err)
+		}
+		defer conn.Close()
+	}
+	wg.Wait()
+	close(conns)
+	n := 0
+	for conn := range conns {
+		conn.Close()
+		n++
+	}
+	if n != dialers {
+		t.Errorf("connected %d != %d", n, dialers)
+	}
+
+	// must be positive
+	xl, err := netpipe.Transport.NewListener("inproc.netpipe://accept_queue_test_invalid")
+	if err != nil {
+		t.Fatalf("new listener error: %s", err)
+	}
+	defer xl.Close()
+	if err := xl.Listen(options.NewOptionsWithValues(options.OptionValues{inproc.Options.AcceptQueueSize: 0})); err != options.ErrInvalidOptionValue {
+		t.Errorf("listen with invalid accept queue size error: %v", err)
+	}
+}
+
+func TestInprocDialRefused(t *testing.T) {
+	addr := "inproc.netpipe://dial_refused_test"
+	d, err := netpipe.Transport.NewDialer(addr)
+	if err != nil {
+		t.Fatalf("new dialer error: %s", err)
+	}
+	opts := options.NewOptions()
+
+	if _, err := d.Dial(opts); err != inproc.ErrNoListener {
+		t.Errorf("dial without listener error: %v", err)
+	}
+
+	l, err := netpipe.Transport.NewListener(addr)
+	if err != nil {
+		t.Fatalf("new listener error: %s", err)
+	}
+	if err := l.Listen(opts); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	// never accepted, until listener is closed
+	errq := make(chan error, 1)
+	go func() {
+		_, err := d.Dial(opts)
+		errq <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	l.Close()
+	select {
+	case err := <-errq:
+		if err != inproc.ErrListenerClosing {
+			t.Errorf("dial closing listener error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("dial not refused")
+	}
+
+	if _, err := d.Dial(opts); err != inproc.ErrNoListener {
+		t.Errorf("dial closed listener error: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+// +build !windows,!nacl,!plan9
+
+package test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport/ipc"
+)
+
+func TestIPCGramMessageBoundaries(t *testing.T) {
+	addr := "ipcgram://" + filepath.Join(os.TempDir(), "multisocket_ipcgram_test.sock")
+	srvsock, clisock, err := prepareSocks(addr)
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	contents := [][]byte{[]byte("a"), genRandomContent(1000), []byte("bc"), genRandomContent(32 * 1024), []byte("d")}
+	for _, content := range contents {
+		if err = clisock.Send(content); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+	for i, content := range contents {
+		msg, err := srvsock.RecvMsgTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("recv %d error: %s", i, err)
+		}
+		if string(msg.Content) != string(content) {
+			t.Errorf("recv %d: %d bytes, expected %d bytes", i, len(msg.Content), len(content))
+		}
+		// reply
+		if err = srvsock.SendTo(msg.Source, msg.Content); err != nil {
+			t.Errorf("reply error: %s", err)
+		}
+		msg.FreeAll()
+	}
+	for i, content := range contents {
+		if reply, err := clisock.RecvTimeout(time.Second); err != nil || string(reply) != string(content) {
+			t.Errorf("recv reply %d error: %v, %d bytes, expected %d bytes", i, err, len(reply), len(content))
+		}
+	}
+}
+
+func TestIPCGramBestEffort(t *testing.T) {
+	// a peer never reads
+	path := filepath.Join(os.TempDir(), "multisocket_ipcgram_besteffort_test.sock")
+	os.Remove(path)
+	uc, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	defer uc.Close()
+
+	d, err := ipc.GramTransport.NewDialer("ipcgram://" + path)
+	if err != nil {
+		t.Fatalf("new dialer error: %s", err)
+	}
+	conn, err := d.Dial(options.NewOptionsWithValues(options.OptionValues{ipc.Options.Gram.BestEffort: true}))
+	if err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	defer conn.Close()
+	sender := conn.RawConn().(interface{ Send([]byte) error })
+
+	// far more than socket buffers, datagrams are dropped once they're full
+	done := make(chan error, 1)
+	go func() {
+		content := genRandomContent(8 * 1024)
+		for i := 0; i < 1000; i++ {
+			if err := sender.Send(content); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("send error: %s", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("send blocked")
+	}
+}
+
+func TestIPCGramFragment(t *testing.T) {
+	addr := "ipcgram://" + filepath.Join(os.TempDir(), "multisocket_ipcgram_fragment_test.sock")
+	srvsock, clisock, err := prepareSocks(addr, options.OptionValues{ipc.Options.Gram.Fragment: true})
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	// larger than a datagram
+	content := genRandomContent(100 * 1024)
+	if err = clisock.Send(content); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	msg, err := srvsock.RecvMsgTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("recv error: %s", err)
+	}
+	if string(msg.Content) != string(content) {
+		t.Errorf("recv %d bytes, expected %d bytes", len(msg.Content), len(content))
+	}
+	msg.FreeAll()
+}
+
+func TestIPCGramMalformedDatagrams(t *testing.T) {
+	addr := "ipcgram://" + filepath.Join(os.TempDir(), "multisocket_ipcgram_malformed_test.sock")
+	srvsock, clisock, err := prepareSocks(addr)
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	// a peer sends datagrams as is
+	d, err := ipc.GramTransport.NewDialer(addr)
+	if err != nil {
+		t.Fatalf("new dialer error: %s", err)
+	}
+	conn, err := d.Dial(options.NewOptions())
+	if err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	defer conn.Close()
+
+	checkMalformedDatagrams(t, srvsock, clisock, conn.RawConn().(interface{ Send([]byte) error }).Send)
+}
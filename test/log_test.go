@@ -0,0 +1,117 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/log"
+)
+
+type logEntry struct {
+	level  log.Level
+	msg    string
+	fields log.Fields
+}
+
+// fakeLogger capture logs of enabled levels
+type fakeLogger struct {
+	sync.Mutex
+	level   log.Level
+	entries []logEntry
+}
+
+func (l *fakeLogger) IsLevelEnabled(level log.Level) bool {
+	return level <= l.level
+}
+
+func (l *fakeLogger) log(level log.Level, msg string, fields log.Fields) {
+	l.Lock()
+	l.entries = append(l.entries, logEntry{level, msg, fields})
+	l.Unlock()
+}
+
+func (l *fakeLogger) Debug(msg string, fields log.Fields) { l.log(log.DebugLevel, msg, fields) }
+func (l *fakeLogger) Info(msg string, fields log.Fields)  { l.log(log.InfoLevel, msg, fields) }
+func (l *fakeLogger) Warn(msg string, fields log.Fields)  { l.log(log.WarnLevel, msg, fields) }
+func (l *fakeLogger) Error(msg string, fields log.Fields) { l.log(log.ErrorLevel, msg, fields) }
+
+func (l *fakeLogger) find(level log.Level, msg string) (entry logEntry, ok bool) {
+	l.Lock()
+	defer l.Unlock()
+	for _, entry = range l.entries {
+		if entry.level == level && entry.msg == msg {
+			return entry, true
+		}
+	}
+	return
+}
+
+func TestSetLogger(t *testing.T) {
+	l := &fakeLogger{level: log.DebugLevel}
+	log.SetLogger(l)
+	defer log.SetLogger(nil)
+
+	sock := multisocket.New(nil)
+	defer sock.Close()
+	// nothing listening
+	addr := "tcp://127.0.0.1:23968"
+	if err := sock.Dial(addr); err == nil {
+		t.Fatalf("dial succeeded")
+	}
+
+	if entry, ok := l.find(log.DebugLevel, "dial"); !ok || entry.fields["addr"] != addr || entry.fields["action"] != "start" {
+		t.Errorf("dial start log: %+v", entry)
+	}
+	if entry, ok := l.find(log.ErrorLevel, "dial"); !ok || entry.fields["action"] != "failed" || entry.fields[log.ErrorKey] == nil {
+		t.Errorf("dial failed log: %+v", entry)
+	}
+
+	// disabled levels are skipped
+	l = &fakeLogger{level: log.InfoLevel}
+	log.SetLogger(l)
+	if err := sock.Dial(addr); err == nil {
+		t.Fatalf("dial succeeded")
+	}
+	l.Lock()
+	defer l.Unlock()
+	if len(l.entries) != 0 {
+		t.Errorf("logged at disabled level: %+v", l.entries)
+	}
+}
+
+func TestConnectQuietStdout(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe error: %s", err)
+	}
+	defer r.Close()
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	// connect and disconnect
+	srvsock, clisock, err := prepareSocks("tcp://127.0.0.1:23969")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	if !waitUntil(time.Second, func() bool { return len(srvsock.Connector().Pipes()) == 1 }) {
+		t.Fatalf("pipe not connected")
+	}
+	clisock.Close()
+	if !waitUntil(time.Second, func() bool { return len(srvsock.Connector().Pipes()) == 0 }) {
+		t.Fatalf("pipe not removed")
+	}
+	srvsock.Close()
+
+	os.Stdout = stdout
+	w.Close()
+	if out, _ := ioutil.ReadAll(r); len(out) != 0 {
+		t.Errorf("stdout: %q", out)
+	}
+}
@@ -0,0 +1,314 @@
+package test
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/message"
+)
+
+func TestMessageSetSendType(t *testing.T) {
+	msg := message.NewSendMessage(message.MsgFlagControl, message.SendTypeToOne, 0, nil, nil, []byte("hello"))
+	defer msg.FreeAll()
+
+	if msg.SendType() != message.SendTypeToOne {
+		t.Errorf("send type %d != %d", msg.SendType(), message.SendTypeToOne)
+	}
+	for _, sendType := range []uint8{message.SendTypeToAll, message.SendTypeToDest, message.SendTypeToOne} {
+		if err := msg.SetSendType(sendType); err != nil {
+			t.Errorf("set send type %d error: %s", sendType, err)
+		}
+		if msg.SendType() != sendType {
+			t.Errorf("send type %d != %d", msg.SendType(), sendType)
+		}
+		if !msg.HasFlags(message.MsgFlagControl) {
+			t.Errorf("flags lost after set send type %d", sendType)
+		}
+	}
+
+	if err := msg.SetSendType(message.SendTypeToDest + 1); err != errs.ErrInvalidSendType {
+		t.Errorf("set invalid send type error: %v", err)
+	}
+	if msg.SendType() != message.SendTypeToOne {
+		t.Errorf("send type changed by invalid value: %d", msg.SendType())
+	}
+
+	// re-encoding reflects the change
+	if err := msg.SetSendType(message.SendTypeToAll); err != nil {
+		t.Errorf("set send type error: %s", err)
+	}
+	recvMsg, err := message.NewMessageFromBytes(1, msg.Encode(), 0)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	defer recvMsg.FreeAll()
+	if recvMsg.SendType() != message.SendTypeToAll {
+		t.Errorf("decoded send type %d != %d", recvMsg.SendType(), message.SendTypeToAll)
+	}
+	if string(recvMsg.Content) != "hello" {
+		t.Errorf("decoded content %q", recvMsg.Content)
+	}
+}
+
+func TestMessageInvalidSendType(t *testing.T) {
+	for _, sendType := range []uint8{message.SendTypeToDest + 1, 1 << 2, 0xff} {
+		msg := message.NewSendMessage(message.MsgFlagControl, sendType, 0, nil, nil, []byte("hello"))
+		if err := msg.Validate(); err != errs.ErrInvalidSendType {
+			t.Errorf("validate send type %d error: %v", sendType, err)
+		}
+		if msg.HasFlags(message.MsgFlagInternal) || !msg.HasFlags(message.MsgFlagControl) {
+			t.Errorf("flags corrupted by send type %d: %x", sendType, msg.Flags)
+		}
+		msg.FreeAll()
+	}
+}
+
+func TestMessageFreeAllBatch(t *testing.T) {
+	msgs := make([]*message.Message, 0, 8)
+	for i := 0; i < 4; i++ {
+		msgs = append(msgs, message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, []byte("hello")), nil)
+	}
+	message.FreeAllMsgs(msgs...)
+	for i, msg := range msgs {
+		if msg == nil {
+			continue
+		}
+		if msg.Content != nil || msg.Source != nil || msg.Destination != nil || msg.Length != 0 {
+			t.Errorf("message %d not zeroed", i)
+		}
+	}
+	// empty batch
+	message.FreeAllMsgs()
+}
+
+func TestMessageDupSharesBuf(t *testing.T) {
+	content := []byte("hello")
+	msg := message.NewSendMessage(0, message.SendTypeToAll, 0, nil, nil, content)
+	dup := msg.Dup()
+	if &dup.Content[0] != &msg.Content[0] {
+		t.Errorf("dup does not share content")
+	}
+
+	msg.FreeAll()
+	// msg freed twice
+	msg.FreeAll()
+	if !bytes.Equal(dup.Content, content) {
+		t.Errorf("dup content %q != %q", dup.Content, content)
+	}
+
+	// modified meta copy on write
+	other := dup.Dup()
+	other.TTL--
+	other.Encode()
+	if &other.Content[0] == &dup.Content[0] {
+		t.Errorf("modified dup still shares content")
+	}
+	if got := message.DecodeMeta(dup.Encode()); got != dup.Meta {
+		t.Errorf("shared meta changed: %+v != %+v", got, dup.Meta)
+	}
+	other.FreeAll()
+	dup.FreeAll()
+
+	a := message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, nil)
+	b := message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, nil)
+	if a == b {
+		t.Errorf("double freed message reused")
+	}
+	a.FreeAll()
+	b.FreeAll()
+}
+
+func TestMessageDupRightSized(t *testing.T) {
+	// pooled buf's capacity is rounded up to its size class
+	msg := message.NewSendMessage(0, message.SendTypeToAll, 0, nil, nil, []byte("hello"))
+	defer msg.FreeAll()
+	check := func(name string, m *message.Message) {
+		if n := len(m.Encode()); n != msg.FrameSize() {
+			t.Errorf("%s frame %d bytes, expected: %d", name, n, msg.FrameSize())
+		}
+		if cap(m.Content) != len(m.Content) {
+			t.Errorf("%s content cap %d, len %d", name, cap(m.Content), len(m.Content))
+		}
+	}
+
+	dup := msg.Dup()
+	defer dup.FreeAll()
+	check("dup", dup)
+	// appending never writes into the shared buf
+	_ = append(dup.Content, '!')
+	if string(msg.Content) != "hello" || len(msg.Encode()) != msg.FrameSize() {
+		t.Errorf("shared buf modified: %q", msg.Content)
+	}
+
+	// copy on write
+	unshared := msg.Dup()
+	defer unshared.FreeAll()
+	unshared.TTL--
+	check("unshared", unshared)
+
+	cp := msg.Copy()
+	defer cp.FreeAll()
+	check("copy", cp)
+}
+
+func TestMessageConcurrentDupFree(t *testing.T) {
+	var (
+		content = []byte("hello world")
+		wg      sync.WaitGroup
+	)
+	msg := message.NewSendMessage(0, message.SendTypeToAll, 0, nil, nil, content)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(dup *message.Message) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				d := dup.Dup()
+				if !bytes.Equal(d.Encode()[message.MetaSize:], content) {
+					t.Errorf("dup content mismatch")
+				}
+				d.FreeAll()
+			}
+			dup.FreeAll()
+		}(msg.Dup())
+	}
+	msg.FreeAll()
+	wg.Wait()
+}
+
+type closeTrackingReader struct {
+	*bytes.Reader
+	closed bool
+}
+
+func (r *closeTrackingReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestMessageFromReaderFraming(t *testing.T) {
+	msg := message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, []byte("hello"))
+	frame := append([]byte(nil), msg.Encode()...)
+	msg.SetSendType(message.SendTypeToDest)
+	// to dest without previous node's pipe id
+	garbage := append([]byte(nil), msg.Encode()...)
+	msg.FreeAll()
+
+	cases := []struct {
+		name string
+		b    []byte
+		err  error
+	}{
+		{"Complete", frame, nil},
+		{"EOF", nil, io.EOF},
+		{"TruncatedHeader", frame[:message.MetaSize-3], errs.ErrBadMsgFraming},
+		{"TruncatedContent", frame[:len(frame)-2], errs.ErrBadMsgFraming},
+		{"Garbage", garbage, errs.ErrBadMsgFraming},
+		{"HopsOverflow", overflowFrame(), errs.ErrBadMsgFraming},
+	}
+	for idx := range cases {
+		c := cases[idx]
+		t.Run(c.name, func(t *testing.T) {
+			r := &closeTrackingReader{Reader: bytes.NewReader(c.b)}
+			msg, err := message.NewMessageFromReader(1, r, make([]byte, message.MetaSize), 0)
+			if err != c.err {
+				t.Fatalf("recv error: %v, expected: %v", err, c.err)
+			}
+			if err != nil {
+				if msg != nil {
+					t.Errorf("message returned with error")
+				}
+				if r.closed != (err == errs.ErrBadMsgFraming) {
+					t.Errorf("reader closed: %v", r.closed)
+				}
+				return
+			}
+			defer msg.FreeAll()
+			if string(msg.Content) != "hello" {
+				t.Errorf("recv %q, expected: hello", msg.Content)
+			}
+		})
+	}
+}
+
+// overflowFrame encode a frame whose Hops would overflow when received.
+func overflowFrame() []byte {
+	frame := make([]byte, message.MetaSize+4*0xff)
+	frame[0] = message.SendTypeToOne
+	frame[1] = message.DefaultMsgTTL
+	frame[2] = 0xff
+	return frame
+}
+
+func TestMessageMalformedMeta(t *testing.T) {
+	cases := []struct {
+		name string
+		meta message.Meta
+	}{
+		{"ToDestNoDistance", message.Meta{Flags: message.SendTypeToDest, TTL: message.DefaultMsgTTL}},
+		{"HopsOverflow", message.Meta{Flags: message.SendTypeToOne, TTL: message.DefaultMsgTTL, Hops: 0xff}},
+	}
+	for idx := range cases {
+		c := cases[idx]
+		t.Run(c.name, func(t *testing.T) {
+			src := message.NewSendMessage(0, message.SendTypeToOne, 0, make([]byte, 4*int(c.meta.Hops)), nil, nil)
+			defer src.FreeAll()
+			src.Meta = c.meta
+			frame := src.Encode()
+
+			if msg, err := message.NewMessageFromBytes(1, frame, 0); err != errs.ErrBadMsgFraming || msg != nil {
+				t.Errorf("from bytes error: %v", err)
+			}
+			if msg, err := message.NewMessageFromMsg(1, src, 0); err != errs.ErrBadMsgFraming || msg != nil {
+				t.Errorf("from msg error: %v", err)
+			}
+			r := &closeTrackingReader{Reader: bytes.NewReader(frame)}
+			if msg, err := message.NewMessageFromReader(1, r, make([]byte, message.MetaSize), 0); err != errs.ErrBadMsgFraming || msg != nil {
+				t.Errorf("from reader error: %v", err)
+			}
+			if !r.closed {
+				t.Errorf("reader not closed")
+			}
+		})
+	}
+}
+
+func TestMessageFromMsgDestination(t *testing.T) {
+	// the first destination id is the sender's pipe, the rest are forwarded
+	src := message.NewSendMessage(0, message.SendTypeToDest, 0, nil, message.MsgPath{0, 0, 0, 1, 0, 0, 0, 2}, []byte("hello"))
+	defer src.FreeAll()
+	msg, err := message.NewMessageFromMsg(3, src, 0)
+	if err != nil {
+		t.Fatalf("from msg error: %s", err)
+	}
+	defer msg.FreeAll()
+	if msg.Destination.String() != "2" || msg.Source.String() != "3" || string(msg.Content) != "hello" {
+		t.Errorf("destination: %s, source: %s, content: %q", msg.Destination, msg.Source, msg.Content)
+	}
+}
+
+func TestMsgPathHelpers(t *testing.T) {
+	path := message.MsgPath{0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 1, 0}
+	ids := path.IDs()
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != 256 {
+		t.Errorf("ids: %v", ids)
+	}
+	if s := path.String(); s != "1->2->256" {
+		t.Errorf("string: %s, expected: 1->2->256", s)
+	}
+	for _, id := range []uint32{1, 2, 256} {
+		if !path.Contains(id) {
+			t.Errorf("not contains: %d", id)
+		}
+	}
+	if path.Contains(3) {
+		t.Errorf("contains: 3")
+	}
+
+	var empty message.MsgPath
+	if len(empty.IDs()) != 0 || empty.String() != "" || empty.Contains(0) {
+		t.Errorf("empty path: %v", empty.IDs())
+	}
+}
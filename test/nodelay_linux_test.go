@@ -0,0 +1,75 @@
+package test
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/transport/tcp"
+)
+
+// pipeNoDelay get TCP_NODELAY of pipe's connection.
+func pipeNoDelay(t *testing.T, p connector.Pipe) bool {
+	rc, err := p.RawConn().(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("syscall conn error: %s", err)
+	}
+	var val int
+	if cerr := rc.Control(func(fd uintptr) {
+		val, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	}); cerr != nil {
+		t.Fatalf("control error: %s", cerr)
+	}
+	if err != nil {
+		t.Fatalf("get TCP_NODELAY error: %s", err)
+	}
+	return val != 0
+}
+
+func TestSocketTCPNoDelayDefault(t *testing.T) {
+	addr := "tcp://127.0.0.1:23996"
+	srvsock := multisocket.NewWith(multisocket.WithOption(tcp.Options.NoDelay, false))
+	defer srvsock.Close()
+	pipes := make(chan connector.Pipe, 2)
+	srvsock.OnPipeEvent(func(e connector.PipeEvent, p connector.Pipe) {
+		if e == connector.PipeEventAdd {
+			pipes <- p
+		}
+	})
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	var clisocks []multisocket.Socket
+	defer func() {
+		for _, clisock := range clisocks {
+			clisock.Close()
+		}
+	}()
+	accept := func() connector.Pipe {
+		clisock := multisocket.New(nil)
+		clisocks = append(clisocks, clisock)
+		if err := clisock.Dial(addr); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		select {
+		case p := <-pipes:
+			return p
+		case <-time.After(time.Second):
+			t.Fatalf("pipe not accepted")
+		}
+		return nil
+	}
+
+	if pipeNoDelay(t, accept()) {
+		t.Errorf("NoDelay enabled, expected disabled by socket default")
+	}
+
+	srvsock.SetOption(tcp.Options.NoDelay, true)
+	if !pipeNoDelay(t, accept()) {
+		t.Errorf("NoDelay disabled, expected enabled by socket default")
+	}
+}
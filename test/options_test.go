@@ -0,0 +1,225 @@
+package test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/address"
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/options"
+)
+
+func TestOptionsParse(t *testing.T) {
+	cases := []struct {
+		name string
+		s    string
+		opt  options.Option
+		val  interface{}
+	}{
+		{"Bool", "true", connector.Options.Dialer.DialAsync, true},
+		{"BoolEmpty", "", connector.Options.Dialer.DialAsync, true},
+		{"Int", "-1", connector.Options.PipeLimit, -1},
+		{"Uint8", "8", multisocket.Options.SendTTL, uint8(8)},
+		{"Uint16", "128", multisocket.Options.SendQueueSize, uint16(128)},
+		{"Uint32", "1024", connector.Options.Pipe.MaxRecvContentLength, uint32(1024)},
+		{"TimeDuration", "10ms", connector.Options.Dialer.MinReconnectTime, 10 * time.Millisecond},
+	}
+	for idx := range cases {
+		c := cases[idx]
+		t.Run(c.name, func(t *testing.T) {
+			val, err := c.opt.Parse(c.s)
+			if err != nil {
+				t.Fatalf("parse error: %s", err)
+			}
+			if val != c.val {
+				t.Errorf("parse %q => %#v != %#v", c.s, val, c.val)
+			}
+			if _, err = c.opt.Validate(val); err != nil {
+				t.Errorf("validate error: %s", err)
+			}
+		})
+	}
+
+	malformed := []struct {
+		s   string
+		opt options.Option
+	}{
+		{"maybe", connector.Options.Dialer.DialAsync},
+		{"1.5", connector.Options.PipeLimit},
+		{"256", multisocket.Options.SendTTL},
+		{"-1", multisocket.Options.SendQueueSize},
+		{"4294967296", connector.Options.Pipe.MaxRecvContentLength},
+		{"10", connector.Options.Dialer.MinReconnectTime},
+	}
+	for _, c := range malformed {
+		if val, err := c.opt.Parse(c.s); err == nil {
+			t.Errorf("parse malformed %q => %#v", c.s, val)
+		}
+	}
+}
+
+func TestOptionsParseAddress(t *testing.T) {
+	sa, err := address.ParseMultiSocketAddress("tcp://127.0.0.1:9000?Connector.Dialer.DialAsync=true&connector.dialer.minreconnecttime=10ms&Socket.SendTTL=8#dial")
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+	if sa.ConnectType() != address.ConnDial || sa.Address() != "tcp://127.0.0.1:9000" {
+		t.Errorf("parse address %s %s", sa.ConnectType(), sa.Address())
+	}
+	expected := options.OptionValues{
+		connector.Options.Dialer.DialAsync:        true,
+		connector.Options.Dialer.MinReconnectTime: 10 * time.Millisecond,
+		multisocket.Options.SendTTL:               uint8(8),
+	}
+	ovs := sa.OptionValues()
+	if len(ovs) != len(expected) {
+		t.Errorf("parse %d option values", len(ovs))
+	}
+	for opt, val := range expected {
+		if ovs[opt] != val {
+			t.Errorf("option %s: %#v != %#v", opt, ovs[opt], val)
+		}
+	}
+
+	for _, s := range []string{
+		"tcp://127.0.0.1:9000?Connector.Dialer.DialAsync=maybe#dial",
+		"tcp://127.0.0.1:9000?Connector.Dialer.NoSuchOption=1#dial",
+		"tcp://127.0.0.1:9000?NoSuchDomain.DialAsync=1#dial",
+	} {
+		if _, err = address.ParseMultiSocketAddress(s); err == nil {
+			t.Errorf("parse %s succeeded", s)
+		}
+	}
+}
+
+func TestOptionsNames(t *testing.T) {
+	ovs := options.OptionValues{
+		connector.Options.PipeLimit:               1,
+		connector.Options.Dialer.MinReconnectTime: time.Second,
+		connector.Options.Pipe.Raw:                true,
+		multisocket.Options.SendTTL:               uint8(8),
+	}
+	names := map[string]interface{}{}
+	for opt, val := range ovs {
+		names[opt.String()] = val
+	}
+	if _, ok := names["Connector.Dialer.MinReconnectTime"]; !ok {
+		t.Errorf("option names: %v", names)
+	}
+
+	for name, val := range names {
+		opt, err := options.ParseOption(name)
+		if err != nil {
+			t.Fatalf("parse option %s error: %s", name, err)
+		}
+		if options.OptionName(opt) != name {
+			t.Errorf("option name %s != %s", options.OptionName(opt), name)
+		}
+		if ovs[opt] != val {
+			t.Errorf("option %s value %#v != %#v", name, ovs[opt], val)
+		}
+	}
+
+	if s := options.NewIntOption(3).String(); s != "<int:3>" {
+		t.Errorf("unregistered option name %s", s)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		opt      options.Option
+		accepted []interface{}
+		expected []interface{}
+		rejected []interface{}
+	}{
+		{
+			"Int64", options.NewInt64Option(0),
+			[]interface{}{int64(1 << 40), 3, int32(-4), uint32(5)},
+			[]interface{}{int64(1 << 40), int64(3), int64(-4), int64(5)},
+			[]interface{}{"1", 1.5, uint64(1)},
+		},
+		{
+			"Float64", options.NewFloat64Option(1.0),
+			[]interface{}{1.5, float32(0.5), 2},
+			[]interface{}{1.5, 0.5, float64(2)},
+			[]interface{}{"1.5", int64(1), math.NaN(), math.Inf(1)},
+		},
+		{
+			"String", options.NewStringOption(""),
+			[]interface{}{"chat.v1"},
+			[]interface{}{"chat.v1"},
+			[]interface{}{1, []byte("chat.v1")},
+		},
+	}
+	for idx := range cases {
+		c := cases[idx]
+		t.Run(c.name, func(t *testing.T) {
+			for i, val := range c.accepted {
+				newVal, err := c.opt.Validate(val)
+				if err != nil {
+					t.Errorf("validate %#v error: %s", val, err)
+				} else if newVal != c.expected[i] {
+					t.Errorf("validate %#v => %#v != %#v", val, newVal, c.expected[i])
+				}
+			}
+			for _, val := range c.rejected {
+				if newVal, err := c.opt.Validate(val); err != options.ErrInvalidOptionValue {
+					t.Errorf("validate %#v => %#v, %v", val, newVal, err)
+				}
+			}
+		})
+	}
+
+	if val, err := options.NewInt64Option(0).Parse("1099511627776"); err != nil || val != int64(1<<40) {
+		t.Errorf("parse int64 %#v, %v", val, err)
+	}
+	if val, err := options.NewFloat64Option(0).Parse("1.3"); err != nil || val != 1.3 {
+		t.Errorf("parse float64 %#v, %v", val, err)
+	}
+	for _, s := range []string{"x", "NaN", "Inf"} {
+		if _, err := options.NewFloat64Option(0).Parse(s); err == nil {
+			t.Errorf("parse float64 %q succeeded", s)
+		}
+	}
+}
+
+func TestOptionsTypedSetters(t *testing.T) {
+	sock := multisocket.New(nil)
+	defer sock.Close()
+
+	var changed []options.Option
+	sock.AddOptionChangeHook(func(opt options.Option, oldVal, newVal interface{}) error {
+		changed = append(changed, opt)
+		return nil
+	})
+	if err := sock.SetUint8(multisocket.Options.SendTTL, 8); err != nil {
+		t.Fatalf("set uint8 error: %s", err)
+	}
+	if err := sock.SetDuration(multisocket.Options.SendDeadline, time.Minute); err != nil {
+		t.Fatalf("set duration error: %s", err)
+	}
+	if ttl := multisocket.Options.SendTTL.ValueFrom(sock); ttl != 8 {
+		t.Errorf("send ttl %d, expected: 8", ttl)
+	}
+	if d := multisocket.Options.SendDeadline.ValueFrom(sock); d != time.Minute {
+		t.Errorf("send deadline %s, expected: %s", d, time.Minute)
+	}
+	if len(changed) != 2 || changed[0] != multisocket.Options.SendTTL || changed[1] != multisocket.Options.SendDeadline {
+		t.Errorf("option change hook calls: %v", changed)
+	}
+
+	fopt := options.NewFloat64Option(1.0)
+	opts := options.NewOptionsWithAccepts(fopt)
+	if err := opts.SetFloat64(fopt, 2.5); err != nil || fopt.ValueFrom(opts) != 2.5 {
+		t.Errorf("set float64 %v, %v", fopt.ValueFrom(opts), err)
+	}
+	if err := opts.SetFloat64(fopt, math.NaN()); err != options.ErrInvalidOptionValue {
+		t.Errorf("set float64 NaN error: %v", err)
+	}
+	if err := opts.SetBool(connector.Options.Dialer.DialAsync, true); err != options.ErrUnsupportedOption {
+		t.Errorf("set unsupported option error: %v", err)
+	}
+}
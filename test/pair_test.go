@@ -0,0 +1,72 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/protocol/pair"
+	_ "github.com/multisocket/multisocket/transport/inproc"
+)
+
+func TestPairSinglePipe(t *testing.T) {
+	addr := "inproc://pair_test"
+	srv := pair.New()
+	defer srv.Close()
+	if err := srv.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	cli := pair.New()
+	defer cli.Close()
+	if err := cli.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+
+	if err := cli.Send([]byte("hello")); err != nil {
+		t.Errorf("send error: %s", err)
+	}
+	if content, err := srv.Recv(); err != nil || string(content) != "hello" {
+		t.Errorf("recv error: %s, %q", err, content)
+	}
+	if err := srv.Send([]byte("world")); err != nil {
+		t.Errorf("send error: %s", err)
+	}
+	if content, err := cli.Recv(); err != nil || string(content) != "world" {
+		t.Errorf("recv error: %s, %q", err, content)
+	}
+
+	// second pipe must be closed immediately
+	events := make(chan connector.PipeEvent, 4)
+	ctr := connector.NewWithOptionValues(options.OptionValues{connector.Options.Dialer.Reconnect: false})
+	defer ctr.Close()
+	ctr.SetPipeEventHandler(func(e connector.PipeEvent, p connector.Pipe) {
+		if e == connector.PipeEventAdd {
+			// recv to detect peer closing
+			go p.RecvMsg()
+		}
+		events <- e
+	})
+	if err := ctr.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	for _, expected := range []connector.PipeEvent{connector.PipeEventAdd, connector.PipeEventRemove} {
+		select {
+		case e := <-events:
+			if e != expected {
+				t.Errorf("pipe event %d != %d", e, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("wait pipe event %d timeout", expected)
+		}
+	}
+
+	// first pipe keeps working
+	if err := cli.Send([]byte("again")); err != nil {
+		t.Errorf("send error: %s", err)
+	}
+	if content, err := srv.Recv(); err != nil || string(content) != "again" {
+		t.Errorf("recv error: %s, %q", err, content)
+	}
+}
@@ -0,0 +1,176 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/message"
+	"github.com/multisocket/multisocket/options"
+)
+
+func TestPipeReadBufferBoundary(t *testing.T) {
+	readBuffer := 64
+	for idx := range simpleTransports {
+		tp := simpleTransports[idx]
+		if tp.name != "ipc" && tp.name != "tcp" {
+			// stream transports only
+			continue
+		}
+		t.Run(tp.name, func(t *testing.T) {
+			srvsock, clisock, err := prepareSocks(tp.addr, options.OptionValues{connector.Options.Pipe.ReadBuffer: readBuffer})
+			if err != nil {
+				t.Fatalf("connect error: %s", err)
+			}
+			defer srvsock.Close()
+			defer clisock.Close()
+
+			// messages smaller and larger than read buffer, crossing buffer boundaries
+			contents := make([][]byte, 200)
+			for i := range contents {
+				contents[i] = genRandomContent(rand.Intn(2 * readBuffer))
+			}
+			go func() {
+				for _, content := range contents {
+					if err := clisock.Send(content); err != nil {
+						t.Errorf("send error: %s", err)
+					}
+				}
+			}()
+			for i, content := range contents {
+				msg, err := srvsock.RecvMsg()
+				if err != nil {
+					t.Fatalf("recv error: %s", err)
+				}
+				if !bytes.Equal(msg.Content, content) {
+					t.Errorf("send/recv not equal: i=%d, len=%d/%d", i, len(content), len(msg.Content))
+				}
+				msg.FreeAll()
+			}
+		})
+	}
+}
+
+func TestPipeStats(t *testing.T) {
+	for idx := range simpleTransports {
+		tp := simpleTransports[idx]
+		t.Run(tp.name, func(t *testing.T) {
+			srvsock, clisock, err := prepareSocks(tp.addr)
+			if err != nil {
+				t.Fatalf("connect error: %s", err)
+			}
+			defer srvsock.Close()
+			defer clisock.Close()
+
+			N := 10
+			content := genRandomContent(100)
+			for i := 0; i < N; i++ {
+				if err = clisock.Send(content); err != nil {
+					t.Errorf("send error: %s", err)
+				}
+				msg, err := srvsock.RecvMsg()
+				if err != nil {
+					t.Fatalf("recv error: %s", err)
+				}
+				msg.FreeAll()
+			}
+
+			cliPipes, srvPipes := clisock.Pipes(), srvsock.Pipes()
+			if len(cliPipes) != 1 || len(srvPipes) != 1 {
+				t.Fatalf("pipes count: %d/%d", len(cliPipes), len(srvPipes))
+			}
+			cp, sp := cliPipes[0], srvPipes[0]
+			// sender's stats are updated after message sent
+			waitUntil(time.Second, func() bool { return cp.MsgsSent() == uint64(N) })
+			if cp.MsgsSent() != uint64(N) || sp.MsgsRecv() != uint64(N) {
+				t.Errorf("msgs sent/recv: %d/%d", cp.MsgsSent(), sp.MsgsRecv())
+			}
+			if cp.BytesSent() < uint64(N*len(content)) {
+				t.Errorf("bytes sent: %d", cp.BytesSent())
+			}
+			if cp.BytesSent() != sp.BytesRecv() {
+				t.Errorf("bytes sent/recv: %d/%d", cp.BytesSent(), sp.BytesRecv())
+			}
+			if cp.MsgsRecv() != 0 || sp.MsgsSent() != 0 {
+				t.Errorf("unexpected msgs recv/sent: %d/%d", cp.MsgsRecv(), sp.MsgsSent())
+			}
+		})
+	}
+}
+
+func TestPipeInbound(t *testing.T) {
+	for idx := range simpleTransports {
+		tp := simpleTransports[idx]
+		t.Run(tp.name, func(t *testing.T) {
+			srvsock, clisock, err := prepareSocks(tp.addr)
+			if err != nil {
+				t.Fatalf("connect error: %s", err)
+			}
+			defer srvsock.Close()
+			defer clisock.Close()
+
+			if !waitUntil(time.Second, func() bool { return len(clisock.Pipes()) == 1 && len(srvsock.Pipes()) == 1 }) {
+				t.Fatalf("pipes count: %d/%d", len(clisock.Pipes()), len(srvsock.Pipes()))
+			}
+			if clisock.Pipes()[0].IsInbound() {
+				t.Errorf("dialer-side pipe is inbound")
+			}
+			if !srvsock.Pipes()[0].IsInbound() {
+				t.Errorf("listener-side pipe is outbound")
+			}
+		})
+	}
+}
+
+func TestPipeMalformedFrames(t *testing.T) {
+	addr := "tcp://127.0.0.1:23998"
+	frames := []struct {
+		name  string
+		frame []byte
+	}{
+		{"ToDestNoDistance", []byte{message.SendTypeToDest, 16, 0, 0, 0, 0, 0, 0}},
+		{"HopsOverflow", overflowFrame()},
+	}
+	for _, readBuffer := range []int{0, 8 * 1024} {
+		srvsock := multisocket.New(nil)
+		if err := srvsock.ListenOptions(addr, options.OptionValues{connector.Options.Pipe.ReadBuffer: readBuffer}); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		for _, f := range frames {
+			t.Run(fmt.Sprintf("%s/ReadBuffer(%d)", f.name, readBuffer), func(t *testing.T) {
+				conn, err := net.Dial("tcp", "127.0.0.1:23998")
+				if err != nil {
+					t.Fatalf("dial error: %s", err)
+				}
+				defer conn.Close()
+				if _, err = conn.Write(f.frame); err != nil {
+					t.Fatalf("write error: %s", err)
+				}
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				// closed with unread bytes may be reset
+				if _, err = conn.Read(make([]byte, 1)); err == nil {
+					t.Errorf("pipe not closed")
+				} else if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+					t.Errorf("pipe not closed in time")
+				}
+			})
+		}
+
+		// still serving well-formed peers
+		clisock := multisocket.New(nil)
+		if err := clisock.Dial(addr); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		clisock.Send([]byte("hello"))
+		if content, err := srvsock.RecvTimeout(time.Second); err != nil || string(content) != "hello" {
+			t.Errorf("recv: %q, %v", content, err)
+		}
+		clisock.Close()
+		srvsock.Close()
+	}
+}
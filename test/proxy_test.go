@@ -0,0 +1,147 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/message"
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/proxy"
+)
+
+// startProxy connect srvsock<-|back,front|<-clisock, and run proxy between front and back.
+func startProxy(t *testing.T, name string, ovs options.OptionValues) (srvsock, clisock multisocket.Socket, closeAll func()) {
+	front, cli, err := prepareSocks("inproc://proxy_front_" + name)
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	srv, back, err := prepareSocks("inproc://proxy_back_" + name)
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	go proxy.RunWithOptionValues(front, back, ovs)
+	return srv, cli, func() {
+		cli.Close()
+		srv.Close()
+		back.Close()
+		front.Close()
+	}
+}
+
+func TestProxy(t *testing.T) {
+	t.Run("Both", func(t *testing.T) {
+		srvsock, clisock, closeAll := startProxy(t, "both", nil)
+		defer closeAll()
+		if err := clisock.Send([]byte("ping")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		msg, err := srvsock.RecvMsgTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("server recv error: %s", err)
+		}
+		if string(msg.Content) != "ping" {
+			t.Errorf("server recv %s, expected: ping", msg.Content)
+		}
+		if err := srvsock.SendTo(msg.Source, []byte("pong")); err != nil {
+			t.Fatalf("reply error: %s", err)
+		}
+		msg.FreeAll()
+		if content, err := clisock.RecvTimeout(time.Second); err != nil {
+			t.Fatalf("client recv error: %s", err)
+		} else if string(content) != "pong" {
+			t.Errorf("client recv %s, expected: pong", content)
+		}
+
+	})
+
+	t.Run("Stop", func(t *testing.T) {
+		front := multisocket.New(nil)
+		back := multisocket.New(nil)
+		defer back.Close()
+		done := make(chan error, 1)
+		go func() {
+			done <- proxy.Run(front, back)
+		}()
+		front.Close()
+		select {
+		case err := <-done:
+			if err != errs.ErrClosed {
+				t.Errorf("proxy error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("proxy not stopped after front closed")
+		}
+	})
+
+	t.Run("OneDirectionStop", func(t *testing.T) {
+		front := multisocket.New(nil)
+		back := multisocket.New(nil)
+		defer front.Close()
+		done := make(chan error, 1)
+		go func() {
+			done <- proxy.RunWithOptionValues(front, back, options.OptionValues{
+				proxy.Options.Direction: proxy.DirectionFrontToBack,
+			})
+		}()
+		// no traffic to notice it by sending
+		back.Close()
+		select {
+		case err := <-done:
+			if err != errs.ErrClosed {
+				t.Errorf("proxy error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("proxy not stopped after back closed")
+		}
+	})
+
+	t.Run("OneDirection", func(t *testing.T) {
+		srvsock, clisock, closeAll := startProxy(t, "one_direction", options.OptionValues{
+			proxy.Options.Direction: proxy.DirectionFrontToBack,
+		})
+		defer closeAll()
+		if err := clisock.Send([]byte("ping")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		msg, err := srvsock.RecvMsgTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("server recv error: %s", err)
+		}
+		if err := srvsock.SendTo(msg.Source, []byte("pong")); err != nil {
+			t.Fatalf("reply error: %s", err)
+		}
+		msg.FreeAll()
+		if content, err := clisock.RecvTimeout(200 * time.Millisecond); err != multisocket.ErrTimeout {
+			t.Errorf("client recv %s, %v, expected nothing", content, err)
+		}
+	})
+
+	t.Run("Transform", func(t *testing.T) {
+		srvsock, clisock, closeAll := startProxy(t, "transform", options.OptionValues{
+			proxy.Options.Transform: proxy.TransformFunc(func(msg *message.Message) (*message.Message, bool) {
+				if bytes.HasPrefix(msg.Content, []byte("#")) {
+					return nil, false
+				}
+				msg.Content = bytes.ToUpper(msg.Content)
+				return msg, true
+			}),
+		})
+		defer closeAll()
+		for _, content := range []string{"#drop", "hello"} {
+			if err := clisock.Send([]byte(content)); err != nil {
+				t.Fatalf("send error: %s", err)
+			}
+		}
+		if content, err := srvsock.RecvTimeout(time.Second); err != nil {
+			t.Fatalf("server recv error: %s", err)
+		} else if string(content) != "HELLO" {
+			t.Errorf("server recv %s, expected: HELLO", content)
+		}
+		if content, err := srvsock.RecvTimeout(200 * time.Millisecond); err != multisocket.ErrTimeout {
+			t.Errorf("server recv %s, %v, expected nothing", content, err)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport/tcp"
+)
+
+func TestTCPReusePort(t *testing.T) {
+	addr := "tcp://127.0.0.1:23984"
+	ovs := options.OptionValues{tcp.Options.ReusePort: true}
+
+	srvsock1 := multisocket.New(nil)
+	defer srvsock1.Close()
+	if err := srvsock1.ListenOptions(addr, ovs); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	srvsock2 := multisocket.New(nil)
+	defer srvsock2.Close()
+	if err := srvsock2.ListenOptions(addr, ovs); err != nil {
+		t.Fatalf("listen with ReusePort error: %s", err)
+	}
+	srvsock3 := multisocket.New(nil)
+	defer srvsock3.Close()
+	if err := srvsock3.Listen(addr); err == nil {
+		t.Errorf("listen without ReusePort succeeded")
+	}
+
+	// the port is still served after the first listener is closed
+	srvsock1.Close()
+	clisock := multisocket.New(nil)
+	defer clisock.Close()
+	if err := clisock.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	if err := clisock.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if content, err := srvsock2.RecvTimeout(time.Second); err != nil || string(content) != "hello" {
+		t.Errorf("recv error: %v", err)
+	}
+}
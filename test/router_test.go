@@ -0,0 +1,183 @@
+package test
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/protocol/router"
+	_ "github.com/multisocket/multisocket/transport/inproc"
+)
+
+// linkRouters connect a to b, returns ids of the pipe on a's and b's side.
+func linkRouters(t *testing.T, a, b router.Router, addr string) (aPipe, bPipe uint32) {
+	aq := make(chan uint32, 1)
+	bq := make(chan uint32, 1)
+	a.OnPipeEvent(func(e connector.PipeEvent, p connector.Pipe) {
+		if e == connector.PipeEventAdd {
+			aq <- p.ID()
+		}
+	})
+	b.OnPipeEvent(func(e connector.PipeEvent, p connector.Pipe) {
+		if e == connector.PipeEventAdd {
+			bq <- p.ID()
+		}
+	})
+	defer a.OnPipeEvent(nil)
+	defer b.OnPipeEvent(nil)
+
+	if err := b.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	if err := a.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	for _, q := range []chan uint32{aq, bq} {
+		select {
+		case id := <-q:
+			if q == aq {
+				aPipe = id
+			} else {
+				bPipe = id
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("wait pipe timeout")
+		}
+	}
+	return
+}
+
+// newRouterChain create routers of node 1..n connected as a chain,
+// every router routes to all other nodes.
+func newRouterChain(t *testing.T, name string, n int, ovs options.OptionValues) []router.Router {
+	routers := make([]router.Router, n)
+	for i := range routers {
+		routers[i] = router.NewWithOptionValues(uint32(i+1), ovs)
+	}
+	for i := 0; i < n-1; i++ {
+		a, b := routers[i], routers[i+1]
+		aPipe, bPipe := linkRouters(t, a, b, fmt.Sprintf("inproc://%s_%d", name, i))
+		for j := i + 1; j < n; j++ {
+			a.AddRoute(uint32(j+1), aPipe)
+		}
+		for j := 0; j <= i; j++ {
+			b.AddRoute(uint32(j+1), bPipe)
+		}
+	}
+	return routers
+}
+
+func closeRouters(routers []router.Router) {
+	for _, r := range routers {
+		r.Close()
+	}
+}
+
+func TestRouterChain(t *testing.T) {
+	routers := newRouterChain(t, "router_chain", 4, nil)
+	defer closeRouters(routers)
+	first, last := routers[0], routers[3]
+
+	if err := first.SendToNode(4, []byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if from, content, err := last.Recv(); err != nil || from != 1 || string(content) != "hello" {
+		t.Errorf("recv error: %v, %d, %q", err, from, content)
+	}
+
+	// and back
+	if err := last.SendToNode(1, []byte("world")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if from, content, err := first.Recv(); err != nil || from != 4 || string(content) != "world" {
+		t.Errorf("recv error: %v, %d, %q", err, from, content)
+	}
+
+	if err := first.SendToNode(5, []byte("nowhere")); err != router.ErrNoRoute {
+		t.Errorf("send to unknown node error: %v", err)
+	}
+}
+
+func TestRouterTTL(t *testing.T) {
+	// a message's TTL is decremented at each hop, it can only go 2 hops.
+	routers := newRouterChain(t, "router_ttl", 4, options.OptionValues{multisocket.Options.SendTTL: uint8(2)})
+	defer closeRouters(routers)
+	first, third, last := routers[0], routers[2], routers[3]
+
+	if err := first.SendToNode(3, []byte("near")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if from, content, err := third.Recv(); err != nil || from != 1 || string(content) != "near" {
+		t.Errorf("recv error: %v, %d, %q", err, from, content)
+	}
+
+	if err := first.SendToNode(4, []byte("far")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	// messages are handled in order, so "far" has reached the third node when "near" arrives.
+	if err := first.SendToNode(3, []byte("near")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if _, content, err := third.Recv(); err != nil || string(content) != "near" {
+		t.Errorf("recv error: %v, %q", err, content)
+	}
+	// ttl exhausted "far" is dropped there, not forwarded before "next"
+	if err := third.SendToNode(4, []byte("next")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if from, content, err := last.Recv(); err != nil || from != 3 || string(content) != "next" {
+		t.Errorf("recv error: %v, %d, %q", err, from, content)
+	}
+}
+
+func TestRouterLoop(t *testing.T) {
+	ovs := options.OptionValues{multisocket.Options.SendTTL: uint8(0xff)}
+	first, second := router.NewWithOptionValues(1, ovs), router.NewWithOptionValues(2, ovs)
+	defer closeRouters([]router.Router{first, second})
+	aPipe, bPipe := linkRouters(t, first, second, "inproc://router_loop")
+	first.AddRoute(2, aPipe)
+	// node 3 is routed back and forth, until the message's path is too long
+	first.AddRoute(3, aPipe)
+	second.AddRoute(3, bPipe)
+
+	if err := first.SendToNode(3, []byte("loop")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	// the link is still fine
+	if err := first.SendToNode(2, []byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if from, content, err := second.Recv(); err != nil || from != 1 || string(content) != "hello" {
+		t.Errorf("recv error: %v, %d, %q", err, from, content)
+	}
+}
+
+// routerRunning check if any router's goroutine is running
+func routerRunning() bool {
+	buf := make([]byte, 1<<20)
+	return strings.Contains(string(buf[:runtime.Stack(buf, true)]), "router.(*router).run")
+}
+
+func TestRouterCloseUndrained(t *testing.T) {
+	routers := newRouterChain(t, "router_close", 2, nil)
+	first := routers[0]
+
+	// more than last's recv queue holds, and never recv
+	for i := 0; i < 100; i++ {
+		if err := first.SendToNode(2, []byte("hello")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	closeRouters(routers)
+
+	if !waitUntil(time.Second, func() bool { return !routerRunning() }) {
+		t.Errorf("router still running after closed")
+	}
+}
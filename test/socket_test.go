@@ -1,8 +1,18 @@
 package test
 
 import (
+	"bufio"
+	"context"
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"math/rand"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,7 +24,10 @@ import (
 	"github.com/multisocket/multisocket/errs"
 	"github.com/multisocket/multisocket/message"
 	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport"
 	_ "github.com/multisocket/multisocket/transport/all"
+	"github.com/multisocket/multisocket/transport/tcp"
+	"github.com/multisocket/multisocket/utils"
 )
 
 func TestSocketSendRecv(t *testing.T) {
@@ -148,6 +161,47 @@ func testSocketSendRecv(t *testing.T, addr string, sz int) {
 	}
 }
 
+func TestSocketMaxRecvContentLengthPerListener(t *testing.T) {
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	for _, c := range []struct {
+		addr  string
+		limit uint32
+		// accepted sizes
+		sizes []int
+	}{
+		{"tcp://127.0.0.1:23990", 1024, []int{1024}},
+		// larger than the other listener's limit
+		{"tcp://127.0.0.1:23991", 64 * 1024, []int{1024 + 1, 64 * 1024}},
+	} {
+		if err := srvsock.ListenOptions(c.addr, options.OptionValues{connector.Options.Pipe.MaxRecvContentLength: c.limit}); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		t.Run(fmt.Sprintf("%d", c.limit), func(t *testing.T) {
+			clisock := multisocket.New(nil)
+			defer clisock.Close()
+			if err := clisock.Dial(c.addr); err != nil {
+				t.Fatalf("dial error: %s", err)
+			}
+			for _, sz := range c.sizes {
+				if err := clisock.Send(genRandomContent(sz)); err != nil {
+					t.Fatalf("send error: %s", err)
+				}
+				if content, err := srvsock.RecvTimeout(time.Second); err != nil || len(content) != sz {
+					t.Errorf("recv %d bytes, error: %v, expected: %d bytes", len(content), err, sz)
+				}
+			}
+			// over the limit
+			if err := clisock.Send(genRandomContent(int(c.limit) + 1)); err != nil {
+				t.Fatalf("send error: %s", err)
+			}
+			if content, err := srvsock.RecvTimeout(200 * time.Millisecond); err != multisocket.ErrTimeout {
+				t.Errorf("recv %d bytes, error: %v, expected timeout", len(content), err)
+			}
+		})
+	}
+}
+
 func testSocketSwitch(t *testing.T, addr string, hops uint8) {
 	var (
 		err     error
@@ -240,6 +294,46 @@ func testSocketSwitchServer(t *testing.T, srvsock multisocket.Socket, sendTTL, h
 	done <- msgCount
 }
 
+func TestSocketTTLExpired(t *testing.T) {
+	// src->|b1,b2|->|c1,c2|->dst
+	var socks [6]multisocket.Socket
+	for i := 0; i < 3; i++ {
+		srv, cli, err := prepareSocks(fmt.Sprintf("inproc://ttl_expired_%d", i))
+		if err != nil {
+			t.Fatalf("connect error: %s", err)
+		}
+		defer srv.Close()
+		defer cli.Close()
+		socks[2*i], socks[2*i+1] = srv, cli
+	}
+	src, b1, b2, c1, c2, dst := socks[0], socks[1], socks[2], socks[3], socks[4], socks[5]
+	multisocket.StartSwitch(b1, b2, nil)
+	multisocket.StartSwitch(c1, c2, nil)
+
+	// ttl runs out at the second switch
+	if err := src.SendMsg(message.NewSendMessage(0, message.SendTypeToOne, 2, nil, nil, []byte("expired"))); err != nil {
+		t.Fatalf("Send error: %s", err)
+	}
+	if err := src.SendMsg(message.NewSendMessage(0, message.SendTypeToOne, 3, nil, nil, []byte("alive"))); err != nil {
+		t.Fatalf("Send error: %s", err)
+	}
+	msg, err := dst.RecvMsgTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("RecvMsg error: %s", err)
+	}
+	if string(msg.Content) != "alive" || msg.TTL != 0 || msg.Hops != 3 {
+		t.Errorf("RecvMsg %q TTL(%d) Hops(%d)", msg.Content, msg.TTL, msg.Hops)
+	}
+	msg.FreeAll()
+
+	if n := b2.TTLExpiredMsgs(); n != 0 {
+		t.Errorf("first switch TTLExpiredMsgs %d != 0", n)
+	}
+	if n := c2.TTLExpiredMsgs(); n != 1 {
+		t.Errorf("second switch TTLExpiredMsgs %d != 1", n)
+	}
+}
+
 func testSocketMaxRecvContentLength(t *testing.T, addr string, sz int) {
 	var (
 		err     error
@@ -341,3 +435,2708 @@ func testSocketCloseSender(t *testing.T, addr string, sz int) {
 		t.Errorf("%d messages dropped after sender closed!!", N-count)
 	}
 }
+
+func TestSocketSendStrictDest(t *testing.T) {
+	addr := "tcp://127.0.0.1:23934"
+	srvsock, clisock, err := prepareSocks(addr)
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+	srvsock.SetOption(multisocket.Options.SendStrictDest, true)
+
+	if err = clisock.Send([]byte("hello")); err != nil {
+		t.Errorf("send error: %s", err)
+	}
+	msg, err := srvsock.RecvMsg()
+	if err != nil {
+		t.Fatalf("recv error: %s", err)
+	}
+	defer msg.FreeAll()
+
+	forged := make(message.MsgPath, 4)
+	binary.BigEndian.PutUint32(forged, msg.PipeID()+1000)
+	for _, dest := range []message.MsgPath{forged, append(msg.Source, 0x01)} {
+		if err = srvsock.SendTo(dest, []byte("world")); err != multisocket.ErrBadDestination {
+			t.Errorf("send to %v error: %v", dest, err)
+		}
+	}
+
+	if err = srvsock.SendTo(msg.Source, []byte("world")); err != nil {
+		t.Errorf("send to error: %s", err)
+	}
+	if msg, err := clisock.RecvMsg(); err != nil || string(msg.Content) != "world" {
+		t.Errorf("recv reply error: %v", err)
+	}
+
+	// raw peer can not forward messages
+	rawAddr := "tcp://127.0.0.1:23935"
+	if err = srvsock.ListenOptions(rawAddr, options.OptionValues{connector.Options.Pipe.Raw: true}); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	conn, err := net.Dial("tcp", "127.0.0.1:23935")
+	if err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	defer conn.Close()
+	// raw connection's first empty message
+	rawMsg, err := srvsock.RecvMsg()
+	if err != nil {
+		t.Fatalf("recv error: %s", err)
+	}
+	defer rawMsg.FreeAll()
+	if err = srvsock.SendTo(append(rawMsg.Source, msg.Source...), []byte("world")); err != multisocket.ErrBadDestination {
+		t.Errorf("send to raw peer error: %v", err)
+	}
+	if err = srvsock.SendTo(rawMsg.Source, []byte("world")); err != nil {
+		t.Errorf("send to raw peer error: %s", err)
+	}
+}
+
+func TestSocketCloseGracefully(t *testing.T) {
+	for idx := range simpleTransports {
+		tp := simpleTransports[idx]
+		t.Run(tp.name, func(t *testing.T) {
+			srvsock, clisock, err := prepareSocks(tp.addr)
+			if err != nil {
+				t.Fatalf("connect error: %s", err)
+			}
+			defer srvsock.Close()
+
+			N := 64
+			for i := 0; i < N; i++ {
+				if err = clisock.Send(genRandomContent(1024)); err != nil {
+					t.Errorf("send error: %s", err)
+				}
+			}
+			if err = clisock.CloseGracefully(time.Second); err != nil {
+				t.Errorf("close gracefully error: %s", err)
+			}
+			if err = clisock.Send([]byte("closed")); err != errs.ErrClosed {
+				t.Errorf("send after close error: %v", err)
+			}
+
+			for i := 0; i < N; i++ {
+				msg, err := srvsock.RecvMsg()
+				if err != nil {
+					t.Fatalf("recv %d error: %s", i, err)
+				}
+				msg.FreeAll()
+			}
+		})
+	}
+
+	t.Run("Batch", func(t *testing.T) {
+		// the last batch is still being written after queues are empty, for the slow reader
+		srvsock, clisock, err := prepareSocks("tcp://127.0.0.1:24000")
+		if err != nil {
+			t.Fatalf("connect error: %s", err)
+		}
+		defer srvsock.Close()
+		srvsock.SetOption(multisocket.Options.RecvQueueSize, uint16(1))
+		clisock.SetOption(multisocket.Options.SendBatchSize, uint16(16))
+		// not to wait in Close
+		clisock.SetOption(multisocket.Options.SendStopTimeout, time.Millisecond)
+
+		N := 64
+		content := genRandomContent(64 * 1024)
+		for i := 0; i < N; i++ {
+			if err = clisock.Send(content); err != nil {
+				t.Errorf("send error: %s", err)
+			}
+		}
+		recvd := make(chan int, 1)
+		go func() {
+			n := 0
+			for ; n < N; n++ {
+				msg, err := srvsock.RecvMsgTimeout(time.Second)
+				if err != nil {
+					break
+				}
+				msg.FreeAll()
+				time.Sleep(time.Millisecond)
+			}
+			recvd <- n
+		}()
+		if err = clisock.CloseGracefully(5 * time.Second); err != nil {
+			t.Errorf("close gracefully error: %s", err)
+		}
+		if n := <-recvd; n != N {
+			t.Errorf("recv %d/%d messages", n, N)
+		}
+	})
+
+	t.Run("AfterSendAll", func(t *testing.T) {
+		srvsock, clisock, err := prepareSocks("inproc://close_gracefully_sendall_test")
+		if err != nil {
+			t.Fatalf("connect error: %s", err)
+		}
+		if err = clisock.SendAll([]byte("hello")); err != nil {
+			t.Fatalf("send all error: %s", err)
+		}
+		if _, err = srvsock.RecvTimeout(time.Second); err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+		srvsock.Close()
+		if !waitUntil(time.Second, func() bool { return clisock.Stats().Pipes == 0 }) {
+			t.Fatalf("pipe not removed")
+		}
+		// no pipes, the message remains queued
+		if err = clisock.Send([]byte("hello")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		if err = clisock.CloseGracefully(50 * time.Millisecond); err != errs.ErrTimeout {
+			t.Errorf("close gracefully error: %v", err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		// no pipes, messages remain queued
+		sock := multisocket.New(nil)
+		if err := sock.Send([]byte("hello")); err != nil {
+			t.Errorf("send error: %s", err)
+		}
+		if err := sock.CloseGracefully(50 * time.Millisecond); err != errs.ErrTimeout {
+			t.Errorf("close gracefully error: %v", err)
+		}
+		if err := sock.Close(); err != errs.ErrClosed {
+			t.Errorf("close again error: %v", err)
+		}
+	})
+}
+
+func TestSocketMsgTransport(t *testing.T) {
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	schemes := map[string]string{
+		"ipc": "ipc:///tmp/msg_transport_test.sock",
+		"tcp": "tcp://127.0.0.1:23936",
+	}
+	for scheme, addr := range schemes {
+		if err := srvsock.Listen(addr); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		clisock := multisocket.New(nil)
+		defer clisock.Close()
+		if err := clisock.Dial(addr); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		if err := clisock.Send([]byte(scheme)); err != nil {
+			t.Errorf("send error: %s", err)
+		}
+	}
+
+	for i := 0; i < len(schemes); i++ {
+		msg, err := srvsock.RecvMsg()
+		if err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+		if tran := srvsock.MsgTransport(msg); tran == nil || tran.Scheme() != string(msg.Content) {
+			t.Errorf("message transport %v != %s", tran, msg.Content)
+		}
+		msg.FreeAll()
+	}
+
+	msg := message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, nil)
+	defer msg.FreeAll()
+	if tran := srvsock.MsgTransport(msg); tran != nil {
+		t.Errorf("send message transport: %v", tran)
+	}
+}
+
+func TestSocketListenRetry(t *testing.T) {
+	addr := "tcp://127.0.0.1:23937"
+	blocker, err := net.Listen("tcp", "127.0.0.1:23937")
+	if err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	sock := multisocket.NewDefault()
+	defer sock.Close()
+	if err = sock.Listen(addr); err == nil {
+		t.Errorf("listen on used address without retry succeeded")
+	}
+
+	time.AfterFunc(150*time.Millisecond, func() { blocker.Close() })
+	ovs := options.OptionValues{
+		connector.Options.Listener.ListenRetry:  10,
+		connector.Options.Listener.MinRetryTime: 50 * time.Millisecond,
+		connector.Options.Listener.MaxRetryTime: 100 * time.Millisecond,
+	}
+	if err = sock.ListenOptions(addr, ovs); err != nil {
+		t.Errorf("listen with retry error: %s", err)
+	}
+}
+
+func TestSocketListenRetryClose(t *testing.T) {
+	addr := "tcp://127.0.0.1:24005"
+	blocker, err := net.Listen("tcp", "127.0.0.1:24005")
+	if err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	defer blocker.Close()
+
+	sock := multisocket.NewDefault()
+	done := make(chan error, 1)
+	go func() {
+		done <- sock.ListenOptions(addr, options.OptionValues{
+			connector.Options.Listener.ListenRetry:  -1,
+			connector.Options.Listener.MinRetryTime: time.Second,
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	sock.Close()
+	select {
+	case err = <-done:
+		if err != errs.ErrClosed {
+			t.Errorf("listen error: %v, expected: %v", err, errs.ErrClosed)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Errorf("listen retry not stopped by close")
+	}
+}
+
+func TestSocketSendBatch(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("tcp://127.0.0.1:23938")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+	clisock.SetOption(multisocket.Options.SendBatchSize, uint16(8))
+
+	N := 1000
+	go func() {
+		for i := 0; i < N; i++ {
+			if err := clisock.Send([]byte(fmt.Sprintf("msg-%d", i))); err != nil {
+				t.Errorf("send error: %s", err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < N; i++ {
+		msg, err := srvsock.RecvMsg()
+		if err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+		if expected := fmt.Sprintf("msg-%d", i); string(msg.Content) != expected {
+			t.Fatalf("recv %q != %q", msg.Content, expected)
+		}
+		msg.FreeAll()
+	}
+}
+
+func TestSocketDialerMaxPipes(t *testing.T) {
+	addr := "inproc://max_pipes_test"
+	srvsock := multisocket.NewDefault()
+	defer srvsock.Close()
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	maxPipes := 2
+	clisock := multisocket.New(options.OptionValues{
+		connector.Options.Dialer.MaxPipes:         maxPipes,
+		connector.Options.Dialer.MinReconnectTime: 10 * time.Millisecond,
+	})
+	defer clisock.Close()
+	for i := 0; i < 4; i++ {
+		err := clisock.Dial(addr)
+		if i < maxPipes && err != nil {
+			t.Errorf("dial %d error: %s", i, err)
+		} else if i >= maxPipes && err != connector.ErrMaxPipes {
+			t.Errorf("dial %d error: %v", i, err)
+		}
+	}
+
+	pipes := func() int { return len(clisock.Connector().Pipes()) }
+	for i := 0; i < 5; i++ {
+		if !waitUntil(time.Second, func() bool { return pipes() == maxPipes }) {
+			t.Fatalf("reconnect %d: %d pipes", i, pipes())
+		}
+		srvsock.Connector().Pipes()[0].Close()
+		for j := 0; j < 10; j++ {
+			if n := pipes(); n > maxPipes {
+				t.Fatalf("reconnect %d: %d pipes exceed max pipes", i, n)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+func TestSocketKeepAlive(t *testing.T) {
+	ovs := options.OptionValues{
+		connector.Options.Dialer.Reconnect:       false,
+		connector.Options.Pipe.KeepAliveInterval: 20 * time.Millisecond,
+		connector.Options.Pipe.KeepAliveTimeout:  50 * time.Millisecond,
+	}
+
+	t.Run("Alive", func(t *testing.T) {
+		srvsock := multisocket.New(nil)
+		defer srvsock.Close()
+		if err := srvsock.Listen("tcp://127.0.0.1:23944"); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		clisock := multisocket.New(nil)
+		defer clisock.Close()
+		if err := clisock.DialOptions("tcp://127.0.0.1:23944", ovs); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+
+		time.Sleep(200 * time.Millisecond)
+		if n := len(clisock.Connector().Pipes()); n != 1 {
+			t.Errorf("%d pipes alive", n)
+		}
+		if err := srvsock.Send([]byte("hello")); err != nil {
+			t.Errorf("send error: %s", err)
+		}
+		if msg, err := clisock.RecvMsg(); err != nil || string(msg.Content) != "hello" {
+			t.Errorf("recv error: %v", err)
+		}
+	})
+
+	t.Run("Dead", func(t *testing.T) {
+		// peer never replies pongs
+		ctr := connector.NewWithOptionValues(nil)
+		defer ctr.Close()
+		ctr.SetPipeEventHandler(func(e connector.PipeEvent, p connector.Pipe) {
+			if e == connector.PipeEventAdd {
+				go func() {
+					for {
+						msg, err := p.RecvMsg()
+						if err != nil {
+							return
+						}
+						msg.FreeAll()
+					}
+				}()
+			}
+		})
+		if err := ctr.Listen("tcp://127.0.0.1:23945"); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+
+		clisock := multisocket.New(nil)
+		defer clisock.Close()
+		if err := clisock.DialOptions("tcp://127.0.0.1:23945", ovs); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		if !waitUntil(time.Second, func() bool { return len(clisock.Connector().Pipes()) == 0 }) {
+			t.Errorf("dead pipe not closed")
+		}
+	})
+}
+
+func TestSocketInternalMsg(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("tcp://127.0.0.1:23946")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+	if !waitUntil(time.Second, func() bool { return len(srvsock.Connector().Pipes()) == 1 }) {
+		t.Fatalf("server pipe not connected")
+	}
+	cliPipeID := clisock.Connector().Pipes()[0].ID()
+
+	// custom handler
+	const typ = uint8(100)
+	handledq := make(chan uint32, 1)
+	srvsock.SetInternalMsgHandler(typ, func(p connector.Pipe, msg *message.Message) {
+		handledq <- p.ID()
+	})
+	if err = clisock.SendMsg(message.NewInternalMessage(cliPipeID, typ)); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	srvPipeID := srvsock.Connector().Pipes()[0].ID()
+	select {
+	case id := <-handledq:
+		if id != srvPipeID {
+			t.Errorf("handled on pipe %d != %d", id, srvPipeID)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("internal message not handled")
+	}
+
+	// close peer
+	if err = clisock.SendMsg(message.NewInternalMessage(cliPipeID, message.InternalMsgClosePeer)); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	removed := waitUntil(time.Second, func() bool {
+		for _, p := range srvsock.Connector().Pipes() {
+			if p.ID() == srvPipeID {
+				return false
+			}
+		}
+		return true
+	})
+	if !removed {
+		t.Errorf("pipe not closed by peer")
+	}
+}
+
+func TestSocketRecvTimeout(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("inproc://recv_timeout_test")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer clisock.Close()
+
+	start := time.Now()
+	if _, err = srvsock.RecvMsgTimeout(50 * time.Millisecond); err != multisocket.ErrTimeout {
+		t.Errorf("recv error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("recv timeout after %s", elapsed)
+	}
+
+	if err = clisock.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if content, err := srvsock.RecvTimeout(time.Second); err != nil || string(content) != "hello" {
+		t.Errorf("recv error: %v, %q", err, content)
+	}
+
+	srvsock.Close()
+	if _, err = srvsock.RecvTimeout(time.Second); err != errs.ErrClosed {
+		t.Errorf("recv after close error: %v", err)
+	}
+}
+
+func TestSocketReadDeadline(t *testing.T) {
+	// stalled peer, accepts but never writes
+	ln, err := net.Listen("tcp", "127.0.0.1:23947")
+	if err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	defer ln.Close()
+
+	clisock := multisocket.New(nil)
+	defer clisock.Close()
+	if err = clisock.DialOptions("tcp://127.0.0.1:23947", options.OptionValues{
+		connector.Options.Dialer.Reconnect: false,
+		transport.Options.ReadDeadline:     100 * time.Millisecond,
+	}); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept error: %s", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(time.Second))
+	if _, err = conn.Read(make([]byte, 64)); err == nil {
+		t.Fatalf("peer recv data")
+	} else if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		t.Fatalf("pipe not closed after read deadline")
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("pipe closed too early: %s", d)
+	}
+	if !waitUntil(time.Second, func() bool { return len(clisock.Connector().Pipes()) == 0 }) {
+		t.Errorf("pipe not removed")
+	}
+}
+
+func TestSocketSendPriority(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		burst uint16
+		// expected send order, p for priority, n for normal
+		order string
+	}{
+		{"Overtake", 8, "pppppnnnnn"},
+		{"NoStarve", 2, "ppnppnpnnn"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// queue messages before any pipe is connected
+			clisock := multisocket.New(options.OptionValues{multisocket.Options.SendPriorityBurst: tc.burst})
+			defer clisock.Close()
+			if err := clisock.Listen("tcp://127.0.0.1:23948"); err != nil {
+				t.Fatalf("listen error: %s", err)
+			}
+			for i := 0; i < 5; i++ {
+				if err := clisock.Send([]byte("n")); err != nil {
+					t.Fatalf("send error: %s", err)
+				}
+			}
+			for i := 0; i < 5; i++ {
+				if err := clisock.SendPriority([]byte("p")); err != nil {
+					t.Fatalf("send priority error: %s", err)
+				}
+			}
+
+			srvsock := multisocket.New(nil)
+			defer srvsock.Close()
+			if err := srvsock.Dial("tcp://127.0.0.1:23948"); err != nil {
+				t.Fatalf("dial error: %s", err)
+			}
+			order := ""
+			for i := 0; i < len(tc.order); i++ {
+				msg, err := srvsock.RecvMsgTimeout(time.Second)
+				if err != nil {
+					t.Fatalf("recv error: %s", err)
+				}
+				if msg.HasFlags(message.MsgFlagPriority) != (msg.Content[0] == 'p') {
+					t.Errorf("bad priority flag: %s", msg.Content)
+				}
+				order += string(msg.Content)
+				msg.FreeAll()
+			}
+			if order != tc.order {
+				t.Errorf("send order: %s, expected: %s", order, tc.order)
+			}
+		})
+	}
+}
+
+func TestSocketSendToPipe(t *testing.T) {
+	addr := "tcp://127.0.0.1:23949"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	clisockA := multisocket.New(nil)
+	defer clisockA.Close()
+	if err := clisockA.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	if !waitUntil(time.Second, func() bool { return len(srvsock.Connector().Pipes()) == 1 }) {
+		t.Fatalf("pipe not connected")
+	}
+	// pin client A to its pipe
+	id := srvsock.Connector().Pipes()[0].ID()
+
+	clisockB := multisocket.New(nil)
+	defer clisockB.Close()
+	if err := clisockB.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	if !waitUntil(time.Second, func() bool { return len(srvsock.Connector().Pipes()) == 2 }) {
+		t.Fatalf("pipe not connected")
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := srvsock.SendToPipe(id, []byte("hello")); err != nil {
+			t.Fatalf("send to pipe error: %s", err)
+		}
+		if content, err := clisockA.RecvTimeout(time.Second); err != nil || string(content) != "hello" {
+			t.Fatalf("recv error: %v", err)
+		}
+	}
+	if _, err := clisockB.RecvTimeout(50 * time.Millisecond); err != errs.ErrTimeout {
+		t.Errorf("other peer recv error: %v", err)
+	}
+
+	clisockA.Close()
+	if !waitUntil(time.Second, func() bool { return len(srvsock.Connector().Pipes()) == 1 }) {
+		t.Fatalf("pipe not closed")
+	}
+	if err := srvsock.SendToPipe(id, []byte("hello")); err != multisocket.ErrPipeNotFound {
+		t.Errorf("send to closed pipe error: %v", err)
+	}
+}
+
+func TestSocketOnPipeEvent(t *testing.T) {
+	type event struct {
+		e          connector.PipeEvent
+		id         uint32
+		remoteAddr string
+	}
+	events := make(chan event, 8)
+
+	addr := "tcp://127.0.0.1:23950"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	srvsock.OnPipeEvent(func(e connector.PipeEvent, p connector.Pipe) {
+		events <- event{e, p.ID(), p.RemoteAddress()}
+	})
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	clisock := multisocket.New(nil)
+	defer clisock.Close()
+	if err := clisock.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	if !waitUntil(time.Second, func() bool { return len(clisock.Connector().Pipes()) == 1 }) {
+		t.Fatalf("pipe not connected")
+	}
+	cliAddr := clisock.Connector().Pipes()[0].LocalAddress()
+
+	var added event
+	select {
+	case added = <-events:
+	case <-time.After(time.Second):
+		t.Fatalf("no add event")
+	}
+	if added.e != connector.PipeEventAdd || added.remoteAddr != cliAddr {
+		t.Errorf("add event: %+v, expected address: %s", added, cliAddr)
+	}
+
+	clisock.Close()
+	select {
+	case removed := <-events:
+		if removed.e != connector.PipeEventRemove || removed.id != added.id || removed.remoteAddr != cliAddr {
+			t.Errorf("remove event: %+v, expected: %+v", removed, added)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no remove event")
+	}
+}
+
+func TestSocketSendQueueFullPolicy(t *testing.T) {
+	newFullSock := func(policy uint8) multisocket.Socket {
+		// no pipes, queue is full after 2 sends
+		sock := multisocket.New(options.OptionValues{
+			multisocket.Options.SendQueueSize:       uint16(2),
+			multisocket.Options.SendQueueFullPolicy: policy,
+			multisocket.Options.SendBlockTimeout:    50 * time.Millisecond,
+		})
+		for i := 0; i < 2; i++ {
+			if err := sock.Send([]byte("hello")); err != nil {
+				t.Fatalf("send error: %s", err)
+			}
+		}
+		return sock
+	}
+
+	t.Run("Block", func(t *testing.T) {
+		sock := newFullSock(multisocket.SendQueueFullBlock)
+		done := make(chan error, 1)
+		go func() {
+			done <- sock.Send([]byte("hello"))
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("send not blocked: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		sock.Close()
+		if err := <-done; err != errs.ErrClosed {
+			t.Errorf("send error: %v", err)
+		}
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		sock := newFullSock(multisocket.SendQueueFullDrop)
+		defer sock.Close()
+		start := time.Now()
+		if err := sock.Send([]byte("hello")); err != multisocket.ErrMsgDropped {
+			t.Errorf("send error: %v", err)
+		}
+		if d := time.Since(start); d >= 50*time.Millisecond {
+			t.Errorf("send blocked: %s", d)
+		}
+	})
+
+	t.Run("BlockTimeout", func(t *testing.T) {
+		sock := newFullSock(multisocket.SendQueueFullBlockTimeout)
+		defer sock.Close()
+		start := time.Now()
+		if err := sock.Send([]byte("hello")); err != multisocket.ErrMsgDropped {
+			t.Errorf("send error: %v", err)
+		}
+		if d := time.Since(start); d < 50*time.Millisecond {
+			t.Errorf("send not blocked: %s", d)
+		}
+	})
+}
+
+func TestSocketMaxSendContentLength(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("tcp://127.0.0.1:23952")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+	clisock.SetOption(multisocket.Options.MaxSendContentLength, uint32(1024))
+
+	if err = clisock.Send(make([]byte, 1024)); err != nil {
+		t.Errorf("send at limit error: %s", err)
+	}
+	if content, err := srvsock.RecvTimeout(time.Second); err != nil || len(content) != 1024 {
+		t.Errorf("recv error: %v", err)
+	}
+	if err = clisock.Send(make([]byte, 1025)); err != multisocket.ErrContentTooLong {
+		t.Errorf("send over limit error: %v", err)
+	}
+	if err = clisock.SendMsg(message.NewSendMessage(0, message.SendTypeToOne, message.DefaultMsgTTL, nil, nil, make([]byte, 1025))); err != multisocket.ErrContentTooLong {
+		t.Errorf("send msg over limit error: %v", err)
+	}
+	if _, err := srvsock.RecvTimeout(50 * time.Millisecond); err != errs.ErrTimeout {
+		t.Errorf("oversize message sent: %v", err)
+	}
+}
+
+func TestConnectorDialWithResult(t *testing.T) {
+	ctr := connector.NewWithOptionValues(nil)
+	defer ctr.Close()
+
+	t.Run("Refused", func(t *testing.T) {
+		d, err := ctr.NewDialer("tcp://127.0.0.1:23955", nil)
+		if err != nil {
+			t.Fatalf("new dialer error: %s", err)
+		}
+		defer d.Close()
+		result, err := d.DialWithResult()
+		if err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		select {
+		case err = <-result:
+			if err == nil {
+				t.Errorf("dial to refused address succeeded")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no dial result")
+		}
+		if _, err = d.DialWithResult(); err != errs.ErrAddrInUse {
+			t.Errorf("dial again error: %v", err)
+		}
+	})
+
+	t.Run("Connected", func(t *testing.T) {
+		srvsock := multisocket.New(nil)
+		defer srvsock.Close()
+		if err := srvsock.Listen("tcp://127.0.0.1:23956"); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		d, err := ctr.NewDialer("tcp://127.0.0.1:23956", nil)
+		if err != nil {
+			t.Fatalf("new dialer error: %s", err)
+		}
+		result, err := d.DialWithResult()
+		if err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		select {
+		case err = <-result:
+			if err != nil {
+				t.Errorf("dial result: %s", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no dial result")
+		}
+		if n := len(ctr.Pipes()); n != 1 {
+			t.Errorf("%d pipes connected", n)
+		}
+	})
+}
+
+// countTran is a tcp transport which counts dials.
+type countTran struct {
+	transport.Transport
+	dials *int32
+}
+
+func (t countTran) Scheme() string {
+	return "counttcp"
+}
+
+func (t countTran) NewDialer(address string) (transport.Dialer, error) {
+	d, err := t.Transport.NewDialer(address)
+	if err != nil {
+		return nil, err
+	}
+	return countDialer{d, t.dials}, nil
+}
+
+type countDialer struct {
+	transport.Dialer
+	dials *int32
+}
+
+func (d countDialer) Dial(opts options.Options) (transport.Connection, error) {
+	atomic.AddInt32(d.dials, 1)
+	return d.Dialer.Dial(opts)
+}
+
+func TestConnectorMaxReconnectAttempts(t *testing.T) {
+	dials := new(int32)
+	transport.RegisterTransport(countTran{tcp.Transport, dials})
+
+	type giveUp struct {
+		addr string
+		err  error
+	}
+	giveUpq := make(chan giveUp, 1)
+	ctr := connector.NewWithOptionValues(nil)
+	defer ctr.Close()
+	// never listening
+	addr := "counttcp://127.0.0.1:23957"
+	if err := ctr.DialOptions(addr, options.OptionValues{
+		connector.Options.Dialer.DialAsync:            true,
+		connector.Options.Dialer.MinReconnectTime:     10 * time.Millisecond,
+		connector.Options.Dialer.MaxReconnectAttempts: 3,
+		connector.Options.Dialer.OnGiveUp: func(addr string, lastErr error) {
+			giveUpq <- giveUp{addr, lastErr}
+		},
+	}); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+
+	select {
+	case g := <-giveUpq:
+		if g.addr != addr || g.err == nil {
+			t.Errorf("give up: %+v", g)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("dialer not give up")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(dials); n != 3 {
+		t.Errorf("%d dials", n)
+	}
+}
+
+func TestConnectorDialMulti(t *testing.T) {
+	dials := new(int32)
+	transport.RegisterTransport(countTran{tcp.Transport, dials})
+
+	// first address always refuses
+	addrs := []string{"counttcp://127.0.0.1:23977", "tcp://127.0.0.1:23978"}
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	if err := srvsock.Listen(addrs[1]); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	for _, async := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Async=%v", async), func(t *testing.T) {
+			atomic.StoreInt32(dials, 0)
+			clisock := multisocket.New(nil)
+			defer clisock.Close()
+			if err := clisock.DialMulti(addrs, options.OptionValues{
+				connector.Options.Dialer.DialAsync:        async,
+				connector.Options.Dialer.MinReconnectTime: 10 * time.Millisecond,
+			}); err != nil {
+				t.Fatalf("dial error: %s", err)
+			}
+			if !waitUntil(time.Second, func() bool { return len(clisock.Connector().Pipes()) == 1 }) {
+				t.Fatalf("not connected")
+			}
+			if addr := clisock.Connector().Pipes()[0].RemoteAddress(); addr != addrs[1] {
+				t.Errorf("connected to %s, expected %s", addr, addrs[1])
+			}
+			if n := atomic.LoadInt32(dials); n != 1 {
+				t.Errorf("%d dials to first address", n)
+			}
+		})
+	}
+}
+
+// stampTran is a tcp transport which sends time of dials to stamps.
+type stampTran struct {
+	transport.Transport
+	stamps chan time.Time
+}
+
+func (t stampTran) Scheme() string {
+	return "stamptcp"
+}
+
+func (t stampTran) NewDialer(address string) (transport.Dialer, error) {
+	d, err := t.Transport.NewDialer(address)
+	if err != nil {
+		return nil, err
+	}
+	return stampDialer{d, t.stamps}, nil
+}
+
+type stampDialer struct {
+	transport.Dialer
+	stamps chan time.Time
+}
+
+func (d stampDialer) Dial(opts options.Options) (transport.Connection, error) {
+	select {
+	case d.stamps <- time.Now():
+	default:
+	}
+	return d.Dialer.Dial(opts)
+}
+
+func TestConnectorBackoffStrategy(t *testing.T) {
+	const (
+		attempts = 5
+		minTime  = 30 * time.Millisecond
+		slack    = 25 * time.Millisecond
+	)
+	stamps := make(chan time.Time, attempts)
+	transport.RegisterTransport(stampTran{tcp.Transport, stamps})
+
+	for _, c := range []struct {
+		name     string
+		strategy uint8
+		// min and max delay before attempt i+1
+		delay func(i int) (time.Duration, time.Duration)
+	}{
+		{"Exponential", connector.BackoffExponential, func(i int) (time.Duration, time.Duration) {
+			return time.Duration(float64(minTime) * math.Pow(1.1, float64(i))), time.Duration(float64(minTime) * math.Pow(1.5, float64(i)))
+		}},
+		{"Linear", connector.BackoffLinear, func(i int) (time.Duration, time.Duration) {
+			return minTime * time.Duration(i+1), minTime * time.Duration(i+1)
+		}},
+		{"Constant", connector.BackoffConstant, func(i int) (time.Duration, time.Duration) {
+			return minTime, minTime
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ctr := connector.NewWithOptionValues(nil)
+			defer ctr.Close()
+			// never listening
+			if err := ctr.DialOptions("stamptcp://127.0.0.1:23982", options.OptionValues{
+				connector.Options.Dialer.DialAsync:            true,
+				connector.Options.Dialer.MinReconnectTime:     minTime,
+				connector.Options.Dialer.BackoffStrategy:      c.strategy,
+				connector.Options.Dialer.MaxReconnectAttempts: attempts,
+			}); err != nil {
+				t.Fatalf("dial error: %s", err)
+			}
+
+			var last time.Time
+			for i := 0; i < attempts; i++ {
+				var stamp time.Time
+				select {
+				case stamp = <-stamps:
+				case <-time.After(time.Second):
+					t.Fatalf("attempt %d not dialed", i)
+				}
+				if i > 0 {
+					min, max := c.delay(i - 1)
+					if d := stamp.Sub(last); d < min-time.Millisecond || d > max+slack {
+						t.Errorf("delay before attempt %d: %s, expected: [%s, %s]", i, d, min, max)
+					}
+				}
+				last = stamp
+			}
+		})
+	}
+}
+
+func TestConnectorConnEvents(t *testing.T) {
+	type event struct {
+		e    connector.PipeEvent
+		addr string
+		err  error
+	}
+	handler := func(events chan<- event) connector.ConnEventHandlerFunc {
+		return func(e connector.PipeEvent, addr string, err error) {
+			events <- event{e, addr, err}
+		}
+	}
+	next := func(t *testing.T, events <-chan event) event {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(time.Second):
+			t.Fatalf("no event")
+		}
+		return event{}
+	}
+
+	t.Run("DialFailed", func(t *testing.T) {
+		const attempts = 3
+		addr := "tcp://127.0.0.1:23987"
+		events := make(chan event, 2*attempts)
+		ctr := connector.NewWithOptionValues(nil)
+		defer ctr.Close()
+		ctr.SetConnEventHandler(handler(events))
+		// refused, never listening
+		if err := ctr.DialOptions(addr, options.OptionValues{
+			connector.Options.Dialer.DialAsync:            true,
+			connector.Options.Dialer.MinReconnectTime:     10 * time.Millisecond,
+			connector.Options.Dialer.MaxReconnectAttempts: attempts,
+		}); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		for i := 0; i < attempts; i++ {
+			if ev := next(t, events); ev.e != connector.PipeEventDialing || ev.addr != addr || ev.err != nil {
+				t.Errorf("attempt %d: %+v, expected dialing", i, ev)
+			}
+			if ev := next(t, events); ev.e != connector.PipeEventDialFailed || ev.addr != addr || ev.err == nil {
+				t.Errorf("attempt %d: %+v, expected dial failed with error", i, ev)
+			}
+		}
+	})
+
+	t.Run("Accepted", func(t *testing.T) {
+		addr := "tcp://127.0.0.1:23988"
+		srvEvents := make(chan event, 1)
+		srvctr := connector.NewWithOptionValues(nil)
+		defer srvctr.Close()
+		srvctr.SetConnEventHandler(handler(srvEvents))
+		if err := srvctr.Listen(addr); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		cliEvents := make(chan event, 1)
+		clictr := connector.NewWithOptionValues(nil)
+		defer clictr.Close()
+		clictr.SetConnEventHandler(handler(cliEvents))
+		if err := clictr.Dial(addr); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		if ev := next(t, cliEvents); ev.e != connector.PipeEventDialing || ev.addr != addr {
+			t.Errorf("dialer event: %+v, expected dialing", ev)
+		}
+		if ev := next(t, srvEvents); ev.e != connector.PipeEventAccepted || ev.addr == "" || ev.err != nil {
+			t.Errorf("listener event: %+v, expected accepted", ev)
+		}
+	})
+}
+
+func TestConnectorDialerState(t *testing.T) {
+	t.Run("BackingOff", func(t *testing.T) {
+		addr := "tcp://127.0.0.1:23992"
+		ctr := connector.NewWithOptionValues(nil)
+		defer ctr.Close()
+		// refused, never listening
+		d, err := ctr.NewDialer(addr, options.OptionValues{
+			connector.Options.Dialer.DialAsync:        true,
+			connector.Options.Dialer.MinReconnectTime: time.Second,
+		})
+		if err != nil {
+			t.Fatalf("new dialer error: %s", err)
+		}
+		if err = d.Dial(); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		var state connector.DialerState
+		if !waitUntil(time.Second, func() bool { state = d.State(); return state.BackingOff }) {
+			t.Fatalf("not backing off: %+v", state)
+		}
+		if state.Addr != addr || state.Connected || state.Dialing || state.Attempts != 1 || !state.NextAttempt.After(time.Now()) {
+			t.Errorf("backing off state: %+v", state)
+		}
+
+		d.Close()
+		if state = d.State(); !state.Closed || state.BackingOff {
+			t.Errorf("closed state: %+v", state)
+		}
+	})
+
+	t.Run("Connected", func(t *testing.T) {
+		addr := "tcp://127.0.0.1:23993"
+		ctr := connector.NewWithOptionValues(nil)
+		defer ctr.Close()
+		if err := ctr.Listen(addr); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		d, err := ctr.NewDialer(addr, nil)
+		if err != nil {
+			t.Fatalf("new dialer error: %s", err)
+		}
+		if err = d.Dial(); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		if state := d.State(); !state.Connected || state.Dialing || state.BackingOff || state.Closed {
+			t.Errorf("connected state: %+v", state)
+		}
+	})
+}
+
+func TestSocketMsgFromAddresses(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("tcp://127.0.0.1:23958")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	if err = clisock.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	msg, err := srvsock.RecvMsgTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("recv error: %s", err)
+	}
+	defer msg.FreeAll()
+
+	cliAddr := clisock.Connector().Pipes()[0].LocalAddress()
+	local, remote := msg.FromAddresses()
+	if local != "tcp://127.0.0.1:23958" || remote != cliAddr {
+		t.Errorf("from addresses: %s, %s, expected: tcp://127.0.0.1:23958, %s", local, remote, cliAddr)
+	}
+}
+
+func TestSocketRecvFrom(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("tcp://127.0.0.1:23981")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	if err = clisock.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	content, from, err := srvsock.RecvFrom()
+	if err != nil {
+		t.Fatalf("recv error: %s", err)
+	}
+	if string(content) != "hello" {
+		t.Errorf("content: %s, expected: hello", content)
+	}
+	if cliAddr := clisock.Connector().Pipes()[0].LocalAddress(); from != cliAddr {
+		t.Errorf("from: %s, expected: %s", from, cliAddr)
+	}
+}
+
+func TestConnectorListenerCloseGracefully(t *testing.T) {
+	addr := "tcp://127.0.0.1:23959"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+
+	t.Run("Drain", func(t *testing.T) {
+		l, err := srvsock.Connector().NewListener(addr, nil)
+		if err != nil {
+			t.Fatalf("new listener error: %s", err)
+		}
+		if err = l.Listen(); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		clisocks := []multisocket.Socket{multisocket.New(nil), multisocket.New(nil)}
+		for _, clisock := range clisocks {
+			defer clisock.Close()
+			if err = clisock.Dial(addr); err != nil {
+				t.Fatalf("dial error: %s", err)
+			}
+		}
+		if !waitUntil(time.Second, func() bool { return len(srvsock.Connector().Pipes()) == 2 }) {
+			t.Fatalf("pipes not connected")
+		}
+
+		closedq := make(chan error, 1)
+		go func() {
+			closedq <- l.CloseGracefully(0)
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		// no new connections
+		newsock := multisocket.New(nil)
+		defer newsock.Close()
+		if err = newsock.Dial(addr); err == nil {
+			t.Errorf("dial to closed listener succeeded")
+		}
+
+		// existing pipes still work
+		for _, clisock := range clisocks {
+			if err = clisock.Send([]byte("ping")); err != nil {
+				t.Fatalf("send error: %s", err)
+			}
+			msg, err := srvsock.RecvMsgTimeout(time.Second)
+			if err != nil {
+				t.Fatalf("recv error: %s", err)
+			}
+			if err = srvsock.SendTo(msg.Source, []byte("pong")); err != nil {
+				t.Fatalf("reply error: %s", err)
+			}
+			msg.FreeAll()
+			if content, err := clisock.RecvTimeout(time.Second); err != nil || string(content) != "pong" {
+				t.Errorf("recv reply: %q, %v", content, err)
+			}
+		}
+
+		select {
+		case err = <-closedq:
+			t.Fatalf("closed with pipes remaining: %v", err)
+		default:
+		}
+		for _, clisock := range clisocks {
+			clisock.Close()
+		}
+		select {
+		case err = <-closedq:
+			if err != nil {
+				t.Errorf("close gracefully error: %s", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("close gracefully not return")
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		l, err := srvsock.Connector().NewListener(addr, nil)
+		if err != nil {
+			t.Fatalf("new listener error: %s", err)
+		}
+		if err = l.Listen(); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		clisock := multisocket.New(nil)
+		defer clisock.Close()
+		if err = clisock.Dial(addr); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		if !waitUntil(time.Second, func() bool { return len(srvsock.Connector().Pipes()) == 1 }) {
+			t.Fatalf("pipe not connected")
+		}
+		if err = l.CloseGracefully(50 * time.Millisecond); err != errs.ErrTimeout {
+			t.Errorf("close gracefully error: %v", err)
+		}
+		if !waitUntil(time.Second, func() bool { return len(srvsock.Connector().Pipes()) == 0 }) {
+			t.Errorf("survivors not closed")
+		}
+	})
+}
+
+func TestSocketClientID(t *testing.T) {
+	type announce struct {
+		pid uint32
+		id  string
+	}
+	announceq := make(chan announce, 4)
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	srvsock.OnClientID(func(p connector.Pipe, id []byte) {
+		announceq <- announce{p.ID(), string(id)}
+	})
+	if err := srvsock.Listen("tcp://127.0.0.1:23960"); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	clisock := multisocket.New(options.OptionValues{
+		multisocket.Options.ClientID:              []byte("client-1"),
+		connector.Options.Dialer.MinReconnectTime: 10 * time.Millisecond,
+	})
+	defer clisock.Close()
+	if err := clisock.Dial("tcp://127.0.0.1:23960"); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+
+	var pids []uint32
+	for i := 0; i < 2; i++ {
+		select {
+		case a := <-announceq:
+			if a.id != "client-1" {
+				t.Errorf("client id: %q", a.id)
+			}
+			pids = append(pids, a.pid)
+		case <-time.After(time.Second):
+			t.Fatalf("client id not announced")
+		}
+
+		// messages from the client arrive on the announced pipe
+		if err := clisock.Send([]byte("hello")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		msg, err := srvsock.RecvMsgTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+		if pid := msg.Source.CurID(); pid != pids[i] {
+			t.Errorf("message from pipe %d, announced pipe %d", pid, pids[i])
+		}
+		msg.FreeAll()
+
+		// disconnect, client redials
+		srvsock.ClosePipe(pids[i])
+	}
+	if pids[0] == pids[1] {
+		t.Errorf("redialed on the same pipe %d", pids[0])
+	}
+}
+
+func TestSocketSendAllSlowPipe(t *testing.T) {
+	// srvsock broadcasts to two fast peers and a slow peer which accepts but never reads
+	prepare := func(t *testing.T, port int, ovs options.OptionValues) (srvsock multisocket.Socket, fastsocks []multisocket.Socket, done func()) {
+		ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port+2))
+		if err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			time.Sleep(10 * time.Second)
+		}()
+
+		ovs[multisocket.Options.SendQueueSize] = uint16(4)
+		ovs[multisocket.Options.SendStopTimeout] = 100 * time.Millisecond
+		srvsock = multisocket.New(ovs)
+		fastovs := options.OptionValues{connector.Options.Pipe.MaxRecvContentLength: uint32(0)}
+		fastsocks = []multisocket.Socket{multisocket.New(fastovs), multisocket.New(fastovs)}
+		done = func() {
+			srvsock.Close()
+			for _, fastsock := range fastsocks {
+				fastsock.Close()
+			}
+			ln.Close()
+		}
+		for i, fastsock := range fastsocks {
+			addr := fmt.Sprintf("tcp://127.0.0.1:%d", port+i)
+			if err = fastsock.Listen(addr); err != nil {
+				t.Fatalf("listen error: %s", err)
+			}
+			if err = srvsock.Dial(addr); err != nil {
+				t.Fatalf("dial error: %s", err)
+			}
+		}
+		if err = srvsock.Dial(fmt.Sprintf("tcp://127.0.0.1:%d", port+2)); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		if !waitUntil(time.Second, func() bool { return len(srvsock.Connector().Pipes()) == 3 }) {
+			t.Fatalf("pipes not connected")
+		}
+		return
+	}
+	// enough to fill the slow pipe's socket buffers and queue
+	const N = 200
+	content := genRandomContent(64 * 1024)
+	// SendAll never waits for the stuck slow pipe, the fast pipes keep receiving all broadcasts.
+	broadcast := func(t *testing.T, srvsock multisocket.Socket, fastsocks []multisocket.Socket, slowAddr string) {
+		sent := make(chan error, 1)
+		for i := 0; i < N; i++ {
+			go func() { sent <- srvsock.SendAll(content) }()
+			select {
+			case err := <-sent:
+				if err != nil {
+					t.Fatalf("send all error: %s", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("send all %d blocked", i)
+			}
+			for _, fastsock := range fastsocks {
+				if _, err := fastsock.RecvTimeout(time.Second); err != nil {
+					t.Fatalf("broadcast %d recv error: %s", i, err)
+				}
+			}
+		}
+		for _, p := range srvsock.Connector().Pipes() {
+			if p.RemoteAddress() == slowAddr && p.MsgsSent() >= N {
+				t.Errorf("slow pipe sent %d broadcasts", p.MsgsSent())
+			}
+		}
+	}
+
+	t.Run("BestEffort", func(t *testing.T) {
+		srvsock, fastsocks, done := prepare(t, 23961, options.OptionValues{
+			multisocket.Options.SendBestEffort: true,
+		})
+		defer done()
+		broadcast(t, srvsock, fastsocks, "tcp://127.0.0.1:23963")
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		srvsock, fastsocks, done := prepare(t, 23964, options.OptionValues{})
+		defer done()
+		broadcast(t, srvsock, fastsocks, "tcp://127.0.0.1:23966")
+	})
+}
+
+type fakeMetricsCollector struct {
+	sent, sentBytes, recv, recvBytes, dropped int64
+	sendDepth, recvDepth                      int64
+}
+
+func (c *fakeMetricsCollector) MsgSent(bytes int) {
+	atomic.AddInt64(&c.sent, 1)
+	atomic.AddInt64(&c.sentBytes, int64(bytes))
+}
+
+func (c *fakeMetricsCollector) MsgRecv(bytes int) {
+	atomic.AddInt64(&c.recv, 1)
+	atomic.AddInt64(&c.recvBytes, int64(bytes))
+}
+
+func (c *fakeMetricsCollector) MsgDropped() {
+	atomic.AddInt64(&c.dropped, 1)
+}
+
+func (c *fakeMetricsCollector) SendQueueDepth(n int) {
+	atomic.StoreInt64(&c.sendDepth, int64(n))
+}
+
+func (c *fakeMetricsCollector) RecvQueueDepth(n int) {
+	atomic.StoreInt64(&c.recvDepth, int64(n))
+}
+
+func (c *fakeMetricsCollector) snapshot() fakeMetricsCollector {
+	return fakeMetricsCollector{
+		sent:      atomic.LoadInt64(&c.sent),
+		sentBytes: atomic.LoadInt64(&c.sentBytes),
+		recv:      atomic.LoadInt64(&c.recv),
+		recvBytes: atomic.LoadInt64(&c.recvBytes),
+		dropped:   atomic.LoadInt64(&c.dropped),
+		sendDepth: atomic.LoadInt64(&c.sendDepth),
+		recvDepth: atomic.LoadInt64(&c.recvDepth),
+	}
+}
+
+func TestSocketMetricsCollector(t *testing.T) {
+	t.Run("Exchange", func(t *testing.T) {
+		srvsock, clisock, err := prepareSocks("tcp://127.0.0.1:23967")
+		if err != nil {
+			t.Fatalf("connect error: %s", err)
+		}
+		defer srvsock.Close()
+		defer clisock.Close()
+		srvc, clic := &fakeMetricsCollector{}, &fakeMetricsCollector{}
+		srvsock.SetMetricsCollector(srvc)
+		clisock.SetMetricsCollector(clic)
+
+		const N = 3
+		for i := 0; i < N; i++ {
+			if err = clisock.Send([]byte("hello")); err != nil {
+				t.Fatalf("send error: %s", err)
+			}
+		}
+		if !waitUntil(time.Second, func() bool { return atomic.LoadInt64(&srvc.recv) == N }) {
+			t.Fatalf("recv %d messages", atomic.LoadInt64(&srvc.recv))
+		}
+		if d := atomic.LoadInt64(&srvc.recvDepth); d != N {
+			t.Errorf("recv queue depth: %d", d)
+		}
+		for i := 0; i < N; i++ {
+			if _, err = srvsock.RecvTimeout(time.Second); err != nil {
+				t.Fatalf("recv error: %s", err)
+			}
+		}
+
+		if m := clic.snapshot(); m.sent != N || m.sentBytes != N*5 || m.dropped != 0 {
+			t.Errorf("client metrics: %+v", m)
+		}
+		if m := srvc.snapshot(); m.recvBytes != N*5 || m.recvDepth != 0 || m.dropped != 0 {
+			t.Errorf("server metrics: %+v", m)
+		}
+	})
+
+	t.Run("Dropped", func(t *testing.T) {
+		// no pipes, messages stay queued
+		sock := multisocket.New(options.OptionValues{
+			multisocket.Options.SendBestEffort: true,
+			multisocket.Options.SendQueueSize:  uint16(1),
+		})
+		defer sock.Close()
+		c := &fakeMetricsCollector{}
+		sock.SetMetricsCollector(c)
+
+		sock.Send([]byte("hello"))
+		sock.Send([]byte("hello"))
+		sock.Send([]byte("hello"))
+		if m := c.snapshot(); m.sendDepth != 1 || m.dropped != 2 || m.sent != 0 {
+			t.Errorf("metrics: %+v", m)
+		}
+	})
+}
+
+func TestSocketStats(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("tcp://127.0.0.1:23997")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	const N = 3
+	for i := 0; i < N; i++ {
+		if err = clisock.Send([]byte("hello")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		msg, err := srvsock.RecvMsgTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+		if err = srvsock.SendTo(msg.Source, []byte("hi")); err != nil {
+			t.Fatalf("reply error: %s", err)
+		}
+		msg.FreeAll()
+		if _, err = clisock.RecvTimeout(time.Second); err != nil {
+			t.Fatalf("recv reply error: %s", err)
+		}
+	}
+
+	// sender's stats are updated after message sent
+	waitUntil(time.Second, func() bool { return clisock.Stats().MsgsSent == N && srvsock.Stats().MsgsSent == N })
+	expected := multisocket.Stats{BytesSent: N * 5, BytesRecv: N * 2, MsgsSent: N, MsgsRecv: N, Pipes: 1}
+	if stats := clisock.Stats(); stats != expected {
+		t.Errorf("client stats: %+v, expected: %+v", stats, expected)
+	}
+	expected.BytesSent, expected.BytesRecv = expected.BytesRecv, expected.BytesSent
+	if stats := srvsock.Stats(); stats != expected {
+		t.Errorf("server stats: %+v, expected: %+v", stats, expected)
+	}
+}
+
+func TestSocketRawListener(t *testing.T) {
+	sock := multisocket.New(nil)
+	defer sock.Close()
+
+	// recvLine recv raw bytes until a newline
+	recvLine := func(t *testing.T) (line string, source message.MsgPath) {
+		var buf []byte
+		for !bytes.HasSuffix(buf, []byte("\n")) {
+			msg, err := sock.RecvMsgTimeout(time.Second)
+			if err != nil {
+				t.Fatalf("recv error: %s", err)
+			}
+			buf = append(buf, msg.Content...)
+			source = msg.Source
+			msg.FreeAll()
+		}
+		return string(buf), source
+	}
+
+	t.Run("Listen", func(t *testing.T) {
+		l, err := sock.NewRawListener("tcp://127.0.0.1:23970")
+		if err != nil {
+			t.Fatalf("new raw listener error: %s", err)
+		}
+		if err = l.Listen(); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		defer l.Close()
+
+		conn, err := net.Dial("tcp", "127.0.0.1:23970")
+		if err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		defer conn.Close()
+		recvRawConnected(t, sock)
+
+		r := bufio.NewReader(conn)
+		for _, req := range []string{"ping", "hello"} {
+			if _, err = conn.Write([]byte(req + "\n")); err != nil {
+				t.Fatalf("write error: %s", err)
+			}
+			line, source := recvLine(t)
+			if line != req+"\n" {
+				t.Errorf("recv %q", line)
+			}
+			if err = sock.SendTo(source, []byte("echo "+line)); err != nil {
+				t.Fatalf("send error: %s", err)
+			}
+			if s, err := r.ReadString('\n'); err != nil || s != "echo "+req+"\n" {
+				t.Errorf("read %q, %v", s, err)
+			}
+		}
+	})
+
+	t.Run("Dial", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:23971")
+		if err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		defer ln.Close()
+		if err = sock.RawDial("tcp://127.0.0.1:23971"); err != nil {
+			t.Fatalf("raw dial error: %s", err)
+		}
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatalf("accept error: %s", err)
+		}
+		defer conn.Close()
+		recvRawConnected(t, sock)
+
+		if _, err = conn.Write([]byte("hello\n")); err != nil {
+			t.Fatalf("write error: %s", err)
+		}
+		line, source := recvLine(t)
+		if line != "hello\n" {
+			t.Errorf("recv %q", line)
+		}
+		if err = sock.SendTo(source, []byte("world\n")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		if s, err := bufio.NewReader(conn).ReadString('\n'); err != nil || s != "world\n" {
+			t.Errorf("read %q, %v", s, err)
+		}
+	})
+}
+
+func TestPipeCloseWrite(t *testing.T) {
+	sock := multisocket.New(nil)
+	defer sock.Close()
+	l, err := sock.NewRawListener("tcp://127.0.0.1:23972")
+	if err != nil {
+		t.Fatalf("new raw listener error: %s", err)
+	}
+	if err = l.Listen(); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	conn, err := net.Dial("tcp", "127.0.0.1:23972")
+	if err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	defer conn.Close()
+	recvRawConnected(t, sock)
+	p := sock.Connector().Pipes()[0]
+
+	for _, s := range []string{"hello ", "world"} {
+		if err = sock.SendToPipe(p.ID(), []byte(s)); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+	if !waitUntil(time.Second, func() bool { return p.BytesSent() == 11 }) {
+		t.Fatalf("%d bytes sent", p.BytesSent())
+	}
+	if err = p.CloseWrite(); err != nil {
+		t.Fatalf("close write error: %s", err)
+	}
+
+	// peer drains sent data, then sees EOF
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if b, err := ioutil.ReadAll(conn); err != nil || string(b) != "hello world" {
+		t.Errorf("read %q, %v", b, err)
+	}
+
+	// pipe still receives
+	if _, err = conn.Write([]byte("bye")); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	if b, err := sock.RecvTimeout(time.Second); err != nil || string(b) != "bye" {
+		t.Errorf("recv %q, %v", b, err)
+	}
+}
+
+func TestSocketRecvBatch(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("tcp://127.0.0.1:23973")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	for i := 0; i < 5; i++ {
+		if err = clisock.Send([]byte{byte(i)}); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+	checkBatch := func(msgs []*message.Message, from, n int) {
+		if len(msgs) != n {
+			t.Errorf("recv %d messages, expected %d", len(msgs), n)
+		}
+		for i, msg := range msgs {
+			if msg.Content[0] != byte(from+i) {
+				t.Errorf("recv %v, expected %d", msg.Content, from+i)
+			}
+			msg.FreeAll()
+		}
+	}
+
+	t.Run("Filled", func(t *testing.T) {
+		start := time.Now()
+		msgs, err := srvsock.RecvBatch(3, time.Second)
+		if err != nil {
+			t.Fatalf("recv batch error: %s", err)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Errorf("filled batch returned after %s", d)
+		}
+		checkBatch(msgs, 0, 3)
+	})
+
+	t.Run("Partial", func(t *testing.T) {
+		start := time.Now()
+		msgs, err := srvsock.RecvBatch(5, 100*time.Millisecond)
+		if err != nil {
+			t.Fatalf("recv batch error: %s", err)
+		}
+		if d := time.Since(start); d < 100*time.Millisecond {
+			t.Errorf("partial batch returned after %s", d)
+		}
+		checkBatch(msgs, 3, 2)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		if msgs, err := srvsock.RecvBatch(5, 50*time.Millisecond); err != multisocket.ErrTimeout || len(msgs) != 0 {
+			t.Errorf("recv batch: %d messages, %v", len(msgs), err)
+		}
+	})
+}
+
+func TestConnectorPipeLimitByScheme(t *testing.T) {
+	srvsock := multisocket.New(options.OptionValues{
+		connector.Options.PipeLimitByScheme: map[string]int{"inproc": 3, "inproc.netpipe": 1},
+	})
+	defer srvsock.Close()
+	addrs := []string{"inproc://limit_by_scheme_test", "inproc.netpipe://limit_by_scheme_test"}
+	for _, addr := range addrs {
+		if err := srvsock.Listen(addr); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		for _, addr := range addrs {
+			clisock := multisocket.New(nil)
+			defer clisock.Close()
+			if err := clisock.Dial(addr); err != nil {
+				t.Fatalf("dial error: %s", err)
+			}
+		}
+	}
+
+	// inproc is an alias of inproc.channel.msr
+	countPipes := func() map[string]int {
+		counts := make(map[string]int)
+		for _, p := range srvsock.Connector().Pipes() {
+			counts[p.Transport().Scheme()]++
+		}
+		return counts
+	}
+	if !waitUntil(time.Second, func() bool {
+		counts := countPipes()
+		return counts["inproc.channel.msr"] == 3 && counts["inproc.netpipe"] == 1
+	}) {
+		t.Fatalf("pipes: %v", countPipes())
+	}
+	// limits hold
+	time.Sleep(200 * time.Millisecond)
+	if counts := countPipes(); counts["inproc.channel.msr"] != 3 || counts["inproc.netpipe"] != 1 {
+		t.Errorf("pipes: %v", counts)
+	}
+}
+
+func TestConnectorPipeChannel(t *testing.T) {
+	addr := "inproc://pipe_channel_test"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	// never read
+	unread := make(chan connector.Pipe)
+	srvsock.Connector().AddPipeChannel(unread)
+	pipes := make(chan connector.Pipe, 1)
+	srvsock.Connector().AddPipeChannel(pipes)
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	const n = 5
+	for i := 0; i < n; i++ {
+		clisock := multisocket.New(nil)
+		defer clisock.Close()
+		if err := clisock.Dial(addr); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+	}
+	if !waitUntil(time.Second, func() bool {
+		return len(srvsock.Connector().Pipes()) == n
+	}) {
+		t.Fatalf("pipes %d != %d", len(srvsock.Connector().Pipes()), n)
+	}
+
+	// slow consumer gets all pipes eventually
+	ids := make(map[uint32]bool)
+	for i := 0; i < n; i++ {
+		select {
+		case p := <-pipes:
+			ids[p.ID()] = true
+		case <-time.After(time.Second):
+			t.Fatalf("wait pipe timeout")
+		}
+	}
+	for _, p := range srvsock.Connector().Pipes() {
+		if !ids[p.ID()] {
+			t.Errorf("pipe %d not delivered", p.ID())
+		}
+	}
+
+	srvsock.Connector().RemovePipeChannel(pipes)
+	clisock := multisocket.New(nil)
+	defer clisock.Close()
+	if err := clisock.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	select {
+	case p := <-pipes:
+		t.Errorf("pipe %d delivered to removed channel", p.ID())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSocketResizeQueues(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("inproc://resize_queues_test")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	const N = 5000
+	go func() {
+		for i := 0; i < N; i++ {
+			if err := clisock.Send([]byte(strconv.Itoa(i))); err != nil {
+				return
+			}
+		}
+	}()
+	// resize while messages are in flight
+	go func() {
+		for i := 0; i < 20; i++ {
+			size := uint16(1 + i%3*32)
+			srvsock.SetOption(multisocket.Options.RecvQueueSize, size)
+			clisock.SetOption(multisocket.Options.SendQueueSize, size)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	received := make(map[int]bool)
+	for len(received) < N {
+		content, err := srvsock.RecvTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("recv error: %s, received %d/%d", err, len(received), N)
+		}
+		i, err := strconv.Atoi(string(content))
+		if err != nil || received[i] {
+			t.Fatalf("bad or duplicated content %q", content)
+		}
+		received[i] = true
+		if len(received)%100 == 0 {
+			// let queues fill up
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestPipeIdleTimeout(t *testing.T) {
+	addr := "inproc://pipe_idle_timeout_test"
+	srvsock := multisocket.New(options.OptionValues{connector.Options.Pipe.IdleTimeout: 200 * time.Millisecond})
+	defer srvsock.Close()
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	connect := func() multisocket.Socket {
+		sock := multisocket.New(options.OptionValues{connector.Options.Dialer.Reconnect: false})
+		if err := sock.Dial(addr); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		return sock
+	}
+	active := connect()
+	defer active.Close()
+	idle := connect()
+	defer idle.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(50 * time.Millisecond):
+				active.Send([]byte("hello"))
+			}
+		}
+	}()
+
+	if !waitUntil(time.Second, func() bool { return len(idle.Connector().Pipes()) == 0 }) {
+		t.Fatalf("idle pipe not closed")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if len(active.Connector().Pipes()) != 1 {
+		t.Errorf("active pipe closed")
+	}
+	if n := len(srvsock.Connector().Pipes()); n != 1 {
+		t.Errorf("%d pipes, expected 1", n)
+	}
+}
+
+func TestConnectorIDGenerator(t *testing.T) {
+	addr := "inproc://id_generator_test"
+	srv := connector.NewWithIDGenerator(utils.NewRecyclableIDGenerator())
+	defer srv.Close()
+	if err := srv.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		cli := connector.NewWithOptionValues(nil)
+		defer cli.Close()
+		if err := cli.Dial(addr); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		// wait accepted one by one, so ids are in dial order
+		if !waitUntil(time.Second, func() bool {
+			return len(srv.Pipes()) == i
+		}) {
+			t.Fatalf("pipes %d != %d", len(srv.Pipes()), i)
+		}
+		if p := srv.GetPipe(uint32(i)); p == nil {
+			t.Errorf("pipe %d not found", i)
+		}
+	}
+}
+
+func TestSocketNewWith(t *testing.T) {
+	sock := multisocket.NewWith(
+		multisocket.WithSendQueueSize(1024),
+		multisocket.WithRecvQueueSize(512),
+		multisocket.WithDialAsync(true),
+		multisocket.WithOption(multisocket.Options.SendTTL, uint8(3)),
+	)
+	defer sock.Close()
+
+	for opt, val := range map[options.Option]interface{}{
+		multisocket.Options.SendQueueSize:        uint16(1024),
+		multisocket.Options.RecvQueueSize:        uint16(512),
+		connector.Options.Dialer.DialAsync:       true,
+		multisocket.Options.SendTTL:              uint8(3),
+		multisocket.Options.MaxSendContentLength: uint32(0),
+	} {
+		if v := sock.GetOptionDefault(opt); v != val {
+			t.Errorf("option %s: %v != %v", opt, v, val)
+		}
+	}
+}
+
+func TestSocketSendMsgValidate(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("inproc://send_msg_validate_test")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	// wrong length is repaired
+	msg := message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, []byte("hello"))
+	msg.Length = 1024
+	if err := clisock.SendMsg(msg); err != nil {
+		t.Fatalf("SendMsg error: %s", err)
+	}
+	// replaced content is repacked
+	msg = message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, []byte("hello"))
+	msg.Content = []byte("hello world")
+	if err := clisock.SendMsg(msg); err != nil {
+		t.Fatalf("SendMsg error: %s", err)
+	}
+	for _, content := range []string{"hello", "hello world"} {
+		msg, err := srvsock.RecvMsgTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("RecvMsg error: %s", err)
+		}
+		if string(msg.Content) != content || msg.Length != uint32(len(content)) {
+			t.Errorf("RecvMsg content %q, length %d", msg.Content, msg.Length)
+		}
+		msg.FreeAll()
+	}
+
+	// malformed destination is rejected
+	msg = message.NewSendMessage(0, message.SendTypeToDest, 0, nil, []byte{0, 0, 0, 1}, []byte("hello"))
+	msg.Destination = msg.Destination[:3]
+	if err := clisock.SendMsg(msg); err != errs.ErrBadMsg {
+		t.Errorf("SendMsg malformed destination error: %v", err)
+	}
+}
+
+func TestSocketSendMsgCopy(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("inproc://send_msg_copy_test")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+	// sending modifies messages' content
+	clisock.SetOption(multisocket.Options.SendChecksum, true)
+
+	// caller retains msg
+	msg := message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, []byte("hello"))
+	ttl := msg.TTL
+	if err := clisock.SendMsgCopy(msg); err != nil {
+		t.Fatalf("SendMsgCopy error: %s", err)
+	}
+	if string(msg.Content) != "hello" || msg.TTL != ttl || msg.HasFlags(message.MsgFlagChecksum) {
+		t.Errorf("msg modified: %q, ttl %d, flags %x", msg.Content, msg.TTL, msg.Flags)
+	}
+	msg.Content[0] = 'j'
+	if err := clisock.SendMsgCopy(msg); err != nil {
+		t.Fatalf("SendMsgCopy error: %s", err)
+	}
+	msg.FreeAll()
+
+	// socket takes msg
+	msg = message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, []byte("world"))
+	if err := clisock.SendMsg(msg); err != nil {
+		t.Fatalf("SendMsg error: %s", err)
+	}
+
+	for _, content := range []string{"hello", "jello", "world"} {
+		msg, err := srvsock.RecvMsgTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("RecvMsg error: %s", err)
+		}
+		if string(msg.Content) != content {
+			t.Errorf("RecvMsg content %q, expected: %q", msg.Content, content)
+		}
+		msg.FreeAll()
+	}
+}
+
+func TestPipeUserData(t *testing.T) {
+	addr := "inproc://pipe_user_data_test"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	var clients int32
+	srvsock.OnPipeEvent(func(e connector.PipeEvent, p connector.Pipe) {
+		if e == connector.PipeEventAdd {
+			p.SetUserData(fmt.Sprintf("client-%d", atomic.AddInt32(&clients, 1)))
+		}
+	})
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		clisock := multisocket.New(nil)
+		defer clisock.Close()
+		if err := clisock.Dial(addr); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		if !waitUntil(time.Second, func() bool { return atomic.LoadInt32(&clients) == int32(i) }) {
+			t.Fatalf("client %d not connected", i)
+		}
+		if err := clisock.Send([]byte(fmt.Sprintf("client-%d", i))); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		msg, err := srvsock.RecvMsgTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+		p := srvsock.Connector().GetPipe(msg.PipeID())
+		if p == nil {
+			t.Fatalf("pipe %d not found", msg.PipeID())
+		}
+		if data, _ := p.UserData().(string); data != string(msg.Content) {
+			t.Errorf("user data %q, expected: %q", data, msg.Content)
+		}
+		msg.FreeAll()
+	}
+}
+
+func TestSocketRecvFilter(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("inproc://recv_filter_test")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+	// filter sees decompressed content
+	clisock.SetOption(multisocket.Options.Compression, message.CompressionGzip)
+
+	// drop messages starting with '#', strip routing prefix of others
+	srvsock.SetRecvFilter(func(msg *message.Message) (*message.Message, bool) {
+		if len(msg.Content) > 0 && msg.Content[0] == '#' {
+			return nil, false
+		}
+		msg.Content = bytes.TrimPrefix(msg.Content, []byte("route:"))
+		return msg, true
+	})
+	for _, content := range []string{"#comment", "route:hello", "world"} {
+		if err := clisock.Send([]byte(content)); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+	for _, expected := range []string{"hello", "world"} {
+		content, err := srvsock.RecvTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+		if string(content) != expected {
+			t.Errorf("recv %q, expected: %q", content, expected)
+		}
+	}
+
+	// removed
+	srvsock.SetRecvFilter(nil)
+	if err := clisock.Send([]byte("#comment")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if content, err := srvsock.RecvTimeout(time.Second); err != nil || string(content) != "#comment" {
+		t.Errorf("recv %q, error: %v", content, err)
+	}
+}
+
+func TestSocketWaitConnected(t *testing.T) {
+	addr := "inproc://wait_connected_test"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	clisock := multisocket.New(options.OptionValues{connector.Options.Dialer.DialAsync: true})
+	defer clisock.Close()
+
+	// nothing to connect to yet
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := clisock.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	if err := clisock.WaitConnected(ctx); err != context.DeadlineExceeded {
+		t.Errorf("WaitConnected error: %v", err)
+	}
+
+	errq := make(chan error, 1)
+	go func() {
+		errq <- srvsock.WaitConnected(context.Background())
+	}()
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	for _, sock := range []multisocket.Socket{srvsock, clisock} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := sock.WaitConnected(ctx); err != nil {
+			t.Errorf("WaitConnected error: %s", err)
+		}
+		cancel()
+	}
+	select {
+	case err := <-errq:
+		if err != nil {
+			t.Errorf("WaitConnected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("WaitConnected not returned")
+	}
+
+	// closed
+	clisock.Close()
+	if err := clisock.WaitConnected(context.Background()); err != errs.ErrClosed {
+		t.Errorf("WaitConnected closed socket error: %v", err)
+	}
+}
+
+func TestSocketPipeCount(t *testing.T) {
+	addr := "inproc://pipe_count_test"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	if n := srvsock.PipeCount(); n != 0 {
+		t.Errorf("PipeCount %d != 0", n)
+	}
+
+	clisocks := make([]multisocket.Socket, 3)
+	for i := range clisocks {
+		clisocks[i] = multisocket.New(nil)
+		defer clisocks[i].Close()
+		if err := clisocks[i].Dial(addr); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		if !waitUntil(time.Second, func() bool {
+			return srvsock.PipeCount() == i+1
+		}) {
+			t.Errorf("PipeCount %d != %d", srvsock.PipeCount(), i+1)
+		}
+		if n := clisocks[i].PipeCount(); n != 1 {
+			t.Errorf("client PipeCount %d != 1", n)
+		}
+	}
+
+	for i, clisock := range clisocks {
+		clisock.Close()
+		if !waitUntil(time.Second, func() bool {
+			return srvsock.PipeCount() == len(clisocks)-i-1
+		}) {
+			t.Errorf("PipeCount %d != %d", srvsock.PipeCount(), len(clisocks)-i-1)
+		}
+	}
+}
+
+func TestSocketSendRateLimit(t *testing.T) {
+	const (
+		rate  = 100 * 1024
+		burst = 10 * 1024
+		sz    = 1024
+		count = 60
+	)
+	addr := "inproc://send_rate_limit_test"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	clisock := multisocket.New(options.OptionValues{
+		multisocket.Options.SendRateLimit: int64(rate),
+		multisocket.Options.SendRateBurst: int64(burst),
+	})
+	defer clisock.Close()
+	if err := clisock.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+
+	go func() {
+		for {
+			msg, err := srvsock.RecvMsg()
+			if err != nil {
+				return
+			}
+			msg.FreeAll()
+		}
+	}()
+
+	content := make([]byte, sz)
+	start := time.Now()
+	for i := 0; i < count; i++ {
+		if err := clisock.Send(content); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+	// burst is sent at once, the rest at rate
+	expected := time.Duration(float64(count*sz-burst) / rate * float64(time.Second))
+	if elapsed := time.Since(start); elapsed < expected*8/10 || elapsed > expected*15/10 {
+		t.Errorf("sent in %s, expected %s", elapsed, expected)
+	}
+
+	// best effort drops throttled messages
+	clisock.SetOption(multisocket.Options.SendBestEffort, true)
+	clisock.SetOption(multisocket.Options.SendRateBurst, int64(burst))
+	dropped := 0
+	for i := 0; i < count; i++ {
+		if err := clisock.Send(content); err == multisocket.ErrMsgDropped {
+			dropped++
+		} else if err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+	if dropped < count-burst/sz-2 || dropped > count-burst/sz {
+		t.Errorf("dropped %d/%d", dropped, count)
+	}
+}
+
+type upperCodec struct{}
+
+func (upperCodec) Marshal(v interface{}) ([]byte, error) {
+	return bytes.ToUpper([]byte(v.(string))), nil
+}
+
+func (upperCodec) Unmarshal(b []byte, v interface{}) error {
+	*v.(*string) = string(b)
+	return nil
+}
+
+func TestSocketSendRecvObject(t *testing.T) {
+	type point struct {
+		X, Y int
+		Name string
+	}
+	srvsock, clisock, err := prepareSocks("inproc://send_recv_object_test")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	sent := point{X: 1, Y: -2, Name: "p"}
+	if err := clisock.SendObject(sent); err != nil {
+		t.Fatalf("SendObject error: %s", err)
+	}
+	var recvd point
+	if err := srvsock.RecvObject(&recvd); err != nil {
+		t.Fatalf("RecvObject error: %s", err)
+	}
+	if recvd != sent {
+		t.Errorf("RecvObject %+v != %+v", recvd, sent)
+	}
+
+	// bad content
+	if err := clisock.Send([]byte("not json")); err != nil {
+		t.Fatalf("Send error: %s", err)
+	}
+	if err := srvsock.RecvObject(&recvd); err == nil {
+		t.Errorf("RecvObject bad content without error")
+	}
+
+	// custom codec
+	clisock.SetOption(multisocket.Options.Codec, upperCodec{})
+	srvsock.SetOption(multisocket.Options.Codec, upperCodec{})
+	if err := clisock.SendObject("hello"); err != nil {
+		t.Fatalf("SendObject error: %s", err)
+	}
+	var str string
+	if err := srvsock.RecvObject(&str); err != nil || str != "HELLO" {
+		t.Errorf("RecvObject %q, %v", str, err)
+	}
+}
+
+func TestConnectorClosePipesWhere(t *testing.T) {
+	addrA, addrB := "inproc://close_pipes_where_a", "inproc://close_pipes_where_b"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	for _, addr := range []string{addrA, addrB} {
+		if err := srvsock.Listen(addr); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		for i := 0; i < 2; i++ {
+			clisock := multisocket.New(nil)
+			defer clisock.Close()
+			if err := clisock.DialOptions(addr, options.OptionValues{connector.Options.Dialer.Reconnect: false}); err != nil {
+				t.Fatalf("dial error: %s", err)
+			}
+		}
+	}
+	if !waitUntil(time.Second, func() bool {
+		return srvsock.PipeCount() == 4
+	}) {
+		t.Fatalf("pipes %d != 4", srvsock.PipeCount())
+	}
+
+	// addresses are of the inproc implementation's scheme
+	fromA := func(p connector.Pipe) bool {
+		return strings.HasSuffix(p.LocalAddress(), addrA[len("inproc:"):])
+	}
+	if n := srvsock.ClosePipesWhere(fromA); n != 2 {
+		t.Errorf("closed %d pipes, expected 2", n)
+	}
+	if !waitUntil(time.Second, func() bool {
+		return srvsock.PipeCount() == 2
+	}) {
+		t.Fatalf("pipes %d != 2", srvsock.PipeCount())
+	}
+	for _, p := range srvsock.Pipes() {
+		if !strings.HasSuffix(p.LocalAddress(), addrB[len("inproc:"):]) {
+			t.Errorf("pipe of %s not closed", p.LocalAddress())
+		}
+	}
+	if n := srvsock.ClosePipesWhere(fromA); n != 0 {
+		t.Errorf("closed %d pipes, expected 0", n)
+	}
+}
+
+func TestSocketCloseNoLeak(t *testing.T) {
+	for _, addr := range []string{"inproc://TestSocketCloseNoLeak", "tcp://127.0.0.1:23980"} {
+		t.Run(addr, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			for i := 0; i < 3; i++ {
+				srvsock := multisocket.New(nil)
+				clisock := multisocket.New(nil)
+				if err := srvsock.Listen(addr); err != nil {
+					t.Fatalf("listen error: %s", err)
+				}
+				if err := clisock.Dial(addr); err != nil {
+					t.Fatalf("dial error: %s", err)
+				}
+				if err := clisock.Send([]byte("hello")); err != nil {
+					t.Fatalf("send error: %s", err)
+				}
+				if _, err := srvsock.RecvMsgTimeout(time.Second); err != nil {
+					t.Fatalf("recv error: %s", err)
+				}
+				clisock.Close()
+				srvsock.Close()
+			}
+			// all goroutines must have exited when Close returns
+			if after := runtime.NumGoroutine(); after > before {
+				t.Errorf("goroutines %d > %d after close", after, before)
+			}
+		})
+	}
+}
+
+func TestSocketFlush(t *testing.T) {
+	const count = 50
+	addr := "tcp://127.0.0.1:23986"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	clisock := multisocket.New(nil)
+	defer clisock.Close()
+
+	// queued until connected
+	for i := 0; i < count; i++ {
+		if err := clisock.Send([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := clisock.Flush(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Flush without pipes: %v, expected: %s", err, context.DeadlineExceeded)
+	}
+
+	if err := clisock.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := clisock.Flush(ctx); err != nil {
+		t.Fatalf("Flush error: %s", err)
+	}
+	for i := 0; i < count; i++ {
+		content, err := srvsock.RecvTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("recv %d error: %s", i, err)
+		}
+		if string(content) != strconv.Itoa(i) {
+			t.Errorf("recv %s, expected: %d", content, i)
+		}
+	}
+
+	clisock.Close()
+	if err := clisock.Flush(context.Background()); err != errs.ErrClosed {
+		t.Errorf("Flush after close: %v, expected: %s", err, errs.ErrClosed)
+	}
+}
+
+func TestSocketFlushAfterSendAll(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("inproc://flush_sendall_test")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer clisock.Close()
+	for i := 0; i < 5; i++ {
+		if err = clisock.SendAll([]byte("hello")); err != nil {
+			t.Fatalf("send all error: %s", err)
+		}
+		if _, err = srvsock.RecvTimeout(time.Second); err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+	}
+	srvsock.Close()
+	if !waitUntil(time.Second, func() bool { return clisock.Stats().Pipes == 0 }) {
+		t.Fatalf("pipe not removed")
+	}
+
+	// queued without pipes
+	for i := 0; i < 3; i++ {
+		if err = clisock.Send([]byte("hello")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = clisock.Flush(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Flush with queued messages: %v, expected: %s", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSocketProtocolVersion(t *testing.T) {
+	addr := "tcp://127.0.0.1:23989"
+	connect := func(t *testing.T, srvVersion, cliVersion uint8) (srvsock, clisock multisocket.Socket) {
+		srvsock = multisocket.New(options.OptionValues{multisocket.Options.ProtocolVersion: srvVersion})
+		if err := srvsock.Listen(addr); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		clisock = multisocket.New(options.OptionValues{multisocket.Options.ProtocolVersion: cliVersion})
+		if err := clisock.DialOptions(addr, options.OptionValues{connector.Options.Dialer.Reconnect: false}); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		return
+	}
+
+	t.Run("Match", func(t *testing.T) {
+		srvsock, clisock := connect(t, 1, 1)
+		defer srvsock.Close()
+		defer clisock.Close()
+		if err := clisock.Send([]byte("hello")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		if content, err := srvsock.RecvTimeout(time.Second); err != nil || string(content) != "hello" {
+			t.Errorf("recv %q, error: %v", content, err)
+		}
+	})
+
+	onHandshakeFailed := func(failedq chan struct{}, socks ...multisocket.Socket) {
+		for _, sock := range socks {
+			sock.OnPipeEvent(func(e connector.PipeEvent, p connector.Pipe) {
+				if e == connector.PipeEventHandshakeFailed {
+					select {
+					case failedq <- struct{}{}:
+					default:
+					}
+				}
+			})
+		}
+	}
+	waitFailed := func(t *testing.T, failedq <-chan struct{}) {
+		select {
+		case <-failedq:
+		case <-time.After(time.Second):
+			t.Errorf("no handshake failed event")
+		}
+	}
+
+	t.Run("Mismatch", func(t *testing.T) {
+		srvsock := multisocket.New(options.OptionValues{multisocket.Options.ProtocolVersion: uint8(1)})
+		defer srvsock.Close()
+		if err := srvsock.Listen(addr); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		clisock := multisocket.New(options.OptionValues{multisocket.Options.ProtocolVersion: uint8(2)})
+		defer clisock.Close()
+		// the side verified first closes the pipe, the other may not get the handshake
+		failedq := make(chan struct{}, 1)
+		onHandshakeFailed(failedq, srvsock, clisock)
+		if err := clisock.DialOptions(addr, options.OptionValues{connector.Options.Dialer.Reconnect: false}); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		if err := clisock.Send([]byte("hello")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		if content, err := srvsock.RecvTimeout(200 * time.Millisecond); err != multisocket.ErrTimeout {
+			t.Errorf("recv %q, error: %v, expected timeout", content, err)
+		}
+		waitFailed(t, failedq)
+		if !waitUntil(time.Second, func() bool { return clisock.PipeCount() == 0 && srvsock.PipeCount() == 0 }) {
+			t.Errorf("pipes not closed")
+		}
+
+		// still queued, sent to a peer of the same version
+		matchaddr := "tcp://127.0.0.1:24004"
+		matchsock := multisocket.New(options.OptionValues{multisocket.Options.ProtocolVersion: uint8(2)})
+		defer matchsock.Close()
+		if err := matchsock.Listen(matchaddr); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		if err := clisock.Dial(matchaddr); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		if content, err := matchsock.RecvTimeout(time.Second); err != nil || string(content) != "hello" {
+			t.Errorf("recv %q, error: %v", content, err)
+		}
+	})
+
+	t.Run("NoHandshake", func(t *testing.T) {
+		srvsock, clisock := connect(t, 1, 0)
+		defer srvsock.Close()
+		defer clisock.Close()
+		failedq := make(chan struct{}, 1)
+		onHandshakeFailed(failedq, srvsock)
+		if err := clisock.Send([]byte("hello")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		if content, err := srvsock.RecvTimeout(200 * time.Millisecond); err != multisocket.ErrTimeout {
+			t.Errorf("recv %q, error: %v, expected timeout", content, err)
+		}
+		waitFailed(t, failedq)
+		if !waitUntil(time.Second, func() bool { return clisock.PipeCount() == 0 && srvsock.PipeCount() == 0 }) {
+			t.Errorf("pipes not closed")
+		}
+	})
+}
+
+func TestSocketBroadcast(t *testing.T) {
+	const clients = 3
+	addr := "inproc://broadcast_test"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	var clisocks [clients]multisocket.Socket
+	for i := range clisocks {
+		clisocks[i] = multisocket.New(nil)
+		defer clisocks[i].Close()
+		if err := clisocks[i].Dial(addr); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+	}
+	if !waitUntil(time.Second, func() bool { return srvsock.PipeCount() == clients }) {
+		t.Fatalf("PipeCount %d != %d", srvsock.PipeCount(), clients)
+	}
+
+	if err := srvsock.Broadcast([]byte("hello")); err != nil {
+		t.Fatalf("Broadcast error: %s", err)
+	}
+	for i, clisock := range clisocks {
+		if content, err := clisock.RecvTimeout(time.Second); err != nil || string(content) != "hello" {
+			t.Errorf("client %d recv %q, error: %v", i, content, err)
+		}
+	}
+
+	msg := message.NewSendMessage(0, message.SendTypeToDest+1, 0, nil, nil, []byte("hello"))
+	if err := srvsock.SendMsg(msg); err != multisocket.ErrInvalidSendType {
+		t.Errorf("SendMsg invalid send type error: %v", err)
+	}
+}
+
+func TestSocketSendToMany(t *testing.T) {
+	const clients = 5
+	addr := "inproc://send_to_many_test"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	var clisocks [clients]multisocket.Socket
+	for i := range clisocks {
+		clisocks[i] = multisocket.New(nil)
+		defer clisocks[i].Close()
+		if err := clisocks[i].Dial(addr); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		if err := clisocks[i].Send([]byte{byte(i)}); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+	// learn clients' paths
+	var paths [clients]message.MsgPath
+	for range clisocks {
+		msg, err := srvsock.RecvMsgTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+		paths[msg.Content[0]] = append(message.MsgPath(nil), msg.Source...)
+		msg.FreeAll()
+	}
+
+	room := map[int]bool{0: true, 2: true, 3: true}
+	var dests []message.MsgPath
+	for i := range room {
+		dests = append(dests, paths[i])
+	}
+	if err := srvsock.SendToMany(dests, []byte("hello")); err != nil {
+		t.Fatalf("SendToMany error: %s", err)
+	}
+	for i, clisock := range clisocks {
+		content, err := clisock.RecvTimeout(100 * time.Millisecond)
+		if room[i] {
+			if err != nil || string(content) != "hello" {
+				t.Errorf("client %d recv %q, error: %v", i, content, err)
+			}
+		} else if err != multisocket.ErrTimeout {
+			t.Errorf("client %d not in room recv %q, error: %v", i, content, err)
+		}
+	}
+
+	// partial failure
+	var unknown [4]byte
+	binary.BigEndian.PutUint32(unknown[:], 0xffffffff)
+	err := srvsock.SendToMany([]message.MsgPath{paths[1], unknown[:]}, []byte("world"))
+	if derr, ok := err.(*multisocket.DestsError); !ok || len(derr.Errs) != 2 || derr.Errs[0] != nil || derr.Errs[1] != multisocket.ErrBrokenPath {
+		t.Errorf("SendToMany error: %v", err)
+	}
+	if content, err := clisocks[1].RecvTimeout(time.Second); err != nil || string(content) != "world" {
+		t.Errorf("client 1 recv %q, error: %v", content, err)
+	}
+}
+
+func TestConnectorListenerBoundAddress(t *testing.T) {
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+
+	l, err := srvsock.Connector().NewListener("tcp://127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("new listener error: %s", err)
+	}
+	if err = l.Listen(); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	addr := l.BoundAddress()
+	if addr == "tcp://127.0.0.1:0" || !strings.HasPrefix(addr, "tcp://127.0.0.1:") {
+		t.Fatalf("bound address: %s", addr)
+	}
+
+	clisock := multisocket.New(nil)
+	defer clisock.Close()
+	if err = clisock.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	if err = clisock.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if content, err := srvsock.RecvTimeout(time.Second); err != nil || string(content) != "hello" {
+		t.Errorf("recv: %q, %v", content, err)
+	}
+}
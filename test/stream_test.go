@@ -0,0 +1,287 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/options"
+)
+
+func TestSocketStream(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("inproc://stream_test")
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	const N = 10
+	// echo server
+	go func() {
+		for {
+			st, err := srvsock.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func(st multisocket.Stream) {
+				for {
+					content, err := st.Recv()
+					if err != nil {
+						return
+					}
+					if err = st.Send(content); err != nil {
+						return
+					}
+				}
+			}(st)
+		}
+	}()
+
+	streams := make([]multisocket.Stream, 2)
+	for i := range streams {
+		if streams[i], err = clisock.OpenStream(); err != nil {
+			t.Fatalf("open stream error: %s", err)
+		}
+	}
+	if streams[0].ID() == streams[1].ID() {
+		t.Fatalf("streams with same id: %d", streams[0].ID())
+	}
+
+	// interleave streams' traffic
+	for i := 0; i < N; i++ {
+		for j, st := range streams {
+			if err = st.Send([]byte(fmt.Sprintf("stream%d-%d", j, i))); err != nil {
+				t.Fatalf("send error: %s", err)
+			}
+		}
+	}
+	for j, st := range streams {
+		for i := 0; i < N; i++ {
+			content, err := st.Recv()
+			if err != nil {
+				t.Fatalf("recv error: %s", err)
+			}
+			if expected := fmt.Sprintf("stream%d-%d", j, i); string(content) != expected {
+				t.Errorf("recv %q, expected %q", content, expected)
+			}
+		}
+	}
+
+	// peer of a closed stream gets ErrClosed
+	peer, err := srvsock.OpenStream()
+	if err != nil {
+		t.Fatalf("open stream error: %s", err)
+	}
+	if err = peer.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	st, err := clisock.AcceptStream()
+	if err != nil {
+		t.Fatalf("accept stream error: %s", err)
+	}
+	if content, err := st.Recv(); err != nil || string(content) != "hello" {
+		t.Fatalf("recv: %q, %v", content, err)
+	}
+	if err = st.Close(); err != nil {
+		t.Fatalf("close error: %s", err)
+	}
+	if _, err = peer.Recv(); err != errs.ErrClosed {
+		t.Errorf("recv from closed stream: %v", err)
+	}
+	if err = st.Close(); err != errs.ErrClosed {
+		t.Errorf("close again: %v", err)
+	}
+}
+
+func TestSocketStreamUndrained(t *testing.T) {
+	const queueSize = 4
+	srvsock := multisocket.New(options.OptionValues{multisocket.Options.RecvQueueSize: uint16(queueSize)})
+	clisock := multisocket.New(nil)
+	defer srvsock.Close()
+	defer clisock.Close()
+	if err := srvsock.Listen("inproc://stream_undrained_test"); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	if err := clisock.Dial("inproc://stream_undrained_test"); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+
+	stuck, err := clisock.OpenStream()
+	if err != nil {
+		t.Fatalf("open stream error: %s", err)
+	}
+	active, err := clisock.OpenStream()
+	if err != nil {
+		t.Fatalf("open stream error: %s", err)
+	}
+	// more than stuck's peer can queue
+	for i := 0; i < 2*queueSize; i++ {
+		if err = stuck.Send([]byte("stuck")); err != nil {
+			break
+		}
+	}
+	if _, err = srvsock.AcceptStream(); err != nil {
+		t.Fatalf("accept stream error: %s", err)
+	}
+
+	// drained one keeps going
+	var st multisocket.Stream
+	for i := 0; i < 2*queueSize; i++ {
+		if err = active.Send([]byte(fmt.Sprintf("active-%d", i))); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		if st == nil {
+			if st, err = srvsock.AcceptStream(); err != nil {
+				t.Fatalf("accept stream error: %s", err)
+			}
+		}
+		if content, err := st.Recv(); err != nil || string(content) != fmt.Sprintf("active-%d", i) {
+			t.Fatalf("recv %d: %q, %v", i, content, err)
+		}
+	}
+
+	// the undrained stream is failed
+	done := make(chan error, 1)
+	go func() {
+		_, err := stuck.Recv()
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err != errs.ErrClosed {
+			t.Errorf("recv from failed stream: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("stream not failed")
+	}
+
+	// late data of the failed stream is dropped, not accepted as a new stream
+	accepted := make(chan multisocket.Stream, 1)
+	go func() {
+		if st, err := srvsock.AcceptStream(); err == nil {
+			accepted <- st
+		}
+	}()
+	select {
+	case st := <-accepted:
+		t.Errorf("accepted stream %d", st.ID())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSocketStreamFinNoBlock(t *testing.T) {
+	addr := "inproc://stream_fin_no_block_test"
+	srvsock := multisocket.New(options.OptionValues{multisocket.Options.SendQueueSize: uint16(1)})
+	clisock := multisocket.New(options.OptionValues{multisocket.Options.RecvQueueSize: uint16(1)})
+	defer srvsock.Close()
+	defer clisock.Close()
+	if err := srvsock.Listen(addr); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	if err := clisock.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+
+	st, err := clisock.OpenStream()
+	if err != nil {
+		t.Fatalf("open stream error: %s", err)
+	}
+	if err = st.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	peer, err := srvsock.AcceptStream()
+	if err != nil {
+		t.Fatalf("accept stream error: %s", err)
+	}
+
+	// client never recvs, so the server pipe's sender gets stuck
+	go func() {
+		for srvsock.Send([]byte("stuck")) == nil {
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	// and its pipe's send queue fills up
+	go func() {
+		for peer.Send([]byte("stuck")) == nil {
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// peer's fin reply must not block the server pipe's receiver
+	if err = st.Close(); err != nil {
+		t.Fatalf("close error: %s", err)
+	}
+	if err = clisock.Send([]byte("after")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if content, err := srvsock.RecvTimeout(time.Second); err != nil || string(content) != "after" {
+		t.Errorf("recv %q, error: %v", content, err)
+	}
+}
+
+func TestSocketMaxStreams(t *testing.T) {
+	srvsock, clisock, err := prepareSocks("inproc://max_streams_test", options.OptionValues{multisocket.Options.MaxStreams: uint16(2)})
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	streams := make([]multisocket.Stream, 3)
+	for i := range streams {
+		if streams[i], err = clisock.OpenStream(); err != nil {
+			t.Fatalf("open stream error: %s", err)
+		}
+		if err = streams[i].Send([]byte("hello")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+	// refused
+	if _, err = streams[2].Recv(); err != errs.ErrClosed {
+		t.Errorf("recv from refused stream: %v", err)
+	}
+	accepted := make([]multisocket.Stream, 2)
+	for i := range accepted {
+		if accepted[i], err = srvsock.AcceptStream(); err != nil {
+			t.Fatalf("accept stream error: %s", err)
+		}
+	}
+
+	// room for a new one after a stream is closed
+	accepted[0].Close()
+	if _, err = streams[0].Recv(); err != errs.ErrClosed {
+		t.Errorf("recv from closed stream: %v", err)
+	}
+	acceptedq := make(chan multisocket.Stream, 1)
+	go func() {
+		if st, err := srvsock.AcceptStream(); err == nil {
+			acceptedq <- st
+		}
+	}()
+	deadline := time.After(time.Second)
+	for {
+		// may be refused before peer's fin reply arrives
+		st, err := clisock.OpenStream()
+		if err != nil {
+			t.Fatalf("open stream error: %s", err)
+		}
+		if err = st.Send([]byte("hello")); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		refusedq := make(chan struct{})
+		go func() {
+			st.Recv()
+			close(refusedq)
+		}()
+		select {
+		case <-acceptedq:
+			return
+		case <-refusedq:
+		case <-deadline:
+			t.Fatalf("new stream not accepted")
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/options"
+	tlstran "github.com/multisocket/multisocket/transport/tls"
+)
+
+// genSelfSignedCert generate a self signed certificate for 127.0.0.1
+func genSelfSignedCert() (cert tls.Certificate, pool *x509.CertPool, err error) {
+	var (
+		key *ecdsa.PrivateKey
+		der []byte
+	)
+	if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"multisocket"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if der, err = x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key); err != nil {
+		return
+	}
+	var x509Cert *x509.Certificate
+	if x509Cert, err = x509.ParseCertificate(der); err != nil {
+		return
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(x509Cert)
+	cert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return
+}
+
+func TestTLSSendRecv(t *testing.T) {
+	cert, pool, err := genSelfSignedCert()
+	if err != nil {
+		t.Fatalf("generate cert error: %s", err)
+	}
+	addr := "tls://127.0.0.1:23933"
+
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	if err = srvsock.ListenOptions(addr, options.OptionValues{
+		tlstran.Options.Config: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	clisock := multisocket.New(nil)
+	defer clisock.Close()
+	if err = clisock.DialOptions(addr, options.OptionValues{
+		tlstran.Options.Config: &tls.Config{RootCAs: pool},
+	}); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		content := genRandomContent(1024)
+		if err = clisock.Send(content); err != nil {
+			t.Errorf("send error: %s", err)
+		}
+		msg, err := srvsock.RecvMsg()
+		if err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+		if string(msg.Content) != string(content) {
+			t.Errorf("send/recv not equal")
+		}
+		if err = srvsock.SendTo(msg.Source, msg.Content); err != nil {
+			t.Errorf("send to error: %s", err)
+		}
+		msg.FreeAll()
+		if msg, err = clisock.RecvMsg(); err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+		if string(msg.Content) != string(content) {
+			t.Errorf("reply not equal")
+		}
+		msg.FreeAll()
+	}
+
+	// untrusted server certificate
+	badsock := multisocket.New(options.OptionValues{connector.Options.Dialer.Reconnect: false})
+	defer badsock.Close()
+	if err = badsock.DialOptions(addr, options.OptionValues{
+		tlstran.Options.Config: &tls.Config{},
+	}); err == nil {
+		t.Errorf("dial with untrusted certificate should fail")
+	}
+
+	// missing config
+	if err = badsock.Dial(addr); err != tlstran.ErrConfigMissing {
+		t.Errorf("dial without config error: %v", err)
+	}
+}
+
+func TestTLSStalledHandshake(t *testing.T) {
+	cert, pool, err := genSelfSignedCert()
+	if err != nil {
+		t.Fatalf("generate cert error: %s", err)
+	}
+	addr := "tls://127.0.0.1:24002"
+
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	if err = srvsock.ListenOptions(addr, options.OptionValues{
+		tlstran.Options.Config:           &tls.Config{Certificates: []tls.Certificate{cert}},
+		tlstran.Options.HandshakeTimeout: 200 * time.Millisecond,
+	}); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	// never sends a client hello
+	raw, err := net.Dial("tcp", "127.0.0.1:24002")
+	if err != nil {
+		t.Fatalf("raw dial error: %s", err)
+	}
+	defer raw.Close()
+
+	clisock := multisocket.New(nil)
+	defer clisock.Close()
+	if err = clisock.DialOptions(addr, options.OptionValues{
+		tlstran.Options.Config: &tls.Config{RootCAs: pool},
+	}); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	if err = clisock.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	content, err := srvsock.RecvTimeout(100 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("recv behind a stalled handshake error: %s", err)
+	}
+	if string(content) != "hello" {
+		t.Errorf("recv: %q, expected: hello", content)
+	}
+
+	// stalled conn is closed after handshake timeout
+	raw.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = raw.Read(make([]byte, 1)); err == nil {
+		t.Errorf("stalled conn not closed")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Errorf("stalled conn not closed after handshake timeout")
+	}
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport"
+	_ "github.com/multisocket/multisocket/transport/all"
+)
+
+type fakeTransport struct{}
+
+func (fakeTransport) Scheme() string {
+	return "fake"
+}
+
+func (fakeTransport) NewDialer(addr string) (transport.Dialer, error) {
+	return nil, transport.ErrConnRefused
+}
+
+func (fakeTransport) NewListener(addr string) (transport.Listener, error) {
+	return nil, transport.ErrNotListening
+}
+
+func hasScheme(schemes []string, scheme string) bool {
+	for _, s := range schemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTransportRegistry(t *testing.T) {
+	if hasScheme(transport.Transports(), "fake") {
+		t.Fatalf("fake transport registered already")
+	}
+
+	transport.RegisterTransport(fakeTransport{})
+	if !hasScheme(transport.Transports(), "fake") {
+		t.Errorf("fake transport not listed")
+	}
+	if transport.GetTransportFromAddr("fake://addr") == nil {
+		t.Errorf("fake transport not found")
+	}
+
+	transport.DeregisterTransport("fake")
+	if hasScheme(transport.Transports(), "fake") {
+		t.Errorf("fake transport listed after deregistered")
+	}
+	if transport.GetTransportFromAddr("fake://addr") != nil {
+		t.Errorf("fake transport found after deregistered")
+	}
+}
+
+func TestTransportAcceptHook(t *testing.T) {
+	addr := "tcp://127.0.0.1:23995"
+	banned := net.ParseIP("127.0.0.2")
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	err := srvsock.ListenOptions(addr, options.OptionValues{
+		transport.Options.AcceptHook: func(conn net.Conn) error {
+			if conn.RemoteAddr().(*net.TCPAddr).IP.Equal(banned) {
+				return errors.New("banned")
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	// rejected
+	nd := &net.Dialer{LocalAddr: &net.TCPAddr{IP: banned}}
+	conn, err := nd.Dial("tcp", "127.0.0.1:23995")
+	if err != nil {
+		t.Fatalf("dial from %s error: %s", banned, err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("banned connection not closed")
+	} else if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		t.Errorf("banned connection not closed in time")
+	}
+
+	// accepted
+	clisock := multisocket.New(nil)
+	defer clisock.Close()
+	if err = clisock.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	if err = clisock.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if content, err := srvsock.RecvTimeout(time.Second); err != nil || string(content) != "hello" {
+		t.Errorf("recv: %q, %v", content, err)
+	}
+	if n := srvsock.Connector().PipeCount(); n != 1 {
+		t.Errorf("pipes: %d, expected: 1", n)
+	}
+}
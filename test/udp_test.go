@@ -0,0 +1,424 @@
+package test
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/message"
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport"
+	"github.com/multisocket/multisocket/transport/udp"
+)
+
+func recvMsgs(sock multisocket.Socket, idle time.Duration) <-chan *message.Message {
+	msgs := make(chan *message.Message, 1024)
+	go func() {
+		for {
+			msg, err := sock.RecvMsg()
+			if err != nil {
+				return
+			}
+			msgs <- msg
+		}
+	}()
+	out := make(chan *message.Message, 1024)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case msg := <-msgs:
+				out <- msg
+			case <-time.After(idle):
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func TestUDPDatagrams(t *testing.T) {
+	addr := "udp://127.0.0.1:23939"
+	srvsock, clisock, err := prepareSocks(addr)
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	N := 1000
+	for i := 0; i < N; i++ {
+		if err = clisock.Send([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+
+	count, last := 0, -1
+	for msg := range recvMsgs(srvsock, 500*time.Millisecond) {
+		i, err := strconv.Atoi(string(msg.Content))
+		if err != nil {
+			t.Errorf("bad content %q", msg.Content)
+		} else if i <= last {
+			t.Errorf("out of order: %d after %d", i, last)
+		} else {
+			last = i
+		}
+		count++
+		msg.FreeAll()
+	}
+	if count == 0 {
+		t.Errorf("no datagrams received")
+	}
+	t.Logf("received %d/%d datagrams", count, N)
+}
+
+// malformedDatagrams encode datagrams the receiving pipe must reject.
+func malformedDatagrams() [][]byte {
+	msg := message.NewSendMessage(0, message.SendTypeToOne, 0, nil, nil, []byte("hello"))
+	defer msg.FreeAll()
+	frame := msg.Encode()
+	return [][]byte{
+		{message.SendTypeToDest, message.DefaultMsgTTL, 0, 0, 0, 0, 0, 0},
+		overflowFrame(),
+		append([]byte(nil), frame[:message.MetaSize-3]...),
+		append([]byte(nil), frame[:len(frame)-2]...),
+	}
+}
+
+// checkMalformedDatagrams send malformed datagrams by send, the server must drop them
+// and keep serving well-formed peers.
+func checkMalformedDatagrams(t *testing.T, srvsock, clisock multisocket.Socket, send func(b []byte) error) {
+	for _, b := range malformedDatagrams() {
+		if err := send(b); err != nil {
+			t.Fatalf("send malformed error: %s", err)
+		}
+	}
+
+	if err := clisock.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	count := 0
+	for msg := range recvMsgs(srvsock, 300*time.Millisecond) {
+		if string(msg.Content) != "hello" {
+			t.Errorf("recv unexpected message of %d bytes", len(msg.Content))
+		}
+		count++
+		msg.FreeAll()
+	}
+	if count != 1 {
+		t.Errorf("recv %d messages", count)
+	}
+}
+
+func TestUDPMalformedDatagrams(t *testing.T) {
+	addr := "udp://127.0.0.1:23999"
+	srvsock, clisock, err := prepareSocks(addr)
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	raddr, err := net.ResolveUDPAddr("udp", "127.0.0.1:23999")
+	if err != nil {
+		t.Fatalf("resolve error: %s", err)
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	defer conn.Close()
+
+	checkMalformedDatagrams(t, srvsock, clisock, func(b []byte) (err error) {
+		_, err = conn.Write(b)
+		return
+	})
+}
+
+func TestUDPMsgTooLarge(t *testing.T) {
+	addr := "udp://127.0.0.1:23940"
+	srvsock, clisock, err := prepareSocks(addr, options.OptionValues{udp.Options.MTU: 256})
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	if err = clisock.Send(make([]byte, 256)); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if err = clisock.Send([]byte("small")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	count := 0
+	for msg := range recvMsgs(srvsock, 300*time.Millisecond) {
+		if string(msg.Content) != "small" {
+			t.Errorf("recv unexpected message of %d bytes", len(msg.Content))
+		}
+		count++
+		msg.FreeAll()
+	}
+	if count != 1 {
+		t.Errorf("recv %d messages", count)
+	}
+}
+
+func TestUDPFragment(t *testing.T) {
+	addr := "udp://127.0.0.1:23979"
+	srvsock, clisock, err := prepareSocks(addr, options.OptionValues{udp.Options.MTU: 256, udp.Options.Fragment: true})
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer srvsock.Close()
+	defer clisock.Close()
+
+	contents := [][]byte{[]byte("small"), genRandomContent(256 * 5), genRandomContent(256*8 + 1)}
+	for _, content := range contents {
+		if err = clisock.Send(content); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		msg, err := srvsock.RecvMsgTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+		if !bytes.Equal(msg.Content, content) {
+			t.Errorf("recv %d bytes, expected %d bytes", len(msg.Content), len(content))
+		}
+		// and back
+		if err = srvsock.SendTo(msg.Source, msg.Content); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		msg.FreeAll()
+		if msg, err = clisock.RecvMsgTimeout(time.Second); err != nil {
+			t.Fatalf("recv reply error: %s", err)
+		}
+		if !bytes.Equal(msg.Content, content) {
+			t.Errorf("recv reply %d bytes, expected %d bytes", len(msg.Content), len(content))
+		}
+		msg.FreeAll()
+	}
+}
+
+func TestFragmenterLostFragment(t *testing.T) {
+	var (
+		f     = transport.NewFragmenter(64, 50*time.Millisecond, 0, 0)
+		frags [][]byte
+	)
+	content := genRandomContent(64 * 4)
+	if err := f.Send(content, func(frag []byte) error {
+		frags = append(frags, append([]byte(nil), frag...))
+		return nil
+	}); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if len(frags) < 5 {
+		t.Fatalf("%d fragments", len(frags))
+	}
+
+	// lose the second one
+	for i, frag := range frags {
+		if i == 1 {
+			continue
+		}
+		if b := f.Reassemble(frag); b != nil {
+			t.Errorf("reassembled %d bytes without all fragments", len(b))
+		}
+	}
+	if n := f.Pending(); n != 1 {
+		t.Errorf("pending %d != 1", n)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := f.Pending(); n != 0 {
+		t.Errorf("pending %d != 0 after timeout", n)
+	}
+
+	// fragments may arrive out of order
+	frags = frags[:0]
+	if err := f.Send(content, func(frag []byte) error {
+		frags = append(frags, append([]byte(nil), frag...))
+		return nil
+	}); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	for i := len(frags) - 1; i >= 0; i-- {
+		b := f.Reassemble(frags[i])
+		if i > 0 && b != nil {
+			t.Errorf("reassembled before all fragments received")
+		}
+		if i == 0 && !bytes.Equal(b, content) {
+			t.Errorf("reassembled %d bytes, expected %d bytes", len(b), len(content))
+		}
+	}
+}
+
+func TestFragmenterLimits(t *testing.T) {
+	fragments := func(f *transport.Fragmenter, content []byte) (frags [][]byte) {
+		if err := f.Send(content, func(frag []byte) error {
+			frags = append(frags, append([]byte(nil), frag...))
+			return nil
+		}); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		return
+	}
+
+	t.Run("MaxPending", func(t *testing.T) {
+		f := transport.NewFragmenter(64, time.Second, 2, 0)
+		contents := [][]byte{genRandomContent(128), genRandomContent(128), genRandomContent(128)}
+		msgsFrags := make([][][]byte, len(contents))
+		for i, content := range contents {
+			msgsFrags[i] = fragments(f, content)
+			f.Reassemble(msgsFrags[i][0])
+		}
+		if n := f.Pending(); n != 2 {
+			t.Errorf("pending %d != 2", n)
+		}
+		// the oldest one is dropped
+		for i, frags := range msgsFrags[1:] {
+			var b []byte
+			for _, frag := range frags[1:] {
+				b = f.Reassemble(frag)
+			}
+			if !bytes.Equal(b, contents[i+1]) {
+				t.Errorf("message %d not reassembled", i+1)
+			}
+		}
+		for _, frag := range msgsFrags[0][1:] {
+			if b := f.Reassemble(frag); b != nil {
+				t.Errorf("reassembled dropped message")
+			}
+		}
+	})
+
+	t.Run("MaxSize", func(t *testing.T) {
+		f := transport.NewFragmenter(64, time.Second, 0, 100)
+		frags := fragments(f, genRandomContent(256))
+		// out of order, so the size is not known from the first one
+		for i := len(frags) - 1; i >= 0; i-- {
+			if b := f.Reassemble(frags[i]); b != nil {
+				t.Errorf("reassembled %d bytes larger than max size", len(b))
+			}
+		}
+		if n := f.Pending(); n != 0 {
+			t.Errorf("pending %d != 0", n)
+		}
+
+		content := genRandomContent(100)
+		var b []byte
+		for _, frag := range fragments(f, content) {
+			b = f.Reassemble(frag)
+		}
+		if !bytes.Equal(b, content) {
+			t.Errorf("message of max size not reassembled")
+		}
+	})
+}
+
+func TestUDPMsgTooLargeBatch(t *testing.T) {
+	addr := "udp://127.0.0.1:24001"
+	ovs := options.OptionValues{
+		multisocket.Options.RequireAck: true,
+		multisocket.Options.AckTimeout: 50 * time.Millisecond,
+	}
+	srvsock := multisocket.New(ovs)
+	defer srvsock.Close()
+	if err := srvsock.ListenOptions(addr, options.OptionValues{udp.Options.MTU: 256}); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	ovs[multisocket.Options.SendBatchSize] = uint16(16)
+	clisock := multisocket.New(ovs)
+	defer clisock.Close()
+
+	// queued before connected, so they're sent as a batch
+	for _, content := range [][]byte{[]byte("a"), make([]byte, 256), []byte("b")} {
+		if err := clisock.Send(content); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+	if err := clisock.DialOptions(addr, options.OptionValues{udp.Options.MTU: 256}); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+	for _, expected := range []string{"a", "b"} {
+		if content, err := srvsock.RecvTimeout(time.Second); err != nil || string(content) != expected {
+			t.Fatalf("recv: %q, %v, expected: %q", content, err, expected)
+		}
+	}
+
+	// the dropped one is neither counted nor resent
+	time.Sleep(200 * time.Millisecond)
+	if stats := clisock.Stats(); stats.MsgsSent != 2 {
+		t.Errorf("sent %d messages", stats.MsgsSent)
+	}
+	if content, err := srvsock.RecvTimeout(100 * time.Millisecond); err == nil {
+		t.Errorf("recv %d bytes", len(content))
+	}
+}
+
+func TestUDPPeerIdleTimeout(t *testing.T) {
+	addr := "udp://127.0.0.1:24006"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	if err := srvsock.ListenOptions(addr, options.OptionValues{udp.Options.PeerIdleTimeout: 100 * time.Millisecond}); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+	clisock := multisocket.New(nil)
+	defer clisock.Close()
+	if err := clisock.Dial(addr); err != nil {
+		t.Fatalf("dial error: %s", err)
+	}
+
+	if err := clisock.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if _, err := srvsock.RecvTimeout(time.Second); err != nil {
+		t.Fatalf("recv error: %s", err)
+	}
+	if srvsock.PipeCount() != 1 {
+		t.Fatalf("PipeCount %d != 1", srvsock.PipeCount())
+	}
+	if !waitUntil(time.Second, func() bool { return srvsock.PipeCount() == 0 }) {
+		t.Errorf("idle peer not closed")
+	}
+
+	// comes back as a new peer
+	if err := clisock.Send([]byte("hello")); err != nil {
+		t.Fatalf("send error: %s", err)
+	}
+	if _, err := srvsock.RecvTimeout(time.Second); err != nil {
+		t.Errorf("recv after expired error: %s", err)
+	}
+}
+
+func TestUDPMaxPeers(t *testing.T) {
+	addr := "udp://127.0.0.1:24007"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	if err := srvsock.ListenOptions(addr, options.OptionValues{udp.Options.MaxPeers: 1}); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	clisocks := []multisocket.Socket{multisocket.New(nil), multisocket.New(nil)}
+	for i, clisock := range clisocks {
+		defer clisock.Close()
+		if err := clisock.Dial(addr); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		if err := clisock.Send([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+		if i == 0 {
+			if content, err := srvsock.RecvTimeout(time.Second); err != nil || string(content) != "0" {
+				t.Fatalf("recv %q, error: %v", content, err)
+			}
+		}
+	}
+	if content, err := srvsock.RecvTimeout(200 * time.Millisecond); err != multisocket.ErrTimeout {
+		t.Errorf("recv from peer beyond max peers: %q, %v", content, err)
+	}
+	if srvsock.PipeCount() != 1 {
+		t.Errorf("PipeCount %d != 1", srvsock.PipeCount())
+	}
+}
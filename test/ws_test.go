@@ -0,0 +1,204 @@
+package test
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/multisocket/multisocket"
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport/ws"
+)
+
+func TestWebsocketSubprotocols(t *testing.T) {
+	addr := "ws://127.0.0.1:24852/ws"
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	if err := srvsock.ListenOptions(addr, options.OptionValues{ws.Options.Subprotocols: []string{"chat.v1", "chat.v2"}}); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	t.Run("Matching", func(t *testing.T) {
+		clisock := multisocket.New(nil)
+		defer clisock.Close()
+		if err := clisock.DialOptions(addr, options.OptionValues{ws.Options.Subprotocols: []string{"chat.v3", "chat.v2"}}); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		if err := clisock.Send([]byte("hello")); err != nil {
+			t.Errorf("send error: %s", err)
+		}
+		msg, err := srvsock.RecvMsg()
+		if err != nil {
+			t.Fatalf("recv error: %s", err)
+		}
+		if string(msg.Content) != "hello" {
+			t.Errorf("recv content %q", msg.Content)
+		}
+		msg.FreeAll()
+	})
+
+	t.Run("NonMatching", func(t *testing.T) {
+		clisock := multisocket.New(options.OptionValues{connector.Options.Dialer.Reconnect: false})
+		defer clisock.Close()
+		if err := clisock.DialOptions(addr, options.OptionValues{ws.Options.Subprotocols: []string{"chat.v3"}}); err != ws.ErrSubprotocolNotNegotiated {
+			t.Errorf("dial error: %v", err)
+		}
+	})
+}
+
+func TestWebsocketPingPong(t *testing.T) {
+	opts := options.OptionValues{
+		ws.Options.PingInterval: 50 * time.Millisecond,
+		ws.Options.PongTimeout:  100 * time.Millisecond,
+	}
+
+	t.Run("Alive", func(t *testing.T) {
+		addr := "ws://127.0.0.1:23975/ws"
+		srvsock := multisocket.New(nil)
+		defer srvsock.Close()
+		if err := srvsock.ListenOptions(addr, opts); err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		clisock := multisocket.New(nil)
+		defer clisock.Close()
+		if err := clisock.DialOptions(addr, opts); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+
+		var pid uint32
+		if !waitUntil(time.Second, func() bool {
+			pipes := clisock.Connector().Pipes()
+			if len(pipes) == 1 {
+				pid = pipes[0].ID()
+			}
+			return pid != 0
+		}) {
+			t.Fatalf("wait pipe timeout")
+		}
+
+		// pongs keep returning, the pipe is kept
+		time.Sleep(400 * time.Millisecond)
+		if pipes := clisock.Connector().Pipes(); len(pipes) != 1 || pipes[0].ID() != pid {
+			t.Errorf("pipe is not kept alive")
+		}
+		if err := clisock.Send([]byte("hello")); err != nil {
+			t.Errorf("send error: %s", err)
+		}
+		if content, err := srvsock.RecvTimeout(time.Second); err != nil || string(content) != "hello" {
+			t.Errorf("recv error: %v, %q", err, content)
+		}
+	})
+
+	t.Run("StalledPong", func(t *testing.T) {
+		// a peer which never returns pongs
+		ln, err := net.Listen("tcp", "127.0.0.1:23976")
+		if err != nil {
+			t.Fatalf("listen error: %s", err)
+		}
+		upgrader := websocket.Upgrader{Subprotocols: []string{"multisocket.binary"}}
+		htsvr := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.SetPingHandler(func(string) error { return nil })
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		})}
+		go htsvr.Serve(ln)
+		defer htsvr.Close()
+
+		clisock := multisocket.New(options.OptionValues{connector.Options.Dialer.Reconnect: false})
+		defer clisock.Close()
+		events := make(chan connector.PipeEvent, 2)
+		clisock.OnPipeEvent(func(e connector.PipeEvent, p connector.Pipe) {
+			events <- e
+		})
+		if err := clisock.DialOptions("ws://127.0.0.1:23976/", opts); err != nil {
+			t.Fatalf("dial error: %s", err)
+		}
+		for _, expected := range []connector.PipeEvent{connector.PipeEventAdd, connector.PipeEventRemove} {
+			select {
+			case e := <-events:
+				if e != expected {
+					t.Errorf("pipe event %d != %d", e, expected)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("wait pipe event %d timeout", expected)
+			}
+		}
+	})
+}
+
+func TestWebsocketSecure(t *testing.T) {
+	cert, pool, err := genSelfSignedCert()
+	if err != nil {
+		t.Fatalf("generate cert error: %s", err)
+	}
+	addr := "wss://127.0.0.1:23985/ws"
+
+	srvsock := multisocket.New(nil)
+	defer srvsock.Close()
+	if err = srvsock.ListenOptions(addr, options.OptionValues{
+		ws.Options.TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}); err != nil {
+		t.Fatalf("listen error: %s", err)
+	}
+
+	for _, c := range []struct {
+		name   string
+		config *tls.Config
+	}{
+		{"Verify", &tls.Config{RootCAs: pool}},
+		{"InsecureSkipVerify", &tls.Config{InsecureSkipVerify: true}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			clisock := multisocket.New(nil)
+			defer clisock.Close()
+			if err := clisock.DialOptions(addr, options.OptionValues{ws.Options.TLSConfig: c.config}); err != nil {
+				t.Fatalf("dial error: %s", err)
+			}
+			if err := clisock.Send([]byte("hello")); err != nil {
+				t.Fatalf("send error: %s", err)
+			}
+			msg, err := srvsock.RecvMsgTimeout(time.Second)
+			if err != nil {
+				t.Fatalf("recv error: %s", err)
+			}
+			if string(msg.Content) != "hello" {
+				t.Errorf("recv content %q", msg.Content)
+			}
+			if err := srvsock.SendTo(msg.Source, []byte("world")); err != nil {
+				t.Fatalf("reply error: %s", err)
+			}
+			msg.FreeAll()
+			if content, err := clisock.RecvTimeout(time.Second); err != nil || string(content) != "world" {
+				t.Errorf("recv reply %q, error: %v", content, err)
+			}
+		})
+	}
+
+	t.Run("UnknownAuthority", func(t *testing.T) {
+		clisock := multisocket.New(options.OptionValues{connector.Options.Dialer.Reconnect: false})
+		defer clisock.Close()
+		if err := clisock.DialOptions(addr, options.OptionValues{ws.Options.TLSConfig: &tls.Config{}}); err == nil {
+			t.Errorf("dial with unverified certificate succeeded")
+		}
+	})
+
+	t.Run("ConfigMissing", func(t *testing.T) {
+		clisock := multisocket.New(options.OptionValues{connector.Options.Dialer.Reconnect: false})
+		defer clisock.Close()
+		if err := clisock.Dial(addr); err != ws.ErrTLSConfigMissing {
+			t.Errorf("dial error: %v", err)
+		}
+	})
+}
@@ -11,5 +11,7 @@ import (
 	_ "github.com/multisocket/multisocket/transport/inproc/netpipe"
 	_ "github.com/multisocket/multisocket/transport/ipc"
 	_ "github.com/multisocket/multisocket/transport/tcp"
+	_ "github.com/multisocket/multisocket/transport/tls"
+	_ "github.com/multisocket/multisocket/transport/udp"
 	_ "github.com/multisocket/multisocket/transport/ws"
 )
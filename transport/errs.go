@@ -8,4 +8,5 @@ import (
 const (
 	ErrConnRefused  = errs.Err("connection refused")
 	ErrNotListening = errs.Err("not listening")
+	ErrMsgTooLarge  = errs.Err("message too large")
 )
@@ -0,0 +1,196 @@
+package transport
+
+import (
+	"container/list"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/multisocket/multisocket/bytespool"
+)
+
+type (
+	// Fragmenter split messages into fragments fitting in datagrams of mtu bytes,
+	// and reassemble received fragments, incomplete messages are dropped after timeout.
+	Fragmenter struct {
+		mtu        int
+		timeout    time.Duration
+		maxPending int
+		maxSize    int
+		nextID     uint32
+
+		sync.Mutex
+		msgs    map[uint32]*fragMsg
+		pending *list.List // of *fragMsg, in deadline order
+		last    []byte     // last reassembled message
+	}
+
+	// fragMsg is a message being reassembled
+	fragMsg struct {
+		id       uint32
+		parts    [][]byte
+		received int
+		size     int
+		deadline time.Time
+		elem     *list.Element
+	}
+)
+
+// FragmentHeaderSize is the size of fragment header: message id, index and total of fragments.
+const FragmentHeaderSize = 8
+
+const maxFragments = 0xffff
+
+// NewFragmenter create a Fragmenter.
+// At most maxPending messages are reassembled at once, the oldest one is dropped for a new one beyond it,
+// and messages larger than maxSize are dropped, 0 for no limit.
+func NewFragmenter(mtu int, timeout time.Duration, maxPending, maxSize int) *Fragmenter {
+	return &Fragmenter{
+		mtu:        mtu,
+		timeout:    timeout,
+		maxPending: maxPending,
+		maxSize:    maxSize,
+		msgs:       make(map[uint32]*fragMsg),
+		pending:    list.New(),
+	}
+}
+
+// Send split b into fragments and send them by send one by one.
+func (f *Fragmenter) Send(b []byte, send func(frag []byte) error) (err error) {
+	sz := f.mtu - FragmentHeaderSize
+	if sz <= 0 {
+		return ErrMsgTooLarge
+	}
+	total := (len(b) + sz - 1) / sz
+	if total == 0 {
+		total = 1
+	}
+	if total > maxFragments {
+		return ErrMsgTooLarge
+	}
+
+	id := atomic.AddUint32(&f.nextID, 1)
+	frag := bytespool.Alloc(FragmentHeaderSize + sz)
+	defer bytespool.Free(frag)
+	binary.BigEndian.PutUint32(frag, id)
+	binary.BigEndian.PutUint16(frag[6:], uint16(total))
+	for i := 0; i < total; i++ {
+		binary.BigEndian.PutUint16(frag[4:], uint16(i))
+		n := copy(frag[FragmentHeaderSize:], b[i*sz:])
+		if err = send(frag[:FragmentHeaderSize+n]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Reassemble add a received fragment, returns the message once all its fragments are received, otherwise nil.
+// frag is not retained, but the returned message may refer to it, and is valid until next call.
+// malformed fragments are dropped.
+func (f *Fragmenter) Reassemble(frag []byte) []byte {
+	if len(frag) < FragmentHeaderSize {
+		return nil
+	}
+	id := binary.BigEndian.Uint32(frag)
+	index := int(binary.BigEndian.Uint16(frag[4:]))
+	total := int(binary.BigEndian.Uint16(frag[6:]))
+	if index >= total {
+		return nil
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	if f.last != nil {
+		bytespool.Free(f.last)
+		f.last = nil
+	}
+	if total == 1 {
+		return frag[FragmentHeaderSize:]
+	}
+
+	size := len(frag) - FragmentHeaderSize
+	if f.maxSize > 0 && (total > f.maxSize || (index < total-1 && (total-1)*size > f.maxSize)) {
+		// too large even before it's reassembled
+		if m := f.msgs[id]; m != nil {
+			f.drop(m)
+		}
+		return nil
+	}
+
+	now := time.Now()
+	f.dropExpired(now)
+	m := f.msgs[id]
+	if m == nil {
+		if f.maxPending > 0 && len(f.msgs) >= f.maxPending {
+			f.drop(f.pending.Front().Value.(*fragMsg))
+		}
+		m = &fragMsg{
+			id:       id,
+			parts:    make([][]byte, total),
+			deadline: now.Add(f.timeout),
+		}
+		m.elem = f.pending.PushBack(m)
+		f.msgs[id] = m
+	} else if len(m.parts) != total {
+		return nil
+	}
+	if m.parts[index] != nil {
+		// duplicated
+		return nil
+	}
+	if f.maxSize > 0 && m.size+size > f.maxSize {
+		f.drop(m)
+		return nil
+	}
+	part := bytespool.Alloc(size)
+	copy(part, frag[FragmentHeaderSize:])
+	m.parts[index] = part
+	m.received++
+	m.size += size
+	if m.received < total {
+		return nil
+	}
+
+	delete(f.msgs, id)
+	f.pending.Remove(m.elem)
+	b := bytespool.Alloc(m.size)
+	n := 0
+	for _, part := range m.parts {
+		n += copy(b[n:], part)
+		bytespool.Free(part)
+	}
+	f.last = b
+	return b
+}
+
+// Pending get count of messages waiting for fragments.
+func (f *Fragmenter) Pending() int {
+	f.Lock()
+	f.dropExpired(time.Now())
+	n := len(f.msgs)
+	f.Unlock()
+	return n
+}
+
+// dropExpired drop messages past deadline, they are the oldest ones.
+func (f *Fragmenter) dropExpired(now time.Time) {
+	for e := f.pending.Front(); e != nil; e = f.pending.Front() {
+		m := e.Value.(*fragMsg)
+		if !now.After(m.deadline) {
+			return
+		}
+		f.drop(m)
+	}
+}
+
+// drop drop an incomplete message and free its fragments.
+func (f *Fragmenter) drop(m *fragMsg) {
+	for _, part := range m.parts {
+		if part != nil {
+			bytespool.Free(part)
+		}
+	}
+	delete(f.msgs, m.id)
+	f.pending.Remove(m.elem)
+}
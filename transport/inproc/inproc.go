@@ -42,8 +42,12 @@ type (
 	}
 )
 
+// errors
 const (
-	defaultAcceptQueueSize = 8
+	// ErrNoListener nobody listens on the address, dial may succeed once one listens.
+	ErrNoListener = errs.Err("connection refused: no listener")
+	// ErrListenerClosing listener is closed before accepting the dial.
+	ErrListenerClosing = errs.Err("connection refused: listener closing")
 )
 
 // NewTransport create a inproc transport
@@ -66,19 +70,19 @@ func (d *dialer) Dial(opts options.Options) (transport.Connection, error) {
 	)
 
 	if l, ok = d.t.getListenerByAddr(d.addr); !ok {
-		return nil, transport.ErrConnRefused
+		return nil, ErrNoListener
 	}
 
 	ac := make(chan net.Conn)
 	select {
 	case <-l.closedq:
-		return nil, transport.ErrConnRefused
+		return nil, ErrListenerClosing
 	case l.accepts <- ac:
 	}
 
 	select {
 	case <-l.closedq:
-		return nil, transport.ErrConnRefused
+		return nil, ErrListenerClosing
 	case dc := <-ac:
 		return transport.NewConnection(d.t, dc, false)
 	}
@@ -93,10 +97,15 @@ func (l *listener) Listen(opts options.Options) error {
 	default:
 	}
 
+	acceptQueueSize := opts.GetOptionDefault(Options.AcceptQueueSize).(int)
+	if acceptQueueSize <= 0 {
+		return options.ErrInvalidOptionValue
+	}
+
 	if ok, err := l.t.addListener(l); !ok {
 		return err
 	}
-	l.accepts = make(chan chan net.Conn, defaultAcceptQueueSize)
+	l.accepts = make(chan chan net.Conn, acceptQueueSize)
 
 	return nil
 }
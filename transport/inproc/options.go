@@ -8,6 +8,8 @@ import (
 type (
 	inprocOptions struct {
 		ReadBuffer options.IntOption
+		// max count of pending dials waiting to be accepted by a listener, must be positive.
+		AcceptQueueSize options.IntOption
 	}
 )
 
@@ -16,7 +18,8 @@ var (
 	OptionDomains = append(transport.OptionDomains, "inproc")
 	// Options for inproc
 	Options = inprocOptions{
-		ReadBuffer: options.NewIntOption(8 * 1024),
+		ReadBuffer:      options.NewIntOption(8 * 1024),
+		AcceptQueueSize: options.NewIntOption(8),
 	}
 )
 
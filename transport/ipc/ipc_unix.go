@@ -5,7 +5,6 @@ package ipc
 
 import (
 	"net"
-	"os"
 	"sync"
 
 	"github.com/multisocket/multisocket/errs"
@@ -42,16 +41,7 @@ func (l *listener) Listen(opts options.Options) error {
 	}
 
 	// remove exists socket file
-	path := l.addr.String()
-	if stat, err := os.Stat(path); err == nil {
-		if stat.Mode()&os.ModeSocket != 0 {
-			if err := os.Remove(path); err != nil {
-				return errs.ErrAddrInUse
-			}
-		} else {
-			return errs.ErrBadAddr
-		}
-	} else if !os.IsNotExist(err) {
+	if err := removeSocketFile(l.addr.String()); err != nil {
 		return err
 	}
 
@@ -74,11 +64,15 @@ func (l *listener) Accept(opts options.Options) (transport.Connection, error) {
 		return nil, errs.ErrBadOperateState
 	}
 
-	conn, err := l.listener.AcceptUnix()
-	if err != nil {
-		return nil, err
+	for {
+		conn, err := l.listener.AcceptUnix()
+		if err != nil {
+			return nil, err
+		}
+		if !transport.RejectConn(opts, conn) {
+			return transport.NewConnection(Transport, conn, true)
+		}
 	}
-	return transport.NewConnection(Transport, conn, true)
 }
 
 // Close implements the PipeListener Close method.
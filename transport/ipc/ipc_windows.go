@@ -82,11 +82,15 @@ func (l *listener) Accept(opts options.Options) (transport.Connection, error) {
 		return nil, errs.ErrBadOperateState
 	}
 
-	conn, err := l.listener.Accept()
-	if err != nil {
-		return nil, err
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if !transport.RejectConn(opts, conn) {
+			return transport.NewConnection(Transport, conn, true)
+		}
 	}
-	return transport.NewConnection(Transport, conn, true)
 }
 
 func (l *listener) Close() error {
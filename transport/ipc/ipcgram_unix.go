@@ -0,0 +1,468 @@
+// +build !windows,!nacl,!plan9
+
+package ipc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/multisocket/multisocket/bytespool"
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/message"
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport"
+)
+
+type (
+	ipcgramTran string
+
+	gramDialer struct {
+		addr *net.UnixAddr
+	}
+
+	gramListener struct {
+		addr *net.UnixAddr
+		conn *net.UnixConn
+		sync.Mutex
+		peers   map[string]*gramPeerConn
+		acceptq chan *gramPeerConn
+		closedq chan struct{}
+	}
+
+	// gramConn is a dialed connection, each message is framed into a single datagram.
+	gramConn struct {
+		*net.UnixConn
+		rc         syscall.RawConn
+		bestEffort bool
+		buf        []byte
+		frag       *transport.Fragmenter // nil if fragmentation is disabled
+	}
+
+	// gramPeerConn is an accepted connection sharing the listener's socket.
+	gramPeerConn struct {
+		l          *gramListener
+		raddr      *net.UnixAddr
+		sa         *syscall.SockaddrUnix
+		rc         syscall.RawConn
+		bestEffort bool
+		frag       *transport.Fragmenter // nil if fragmentation is disabled
+		recvq      chan []byte
+		lastBytes  []byte
+		unread     []byte
+		closeOnce  sync.Once
+		closedq    chan struct{}
+	}
+)
+
+const (
+	// GramTransport is a transport.Transport for datagram oriented IPC.
+	GramTransport = ipcgramTran("ipcgram")
+
+	maxDatagramSize = 64 * 1024
+)
+
+// sequence of dialers' local socket files
+var gramSeq uint32
+
+func init() {
+	transport.RegisterTransport(GramTransport)
+}
+
+// removeSocketFile remove socket file left at path
+func removeSocketFile(path string) error {
+	if stat, err := os.Stat(path); err == nil {
+		if stat.Mode()&os.ModeSocket == 0 {
+			return errs.ErrBadAddr
+		}
+		if err := os.Remove(path); err != nil {
+			return errs.ErrAddrInUse
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// sendNoWait send by f without waiting for room in socket buffer, the datagram is dropped if it's full.
+func sendNoWait(rc syscall.RawConn, f func(fd int) error) (err error) {
+	if werr := rc.Write(func(fd uintptr) bool {
+		err = f(int(fd))
+		return true
+	}); werr != nil {
+		return werr
+	}
+	if err == syscall.EAGAIN || err == syscall.ENOBUFS {
+		// dropped
+		err = nil
+	}
+	return
+}
+
+// newFragmenter create a fragmenter if fragmentation is enabled
+func newFragmenter(opts options.Options) *transport.Fragmenter {
+	if !Options.Gram.Fragment.ValueFrom(opts) {
+		return nil
+	}
+	return transport.NewFragmenter(maxDatagramSize, Options.Gram.FragmentTimeout.ValueFrom(opts),
+		Options.Gram.FragmentMaxPending.ValueFrom(opts), maxFragmentedSize(opts))
+}
+
+// maxFragmentedSize get max size of reassembled messages, by pipe's max content length.
+func maxFragmentedSize(opts options.Options) int {
+	if n := connector.Options.Pipe.MaxRecvContentLength.ValueFrom(opts); n > 0 {
+		return message.MaxHeaderSize + int(n)
+	}
+	return 0
+}
+
+func (d *gramDialer) Dial(opts options.Options) (_ transport.Connection, err error) {
+	// bind a local address, so replies can be received
+	laddr := &net.UnixAddr{
+		Name: filepath.Join(os.TempDir(), fmt.Sprintf("multisocket-ipcgram-%d-%d", os.Getpid(), atomic.AddUint32(&gramSeq, 1))),
+		Net:  "unixgram",
+	}
+	if err = removeSocketFile(laddr.Name); err != nil {
+		return
+	}
+	uc, err := net.DialUnix("unixgram", laddr, d.addr)
+	if err != nil {
+		os.Remove(laddr.Name)
+		return nil, err
+	}
+	c := &gramConn{
+		UnixConn:   uc,
+		bestEffort: Options.Gram.BestEffort.ValueFrom(opts),
+		buf:        make([]byte, maxDatagramSize),
+		frag:       newFragmenter(opts),
+	}
+	if c.rc, err = uc.SyscallConn(); err != nil {
+		c.Close()
+		return
+	}
+	return transport.NewConnection(GramTransport, c, false)
+}
+
+// SendReceiver
+
+func (c *gramConn) Send(b []byte) (err error) {
+	if c.frag != nil {
+		return c.frag.Send(b, c.write)
+	}
+	if len(b) > maxDatagramSize {
+		return transport.ErrMsgTooLarge
+	}
+	return c.write(b)
+}
+
+func (c *gramConn) write(b []byte) (err error) {
+	if c.bestEffort {
+		return sendNoWait(c.rc, func(fd int) (err error) {
+			_, err = syscall.Write(fd, b)
+			return
+		})
+	}
+	_, err = c.UnixConn.Write(b)
+	return
+}
+
+func (c *gramConn) Recv() (b []byte, err error) {
+	var n int
+	for {
+		if n, err = c.UnixConn.Read(c.buf); err != nil {
+			return
+		}
+		b = c.buf[:n]
+		if c.frag == nil {
+			return
+		}
+		if b = c.frag.Reassemble(b); b != nil {
+			return
+		}
+	}
+}
+
+func (c *gramConn) Close() error {
+	err := c.UnixConn.Close()
+	if laddr, ok := c.UnixConn.LocalAddr().(*net.UnixAddr); ok && laddr != nil {
+		os.Remove(laddr.Name)
+	}
+	return err
+}
+
+func (l *gramListener) Listen(opts options.Options) (err error) {
+	select {
+	case <-l.closedq:
+		return errs.ErrClosed
+	default:
+	}
+
+	if err = removeSocketFile(l.addr.Name); err != nil {
+		return
+	}
+	if l.conn, err = net.ListenUnixgram("unixgram", l.addr); err != nil {
+		return
+	}
+	rc, err := l.conn.SyscallConn()
+	if err != nil {
+		l.conn.Close()
+		l.conn = nil
+		return
+	}
+	go l.serve(rc, opts)
+	return
+}
+
+// serve dispatch received datagrams to peers by remote address.
+func (l *gramListener) serve(rc syscall.RawConn, opts options.Options) {
+	var (
+		bestEffort    = Options.Gram.BestEffort.ValueFrom(opts)
+		recvQueueSize = Options.Gram.RecvQueueSize.ValueFrom(opts)
+		buf           = make([]byte, maxDatagramSize)
+	)
+	for {
+		n, raddr, err := l.conn.ReadFromUnix(buf)
+		if err != nil {
+			select {
+			case <-l.closedq:
+				return
+			default:
+			}
+			// Debounce a little bit, to avoid thrashing the CPU.
+			time.Sleep(time.Second / 100)
+			continue
+		}
+		if raddr == nil || raddr.Name == "" {
+			// unbound peer, unable to reply
+			continue
+		}
+
+		l.Lock()
+		p := l.peers[raddr.Name]
+		if p == nil {
+			p = &gramPeerConn{
+				l:          l,
+				raddr:      raddr,
+				sa:         &syscall.SockaddrUnix{Name: raddr.Name},
+				rc:         rc,
+				bestEffort: bestEffort,
+				frag:       newFragmenter(opts),
+				recvq:      make(chan []byte, recvQueueSize),
+				closedq:    make(chan struct{}),
+			}
+			select {
+			case l.acceptq <- p:
+				l.peers[raddr.Name] = p
+			default:
+				// too many pending peers, drop
+				p = nil
+			}
+		}
+		l.Unlock()
+		if p == nil {
+			continue
+		}
+
+		b := bytespool.Alloc(n)
+		copy(b, buf[:n])
+		select {
+		case p.recvq <- b:
+		default:
+			// lossy, drop datagram when peer is busy
+			bytespool.Free(b)
+		}
+	}
+}
+
+func (l *gramListener) Accept(opts options.Options) (transport.Connection, error) {
+	if l.conn == nil {
+		return nil, errs.ErrBadOperateState
+	}
+
+	select {
+	case <-l.closedq:
+		return nil, errs.ErrClosed
+	case p := <-l.acceptq:
+		return transport.NewConnection(GramTransport, p, true)
+	}
+}
+
+func (l *gramListener) remPeer(key string) {
+	l.Lock()
+	delete(l.peers, key)
+	l.Unlock()
+}
+
+func (l *gramListener) Close() error {
+	l.Lock()
+	select {
+	case <-l.closedq:
+		l.Unlock()
+		return errs.ErrClosed
+	default:
+		close(l.closedq)
+	}
+	peers := l.peers
+	l.peers = make(map[string]*gramPeerConn)
+	l.Unlock()
+
+	for _, p := range peers {
+		p.Close()
+	}
+	if l.conn == nil {
+		return nil
+	}
+	return l.conn.Close()
+}
+
+// SendReceiver
+
+func (p *gramPeerConn) Send(b []byte) (err error) {
+	select {
+	case <-p.closedq:
+		return errs.ErrClosed
+	default:
+	}
+	if p.frag != nil {
+		return p.frag.Send(b, p.write)
+	}
+	if len(b) > maxDatagramSize {
+		return transport.ErrMsgTooLarge
+	}
+	return p.write(b)
+}
+
+func (p *gramPeerConn) write(b []byte) (err error) {
+	if p.bestEffort {
+		return sendNoWait(p.rc, func(fd int) error {
+			return syscall.Sendto(fd, b, 0, p.sa)
+		})
+	}
+	_, err = p.l.conn.WriteToUnix(b, p.raddr)
+	return
+}
+
+func (p *gramPeerConn) Recv() (b []byte, err error) {
+	for {
+		if p.lastBytes != nil {
+			bytespool.Free(p.lastBytes)
+			p.lastBytes = nil
+		}
+		select {
+		case <-p.closedq:
+			err = errs.ErrClosed
+			return
+		case b = <-p.recvq:
+		}
+		p.lastBytes = b
+		if p.frag == nil {
+			return
+		}
+		if b = p.frag.Reassemble(b); b != nil {
+			return
+		}
+	}
+}
+
+// net.Conn
+
+func (p *gramPeerConn) Read(b []byte) (n int, err error) {
+	if len(p.unread) == 0 {
+		if p.unread, err = p.Recv(); err != nil {
+			return
+		}
+	}
+	n = copy(b, p.unread)
+	p.unread = p.unread[n:]
+	return
+}
+
+func (p *gramPeerConn) Write(b []byte) (n int, err error) {
+	if err = p.Send(b); err != nil {
+		return
+	}
+	n = len(b)
+	return
+}
+
+func (p *gramPeerConn) Close() (err error) {
+	err = errs.ErrClosed
+	p.closeOnce.Do(func() {
+		close(p.closedq)
+		p.l.remPeer(p.raddr.Name)
+		err = nil
+	})
+	return
+}
+
+func (p *gramPeerConn) LocalAddr() net.Addr {
+	return p.l.addr
+}
+
+func (p *gramPeerConn) RemoteAddr() net.Addr {
+	return p.raddr
+}
+
+func (p *gramPeerConn) SetDeadline(t time.Time) error {
+	return errs.ErrOperationNotSupported
+}
+
+func (p *gramPeerConn) SetReadDeadline(t time.Time) error {
+	return errs.ErrOperationNotSupported
+}
+
+func (p *gramPeerConn) SetWriteDeadline(t time.Time) error {
+	return errs.ErrOperationNotSupported
+}
+
+// Scheme implements the Transport Scheme method.
+func (t ipcgramTran) Scheme() string {
+	return string(t)
+}
+
+func (t ipcgramTran) NewDialer(address string) (transport.Dialer, error) {
+	var (
+		err  error
+		addr *net.UnixAddr
+	)
+
+	if address, err = transport.StripScheme(t, address); err != nil {
+		return nil, err
+	}
+
+	if addr, err = net.ResolveUnixAddr("unixgram", address); err != nil {
+		return nil, err
+	}
+
+	return &gramDialer{addr: addr}, nil
+}
+
+func (t ipcgramTran) NewListener(address string) (transport.Listener, error) {
+	var (
+		err  error
+		addr *net.UnixAddr
+	)
+
+	if address, err = transport.StripScheme(t, address); err != nil {
+		return nil, err
+	}
+
+	if addr, err = net.ResolveUnixAddr("unixgram", address); err != nil {
+		return nil, err
+	}
+
+	l := &gramListener{
+		addr:    addr,
+		peers:   make(map[string]*gramPeerConn),
+		acceptq: make(chan *gramPeerConn, 16),
+		closedq: make(chan struct{}),
+	}
+
+	return l, nil
+}
@@ -0,0 +1,49 @@
+// +build !windows,!nacl,!plan9
+
+package ipc
+
+import (
+	"time"
+
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport"
+)
+
+type (
+	gramOptions struct {
+		// drop datagrams when the socket buffer is full, instead of waiting for room
+		BestEffort options.BoolOption
+		// accepted peer's received datagrams queue size, datagrams are dropped when queue is full
+		RecvQueueSize options.IntOption
+		// split messages larger than a datagram into fragments, peers must enable it too
+		Fragment options.BoolOption
+		// drop fragmented messages not completely received within timeout
+		FragmentTimeout options.TimeDurationOption
+		// max fragmented messages being received at once by a connection, the oldest one is dropped beyond it.
+		// reassembled messages are limited by connector.Options.Pipe.MaxRecvContentLength too.
+		FragmentMaxPending options.IntOption
+	}
+
+	ipcOptions struct {
+		Gram gramOptions
+	}
+)
+
+var (
+	// OptionDomains is option's domain
+	OptionDomains = append(transport.OptionDomains, "ipc")
+	// Options for unix domain sockets
+	Options = ipcOptions{
+		Gram: gramOptions{
+			BestEffort:         options.NewBoolOption(false),
+			RecvQueueSize:      options.NewIntOption(256),
+			Fragment:           options.NewBoolOption(false),
+			FragmentTimeout:    options.NewTimeDurationOption(time.Second),
+			FragmentMaxPending: options.NewIntOption(16),
+		},
+	}
+)
+
+func init() {
+	options.RegisterStructuredOptions(Options, OptionDomains)
+}
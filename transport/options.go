@@ -1,11 +1,23 @@
 package transport
 
 import (
+	"net"
+	"time"
+
 	"github.com/multisocket/multisocket/options"
 )
 
 type (
 	transportOptions struct {
+		// max time to wait for a message to be received, 0 means no deadline.
+		ReadDeadline options.TimeDurationOption
+		// max time to wait for a message to be sent, 0 means no deadline.
+		WriteDeadline options.TimeDurationOption
+		// max time to wait for a dial to complete, 0 means no timeout.
+		DialTimeout options.TimeDurationOption
+		// func(net.Conn) error called by listeners with each accepted conn before it becomes a Connection,
+		// the conn is closed if it returns an error, e.g. to check the peer against an allow-list.
+		AcceptHook options.AnyOption
 	}
 )
 
@@ -13,9 +25,25 @@ var (
 	// OptionDomains is option's domain
 	OptionDomains = []string{"transport"}
 	// Options for transport
-	Options = transportOptions{}
+	Options = transportOptions{
+		ReadDeadline:  options.NewTimeDurationOption(time.Duration(0)),
+		WriteDeadline: options.NewTimeDurationOption(time.Duration(0)),
+		DialTimeout:   options.NewTimeDurationOption(time.Duration(0)),
+		AcceptHook:    options.NewAnyOption((func(net.Conn) error)(nil)),
+	}
 )
 
 func init() {
 	options.RegisterStructuredOptions(Options, OptionDomains)
 }
+
+// RejectConn run AcceptHook of opts on conn accepted by a listener,
+// conn is closed and true returned if the hook rejects it.
+func RejectConn(opts options.Options, conn net.Conn) bool {
+	hook, _ := Options.AcceptHook.ValueFrom(opts).(func(net.Conn) error)
+	if hook == nil || hook(conn) == nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
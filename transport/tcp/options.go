@@ -15,6 +15,10 @@ type (
 		KeepAlivePeriod options.TimeDurationOption
 		ReadBuffer      options.IntOption
 		WriteBuffer     options.IntOption
+		// set SO_REUSEADDR on listening sockets, Go already sets it on unix
+		ReuseAddr options.BoolOption
+		// set SO_REUSEPORT on listening sockets, so that listeners can share a port, unix only
+		ReusePort options.BoolOption
 	}
 )
 
@@ -28,6 +32,8 @@ var (
 		KeepAlivePeriod: options.NewTimeDurationOption(time.Duration(0)),
 		ReadBuffer:      options.NewIntOption(0),
 		WriteBuffer:     options.NewIntOption(0),
+		ReuseAddr:       options.NewBoolOption(false),
+		ReusePort:       options.NewBoolOption(false),
 	}
 )
 
@@ -0,0 +1,19 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package tcp
+
+import (
+	"syscall"
+
+	"github.com/multisocket/multisocket/errs"
+)
+
+// listenControl set socket options of listening sockets before bind.
+func listenControl(reuseAddr, reusePort bool) func(network, address string, c syscall.RawConn) error {
+	if !reuseAddr && !reusePort {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		return errs.ErrOperationNotSupported
+	}
+}
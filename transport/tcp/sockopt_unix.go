@@ -0,0 +1,31 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package tcp
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenControl set socket options of listening sockets before bind.
+func listenControl(reuseAddr, reusePort bool) func(network, address string, c syscall.RawConn) error {
+	if !reuseAddr && !reusePort {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) (err error) {
+		if cerr := c.Control(func(fd uintptr) {
+			if reuseAddr {
+				if err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+					return
+				}
+			}
+			if reusePort {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			}
+		}); cerr != nil {
+			return cerr
+		}
+		return
+	}
+}
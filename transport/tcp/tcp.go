@@ -1,6 +1,7 @@
 package tcp
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"sync"
@@ -69,10 +70,15 @@ func configTCP(conn *net.TCPConn, opts options.Options) error {
 }
 
 func (d *dialer) Dial(opts options.Options) (_ transport.Connection, err error) {
-	conn, err := net.DialTCP("tcp", nil, d.addr)
+	nd := &net.Dialer{Timeout: transport.Options.DialTimeout.ValueFrom(opts)}
+	nc, err := nd.Dial("tcp", d.addr.String())
 	if err != nil {
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			err = errs.ErrTimeout
+		}
 		return nil, err
 	}
+	conn := nc.(*net.TCPConn)
 	if err = configTCP(conn, opts); err != nil {
 		conn.Close()
 		return nil, err
@@ -88,10 +94,15 @@ func (l *listener) Listen(opts options.Options) (err error) {
 	default:
 	}
 
-	l.listener, err = net.ListenTCP("tcp", l.addr)
-	if err == nil {
-		l.bound = l.listener.Addr()
+	lc := net.ListenConfig{
+		Control: listenControl(Options.ReuseAddr.ValueFrom(opts), Options.ReusePort.ValueFrom(opts)),
+	}
+	nl, err := lc.Listen(context.Background(), "tcp", l.addr.String())
+	if err != nil {
+		return
 	}
+	l.listener = nl.(*net.TCPListener)
+	l.bound = l.listener.Addr()
 	return
 }
 
@@ -106,9 +117,17 @@ func (l *listener) Accept(opts options.Options) (transport.Connection, error) {
 		return nil, errs.ErrBadOperateState
 	}
 
-	conn, err := l.listener.AcceptTCP()
-	if err != nil {
-		return nil, err
+	var (
+		conn *net.TCPConn
+		err  error
+	)
+	for {
+		if conn, err = l.listener.AcceptTCP(); err != nil {
+			return nil, err
+		}
+		if !transport.RejectConn(opts, conn) {
+			break
+		}
 	}
 	if err = configTCP(conn, opts); err != nil {
 		conn.Close()
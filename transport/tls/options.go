@@ -0,0 +1,37 @@
+package tls
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport"
+)
+
+type (
+	tlsOptions struct {
+		// *tls.Config, required by both dialer and listener
+		Config options.AnyOption
+		// max time of a server side handshake, 0 for no limit
+		HandshakeTimeout options.TimeDurationOption
+	}
+)
+
+var (
+	// OptionDomains is option's domain
+	OptionDomains = append(transport.OptionDomains, "tls")
+	// Options for tls
+	Options = tlsOptions{
+		Config:           options.NewAnyOption((*tls.Config)(nil)),
+		HandshakeTimeout: options.NewTimeDurationOption(10 * time.Second),
+	}
+)
+
+func init() {
+	options.RegisterStructuredOptions(Options, OptionDomains)
+}
+
+func configFrom(opts options.Options) *tls.Config {
+	config, _ := Options.Config.ValueFrom(opts).(*tls.Config)
+	return config
+}
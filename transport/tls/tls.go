@@ -0,0 +1,179 @@
+// Package tls implements the TLS transport on top of the tcp transport.
+package tls
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport"
+	"github.com/multisocket/multisocket/transport/tcp"
+)
+
+type (
+	tlsTran string
+
+	dialer struct {
+		transport.Dialer
+		host string
+	}
+
+	// listener handshakes accepted conns in their own goroutines,
+	// so a peer which never handshakes does not hold up others.
+	listener struct {
+		transport.Listener
+		acceptq   chan *tls.Conn
+		closedq   chan struct{}
+		closeOnce sync.Once
+	}
+)
+
+const (
+	// Transport is a transport.Transport for TLS.
+	Transport = tlsTran("tls")
+)
+
+// errors
+const (
+	ErrConfigMissing = errs.Err("tls config missing")
+)
+
+func init() {
+	transport.RegisterTransport(Transport)
+}
+
+func (d *dialer) Dial(opts options.Options) (_ transport.Connection, err error) {
+	config := configFrom(opts)
+	if config == nil {
+		return nil, ErrConfigMissing
+	}
+	if config.ServerName == "" && !config.InsecureSkipVerify {
+		config = config.Clone()
+		config.ServerName = d.host
+	}
+
+	tc, err := d.Dialer.Dial(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := tls.Client(tc.RawConn(), config)
+	if err = conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return transport.NewConnection(Transport, conn, false)
+}
+
+func (l *listener) Listen(opts options.Options) (err error) {
+	config := configFrom(opts)
+	if config == nil {
+		return ErrConfigMissing
+	}
+	if err = l.Listener.Listen(opts); err != nil {
+		return
+	}
+	go l.serve(config, opts)
+	return
+}
+
+// serve accepts conns and handshakes them.
+func (l *listener) serve(config *tls.Config, opts options.Options) {
+	timeout := Options.HandshakeTimeout.ValueFrom(opts)
+	for {
+		tc, err := l.Listener.Accept(opts)
+		if err != nil {
+			select {
+			case <-l.closedq:
+				return
+			default:
+			}
+			if err == errs.ErrClosed {
+				return
+			}
+			// Debounce a little bit, to avoid thrashing the CPU.
+			time.Sleep(time.Second / 100)
+			continue
+		}
+		go l.handshake(tls.Server(tc.RawConn(), config), timeout)
+	}
+}
+
+func (l *listener) handshake(conn *tls.Conn, timeout time.Duration) {
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	select {
+	case l.acceptq <- conn:
+	case <-l.closedq:
+		conn.Close()
+	}
+}
+
+func (l *listener) Accept(opts options.Options) (transport.Connection, error) {
+	select {
+	case conn := <-l.acceptq:
+		return transport.NewConnection(Transport, conn, true)
+	case <-l.closedq:
+		return nil, errs.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closedq)
+	})
+	return l.Listener.Close()
+}
+
+func (t tlsTran) Scheme() string {
+	return string(t)
+}
+
+func (t tlsTran) NewDialer(address string) (transport.Dialer, error) {
+	var (
+		err  error
+		td   transport.Dialer
+		addr string
+		host string
+	)
+	if addr, err = transport.StripScheme(t, address); err != nil {
+		return nil, err
+	}
+	if host, _, err = net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+
+	if td, err = tcp.Transport.NewDialer(address); err != nil {
+		return nil, err
+	}
+
+	d := &dialer{
+		Dialer: td,
+		host:   host,
+	}
+	return d, nil
+}
+
+func (t tlsTran) NewListener(address string) (transport.Listener, error) {
+	tl, err := tcp.Transport.NewListener(address)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &listener{
+		Listener: tl,
+		acceptq:  make(chan *tls.Conn),
+		closedq:  make(chan struct{}),
+	}
+	return l, nil
+}
@@ -2,6 +2,7 @@ package transport
 
 import (
 	"net"
+	"sort"
 	"strings"
 	"sync"
 
@@ -77,3 +78,23 @@ func GetTransport(scheme string) Transport {
 	lock.RUnlock()
 	return t
 }
+
+// DeregisterTransport remove the transport registered for scheme,
+// aliases of the transport are kept.
+func DeregisterTransport(scheme string) {
+	lock.Lock()
+	delete(transports, scheme)
+	lock.Unlock()
+}
+
+// Transports get sorted schemes of all registered transports, including aliases.
+func Transports() []string {
+	lock.RLock()
+	schemes := make([]string, 0, len(transports))
+	for scheme := range transports {
+		schemes = append(schemes, scheme)
+	}
+	lock.RUnlock()
+	sort.Strings(schemes)
+	return schemes
+}
@@ -0,0 +1,48 @@
+package udp
+
+import (
+	"time"
+
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport"
+)
+
+type (
+	udpOptions struct {
+		// max datagram size to send, larger messages are rejected
+		MTU options.IntOption
+		// accepted peer's received datagrams queue size, datagrams are dropped when queue is full
+		RecvQueueSize options.IntOption
+		// split messages larger than MTU into fragments, peers must enable it too
+		Fragment options.BoolOption
+		// drop fragmented messages not completely received within timeout
+		FragmentTimeout options.TimeDurationOption
+		// max fragmented messages being received at once by a connection, the oldest one is dropped beyond it.
+		// reassembled messages are limited by connector.Options.Pipe.MaxRecvContentLength too.
+		FragmentMaxPending options.IntOption
+		// close accepted peers which sent nothing within timeout, 0 for never.
+		// peers should keep alive, see connector.Options.Pipe.KeepAliveInterval.
+		PeerIdleTimeout options.TimeDurationOption
+		// max accepted peers of a listener, datagrams from new peers are dropped beyond it, 0 for no limit
+		MaxPeers options.IntOption
+	}
+)
+
+var (
+	// OptionDomains is option's domain
+	OptionDomains = append(transport.OptionDomains, "udp")
+	// Options for udp
+	Options = udpOptions{
+		MTU:                options.NewIntOption(1472),
+		RecvQueueSize:      options.NewIntOption(256),
+		Fragment:           options.NewBoolOption(false),
+		FragmentTimeout:    options.NewTimeDurationOption(time.Second),
+		FragmentMaxPending: options.NewIntOption(16),
+		PeerIdleTimeout:    options.NewTimeDurationOption(2 * time.Minute),
+		MaxPeers:           options.NewIntOption(1024),
+	}
+)
+
+func init() {
+	options.RegisterStructuredOptions(Options, OptionDomains)
+}
@@ -0,0 +1,432 @@
+package udp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/multisocket/multisocket/bytespool"
+	"github.com/multisocket/multisocket/connector"
+	"github.com/multisocket/multisocket/errs"
+	"github.com/multisocket/multisocket/message"
+	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport"
+)
+
+type (
+	udpTran string
+
+	dialer struct {
+		addr *net.UDPAddr
+	}
+
+	listener struct {
+		addr  *net.UDPAddr
+		bound net.Addr
+		conn  *net.UDPConn
+		sync.Mutex
+		peers   map[string]*peerConn
+		acceptq chan *peerConn
+		closedq chan struct{}
+	}
+
+	// conn is a dialed connection, each message is framed into a single datagram.
+	conn struct {
+		*net.UDPConn
+		mtu  int
+		buf  []byte
+		frag *transport.Fragmenter // nil if fragmentation is disabled
+	}
+
+	// peerConn is an accepted connection sharing the listener's udp socket.
+	peerConn struct {
+		// unix nano time of the last received datagram, accessed atomically
+		lastRecv  int64
+		l         *listener
+		key       string
+		raddr     *net.UDPAddr
+		mtu       int
+		frag      *transport.Fragmenter // nil if fragmentation is disabled
+		recvq     chan []byte
+		lastBytes []byte
+		unread    []byte
+		closeOnce sync.Once
+		closedq   chan struct{}
+	}
+)
+
+const (
+	// Transport is a transport.Transport for UDP.
+	Transport = udpTran("udp")
+
+	maxDatagramSize = 64 * 1024
+)
+
+func init() {
+	transport.RegisterTransport(Transport)
+}
+
+func resolveUDPAddr(addr string) (*net.UDPAddr, error) {
+	if strings.HasPrefix(addr, "*") {
+		addr = addr[1:]
+	}
+	return net.ResolveUDPAddr("udp", addr)
+}
+
+// newFragmenter create a fragmenter if fragmentation is enabled
+func newFragmenter(mtu int, opts options.Options) *transport.Fragmenter {
+	if !Options.Fragment.ValueFrom(opts) {
+		return nil
+	}
+	return transport.NewFragmenter(mtu, Options.FragmentTimeout.ValueFrom(opts),
+		Options.FragmentMaxPending.ValueFrom(opts), maxFragmentedSize(opts))
+}
+
+// maxFragmentedSize get max size of reassembled messages, by pipe's max content length.
+func maxFragmentedSize(opts options.Options) int {
+	if n := connector.Options.Pipe.MaxRecvContentLength.ValueFrom(opts); n > 0 {
+		return message.MaxHeaderSize + int(n)
+	}
+	return 0
+}
+
+func (d *dialer) Dial(opts options.Options) (_ transport.Connection, err error) {
+	uc, err := net.DialUDP("udp", nil, d.addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{
+		UDPConn: uc,
+		mtu:     Options.MTU.ValueFrom(opts),
+		buf:     make([]byte, maxDatagramSize),
+	}
+	c.frag = newFragmenter(c.mtu, opts)
+	return transport.NewConnection(Transport, c, false)
+}
+
+// SendReceiver
+
+func (c *conn) Send(b []byte) (err error) {
+	if c.frag != nil {
+		return c.frag.Send(b, c.write)
+	}
+	if len(b) > c.mtu {
+		return transport.ErrMsgTooLarge
+	}
+	return c.write(b)
+}
+
+func (c *conn) write(b []byte) (err error) {
+	_, err = c.UDPConn.Write(b)
+	return
+}
+
+func (c *conn) Recv() (b []byte, err error) {
+	var n int
+	for {
+		if n, err = c.UDPConn.Read(c.buf); err != nil {
+			return
+		}
+		b = c.buf[:n]
+		if c.frag == nil {
+			return
+		}
+		if b = c.frag.Reassemble(b); b != nil {
+			return
+		}
+	}
+}
+
+func (l *listener) Listen(opts options.Options) (err error) {
+	select {
+	case <-l.closedq:
+		return errs.ErrClosed
+	default:
+	}
+
+	if l.conn, err = net.ListenUDP("udp", l.addr); err != nil {
+		return
+	}
+	l.bound = l.conn.LocalAddr()
+	go l.serve(opts)
+	if idleTimeout := Options.PeerIdleTimeout.ValueFrom(opts); idleTimeout > 0 {
+		go l.expirePeers(idleTimeout)
+	}
+	return
+}
+
+// serve dispatch received datagrams to peers by remote address.
+func (l *listener) serve(opts options.Options) {
+	var (
+		mtu           = Options.MTU.ValueFrom(opts)
+		recvQueueSize = Options.RecvQueueSize.ValueFrom(opts)
+		maxPeers      = Options.MaxPeers.ValueFrom(opts)
+		buf           = make([]byte, maxDatagramSize)
+	)
+	for {
+		n, raddr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-l.closedq:
+				return
+			default:
+			}
+			// Debounce a little bit, to avoid thrashing the CPU.
+			time.Sleep(time.Second / 100)
+			continue
+		}
+
+		key := raddr.String()
+		l.Lock()
+		p := l.peers[key]
+		// datagrams of new peers are dropped beyond maxPeers
+		if p == nil && (maxPeers <= 0 || len(l.peers) < maxPeers) {
+			p = &peerConn{
+				lastRecv: time.Now().UnixNano(),
+				l:        l,
+				key:      key,
+				raddr:    raddr,
+				mtu:      mtu,
+				frag:     newFragmenter(mtu, opts),
+				recvq:    make(chan []byte, recvQueueSize),
+				closedq:  make(chan struct{}),
+			}
+			select {
+			case l.acceptq <- p:
+				l.peers[key] = p
+			default:
+				// too many pending peers, drop
+				p = nil
+			}
+		}
+		l.Unlock()
+		if p == nil {
+			continue
+		}
+		atomic.StoreInt64(&p.lastRecv, time.Now().UnixNano())
+
+		b := bytespool.Alloc(n)
+		copy(b, buf[:n])
+		select {
+		case p.recvq <- b:
+		default:
+			// lossy, drop datagram when peer is busy
+			bytespool.Free(b)
+		}
+	}
+}
+
+// expirePeers close peers which sent nothing within idleTimeout.
+func (l *listener) expirePeers(idleTimeout time.Duration) {
+	ticker := time.NewTicker(idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.closedq:
+			return
+		case <-ticker.C:
+		}
+		deadline := time.Now().Add(-idleTimeout).UnixNano()
+		var idle []*peerConn
+		l.Lock()
+		for _, p := range l.peers {
+			if atomic.LoadInt64(&p.lastRecv) < deadline {
+				idle = append(idle, p)
+			}
+		}
+		l.Unlock()
+		for _, p := range idle {
+			p.Close()
+		}
+	}
+}
+
+func (l *listener) Accept(opts options.Options) (transport.Connection, error) {
+	if l.conn == nil {
+		return nil, errs.ErrBadOperateState
+	}
+
+	select {
+	case <-l.closedq:
+		return nil, errs.ErrClosed
+	case p := <-l.acceptq:
+		return transport.NewConnection(Transport, p, true)
+	}
+}
+
+func (l *listener) remPeer(key string) {
+	l.Lock()
+	delete(l.peers, key)
+	l.Unlock()
+}
+
+func (l *listener) Address() string {
+	if b := l.bound; b != nil {
+		return fmt.Sprintf("%s://%s", Transport.Scheme(), b.String())
+	}
+	return fmt.Sprintf("%s://%s", Transport.Scheme(), l.addr.String())
+}
+
+func (l *listener) Close() error {
+	l.Lock()
+	select {
+	case <-l.closedq:
+		l.Unlock()
+		return errs.ErrClosed
+	default:
+		close(l.closedq)
+	}
+	peers := l.peers
+	l.peers = make(map[string]*peerConn)
+	l.Unlock()
+
+	for _, p := range peers {
+		p.Close()
+	}
+	if l.conn == nil {
+		return nil
+	}
+	return l.conn.Close()
+}
+
+// SendReceiver
+
+func (p *peerConn) Send(b []byte) (err error) {
+	select {
+	case <-p.closedq:
+		return errs.ErrClosed
+	default:
+	}
+	if p.frag != nil {
+		return p.frag.Send(b, p.write)
+	}
+	if len(b) > p.mtu {
+		return transport.ErrMsgTooLarge
+	}
+	return p.write(b)
+}
+
+func (p *peerConn) write(b []byte) (err error) {
+	_, err = p.l.conn.WriteToUDP(b, p.raddr)
+	return
+}
+
+func (p *peerConn) Recv() (b []byte, err error) {
+	for {
+		if p.lastBytes != nil {
+			bytespool.Free(p.lastBytes)
+			p.lastBytes = nil
+		}
+		select {
+		case <-p.closedq:
+			err = errs.ErrClosed
+			return
+		case b = <-p.recvq:
+		}
+		p.lastBytes = b
+		if p.frag == nil {
+			return
+		}
+		if b = p.frag.Reassemble(b); b != nil {
+			return
+		}
+	}
+}
+
+// net.Conn
+
+func (p *peerConn) Read(b []byte) (n int, err error) {
+	if len(p.unread) == 0 {
+		if p.unread, err = p.Recv(); err != nil {
+			return
+		}
+	}
+	n = copy(b, p.unread)
+	p.unread = p.unread[n:]
+	return
+}
+
+func (p *peerConn) Write(b []byte) (n int, err error) {
+	if err = p.Send(b); err != nil {
+		return
+	}
+	n = len(b)
+	return
+}
+
+func (p *peerConn) Close() (err error) {
+	err = errs.ErrClosed
+	p.closeOnce.Do(func() {
+		close(p.closedq)
+		p.l.remPeer(p.key)
+		err = nil
+	})
+	return
+}
+
+func (p *peerConn) LocalAddr() net.Addr {
+	return p.l.bound
+}
+
+func (p *peerConn) RemoteAddr() net.Addr {
+	return p.raddr
+}
+
+func (p *peerConn) SetDeadline(t time.Time) error {
+	return errs.ErrOperationNotSupported
+}
+
+func (p *peerConn) SetReadDeadline(t time.Time) error {
+	return errs.ErrOperationNotSupported
+}
+
+func (p *peerConn) SetWriteDeadline(t time.Time) error {
+	return errs.ErrOperationNotSupported
+}
+
+func (t udpTran) Scheme() string {
+	return string(t)
+}
+
+func (t udpTran) NewDialer(address string) (transport.Dialer, error) {
+	var (
+		err  error
+		addr *net.UDPAddr
+	)
+	if address, err = transport.StripScheme(t, address); err != nil {
+		return nil, err
+	}
+
+	if addr, err = resolveUDPAddr(address); err != nil {
+		return nil, err
+	}
+
+	return &dialer{addr: addr}, nil
+}
+
+func (t udpTran) NewListener(address string) (transport.Listener, error) {
+	var (
+		err  error
+		addr *net.UDPAddr
+	)
+
+	if address, err = transport.StripScheme(t, address); err != nil {
+		return nil, err
+	}
+
+	if addr, err = resolveUDPAddr(address); err != nil {
+		return nil, err
+	}
+
+	l := &listener{
+		addr:    addr,
+		peers:   make(map[string]*peerConn),
+		acceptq: make(chan *peerConn, 16),
+		closedq: make(chan struct{}),
+	}
+
+	return l, nil
+}
@@ -1,6 +1,9 @@
 package ws
 
 import (
+	"crypto/tls"
+	"time"
+
 	"github.com/multisocket/multisocket/options"
 	"github.com/multisocket/multisocket/transport"
 )
@@ -16,7 +19,16 @@ type (
 	wsOptions struct {
 		ReadBufferSize  options.IntOption
 		WriteBufferSize options.IntOption
-		Listener        listenerOptions
+		// application subprotocols, dialer requests them and listener selects one of them.
+		Subprotocols options.StringSliceOption
+		// interval to send ping control frames, 0 means no pings.
+		PingInterval options.TimeDurationOption
+		// max time to wait for the pong of a ping, the connection is closed if it's not returned in time.
+		PongTimeout options.TimeDurationOption
+		// *tls.Config of wss, required by both dialer and listener,
+		// set InsecureSkipVerify of dialer's config to accept self-signed certificates.
+		TLSConfig options.AnyOption
+		Listener  listenerOptions
 	}
 )
 
@@ -27,6 +39,10 @@ var (
 	Options = wsOptions{
 		ReadBufferSize:  options.NewIntOption(4 * 1024),
 		WriteBufferSize: options.NewIntOption(4 * 1024),
+		Subprotocols:    options.NewStringSliceOption(nil),
+		PingInterval:    options.NewTimeDurationOption(0),
+		PongTimeout:     options.NewTimeDurationOption(10 * time.Second),
+		TLSConfig:       options.NewAnyOption((*tls.Config)(nil)),
 		Listener: listenerOptions{
 			CheckOrigin:    options.NewBoolOption(false),
 			OriginChecker:  options.NewAnyOption(noCheckOrigin),
@@ -39,3 +55,8 @@ var (
 func init() {
 	options.RegisterStructuredOptions(Options, OptionDomains)
 }
+
+func tlsConfigFrom(opts options.Options) *tls.Config {
+	config, _ := Options.TLSConfig.ValueFrom(opts).(*tls.Config)
+	return config
+}
@@ -2,6 +2,7 @@ package ws
 
 import (
 	"bytes"
+	"crypto/tls"
 	"io"
 	"net"
 	"net/http"
@@ -21,6 +22,7 @@ type (
 		scheme string
 		isSr   bool
 		isRw   bool
+		secure bool // over tls
 	}
 
 	dialer struct {
@@ -37,9 +39,13 @@ type (
 		upgrader websocket.Upgrader
 		*http.ServeMux
 		externalListen bool
+		subprotocols   []string
 		htsvr          *http.Server
 		listener       net.Listener
 		pending        chan net.Conn
+		failures       chan error
+		pingInterval   time.Duration
+		pongTimeout    time.Duration
 		sync.Mutex
 		closedq chan struct{}
 	}
@@ -51,6 +57,10 @@ type (
 		raddr net.Addr
 		r     io.Reader
 		dtype int
+
+		pongq     chan struct{}
+		closedq   chan struct{}
+		closeOnce sync.Once
 	}
 
 	// SendReceiver
@@ -68,6 +78,10 @@ var (
 	RwTransport = &wsTran{scheme: "ws.rw", isRw: true}
 	// SrTransport is a transport.Transport for Websocket, using SendReceiver+ReadWriter.
 	SrTransport = &wsTran{scheme: "ws.sr", isSr: true}
+	// SecureRwTransport is like RwTransport, but over tls.
+	SecureRwTransport = &wsTran{scheme: "wss.rw", isRw: true, secure: true}
+	// SecureSrTransport is like SrTransport, but over tls.
+	SecureSrTransport = &wsTran{scheme: "wss.sr", isSr: true, secure: true}
 )
 
 var (
@@ -85,17 +99,53 @@ var (
 	}
 )
 
+// errors
+const (
+	ErrSubprotocolNotNegotiated = errs.Err("websocket subprotocol negotiation failed")
+	ErrTLSConfigMissing         = errs.Err("wss tls config missing")
+)
+
 func init() {
 	transport.RegisterTransport(RwTransport)
 	transport.RegisterTransport(SrTransport)
+	transport.RegisterTransport(SecureRwTransport)
+	transport.RegisterTransport(SecureSrTransport)
 	// default transport
 	transport.RegisterTransportWithScheme(SrTransport, "ws")
+	transport.RegisterTransportWithScheme(SecureSrTransport, "wss")
+}
+
+// connTransport get transport of connections
+func (t *wsTran) connTransport() transport.Transport {
+	if t.secure {
+		return SecureRwTransport
+	}
+	return RwTransport
 }
 
 func noCheckOrigin(r *http.Request) bool {
 	return true
 }
 
+// negotiatedDataType get data type of the negotiated subprotocol
+func negotiatedDataType(subprotocol string, userSubprotocols []string) (dtype int, err error) {
+	if len(userSubprotocols) == 0 {
+		var ok bool
+		if dtype, ok = dataTypes[subprotocol]; !ok {
+			err = errs.ErrBadProtocol
+		}
+		return
+	}
+
+	for _, sp := range userSubprotocols {
+		if sp == subprotocol {
+			return websocket.BinaryMessage, nil
+		}
+	}
+	err = ErrSubprotocolNotNegotiated
+	return
+}
+
 // ws
 func (c *wsConn) LocalAddr() net.Addr {
 	return c.laddr
@@ -105,6 +155,62 @@ func (c *wsConn) RemoteAddr() net.Addr {
 	return c.raddr
 }
 
+func (c *wsConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closedq)
+	})
+	return c.Conn.Close()
+}
+
+// keepAlive send a ping every interval, close the connection if its pong is not returned within timeout.
+// pongs are handled while reading, which the pipe keeps doing.
+func (c *wsConn) keepAlive(interval, timeout time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	c.pongq = make(chan struct{}, 1)
+	c.Conn.SetPongHandler(func(string) error {
+		select {
+		case c.pongq <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.closedq:
+				return
+			case <-ticker.C:
+			}
+			// drop late pongs of previous pings
+			select {
+			case <-c.pongq:
+			default:
+			}
+			if err := c.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout)); err != nil {
+				c.Close()
+				return
+			}
+
+			tm := time.NewTimer(timeout)
+			select {
+			case <-c.closedq:
+				tm.Stop()
+				return
+			case <-c.pongq:
+				tm.Stop()
+			case <-tm.C:
+				c.Close()
+				return
+			}
+		}
+	}()
+}
+
 // SendReceiver
 
 func (c *srWsConn) Send(b []byte) (err error) {
@@ -171,9 +277,19 @@ func (d *dialer) Dial(opts options.Options) (_ transport.Connection, err error)
 		ws *websocket.Conn
 	)
 
+	userSubprotocols := Options.Subprotocols.ValueFrom(opts)
 	wd := &websocket.Dialer{
-		WriteBufferPool: &sync.Pool{},
-		Subprotocols:    subprotocols,
+		WriteBufferPool:  &sync.Pool{},
+		Subprotocols:     subprotocols,
+		HandshakeTimeout: transport.Options.DialTimeout.ValueFrom(opts),
+	}
+	if len(userSubprotocols) > 0 {
+		wd.Subprotocols = userSubprotocols
+	}
+	if d.t.secure {
+		if wd.TLSClientConfig = tlsConfigFrom(opts); wd.TLSClientConfig == nil {
+			return nil, ErrTLSConfigMissing
+		}
 	}
 	// config
 	if val, ok := opts.GetOption(Options.ReadBufferSize); ok {
@@ -184,30 +300,34 @@ func (d *dialer) Dial(opts options.Options) (_ transport.Connection, err error)
 	}
 
 	if ws, _, err = wd.Dial(d.url.String(), nil); err != nil {
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			err = errs.ErrTimeout
+		}
 		return nil, err
 	}
 
-	dtype, ok := dataTypes[ws.Subprotocol()]
-	if !ok {
+	dtype, err := negotiatedDataType(ws.Subprotocol(), userSubprotocols)
+	if err != nil {
 		ws.Close()
-		err = errs.ErrBadProtocol
 		return
 	}
 
 	c := &wsConn{
-		Conn:  ws,
-		url:   d.url,
-		laddr: ws.LocalAddr(),
-		raddr: transport.NewAddress(d.t.scheme, d.addr),
-		dtype: dtype,
+		Conn:    ws,
+		url:     d.url,
+		laddr:   ws.LocalAddr(),
+		raddr:   transport.NewAddress(d.t.scheme, d.addr),
+		dtype:   dtype,
+		closedq: make(chan struct{}),
 	}
+	c.keepAlive(Options.PingInterval.ValueFrom(opts), Options.PongTimeout.ValueFrom(opts))
 
 	var conn net.Conn = c
 	if d.t.isSr {
 		conn = &srWsConn{wsConn: c}
 	}
 
-	return transport.NewConnection(RwTransport, conn, false)
+	return transport.NewConnection(d.t.connTransport(), conn, false)
 }
 
 // listener
@@ -221,6 +341,12 @@ func (l *Listener) Listen(opts options.Options) (err error) {
 	}
 
 	l.pending = make(chan net.Conn, Options.Listener.PendingSize.ValueFrom(opts))
+	l.failures = make(chan error, Options.Listener.PendingSize.ValueFrom(opts))
+	l.pingInterval = Options.PingInterval.ValueFrom(opts)
+	l.pongTimeout = Options.PongTimeout.ValueFrom(opts)
+	if l.subprotocols = Options.Subprotocols.ValueFrom(opts); len(l.subprotocols) > 0 {
+		l.upgrader.Subprotocols = l.subprotocols
+	}
 	// config
 	if val, ok := opts.GetOption(Options.ReadBufferSize); ok {
 		l.upgrader.ReadBufferSize = Options.ReadBufferSize.Value(val)
@@ -248,6 +374,12 @@ func (l *Listener) Listen(opts options.Options) (err error) {
 	}
 
 	// internal listen
+	var config *tls.Config
+	if l.t.secure {
+		if config = tlsConfigFrom(opts); config == nil {
+			return ErrTLSConfigMissing
+		}
+	}
 	var taddr *net.TCPAddr
 	if taddr, err = transport.ResolveTCPAddr(l.URL.Host); err != nil {
 		return err
@@ -256,6 +388,10 @@ func (l *Listener) Listen(opts options.Options) (err error) {
 	if l.listener, err = net.ListenTCP("tcp", taddr); err != nil {
 		return
 	}
+	if config != nil {
+		// tls handshake before upgrading
+		l.listener = tls.NewListener(l.listener, config)
+	}
 	l.htsvr = &http.Server{Handler: l.ServeMux}
 	go l.htsvr.Serve(l.listener)
 	return nil
@@ -267,11 +403,17 @@ func (l *Listener) Accept(opts options.Options) (transport.Connection, error) {
 		return nil, errs.ErrBadOperateState
 	}
 
-	select {
-	case c := <-l.pending:
-		return transport.NewConnection(RwTransport, c, true)
-	case <-l.closedq:
-		return nil, errs.ErrClosed
+	for {
+		select {
+		case c := <-l.pending:
+			if !transport.RejectConn(opts, c) {
+				return transport.NewConnection(l.t.connTransport(), c, true)
+			}
+		case err := <-l.failures:
+			return nil, err
+		case <-l.closedq:
+			return nil, errs.ErrClosed
+		}
 	}
 }
 
@@ -316,19 +458,27 @@ func (l *Listener) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	default:
 	}
 
-	dtype, ok := dataTypes[ws.Subprotocol()]
-	if !ok {
+	dtype, err := negotiatedDataType(ws.Subprotocol(), l.subprotocols)
+	if err != nil {
+		ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseProtocolError, err.Error()), time.Now().Add(time.Second))
 		ws.Close()
+		select {
+		case l.failures <- err:
+		default:
+		}
 		return
 	}
 
 	c := &wsConn{
-		Conn:  ws,
-		url:   l.URL,
-		laddr: transport.NewAddress(l.t.scheme, l.addr),
-		raddr: ws.RemoteAddr(),
-		dtype: dtype,
+		Conn:    ws,
+		url:     l.URL,
+		laddr:   transport.NewAddress(l.t.scheme, l.addr),
+		raddr:   ws.RemoteAddr(),
+		dtype:   dtype,
+		closedq: make(chan struct{}),
 	}
+	c.keepAlive(l.pingInterval, l.pongTimeout)
 
 	if l.t.isSr {
 		l.pending <- &srWsConn{wsConn: c}
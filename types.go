@@ -1,28 +1,147 @@
 package multisocket
 
 import (
+	"context"
+	"time"
+
 	"github.com/multisocket/multisocket/connector"
 	"github.com/multisocket/multisocket/message"
 	"github.com/multisocket/multisocket/options"
+	"github.com/multisocket/multisocket/transport"
 )
 
 type (
 	// ConnectorAction is connector's actions
 	ConnectorAction = connector.Action
 
+	// InternalMsgHandler handle internal messages received from pipe p, msg is freed after handled.
+	InternalMsgHandler func(p connector.Pipe, msg *message.Message)
+
+	// RecvFilter filter received messages before they are queued for Recv, msg's content is decompressed.
+	// it returns the message to deliver, msg modified or a new one, then msg must be freed by the filter,
+	// or false to drop msg, which is freed by the socket.
+	RecvFilter func(msg *message.Message) (*message.Message, bool)
+
+	// ClientIDHandler handle client id announced by the peer of pipe p.
+	ClientIDHandler func(p connector.Pipe, id []byte)
+
+	// Stream is a logical message stream multiplexed with others over a pipe
+	Stream interface {
+		ID() uint32
+		Send(content []byte) error
+		// Recv recv the next content, returns ErrClosed after stream is closed by either side.
+		// A stream whose recv queue is full is closed rather than blocking the pipe's other streams.
+		Recv() ([]byte, error)
+		// Close close the stream, and notify peer.
+		Close() error
+	}
+
+	// MetricsCollector collect socket's metrics, it's called on hot paths and must not block.
+	MetricsCollector interface {
+		// MsgSent a message of content bytes is sent by a pipe
+		MsgSent(bytes int)
+		// MsgRecv a message of content bytes is received
+		MsgRecv(bytes int)
+		// MsgDropped a message is dropped for full queues, bad checksum or NoRecv
+		MsgDropped()
+		// SendQueueDepth current depth of socket's send queues
+		SendQueueDepth(n int)
+		// RecvQueueDepth current depth of socket's recv queue
+		RecvQueueDepth(n int)
+	}
+
+	// Stats is socket's aggregate stats of all pipes, bytes are of messages' content,
+	// internal messages are not counted.
+	Stats struct {
+		BytesSent uint64
+		BytesRecv uint64
+		MsgsSent  uint64
+		MsgsRecv  uint64
+		// currently connected pipes
+		Pipes int
+	}
+
 	// Socket is a network peer
 	Socket interface {
 		options.Options
 
 		ConnectorAction
 		Connector() connector.Connector
+		// WaitConnected wait until at least one pipe is connected, returns ctx's error if it's done first.
+		WaitConnected(ctx context.Context) error
 
 		RecvMsg() (*message.Message, error)
-		SendMsg(msg *message.Message) error                // for forward message
+		// RecvMsgTimeout is like RecvMsg, but returns ErrTimeout if nothing arrives within d.
+		RecvMsgTimeout(d time.Duration) (*message.Message, error)
+		// RecvBatch recv up to max messages, returns early with the messages arrived after within elapses,
+		// or ErrTimeout if none. Messages received before an error are returned with it.
+		RecvBatch(max int, within time.Duration) ([]*message.Message, error)
+		// RecvTimeout recv a message's content, returns ErrTimeout if nothing arrives within d.
+		RecvTimeout(d time.Duration) ([]byte, error)
+		// RecvFrom recv a message's content and the remote address of the pipe it arrived on.
+		RecvFrom() ([]byte, string, error)
+		// RecvZeroCopy recv a message without copying its content, release must be called once done with msg,
+		// msg and its content are invalid after release is called.
+		RecvZeroCopy() (msg *message.Message, release func(), err error)
+		// CorruptMsgs get count of received messages dropped for bad checksum.
+		CorruptMsgs() uint64
+		// TTLExpiredMsgs get count of forwarded messages dropped for exhausted TTL.
+		TTLExpiredMsgs() uint64
+		// Stats get aggregate stats of messages sent and received by all pipes.
+		Stats() Stats
+		// MsgTransport get the transport which the received message arrived on, nil if its pipe is closed.
+		MsgTransport(msg *message.Message) transport.Transport
+		// SendMsg send msg, the socket takes ownership of msg whether or not it returns an error,
+		// msg may be queued, modified or freed, so it must not be used or freed by the caller after the call.
+		SendMsg(msg *message.Message) error // for forward message
+		// SendMsgCopy is like SendMsg, but sends a copy of msg, so the caller retains ownership of msg.
+		SendMsgCopy(msg *message.Message) error
 		Send(content []byte) error                         // for initiative send one
 		SendAll(content []byte) error                      // for initiative send all
 		SendTo(dest message.MsgPath, content []byte) error // for reply send
+		// SendToMany send content to each of dests, returns *DestsError if some of them failed.
+		SendToMany(dests []message.MsgPath, content []byte) error
+		// Broadcast send content to the peers of all pipes, same as SendAll.
+		Broadcast(content []byte) error
+		// SendToPipe send to the peer of pipe id directly, returns ErrPipeNotFound if pipe is closed.
+		SendToPipe(id uint32, content []byte) error
+		// SendPriority is like Send, but the message overtakes queued normal messages.
+		SendPriority(content []byte) error
+		// SendObject send v marshaled by Options.Codec.
+		SendObject(v interface{}) error
+		// RecvObject recv a message and unmarshal it into v by Options.Codec.
+		RecvObject(v interface{}) error
+		// DialTimeout dial to addr synchronously, returns ErrTimeout if not connected within d.
+		DialTimeout(addr string, d time.Duration) error
+		// NewRawListener create a listener of raw pipes, which pass plain bytes without multisocket framing.
+		// Recv gets an empty content when a peer connects, then the bytes it writes, reply with SendTo(msg.Source, ...).
+		NewRawListener(addr string) (connector.Listener, error)
+		// RawDial dial to addr with a raw pipe, see NewRawListener.
+		RawDial(addr string) error
+		// OnPipeEvent set handler of pipes' add/remove and handshake failed events, nil handler to remove.
+		// handler is called synchronously while the connector is locked, it must not block or call into the connector.
+		OnPipeEvent(handler connector.PipeEventHandlerFunc)
+		// OnClientID set handler of client ids announced by peers, see Options.ClientID, nil handler to remove.
+		// ids are bound to pipes, so a message's sender is identified by the first pipe of its Source.
+		OnClientID(handler ClientIDHandler)
+		// OpenStream open a stream on one of the connected pipes, returns ErrPipeNotFound if none.
+		OpenStream() (Stream, error)
+		// AcceptStream wait for a stream opened by peers, new streams are refused while RecvQueueSize ones are waiting,
+		// or their pipe has Options.MaxStreams ones.
+		AcceptStream() (Stream, error)
+		// SetMetricsCollector set collector of socket's metrics, nil to remove.
+		SetMetricsCollector(collector MetricsCollector)
+		// SetRecvFilter set filter of received messages, nil to remove.
+		SetRecvFilter(filter RecvFilter)
+		// SetInternalMsgHandler set handler of internal message type typ, nil handler to remove.
+		SetInternalMsgHandler(typ uint8, handler InternalMsgHandler)
 
+		// Flush wait until queued messages are sent, returns ctx's error if it's done first.
+		Flush(ctx context.Context) error
 		Close() error
+		// CloseGracefully stop accepting new sends, wait up to timeout for queued messages to be sent, then close.
+		CloseGracefully(timeout time.Duration) error
+		// Closed get a channel which is closed once the socket is closed.
+		Closed() <-chan struct{}
 	}
 )
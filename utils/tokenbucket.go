@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// TokenBucket is a token bucket rate limiter, tokens are refilled at rate per second up to burst.
+	TokenBucket struct {
+		sync.Mutex
+		rate   float64
+		burst  float64
+		tokens float64
+		last   time.Time
+	}
+)
+
+// NewTokenBucket create a full token bucket
+func NewTokenBucket(rate, burst int64) *TokenBucket {
+	return &TokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Take take n tokens, returns how long to wait until they are available.
+// tokens are taken even if not available, so following takers wait longer.
+func (b *TokenBucket) Take(n int) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+	b.refill(time.Now())
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// TryTake take n tokens only if available now,
+// n larger than burst is available when the bucket is full.
+func (b *TokenBucket) TryTake(n int) bool {
+	b.Lock()
+	defer b.Unlock()
+	b.refill(time.Now())
+	need := float64(n)
+	if need > b.burst {
+		need = b.burst
+	}
+	if b.tokens < need {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}